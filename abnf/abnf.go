@@ -0,0 +1,528 @@
+// Package abnf compiles the Augmented BNF grammars into PEG patterns.
+//
+// The syntax is described in RFC 5234, with the case-sensitive strings
+// (%s"text") introduced by RFC 7405. The core rules (ALPHA, DIGIT, CRLF, ...)
+// are predefined, which could be overridden by the grammar, or extended by
+// the incremental alternatives (=/).
+//
+// Rules are compiled to variables of a Let namespace, the rule names are
+// case-insensitive thus stored in lower case. Repetitions and options are
+// translated into the greedy qualifiers, alternations are translated into
+// the ordered choices. As PEG never backtracks into a successfully matched
+// choice or repetition, the compiled pattern may reject text accepted by
+// the ABNF grammar. The suspicious places are reported as warnings.
+//
+// Terminal values (%x, %d, %b) are treated as unicode code points.
+// Prose values (<prose>) could not be compiled, they never match.
+// This package API is currently volatile.
+package abnf // import "github.com/hucsmn/peg/abnf"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hucsmn/peg"
+	"github.com/hucsmn/peg/internal/bnf"
+)
+
+// Core rules described in RFC 5234 Appendix B.1.
+const coreSource = `
+ALPHA  = %x41-5A / %x61-7A
+BIT    = "0" / "1"
+CHAR   = %x01-7F
+CR     = %x0D
+CRLF   = CR LF
+CTL    = %x00-1F / %x7F
+DIGIT  = %x30-39
+DQUOTE = %x22
+HEXDIG = DIGIT / "A" / "B" / "C" / "D" / "E" / "F"
+HTAB   = %x09
+LF     = %x0A
+LWSP   = *(WSP / CRLF WSP)
+OCTET  = %x00-FF
+SP     = %x20
+VCHAR  = %x21-7E
+WSP    = SP / HTAB
+`
+
+var (
+	coreGrammar = mustParseCore()
+
+	// CoreRules contains the compiled core rules, named in lower case.
+	CoreRules = coreGrammar.Patterns()
+)
+
+// Warning reports where the ordered choices or greedy qualifiers may change
+// the meaning of ABNF grammar.
+type Warning struct {
+	Position peg.Position
+	Rule     string
+	Message  string
+}
+
+func (w Warning) String() string {
+	return bnf.Warning(w).String()
+}
+
+// Compile compiles ABNF rules, then returns the pattern which invokes the
+// entry rule inside the namespace of rules.
+//
+// Returns *peg.SyntaxError if the source is invalid.
+func Compile(src, entry string) (peg.Pattern, []Warning, error) {
+	rules, warnings, err := Rules(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := strings.ToLower(entry)
+	if _, ok := rules[name]; !ok {
+		return nil, nil, fmt.Errorf("abnf: rule %q is undefined", entry)
+	}
+	return peg.Let(rules, peg.V(name)), warnings, nil
+}
+
+// Rules compiles ABNF rules to a namespace for peg.Let, including the core
+// rules not overridden. The rule names are converted to lower case.
+//
+// Returns *peg.SyntaxError if the source is invalid.
+func Rules(src string) (map[string]peg.Pattern, []Warning, error) {
+	g, err := parse(src, coreGrammar)
+	if err != nil {
+		return nil, nil, err
+	}
+	var warnings []Warning
+	for _, w := range g.Analyze() {
+		warnings = append(warnings, Warning(w))
+	}
+	return g.Patterns(), warnings, nil
+}
+
+func mustParseCore() *bnf.Grammar {
+	g, err := parse(coreSource, nil)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// Recursive descent parser of ABNF.
+type parser struct {
+	bnf.Scanner
+	g *bnf.Grammar
+}
+
+func parse(src string, core *bnf.Grammar) (*bnf.Grammar, error) {
+	p := &parser{
+		Scanner: bnf.Scanner{Src: src},
+		g:       bnf.NewGrammar(src, core),
+	}
+	for {
+		p.skipEmptyLines()
+		if p.EOF() {
+			break
+		}
+		err := p.rule()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.g.CheckUndefined(); err != nil {
+		return nil, err
+	}
+	return p.g, nil
+}
+
+// rule = rulename defined-as elements c-nl
+func (p *parser) rule() error {
+	at := p.At
+	name := p.rulename()
+	if name == "" {
+		return p.Unexpected()
+	}
+	p.skipCWSP()
+
+	incremental := false
+	switch {
+	case p.Skip("=/"):
+		incremental = true
+	case p.Skip("="):
+	default:
+		return p.Unexpected()
+	}
+	p.skipCWSP()
+
+	def, err := p.alternation()
+	if err != nil {
+		return err
+	}
+	p.skipCWSP()
+	if !p.skipNewline() && p.At < len(p.Src) {
+		return p.Unexpected()
+	}
+
+	key := strings.ToLower(name)
+	r, ok := p.g.Rules[key]
+	if incremental && !ok && p.g.Base != nil {
+		// extends the core rule by a local copy
+		if core := p.g.Base.Lookup(key); core != nil {
+			subs := []*bnf.Node{core.Def}
+			if core.Def.Kind == bnf.Alternation {
+				subs = append([]*bnf.Node(nil), core.Def.Subs...)
+			}
+			p.g.Define(key, core.Name, at, &bnf.Node{Kind: bnf.Alternation, At: at, Subs: subs})
+			r, ok = p.g.Rules[key]
+		}
+	}
+	switch {
+	case incremental && !ok:
+		return p.Errorf(at, "incremental alternatives to undefined rule %q", name)
+	case incremental:
+		if r.Def.Kind != bnf.Alternation {
+			r.Def = &bnf.Node{Kind: bnf.Alternation, At: r.Def.At, Subs: []*bnf.Node{r.Def}}
+		}
+		if def.Kind == bnf.Alternation {
+			r.Def.Subs = append(r.Def.Subs, def.Subs...)
+		} else {
+			r.Def.Subs = append(r.Def.Subs, def)
+		}
+	case !p.g.Define(key, name, at, def):
+		return p.Errorf(at, "rule %q is defined more than once", name)
+	}
+	return nil
+}
+
+// alternation = concatenation *(*c-wsp "/" *c-wsp concatenation)
+func (p *parser) alternation() (*bnf.Node, error) {
+	at := p.At
+	sub, err := p.concatenation()
+	if err != nil {
+		return nil, err
+	}
+	subs := []*bnf.Node{sub}
+	for {
+		save := p.At
+		p.skipCWSP()
+		if !p.Skip("/") {
+			p.At = save
+			break
+		}
+		p.skipCWSP()
+		sub, err = p.concatenation()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) == 1 {
+		return subs[0], nil
+	}
+	return &bnf.Node{Kind: bnf.Alternation, At: at, Subs: subs}, nil
+}
+
+// concatenation = repetition *(1*c-wsp repetition)
+func (p *parser) concatenation() (*bnf.Node, error) {
+	at := p.At
+	sub, err := p.repetition()
+	if err != nil {
+		return nil, err
+	}
+	subs := []*bnf.Node{sub}
+	for {
+		save := p.At
+		p.skipCWSP()
+		if p.At == save || !p.atRepetition() {
+			p.At = save
+			break
+		}
+		sub, err = p.repetition()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) == 1 {
+		return subs[0], nil
+	}
+	return &bnf.Node{Kind: bnf.Concatenation, At: at, Subs: subs}, nil
+}
+
+// repetition = [repeat] element
+// repeat     = 1*DIGIT / (*DIGIT "*" *DIGIT)
+func (p *parser) repetition() (*bnf.Node, error) {
+	at := p.At
+	min, hasmin := p.decimal()
+	if !p.Skip("*") {
+		elem, err := p.element()
+		if err != nil || !hasmin {
+			return elem, err
+		}
+		return &bnf.Node{Kind: bnf.Repetition, At: at, Subs: []*bnf.Node{elem}, Min: min, Max: min}, nil
+	}
+
+	max, hasmax := p.decimal()
+	if !hasmin {
+		min = 0
+	}
+	if !hasmax {
+		max = -1
+	} else if max < min {
+		return nil, p.Errorf(at, "invalid repetition %d*%d", min, max)
+	}
+	elem, err := p.element()
+	if err != nil {
+		return nil, err
+	}
+	return &bnf.Node{Kind: bnf.Repetition, At: at, Subs: []*bnf.Node{elem}, Min: min, Max: max}, nil
+}
+
+// element = rulename / group / option / char-val / num-val / prose-val
+func (p *parser) element() (*bnf.Node, error) {
+	at := p.At
+	switch {
+	case p.atRulename():
+		name := p.rulename()
+		return &bnf.Node{Kind: bnf.RuleName, At: at, Name: strings.ToLower(name)}, nil
+	case p.Skip("("):
+		p.skipCWSP()
+		sub, err := p.alternation()
+		if err != nil {
+			return nil, err
+		}
+		p.skipCWSP()
+		if !p.Skip(")") {
+			return nil, p.Expect("')'", at)
+		}
+		return sub, nil
+	case p.Skip("["):
+		p.skipCWSP()
+		sub, err := p.alternation()
+		if err != nil {
+			return nil, err
+		}
+		p.skipCWSP()
+		if !p.Skip("]") {
+			return nil, p.Expect("']'", at)
+		}
+		return &bnf.Node{Kind: bnf.Repetition, At: at, Subs: []*bnf.Node{sub}, Min: 0, Max: 1}, nil
+	case p.Peek("\""):
+		return p.charVal(at, false)
+	case p.Skip("%s") || p.Skip("%S"):
+		if !p.Peek("\"") {
+			return nil, p.Unexpected()
+		}
+		return p.charVal(at, true)
+	case p.Skip("%i") || p.Skip("%I"):
+		if !p.Peek("\"") {
+			return nil, p.Unexpected()
+		}
+		return p.charVal(at, false)
+	case p.Skip("%"):
+		return p.numVal(at)
+	case p.Skip("<"):
+		i := strings.IndexAny(p.Src[p.At:], ">\r\n")
+		if i < 0 || p.Src[p.At+i] != '>' {
+			if i < 0 {
+				p.At = len(p.Src)
+			} else {
+				p.At += i
+			}
+			return nil, p.Expect("'>'", at)
+		}
+		text := p.Src[p.At : p.At+i]
+		p.At += i + 1
+		return &bnf.Node{Kind: bnf.Prose, At: at, Text: text}, nil
+	default:
+		return nil, p.Unexpected()
+	}
+}
+
+// char-val = DQUOTE *(%x20-21 / %x23-7E) DQUOTE
+func (p *parser) charVal(at int, sensitive bool) (*bnf.Node, error) {
+	start := p.At
+	p.Skip("\"")
+	i := strings.IndexAny(p.Src[p.At:], "\"\r\n")
+	if i < 0 || p.Src[p.At+i] != '"' {
+		if i < 0 {
+			p.At = len(p.Src)
+		} else {
+			p.At += i
+		}
+		return nil, p.Expect("'\"'", start)
+	}
+	text := p.Src[p.At : p.At+i]
+	p.At += i + 1
+	return &bnf.Node{Kind: bnf.Text, At: at, Text: text, Sensitive: sensitive}, nil
+}
+
+// num-val = "%" (bin-val / dec-val / hex-val)
+func (p *parser) numVal(at int) (*bnf.Node, error) {
+	var base int
+	switch {
+	case p.Skip("b") || p.Skip("B"):
+		base = 2
+	case p.Skip("d") || p.Skip("D"):
+		base = 10
+	case p.Skip("x") || p.Skip("X"):
+		base = 16
+	default:
+		return nil, p.Unexpected()
+	}
+
+	low, err := p.value(base)
+	if err != nil {
+		return nil, err
+	}
+	if p.Skip("-") {
+		high, err := p.value(base)
+		if err != nil {
+			return nil, err
+		}
+		if high < low {
+			return nil, p.Errorf(at, "invalid value range")
+		}
+		return &bnf.Node{Kind: bnf.Class, At: at, Ranges: []bnf.RuneRange{{Low: low, High: high}}}, nil
+	}
+
+	runes := []rune{low}
+	for p.Skip(".") {
+		r, err := p.value(base)
+		if err != nil {
+			return nil, err
+		}
+		runes = append(runes, r)
+	}
+	if len(runes) == 1 {
+		return &bnf.Node{Kind: bnf.Class, At: at, Ranges: []bnf.RuneRange{{Low: low, High: low}}}, nil
+	}
+	return &bnf.Node{Kind: bnf.Text, At: at, Text: string(runes), Sensitive: true}, nil
+}
+
+func (p *parser) value(base int) (rune, error) {
+	start := p.At
+	for p.At < len(p.Src) && isDigit(p.Src[p.At], base) {
+		p.At++
+	}
+	if p.At == start {
+		return 0, p.Unexpected()
+	}
+	v, err := strconv.ParseInt(p.Src[start:p.At], base, 32)
+	if err != nil || v > utf8.MaxRune {
+		return 0, p.Errorf(start, "invalid terminal value %q", p.Src[start:p.At])
+	}
+	return rune(v), nil
+}
+
+func (p *parser) decimal() (int, bool) {
+	start := p.At
+	for p.At < len(p.Src) && isDigit(p.Src[p.At], 10) {
+		p.At++
+	}
+	if p.At == start {
+		return 0, false
+	}
+	n, err := strconv.Atoi(p.Src[start:p.At])
+	if err != nil {
+		p.At = start
+		return 0, false
+	}
+	return n, true
+}
+
+// rulename = ALPHA *(ALPHA / DIGIT / "-")
+func (p *parser) rulename() string {
+	if !p.atRulename() {
+		return ""
+	}
+	start := p.At
+	for p.At < len(p.Src) {
+		ch := p.Src[p.At]
+		if isAlpha(ch) || isDigit(ch, 10) || ch == '-' {
+			p.At++
+		} else {
+			break
+		}
+	}
+	return p.Src[start:p.At]
+}
+
+func (p *parser) atRulename() bool {
+	return p.At < len(p.Src) && isAlpha(p.Src[p.At])
+}
+
+func (p *parser) atRepetition() bool {
+	if p.EOF() {
+		return false
+	}
+	ch := p.Src[p.At]
+	return isAlpha(ch) || isDigit(ch, 10) || strings.IndexByte("*([\"%<", ch) >= 0
+}
+
+// Skips *c-wsp, where c-wsp = WSP / (c-nl WSP).
+func (p *parser) skipCWSP() {
+	for p.At < len(p.Src) {
+		switch p.Src[p.At] {
+		case ' ', '\t':
+			p.At++
+		case ';':
+			i := strings.IndexAny(p.Src[p.At:], "\r\n")
+			if i < 0 {
+				p.At = len(p.Src)
+				return
+			}
+			p.At += i
+		default:
+			save := p.At
+			if !p.skipNewline() || !(p.Peek(" ") || p.Peek("\t")) {
+				p.At = save
+				return
+			}
+		}
+	}
+}
+
+// Skips lines only containing white spaces and comments.
+func (p *parser) skipEmptyLines() {
+	for p.At < len(p.Src) {
+		save := p.At
+		for p.Peek(" ") || p.Peek("\t") {
+			p.At++
+		}
+		if p.Peek(";") {
+			i := strings.IndexAny(p.Src[p.At:], "\r\n")
+			if i < 0 {
+				p.At = len(p.Src)
+				return
+			}
+			p.At += i
+		}
+		if !p.skipNewline() {
+			if p.At < len(p.Src) {
+				p.At = save
+			}
+			return
+		}
+	}
+}
+
+// Skips CRLF, being tolerant of LF.
+func (p *parser) skipNewline() bool {
+	return p.Skip("\r\n") || p.Skip("\n")
+}
+
+func isAlpha(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isDigit(ch byte, base int) bool {
+	switch base {
+	case 2:
+		return ch == '0' || ch == '1'
+	case 10:
+		return ch >= '0' && ch <= '9'
+	default:
+		return (ch >= '0' && ch <= '9') ||
+			(ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+	}
+}
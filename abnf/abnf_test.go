@@ -0,0 +1,204 @@
+package abnf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hucsmn/peg"
+)
+
+type fullMatchTestData struct {
+	text string
+	full bool
+	pat  peg.Pattern
+}
+
+func runFullMatchTestData(t *testing.T, data fullMatchTestData) {
+	full := peg.IsFullMatched(data.pat, data.text)
+	if full != data.full {
+		t.Errorf("RESULT DISMATCH: IsFullMatched(%s, %q) => %t != %t\n",
+			data.pat, data.text, full, data.full)
+	}
+}
+
+func mustCompile(t *testing.T, src, entry string) peg.Pattern {
+	pat, _, err := Compile(src, entry)
+	if err != nil {
+		t.Fatalf("Compile(%q, %q) => %s", src, entry, err)
+	}
+	return pat
+}
+
+func TestCompile(t *testing.T) {
+	uri := mustCompile(t, `
+; simplified from RFC 3986
+URI         = scheme ":" hier-part
+scheme      = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+hier-part   = "//" authority path
+            / path
+authority   = host [ ":" port ]
+host        = 1*( ALPHA / DIGIT / "-" / "." )
+port        = *DIGIT
+path        = *( "/" *pchar )
+pchar       = ALPHA / DIGIT / %x2D.2E / "_" / "~"
+`, "uri")
+	values := mustCompile(t, `
+values = %d65 %x42 %b1000011 %x44.45 %x30-39
+`, "VALUES")
+	repeats := mustCompile(t, "r = 2*3\"a\" 2\"b\" *1\"c\" 1*\"d\"\r\n", "r")
+	incremental := mustCompile(t, `
+ruleset = alt1 / alt2
+ruleset =/ alt3
+ruleset =/ alt4 / alt5
+alt1 = "1"
+alt2 = "2"
+alt3 = "3"
+alt4 = "4"
+alt5 = "5"
+`, "ruleset")
+	cases := mustCompile(t, `
+cases = "abc" %s"Def" %i"ghi"
+`, "cases")
+	continued := mustCompile(t, "r = \"a\" ; comment\n    \"b\"\n\n  ; comment\nx = \"x\"\n", "r")
+	overridden := mustCompile(t, `
+r     = DIGIT
+DIGIT = "x"
+`, "r")
+	extended := mustCompile(t, `
+r     = 1*DIGIT
+DIGIT =/ "x"
+`, "r")
+
+	data := []fullMatchTestData{
+		{"http://example.com:80/a/b", true, uri},
+		{"file:/a/b", true, uri},
+		{"1http://", false, uri},
+
+		{"1x2", true, extended},
+		{"1y", false, extended},
+
+		{"ABCDE5", true, values},
+		{"abCDE5", false, values},
+
+		{"aabbcd", true, repeats},
+		{"aaabbdd", true, repeats},
+		{"abbcd", false, repeats},
+		{"aabbccd", false, repeats},
+		{"aabbc", false, repeats},
+
+		{"1", true, incremental},
+		{"3", true, incremental},
+		{"5", true, incremental},
+		{"6", false, incremental},
+
+		{"ABCDefGHI", true, cases},
+		{"abcdefghi", false, cases},
+
+		{"ab", true, continued},
+		{"x", true, overridden},
+		{"1", false, overridden},
+	}
+	for _, d := range data {
+		runFullMatchTestData(t, d)
+	}
+
+	for name, text := range map[string]string{
+		"alpha":  "Z",
+		"bit":    "1",
+		"crlf":   "\r\n",
+		"hexdig": "f",
+		"lwsp":   " \t\r\n ",
+		"octet":  "\xc3\xbf",
+		"vchar":  "~",
+	} {
+		if !peg.IsFullMatched(peg.Let(CoreRules, peg.V(name)), text) {
+			t.Errorf("core rule %s should match %q", name, text)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	data := []struct {
+		src  string
+		line int
+		col  int
+	}{
+		{`r = (`, 1, 6},
+		{`r = "abc`, 1, 9},
+		{`r = <prose`, 1, 11},
+		{`r = x`, 1, 5},
+		{`r = %q`, 1, 6},
+		{`r = %x39-30`, 1, 5},
+		{`r = 3*2"a"`, 1, 5},
+		{"r = \"a\"\nr = \"b\"", 2, 1},
+		{"r = \"a\"\ns =/ \"b\"", 2, 1},
+		{"r \"a\"", 1, 3},
+	}
+	for _, d := range data {
+		_, _, err := Compile(d.src, "r")
+		synerr, ok := err.(*peg.SyntaxError)
+		if !ok {
+			t.Errorf("Compile(%q) => %v, expect syntax error", d.src, err)
+			continue
+		}
+		if synerr.Position.Line+1 != d.line || synerr.Position.Column+1 != d.col {
+			t.Errorf("Compile(%q) => %s, expect error at %d:%d",
+				d.src, synerr, d.line, d.col)
+		}
+	}
+
+	if _, _, err := Compile(`r = "a"`, "s"); err == nil {
+		t.Errorf("Compile with undefined entry should fail")
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	data := []struct {
+		src      string
+		warnings []string
+	}{
+		{`r = "a" / "b"`, nil},
+		{`r = "ab" / "ac"`, nil},
+		{`r = 1*DIGIT "." 1*DIGIT`, nil},
+		{`r = [ "+" / "-" ] 1*DIGIT`, nil},
+		{`r = "a" / "ab"`, []string{
+			`1:5: r: alternative 1 could match "a", which is a prefix of text matched by alternative 2`,
+		}},
+		{`r = "A" / "ab"`, []string{
+			`1:5: r: alternative 1 could match "a", which is a prefix of text matched by alternative 2`,
+		}},
+		{`r = DIGIT / %x31-39 DIGIT`, []string{
+			`1:5: r: alternative 1 could match "1", which is a prefix of text matched by alternative 2`,
+		}},
+		{`r = "x" / 1*"x" "y"`, []string{
+			`1:5: r: alternative 1 could match "x", which is a prefix of text matched by alternative 2`,
+		}},
+		{`r = *"a" / "b"`, []string{
+			`1:5: r: alternative 1 could match the empty string, the later alternatives are unreachable`,
+		}},
+		{`r = *ALPHA "x"`, []string{
+			`1:5: r: repetition is greedy, it may consume text required by the following elements`,
+		}},
+		{"r = [\"a\"] s\ns = \"A\"", []string{
+			`1:5: r: repetition is greedy, it may consume text required by the following elements`,
+		}},
+	}
+	for _, d := range data {
+		_, warnings, err := Rules(d.src)
+		if err != nil {
+			t.Errorf("Rules(%q) => %s", d.src, err)
+			continue
+		}
+		var got []string
+		for _, w := range warnings {
+			got = append(got, w.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(d.warnings, "\n") {
+			t.Errorf("Rules(%q) warns %q, expect %q", d.src, got, d.warnings)
+		}
+	}
+
+	if _, warnings, _ := Rules(coreSource); len(warnings) != 0 {
+		t.Errorf("core rules should not warn: %v", warnings)
+	}
+}
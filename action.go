@@ -0,0 +1,109 @@
+package peg
+
+import "fmt"
+
+// Underlying types implemented Pattern interface.
+type (
+	patternAction struct {
+		pat Pattern
+		fn  ActionFunc
+	}
+
+	patternActionText struct {
+		pat Pattern
+		fn  TextActionFunc
+	}
+)
+
+// Action computes a semantic value from the values computed inside pat,
+// like `$$` of yacc. The values are plain Go values, thus no wrapper type
+// implementing Capture is needed.
+//
+// The values computed by dismatched patterns are discarded on backtracking.
+// The values of the outermost actions are stored into Result.Values.
+func Action(fn ActionFunc, pat Pattern) Pattern {
+	return &patternAction{pat: pat, fn: fn}
+}
+
+// ActionText computes a semantic value from the text matched by pat.
+func ActionText(fn TextActionFunc, pat Pattern) Pattern {
+	return &patternActionText{pat: pat, fn: fn}
+}
+
+// Eval runs pattern matching on given text, guaranteeing that the text must
+// only be full-matched when success. Returns the last value computed by the
+// outermost actions, nil if there is no value.
+func Eval(pat Pattern, text string) (value interface{}, err error) {
+	return defaultConfig.Eval(pat, text)
+}
+
+// Eval runs pattern matching on given text, guaranteeing that the text must
+// only be full-matched when success. Returns the last value computed by the
+// outermost actions, nil if there is no value.
+func (cfg Config) Eval(pat Pattern, text string) (value interface{}, err error) {
+	// enable actions.
+	config := cfg
+	config.DisableActions = false
+	r, err := config.Match(pat, text)
+	if err != nil {
+		return nil, err
+	}
+	if !r.Ok {
+		return nil, errorDismatch
+	}
+	if r.N != len(text) {
+		return nil, errorNotFullMatched
+	}
+	if len(r.Values) == 0 {
+		return nil, nil
+	}
+	return r.Values[len(r.Values)-1], nil
+}
+
+// Computes the value from values inside.
+func (pat *patternAction) match(ctx *context) error {
+	if !ctx.justReturned() {
+		ctx.beginValues()
+		return ctx.call(pat.pat)
+	}
+
+	ret := ctx.ret
+	vals := ctx.endValues()
+	if ret.ok && ctx.acting() {
+		val, err := pat.fn(vals)
+		if err != nil {
+			return err
+		}
+		ctx.pushValue(val)
+	}
+	return ctx.returns(ret)
+}
+
+// Computes the value from matched text.
+func (pat *patternActionText) match(ctx *context) error {
+	if !ctx.justReturned() {
+		return ctx.call(pat.pat)
+	}
+
+	ret := ctx.ret
+	if !ret.ok {
+		return ctx.predicates(false)
+	}
+	ctx.consume(ret.n)
+	if ctx.acting() {
+		val, err := pat.fn(ctx.span())
+		if err != nil {
+			return err
+		}
+		ctx.pushValue(val)
+	}
+	return ctx.commit()
+}
+
+func (pat *patternAction) String() string {
+	return fmt.Sprintf("action_%p{%s}", pat.fn, pat.pat)
+}
+
+func (pat *patternActionText) String() string {
+	return fmt.Sprintf("actiontext_%p{%s}", pat.fn, pat.pat)
+}
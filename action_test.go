@@ -0,0 +1,102 @@
+package peg
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// Tests Action, ActionText and Eval.
+func TestAction(t *testing.T) {
+	number := ActionText(func(s string) (interface{}, error) {
+		return strconv.Atoi(s)
+	}, Q1(R('0', '9')))
+	op := ActionText(func(s string) (interface{}, error) {
+		return s, nil
+	}, S("+-*/"))
+	eval := func(vals []interface{}) (interface{}, error) {
+		x := vals[0].(int)
+		for i := 1; i+1 < len(vals); i += 2 {
+			y := vals[i+1].(int)
+			switch vals[i].(string) {
+			case "+":
+				x += y
+			case "-":
+				x -= y
+			case "*":
+				x *= y
+			case "/":
+				if y == 0 {
+					return nil, errors.New("division by zero")
+				}
+				x /= y
+			}
+		}
+		return x, nil
+	}
+	calc := Let(map[string]Pattern{
+		"factor": Alt(number, Seq(T("("), V("expr"), T(")"))),
+		"term":   Action(eval, J1(V("factor"), Seq(Test(S("*/")), op))),
+		"expr":   Action(eval, J1(V("term"), Seq(Test(S("+-")), op))),
+	}, V("expr"))
+
+	data := []struct {
+		text  string
+		value interface{}
+		err   bool
+	}{
+		{"1", 1, false},
+		{"1+2*3", 7, false},
+		{"(1+2)*3", 9, false},
+		{"1-2*((3+4)/5+6*(7-8))/9", 2, false},
+		{"1/0", nil, true},
+		{"1+", nil, true},
+		{"", nil, true},
+	}
+	for _, d := range data {
+		value, err := Eval(calc, d.text)
+		if (err != nil) != d.err || value != d.value {
+			t.Errorf("Eval(%q) => %v, %v", d.text, value, err)
+		}
+	}
+
+	// values of the dismatched branches are discarded.
+	list := func(vals []interface{}) (interface{}, error) {
+		return fmt.Sprint(vals), nil
+	}
+	text := func(s string) (interface{}, error) {
+		return s, nil
+	}
+	pats := []struct {
+		pat  Pattern
+		text string
+		vals string
+	}{
+		{Alt(Seq(ActionText(text, T("a")), T("b")), Seq(ActionText(text, T("a")), T("c"))), "ac", `[a]`},
+		{Q0(Seq(ActionText(text, Dot), T(","))), "a,b,c", `[a b]`},
+		{Action(list, Seq(ActionText(text, T("a")), Alt(Seq(ActionText(text, T("b")), False), True))), "ab", `[[a]]`},
+		{Seq(ActionText(text, T("a")), ActionText(text, T("b"))), "ab", `[a b]`},
+		{Action(list, True), "", `[[]]`},
+	}
+	for _, d := range pats {
+		r, err := Match(d.pat, d.text)
+		if err != nil || !r.Ok {
+			t.Errorf("Match(%s, %q) => %v, %v", d.pat, d.text, r, err)
+			continue
+		}
+		if got := fmt.Sprint(r.Values); got != d.vals {
+			t.Errorf("Match(%s, %q).Values => %s, expect %s", d.pat, d.text, got, d.vals)
+		}
+	}
+
+	// actions are skipped if disabled.
+	called := false
+	pat := ActionText(func(s string) (interface{}, error) {
+		called = true
+		return s, nil
+	}, T("a"))
+	if !IsFullMatched(pat, "a") || called {
+		t.Errorf("IsFullMatched(%s) => action called %t", pat, called)
+	}
+}
@@ -0,0 +1,479 @@
+package peg
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// BindError reports the failed conversion of captured text in Bind.
+type BindError struct {
+	Position Position
+	Field    string // path of the field, such as "Terms[1].Value"
+	Text     string
+	Err      error
+}
+
+// Capture used by Bind, the named node or span of text.
+type bindCapture struct {
+	name     string
+	node     bool // node of variable, or span of text
+	text     string
+	pos      Position
+	subs     []Capture
+	children []*bindCapture
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	positionType        = reflect.TypeOf(Position{})
+)
+
+// Bind matches the full text, then fills the struct pointed by target with
+// the captures.
+//
+// The exported fields are bound to the captures of CV and NG of the same
+// names (the name is the field name, or specified by the field tag like
+// `peg:"name"`, the fields tagged `peg:"-"` are ignored). CV binds a nested
+// struct with the captures inside the variable, while NG binds the matched
+// text converted into string, bool, numbers, or the types implementing
+// encoding.TextUnmarshaler. Slices collect all the captures of the name,
+// pointers are allocated only if the name is captured, otherwise the last
+// capture is taken.
+//
+// The fields tagged `peg:"@pos"` (of type Position) and `peg:"@text"` are
+// bound to the start position and the matched text of the struct.
+//
+// The rules defined in CLet are bound as if they are invoked by CV, except
+// the rules marked by Silent. The rules marked by Transparent are collapsed
+// into the parent if there is only one capture of CV or NG inside.
+//
+// The captures of CV and NG kept by the other captures, in the Subs of
+// Variable or the elements of Table (such as constructed by CC, CX or Ct),
+// are bound as well, while the other captures (such as Cc, Cp, Cb and Carg)
+// are ignored. An error occurs if the captures of CV or NG are dropped by
+// the constructors of CC and CX, the folder of Cf or the function of Cmt,
+// or nested inside CT and CK. Conversion errors are reported as *BindError with
+// the position of the capture.
+func Bind(target interface{}, pat Pattern, text string) error {
+	return defaultConfig.Bind(target, pat, text)
+}
+
+// Bind matches the full text, then fills the struct pointed by target with
+// the captures, see Bind.
+func (cfg Config) Bind(target interface{}, pat Pattern, text string) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errorf("bind target should be a non-nil struct pointer")
+	}
+	if err := checkBindType(v.Elem().Type(), make(map[reflect.Type]bool)); err != nil {
+		return err
+	}
+	if pat == nil {
+		return errorNilMainPattern
+	}
+
+	pat = Transform(pat, func(pat Pattern) Pattern {
+		switch pat := pat.(type) {
+		case *patternCaptureVariable:
+			if pat.cons == nil {
+				return nil
+			}
+			return CX(newBindNodeConstructor(pat.varname), V(pat.varname))
+		case *patternGrouping:
+			if pat.grpname != "" {
+				return CT(newBindSpanConstructor(pat.grpname), pat)
+			}
+		case *patternLet:
+			if pat.auto {
+				return newBindLet(pat)
+			}
+		case *patternCaptureCons:
+			if pat.cons != nil {
+				return CC(newBindKeptConstructor(pat, pat.cons), pat.pat)
+			}
+		case *patternCaptureContext:
+			if pat.cons != nil {
+				return CX(newBindKeptContextConstructor(pat, pat.cons), pat.pat)
+			}
+		case *patternCaptureFold:
+			if pat.fold != nil {
+				return Cf(newBindKeptFolder(pat, pat.fold), pat.pat)
+			}
+		case *patternCaptureMatchTime:
+			if pat.fn != nil {
+				return Cmt(newBindKeptMatchTimeFunc(pat, pat.fn), pat.pat)
+			}
+		case *patternCaptureTerm:
+			return CT(pat.cons, Cmt(newBindDroppedChecker(pat), pat.pat))
+		case *patternCaptureToken:
+			return CK(pat.toktype, Cmt(newBindDroppedChecker(pat), pat.pat))
+		}
+		return nil
+	})
+	caps, err := cfg.Parse(pat, text)
+	if err != nil {
+		return err
+	}
+	root := &bindCapture{node: true, text: text, subs: caps}
+	return bindStruct(v.Elem(), normalizeBindCapture(root), "")
+}
+
+func (err *BindError) Error() string {
+	return fmt.Sprintf("peg: bind error at %d:%d: %s: cannot convert %q: %s",
+		err.Position.Line+1, err.Position.Column+1, err.Field, err.Text, err.Err)
+}
+
+func (cap *bindCapture) IsTerminal() bool {
+	return !cap.node
+}
+
+func newBindNodeConstructor(name string) ContextConstructor {
+	return func(cc CaptureContext, subs []Capture) (Capture, error) {
+		if len(subs) == 1 {
+			// CV invoking the rule captured automatically
+			if sub, ok := subs[0].(*bindCapture); ok && sub.node && sub.name == name &&
+				sub.pos == cc.Start && sub.text == cc.Text {
+				return sub, nil
+			}
+		}
+		return &bindCapture{name: name, node: true, text: cc.Text, pos: cc.Start, subs: subs}, nil
+	}
+}
+
+// Collapses the rule marked by Transparent if there is only one capture
+// used by Bind inside.
+func newBindTransparentConstructor(name string) ContextConstructor {
+	node := newBindNodeConstructor(name)
+	return func(cc CaptureContext, subs []Capture) (Capture, error) {
+		if found := collectBindCaptures(subs, nil); len(found) == 1 {
+			return found[0], nil
+		}
+		return node(cc, subs)
+	}
+}
+
+// Captures the rules of CLet as CV does, except the rules marked by Silent.
+func newBindLet(pat *patternLet) Pattern {
+	vars := make(map[string]Pattern, len(pat.vars))
+	for name, rule := range pat.vars {
+		switch rule := rule.(type) {
+		case *patternTemplate:
+			vars[name] = rule
+		case *patternRule:
+			if rule.transparent {
+				vars[name] = CX(newBindTransparentConstructor(name), rule)
+			} else {
+				vars[name] = rule
+			}
+		default:
+			vars[name] = CX(newBindNodeConstructor(name), rule)
+		}
+	}
+	return &patternLet{pat: pat.pat, vars: vars}
+}
+
+func newBindSpanConstructor(name string) TerminalConstructor {
+	return func(span string, pos Position) (Capture, error) {
+		return &bindCapture{name: name, text: span, pos: pos}, nil
+	}
+}
+
+// Checks that the captures of CV and NG are kept by the constructor of CC.
+func newBindKeptConstructor(pat Pattern, cons NonTerminalConstructor) NonTerminalConstructor {
+	return func(subs []Capture) (Capture, error) {
+		cap, err := cons(subs)
+		if err != nil {
+			return nil, err
+		}
+		return cap, checkBindKept(pat, subs, []Capture{cap})
+	}
+}
+
+// Checks that the captures of CV and NG are kept by the constructor of CX.
+func newBindKeptContextConstructor(pat Pattern, cons ContextConstructor) ContextConstructor {
+	return func(cc CaptureContext, subs []Capture) (Capture, error) {
+		cap, err := cons(cc, subs)
+		if err != nil {
+			return nil, err
+		}
+		return cap, checkBindKept(pat, subs, []Capture{cap})
+	}
+}
+
+// Rejects the captures of CV and NG inside the terminal captures.
+func newBindDroppedChecker(pat Pattern) MatchTimeFunc {
+	return func(_ CaptureContext, subs []Capture) (bool, []Capture, error) {
+		if dropped := collectBindCaptures(subs, nil); len(dropped) != 0 {
+			return false, nil, errorf("bind capture %q is dropped by %s", dropped[0].name, pat)
+		}
+		return true, subs, nil
+	}
+}
+
+// Checks that the captures of CV and NG are kept by the folder of Cf.
+func newBindKeptFolder(pat Pattern, fold Folder) Folder {
+	return func(acc Capture, cap Capture) (Capture, error) {
+		folded, err := fold(acc, cap)
+		if err != nil {
+			return nil, err
+		}
+		return folded, checkBindKept(pat, []Capture{acc, cap}, []Capture{folded})
+	}
+}
+
+// Checks that the captures of CV and NG are kept by the function of Cmt.
+func newBindKeptMatchTimeFunc(pat Pattern, fn MatchTimeFunc) MatchTimeFunc {
+	return func(cc CaptureContext, subs []Capture) (bool, []Capture, error) {
+		ok, caps, err := fn(cc, subs)
+		if err != nil || !ok {
+			return ok, caps, err
+		}
+		return true, caps, checkBindKept(pat, subs, caps)
+	}
+}
+
+func checkBindKept(pat Pattern, subs []Capture, caps []Capture) error {
+	kept := collectBindCaptures(caps, nil)
+	for _, sub := range collectBindCaptures(subs, nil) {
+		found := false
+		for _, k := range kept {
+			if k == sub {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errorf("bind capture %q is dropped by %s", sub.name, pat)
+		}
+	}
+	return nil
+}
+
+// Collects the outermost captures used by Bind, looking into the Subs of
+// Variable and the elements of Table.
+func collectBindCaptures(caps []Capture, found []*bindCapture) []*bindCapture {
+	for _, cap := range caps {
+		switch cap := cap.(type) {
+		case *bindCapture:
+			found = append(found, cap)
+		case *Variable:
+			found = collectBindCaptures(cap.Subs, found)
+		case Table:
+			found = collectBindCaptures(cap, found)
+		}
+	}
+	return found
+}
+
+// Collects the captures used by Bind, the others are ignored.
+func normalizeBindCapture(node *bindCapture) *bindCapture {
+	node.children = appendBindChildren(node.children, node.subs)
+	return node
+}
+
+func appendBindChildren(children []*bindCapture, caps []Capture) []*bindCapture {
+	for _, cap := range caps {
+		switch cap := cap.(type) {
+		case *bindCapture:
+			if cap.node {
+				normalizeBindCapture(cap)
+			}
+			children = append(children, cap)
+		case *Variable:
+			children = appendBindChildren(children, cap.Subs)
+		case Table:
+			children = appendBindChildren(children, cap)
+		}
+	}
+	return children
+}
+
+// Checks if the struct type could be bound.
+func checkBindType(typ reflect.Type, checked map[reflect.Type]bool) error {
+	if checked[typ] {
+		return nil
+	}
+	checked[typ] = true
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, ok := bindFieldName(field)
+		if !ok {
+			continue
+		}
+		switch name {
+		case "@pos":
+			if field.Type != positionType {
+				return errorf("bind field %s tagged @pos should be Position", field.Name)
+			}
+			continue
+		case "@text":
+			if !isBindTextType(field.Type) {
+				return errorf("bind field %s tagged @text should be text convertible", field.Name)
+			}
+			continue
+		}
+
+		ftype := field.Type
+		if ftype.Kind() == reflect.Slice && !isBindTextType(ftype) {
+			ftype = ftype.Elem()
+		}
+		if ftype.Kind() == reflect.Ptr {
+			ftype = ftype.Elem()
+		}
+		if isBindTextType(ftype) {
+			continue
+		}
+		if ftype.Kind() != reflect.Struct {
+			return errorf("bind field %s has unsupported type %s", field.Name, field.Type)
+		}
+		if err := checkBindType(ftype, checked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gets the capture name of field.
+func bindFieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	name := field.Tag.Get("peg")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
+
+// Tells if the type is converted from text.
+func isBindTextType(typ reflect.Type) bool {
+	if reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// Binds the fields of struct.
+func bindStruct(v reflect.Value, node *bindCapture, path string) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		name, ok := bindFieldName(typ.Field(i))
+		if !ok {
+			continue
+		}
+		field := v.Field(i)
+		fpath := typ.Field(i).Name
+		if path != "" {
+			fpath = path + "." + fpath
+		}
+
+		switch name {
+		case "@pos":
+			field.Set(reflect.ValueOf(node.pos))
+			continue
+		case "@text":
+			if err := bindText(field, node, fpath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var caps []*bindCapture
+		for _, cap := range node.children {
+			if cap.name == name {
+				caps = append(caps, cap)
+			}
+		}
+		if len(caps) == 0 {
+			continue
+		}
+
+		if field.Kind() == reflect.Slice && !isBindTextType(field.Type()) {
+			items := reflect.MakeSlice(field.Type(), len(caps), len(caps))
+			for j, cap := range caps {
+				err := bindValue(items.Index(j), cap, fmt.Sprintf("%s[%d]", fpath, j))
+				if err != nil {
+					return err
+				}
+			}
+			field.Set(items)
+			continue
+		}
+		if err := bindValue(field, caps[len(caps)-1], fpath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Binds the capture to a value.
+func bindValue(v reflect.Value, cap *bindCapture, path string) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if isBindTextType(v.Type()) {
+		return bindText(v, cap, path)
+	}
+	if !cap.node {
+		return &BindError{
+			Position: cap.pos,
+			Field:    path,
+			Text:     cap.text,
+			Err:      errorf("struct requires variable capture"),
+		}
+	}
+	return bindStruct(v, cap, path)
+}
+
+// Converts the matched text into value.
+func bindText(v reflect.Value, cap *bindCapture, path string) error {
+	var err error
+	text := cap.text
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		err = u.UnmarshalText([]byte(text))
+	} else {
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(text)
+		case reflect.Bool:
+			var b bool
+			b, err = strconv.ParseBool(text)
+			v.SetBool(b)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var n int64
+			n, err = strconv.ParseInt(strings.TrimPrefix(text, "+"), 0, v.Type().Bits())
+			v.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			var n uint64
+			n, err = strconv.ParseUint(strings.TrimPrefix(text, "+"), 0, v.Type().Bits())
+			v.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			var f float64
+			f, err = strconv.ParseFloat(text, v.Type().Bits())
+			v.SetFloat(f)
+		}
+	}
+	if err != nil {
+		if numerr, ok := err.(*strconv.NumError); ok {
+			err = numerr.Err
+		}
+		return &BindError{Position: cap.pos, Field: path, Text: text, Err: err}
+	}
+	return nil
+}
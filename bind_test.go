@@ -0,0 +1,222 @@
+package peg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Upper-cased word, for testing encoding.TextUnmarshaler.
+type bindTestWord string
+
+type bindTestEntry struct {
+	Pos   Position `peg:"@pos"`
+	Text  string   `peg:"@text"`
+	Key   bindTestWord
+	Value int
+	Flags []string `peg:"flag"`
+}
+
+type bindTestSection struct {
+	Name    string          `peg:"name"`
+	Entries []bindTestEntry `peg:"entry"`
+	Comment *string         `peg:"comment"`
+	Ignored string          `peg:"-"`
+	private string
+}
+
+type bindTestConfig struct {
+	Sections []*bindTestSection `peg:"section"`
+	Version  *float64           `peg:"version"`
+}
+
+func (w *bindTestWord) UnmarshalText(text []byte) error {
+	if len(text) > 8 {
+		return fmt.Errorf("word too long")
+	}
+	*w = bindTestWord(strings.ToUpper(string(text)))
+	return nil
+}
+
+// Grammar of sections like `[name] key=1 +flag; # comment`.
+func bindTestGrammar() Pattern {
+	word := Q1(R('a', 'z'))
+	return Let(map[string]Pattern{
+		"ws":      Q0(S(" \n")),
+		"entry":   Seq(NG("Key", word), T("="), NG("Value", Seq(Q01(T("-")), Q1(R('0', '9')))), Q0(Seq(T(" +"), NG("flag", word))), T(";")),
+		"section": Seq(T("["), NG("name", word), T("]"), Q0(Seq(V("ws"), CV("entry"))), Q01(Seq(V("ws"), T("# "), NG("comment", word)))),
+		"config":  Seq(Q01(Seq(T("v"), NG("version", Seq(R('0', '9'), T("."), R('0', '9'))), V("ws"))), J0(CV("section"), V("ws"))),
+	}, V("config"))
+}
+
+func TestBind(t *testing.T) {
+	var cfg bindTestConfig
+	text := "v1.5\n[main] a=1 +x +y; bb=-2;\n[misc] # note"
+	if err := Bind(&cfg, bindTestGrammar(), text); err != nil {
+		t.Fatalf("Bind(%q) => %v", text, err)
+	}
+	if cfg.Version == nil || *cfg.Version != 1.5 || len(cfg.Sections) != 2 {
+		t.Fatalf("Bind(%q) => %+v", text, cfg)
+	}
+
+	main, misc := cfg.Sections[0], cfg.Sections[1]
+	if main.Name != "main" || main.Comment != nil || len(main.Entries) != 2 {
+		t.Errorf("section main => %+v", main)
+	} else {
+		a, bb := main.Entries[0], main.Entries[1]
+		if a.Key != "A" || a.Value != 1 || fmt.Sprint(a.Flags) != "[x y]" || a.Text != "a=1 +x +y;" {
+			t.Errorf("entry a => %+v", a)
+		}
+		if bb.Key != "BB" || bb.Value != -2 || bb.Flags != nil {
+			t.Errorf("entry bb => %+v", bb)
+		}
+		if a.Pos.String() != "2:8+12" || bb.Pos.String() != "2:19+23" {
+			t.Errorf("positions of entries => %s, %s", a.Pos.String(), bb.Pos.String())
+		}
+	}
+	if misc.Name != "misc" || misc.Comment == nil || *misc.Comment != "note" || misc.Entries != nil {
+		t.Errorf("section misc => %+v", misc)
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	data := []struct {
+		text  string
+		field string
+		pos   string
+	}{
+		{"[main] a=1;\n[next] abcdefghi=2;", "Sections[1].Entries[0].Key", "2:8+19"},
+		{"[main] a=1; b=99999999999999999999;", "Sections[0].Entries[1].Value", "1:15+14"},
+		{"v9.9\n[main]\n[main] x=1;\n[main] y=-9999999999999999999;", "Sections[2].Entries[0].Value", "4:10+33"},
+	}
+	for _, d := range data {
+		var cfg bindTestConfig
+		err := Bind(&cfg, bindTestGrammar(), d.text)
+		berr, ok := err.(*BindError)
+		if !ok {
+			t.Errorf("Bind(%q) => %v, expect *BindError", d.text, err)
+			continue
+		}
+		if berr.Field != d.field || berr.Position.String() != d.pos {
+			t.Errorf("Bind(%q) => %s at %s, expect %s at %s",
+				d.text, berr.Field, berr.Position.String(), d.field, d.pos)
+		}
+	}
+
+	var cfg bindTestConfig
+	if err := Bind(&cfg, bindTestGrammar(), "[main] a=1"); err == nil {
+		t.Errorf("Bind of dismatched text succeeded")
+	}
+	if err := Bind(cfg, bindTestGrammar(), ""); err == nil {
+		t.Errorf("Bind to non-pointer succeeded")
+	}
+	var bad struct {
+		Ch chan int
+	}
+	if err := Bind(&bad, True, ""); err == nil {
+		t.Errorf("Bind to unsupported field type succeeded")
+	}
+	var leaf struct {
+		Sub struct{ X string }
+	}
+	if _, ok := Bind(&leaf, NG("Sub", Dot), "x").(*BindError); !ok {
+		t.Errorf("Bind text to struct field succeeded")
+	}
+}
+
+// Test the captures of CV and NG kept or dropped by the other captures.
+func TestBindNested(t *testing.T) {
+	var v struct {
+		X string
+		Y int
+	}
+	word, number := Q1(R('a', 'z')), Q1(R('0', '9'))
+	pat := Seq(CC(genJoin, NG("X", word)), T("="), CX(genSpan, NG("Y", number)))
+	if err := Bind(&v, pat, "ab=12"); err != nil || v.X != "ab" || v.Y != 12 {
+		t.Errorf("Bind(CC, CX) => %+v, %v", v, err)
+	}
+
+	dropped := []Pattern{
+		CC(func([]Capture) (Capture, error) { return Text("x"), nil }, NG("X", word)),
+		CX(func(CaptureContext, []Capture) (Capture, error) { return &Variable{}, nil }, NG("X", word)),
+		CT(genUpper, NG("X", word)),
+		CK(1, NG("X", word)),
+	}
+	for _, pat := range dropped {
+		if err := Bind(&v, pat, "ab"); err == nil {
+			t.Errorf("Bind(%s) dropping captures succeeded", pat)
+		}
+	}
+}
+
+// Test the rules of CLet bound as variables.
+func TestBindCLet(t *testing.T) {
+	type pair struct {
+		Key   string `peg:"word"` // collapsed from the transparent key
+		Value int    `peg:"value"`
+	}
+	var v struct {
+		Pairs []pair   `peg:"pair"`
+		Ws    string   `peg:"ws"`
+		Keys  []string `peg:"word"`
+	}
+	pat := CLet(map[string]Pattern{
+		"ws":    Silent(Q0(T(" "))),
+		"word":  Q1(R('a', 'z')),
+		"key":   Transparent(CV("word")),
+		"value": Q1(R('0', '9')),
+		"pair":  Seq(V("key"), T("="), V("value")),
+	}, J0(CV("pair"), V("ws")))
+	if err := Bind(&v, pat, "a=1 bc=23"); err != nil {
+		t.Fatalf("Bind(CLet) => %v", err)
+	}
+	if fmt.Sprint(v.Pairs) != "[{a 1} {bc 23}]" || v.Ws != "" || v.Keys != nil {
+		t.Errorf("Bind(CLet) => %+v", v)
+	}
+}
+
+// Test the LPeg-style captures around the captures of CV and NG.
+func TestBindLPegCaptures(t *testing.T) {
+	type item struct {
+		X string
+		Y int
+	}
+	word, number := NG("X", Q1(R('a', 'z'))), NG("Y", Q1(R('0', '9')))
+	keep := func(acc, cap Capture) (Capture, error) {
+		return Table{acc, cap}, nil
+	}
+	pass := func(_ CaptureContext, subs []Capture) (bool, []Capture, error) {
+		return true, subs, nil
+	}
+	cfg := defaultConfig
+	cfg.Arguments = []Capture{Text("arg")}
+	kept := []Pattern{
+		Seq(Cc(Text("c")), Cp, word, Cb("X"), Carg(0), number),
+		Ct(Seq(word, number)),
+		Cf(keep, Seq(word, number)),
+		Cmt(pass, Seq(word, number)),
+	}
+	for _, pat := range kept {
+		var v item
+		if err := cfg.Bind(&v, pat, "ab12"); err != nil || v != (item{"ab", 12}) {
+			t.Errorf("Bind(%s) => %+v, %v", pat, v, err)
+		}
+	}
+
+	drop := func(acc, cap Capture) (Capture, error) {
+		return cap, nil
+	}
+	replace := func(CaptureContext, []Capture) (bool, []Capture, error) {
+		return true, []Capture{Text("x")}, nil
+	}
+	dropped := []Pattern{
+		Cf(drop, Seq(word, number)),
+		Cmt(replace, Seq(word, number)),
+	}
+	for _, pat := range dropped {
+		var v item
+		if err := cfg.Bind(&v, pat, "ab12"); err == nil {
+			t.Errorf("Bind(%s) dropping captures succeeded", pat)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package peg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Kinds of the predefined captures in JSON.
+const (
+	jsonKindVariable = "variable"
+	jsonKindToken    = "token"
+	jsonKindTable    = "table"
+	jsonKindText     = "text"
+	jsonKindPosition = "position"
+)
+
+// JSON form of captures.
+type captureJSON struct {
+	Kind  string            `json:"kind"`
+	Name  string            `json:"name,omitempty"`
+	Type  int               `json:"type,omitempty"`
+	Value string            `json:"value,omitempty"`
+	Start *positionJSON     `json:"start,omitempty"`
+	End   *positionJSON     `json:"end,omitempty"`
+	Subs  []json.RawMessage `json:"subs,omitempty"`
+	Data  json.RawMessage   `json:"data,omitempty"`
+
+	// tells Variable.Subs or Table is nil or not, for lossless round trips.
+	Empty bool `json:"empty,omitempty"`
+}
+
+// JSON form of Position.
+type positionJSON struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// RegisterCapture registers a customed capture type to DefaultRegistry.
+//
+// Panics if the name is invalid or already registered, or the type is
+// registered more than once.
+func RegisterCapture(name string, sample Capture) {
+	DefaultRegistry.RegisterCapture(name, sample)
+}
+
+// RegisterCapture names the type of sample capture, which is encoded into
+// JSON by encoding/json, along with the registered name. The predefined
+// Variable, Token, Table, Text and *Position are always available.
+//
+// Panics if the name is invalid or already registered, or the type is
+// registered more than once.
+func (reg *Registry) RegisterCapture(name string, sample Capture) {
+	if !isRegistryName(name) || isPredefinedJSONKind(name) {
+		panic(errorf("invalid capture type name %q", name))
+	}
+	if _, ok := reg.types[name]; ok {
+		panic(errorf("capture type name %q is registered more than once", name))
+	}
+	if sample == nil {
+		panic(errorf("capture type name %q is not bound to a type", name))
+	}
+	typ := reflect.TypeOf(sample)
+	if _, ok := reg.typenames[typ]; ok {
+		panic(errorf("capture type %s is registered more than once", typ))
+	}
+	reg.types[name] = typ
+	reg.typenames[typ] = name
+}
+
+// MarshalCaptures encodes the captures into JSON, using DefaultRegistry.
+func MarshalCaptures(caps []Capture) ([]byte, error) {
+	return DefaultRegistry.MarshalCaptures(caps)
+}
+
+// UnmarshalCaptures decodes the captures from JSON, using DefaultRegistry.
+func UnmarshalCaptures(data []byte) ([]Capture, error) {
+	return DefaultRegistry.UnmarshalCaptures(data)
+}
+
+// MarshalCaptures encodes the captures into JSON, which is an array of the
+// objects like:
+//
+//     {"kind": "variable", "name": "call", "start": pos, "end": pos, "subs": [...]}
+//     {"kind": "token", "type": 1, "value": "print", "start": pos, "end": pos}
+//     {"kind": "table", "subs": [...]}
+//     {"kind": "text", "value": "print"}
+//     {"kind": "position", "start": pos}
+//     {"kind": "registered name", "data": ...}
+//
+// where the positions are like {"offset": 0, "line": 0, "column": 0}.
+// The predefined captures are decoded as they were, while the customed
+// captures should be registered by RegisterCapture.
+func (reg *Registry) MarshalCaptures(caps []Capture) ([]byte, error) {
+	items, err := reg.encodeCaptures(caps)
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []json.RawMessage{}
+	}
+	return json.Marshal(items)
+}
+
+// UnmarshalCaptures decodes the captures encoded by MarshalCaptures.
+func (reg *Registry) UnmarshalCaptures(data []byte) ([]Capture, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	return reg.decodeCaptures(items)
+}
+
+// MarshalJSON encodes the variable, see Registry.MarshalCaptures.
+func (v *Variable) MarshalJSON() ([]byte, error) {
+	return DefaultRegistry.encodeCapture(v)
+}
+
+// UnmarshalJSON decodes the variable, see Registry.UnmarshalCaptures.
+func (v *Variable) UnmarshalJSON(data []byte) error {
+	cap, err := DefaultRegistry.decodeCapture(data)
+	if err != nil {
+		return err
+	}
+	decoded, ok := cap.(*Variable)
+	if !ok {
+		return errorf("json of %s is not a variable", cap)
+	}
+	*v = *decoded
+	return nil
+}
+
+// MarshalJSON encodes the token, see Registry.MarshalCaptures.
+func (tok *Token) MarshalJSON() ([]byte, error) {
+	return DefaultRegistry.encodeCapture(tok)
+}
+
+// UnmarshalJSON decodes the token, see Registry.UnmarshalCaptures.
+func (tok *Token) UnmarshalJSON(data []byte) error {
+	cap, err := DefaultRegistry.decodeCapture(data)
+	if err != nil {
+		return err
+	}
+	decoded, ok := cap.(*Token)
+	if !ok {
+		return errorf("json of %s is not a token", cap)
+	}
+	*tok = *decoded
+	return nil
+}
+
+// FormatCaptures prints the capture trees indented, one capture per line,
+// with the spans of Variable and Token, such as:
+//
+//     call 1:1+0-1:9+8
+//       token 1 "print" 1:1+0-1:6+5
+//       args 1:7+6-1:8+7
+//
+// The children of QueryNode are printed as well.
+func FormatCaptures(caps []Capture) string {
+	var buf bytes.Buffer
+	formatCaptures(&buf, caps, 0)
+	return buf.String()
+}
+
+func formatCaptures(buf *bytes.Buffer, caps []Capture, depth int) {
+	for _, cap := range caps {
+		buf.WriteString(strings.Repeat("  ", depth))
+		switch cap := cap.(type) {
+		case *Variable:
+			fmt.Fprintf(buf, "%s %s-%s\n", cap.Name, cap.Start.String(), cap.End.String())
+			formatCaptures(buf, cap.Subs, depth+1)
+		case *Token:
+			fmt.Fprintf(buf, "token %d %q %s-%s\n", cap.Type, cap.Value, cap.Position.String(), cap.End.String())
+		case Table:
+			fmt.Fprintf(buf, "table\n")
+			formatCaptures(buf, cap, depth+1)
+		case QueryNode:
+			fmt.Fprintf(buf, "%v\n", cap)
+			formatCaptures(buf, cap.QueryChildren(), depth+1)
+		default:
+			fmt.Fprintf(buf, "%v\n", cap)
+		}
+	}
+}
+
+func (reg *Registry) encodeCaptures(caps []Capture) ([]json.RawMessage, error) {
+	if caps == nil {
+		return nil, nil
+	}
+	items := make([]json.RawMessage, len(caps))
+	for i, cap := range caps {
+		item, err := reg.encodeCapture(cap)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+func (reg *Registry) encodeCapture(cap Capture) ([]byte, error) {
+	var item captureJSON
+	switch cap := cap.(type) {
+	case *Variable:
+		subs, err := reg.encodeCaptures(cap.Subs)
+		if err != nil {
+			return nil, err
+		}
+		item = captureJSON{
+			Kind:  jsonKindVariable,
+			Name:  cap.Name,
+			Start: newPositionJSON(cap.Start),
+			End:   newPositionJSON(cap.End),
+			Subs:  subs,
+			Empty: cap.Subs != nil && len(cap.Subs) == 0,
+		}
+	case *Token:
+		item = captureJSON{
+			Kind:  jsonKindToken,
+			Type:  cap.Type,
+			Value: cap.Value,
+			Start: newPositionJSON(cap.Position),
+			End:   newPositionJSON(cap.End),
+		}
+	case Table:
+		subs, err := reg.encodeCaptures(cap)
+		if err != nil {
+			return nil, err
+		}
+		item = captureJSON{
+			Kind:  jsonKindTable,
+			Subs:  subs,
+			Empty: cap != nil && len(cap) == 0,
+		}
+	case Text:
+		item = captureJSON{Kind: jsonKindText, Value: string(cap)}
+	case *Position:
+		item = captureJSON{Kind: jsonKindPosition, Start: newPositionJSON(*cap)}
+	default:
+		name, ok := reg.typenames[reflect.TypeOf(cap)]
+		if !ok {
+			return nil, errorf("capture type %T is not registered", cap)
+		}
+		data, err := json.Marshal(cap)
+		if err != nil {
+			return nil, err
+		}
+		item = captureJSON{Kind: name, Data: data}
+	}
+	return json.Marshal(&item)
+}
+
+func (reg *Registry) decodeCaptures(items []json.RawMessage) ([]Capture, error) {
+	if items == nil {
+		return nil, nil
+	}
+	caps := make([]Capture, len(items))
+	for i, item := range items {
+		cap, err := reg.decodeCapture(item)
+		if err != nil {
+			return nil, err
+		}
+		caps[i] = cap
+	}
+	return caps, nil
+}
+
+func (reg *Registry) decodeCapture(data []byte) (Capture, error) {
+	var item captureJSON
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, err
+	}
+	switch item.Kind {
+	case jsonKindVariable:
+		subs, err := reg.decodeCaptures(item.Subs)
+		if err != nil {
+			return nil, err
+		}
+		if subs == nil && item.Empty {
+			subs = []Capture{}
+		}
+		return &Variable{
+			Name:  item.Name,
+			Subs:  subs,
+			Start: item.Start.position(),
+			End:   item.End.position(),
+		}, nil
+	case jsonKindToken:
+		return &Token{
+			Type:     item.Type,
+			Value:    item.Value,
+			Position: item.Start.position(),
+			End:      item.End.position(),
+		}, nil
+	case jsonKindTable:
+		subs, err := reg.decodeCaptures(item.Subs)
+		if err != nil {
+			return nil, err
+		}
+		if subs == nil && item.Empty {
+			subs = []Capture{}
+		}
+		return Table(subs), nil
+	case jsonKindText:
+		return Text(item.Value), nil
+	case jsonKindPosition:
+		pos := item.Start.position()
+		return &pos, nil
+	}
+
+	typ, ok := reg.types[item.Kind]
+	if !ok {
+		return nil, errorf("capture type name %q is not registered", item.Kind)
+	}
+	var v reflect.Value
+	if typ.Kind() == reflect.Ptr {
+		v = reflect.New(typ.Elem())
+		if err := json.Unmarshal(item.Data, v.Interface()); err != nil {
+			return nil, err
+		}
+	} else {
+		v = reflect.New(typ)
+		if err := json.Unmarshal(item.Data, v.Interface()); err != nil {
+			return nil, err
+		}
+		v = v.Elem()
+	}
+	return v.Interface().(Capture), nil
+}
+
+func isPredefinedJSONKind(name string) bool {
+	switch name {
+	case jsonKindVariable, jsonKindToken, jsonKindTable, jsonKindText, jsonKindPosition:
+		return true
+	}
+	return false
+}
+
+func newPositionJSON(pos Position) *positionJSON {
+	return &positionJSON{Offset: pos.Offest, Line: pos.Line, Column: pos.Column}
+}
+
+func (pos *positionJSON) position() Position {
+	if pos == nil {
+		return Position{}
+	}
+	return Position{Offest: pos.Offset, Line: pos.Line, Column: pos.Column}
+}
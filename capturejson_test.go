@@ -0,0 +1,107 @@
+package peg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Customed terminal, for testing the registered capture types.
+type jsonTestWord struct {
+	Text  string `json:"text"`
+	Upper bool   `json:"upper,omitempty"`
+}
+
+func (w jsonTestWord) IsTerminal() bool {
+	return true
+}
+
+func TestCapturesJSON(t *testing.T) {
+	reg := NewRegistry()
+	reg.RegisterCapture("word", jsonTestWord{})
+	reg.RegisterCapture("int", termInt(0))
+
+	word := func(s string, pos Position) (Capture, error) {
+		return jsonTestWord{Text: s, Upper: strings.ToUpper(s) == s}, nil
+	}
+	pat := Let(map[string]Pattern{
+		"ws":   Q0(S(" \n")),
+		"item": Alt(CK(1, Q1(R('0', '9'))), CT(word, Q1(R('a', 'z', 'A', 'Z'))), CV("list")),
+		"list": Seq(T("("), V("ws"), J0(CV("item"), V("ws")), V("ws"), T(")")),
+	}, Seq(CV("list"), CC(func(subs []Capture) (Capture, error) {
+		return termInt(len(subs)), nil
+	}, True)))
+
+	data := []string{"()", "(1 ab\n (XY ()))"}
+	for _, text := range data {
+		caps, err := Parse(pat, text)
+		if err != nil {
+			t.Fatalf("Parse(%q) => %v", text, err)
+		}
+		js, err := reg.MarshalCaptures(caps)
+		if err != nil {
+			t.Errorf("MarshalCaptures(%s) => %v", formatCapList(caps), err)
+			continue
+		}
+		decoded, err := reg.UnmarshalCaptures(js)
+		if err != nil {
+			t.Errorf("UnmarshalCaptures(%s) => %v", js, err)
+			continue
+		}
+		if !reflect.DeepEqual(caps, decoded) {
+			t.Errorf("UnmarshalCaptures(%s) => %s, expect %s", js, formatCapList(decoded), formatCapList(caps))
+		}
+	}
+
+	caps := []Capture{&Token{Type: 2, Value: "x", End: Position{Offest: 1, Column: 1}}}
+	js, err := MarshalCaptures(caps)
+	want := `[{"kind":"token","type":2,"value":"x","start":{"offset":0,"line":0,"column":0},"end":{"offset":1,"line":0,"column":1}}]`
+	if err != nil || string(js) != want {
+		t.Errorf("MarshalCaptures(%s) => %s, %v", formatCapList(caps), js, err)
+	}
+	if js, err := MarshalCaptures(nil); err != nil || string(js) != "[]" {
+		t.Errorf("MarshalCaptures(nil) => %s, %v", js, err)
+	}
+
+	var v Variable
+	if err := v.UnmarshalJSON([]byte(`{"kind":"variable","name":"v","subs":[` + want[1:len(want)-1] + `]}`)); err != nil ||
+		v.Name != "v" || len(v.Subs) != 1 || !reflect.DeepEqual(v.Subs[0], caps[0]) {
+		t.Errorf("Variable.UnmarshalJSON => %s, %v", formatCap(&v), err)
+	}
+
+	// errors.
+	if _, err := MarshalCaptures([]Capture{jsonTestWord{}}); err == nil {
+		t.Errorf("MarshalCaptures of unregistered type succeeded")
+	}
+	for _, js := range []string{`{}`, `[{"kind":"word"}]`, `[{"kind":"variable","subs":[{"kind":"word","data":1}]}]`} {
+		if _, err := UnmarshalCaptures([]byte(js)); err == nil {
+			t.Errorf("UnmarshalCaptures(%s) succeeded", js)
+		}
+	}
+	if err := v.UnmarshalJSON([]byte(want[1 : len(want)-1])); err == nil {
+		t.Errorf("Variable.UnmarshalJSON of token succeeded")
+	}
+}
+
+func TestFormatCaptures(t *testing.T) {
+	caps, err := Parse(Let(map[string]Pattern{
+		"num":  CK(1, Q1(R('0', '9'))),
+		"pair": Seq(V("num"), T("=\n"), CV("num")),
+	}, Seq(CV("pair"), CT(func(s string, pos Position) (Capture, error) {
+		return termOp(s), nil
+	}, T(";")))), "1=\n23;")
+	if err != nil {
+		t.Fatalf("Parse => %v", err)
+	}
+	want := strings.Join([]string{
+		`pair 1:1+0-2:3+5`,
+		`  token 1 "1" 1:1+0-1:2+1`,
+		`  num 2:1+3-2:3+5`,
+		`    token 1 "23" 2:1+3-2:3+5`,
+		`;`,
+		``,
+	}, "\n")
+	if s := FormatCaptures(caps); s != want {
+		t.Errorf("FormatCaptures => %s, expect %s", s, want)
+	}
+}
@@ -10,17 +10,22 @@ type (
 	patternLet struct {
 		pat  Pattern
 		vars map[string]Pattern
+		auto bool
+	}
+
+	patternRule struct {
+		pat         Pattern
+		transparent bool
 	}
 
 	patternCaptureVariable struct {
 		varname string
-		cons    NonTerminalConstructor
+		cons    ContextConstructor
 	}
 
 	patternCaptureToken struct {
 		pat     Pattern
 		toktype int
-		cons    TerminalConstructor
 	}
 
 	patternCaptureCons struct {
@@ -32,6 +37,44 @@ type (
 		pat  Pattern
 		cons TerminalConstructor
 	}
+
+	patternCaptureContext struct {
+		pat  Pattern
+		cons ContextConstructor
+	}
+
+	patternCaptureConst struct {
+		values []Capture
+	}
+
+	patternCapturePosition struct{}
+
+	patternCaptureFold struct {
+		pat  Pattern
+		fold Folder
+	}
+
+	patternCaptureTable struct {
+		pat Pattern
+	}
+
+	patternCaptureBack struct {
+		grpname string
+	}
+
+	patternCaptureArgument struct {
+		n int
+	}
+
+	patternCaptureMatchTime struct {
+		pat Pattern
+		fn  MatchTimeFunc
+	}
+)
+
+var (
+	// Cp captures the current position, as a *Position.
+	Cp Pattern = &patternCapturePosition{}
 )
 
 // Let enters given namespace then invokes the entry pattern.
@@ -46,6 +89,29 @@ func Let(vars map[string]Pattern, entry Pattern) Pattern {
 	return &patternLet{pat: entry, vars: vars}
 }
 
+// CLet enters given namespace then invokes the entry pattern, like Let, but
+// the variables defined are captured automatically when invoked by V, as CV
+// does, except the rules marked by Silent and Transparent.
+//
+// Panics if any variable definition is nil.
+func CLet(vars map[string]Pattern, entry Pattern) Pattern {
+	let := Let(vars, entry).(*patternLet)
+	let.auto = true
+	return let
+}
+
+// Silent marks the rule defined in CLet, which is never captured
+// automatically, such as the rules of white spaces.
+func Silent(pat Pattern) Pattern {
+	return &patternRule{pat: pat, transparent: false}
+}
+
+// Transparent marks the rule defined in CLet, which is collapsed into the
+// parent when captured automatically, if there is only one capture inside.
+func Transparent(pat Pattern) Pattern {
+	return &patternRule{pat: pat, transparent: true}
+}
+
 // V invokes a defined variable without capturing.
 //
 // A runtime error occurs when variable is undefined.
@@ -72,7 +138,6 @@ func CK(toktype int, pat Pattern) Pattern {
 	return &patternCaptureToken{
 		pat:     pat,
 		toktype: toktype,
-		cons:    newTokenConstructor(toktype),
 	}
 }
 
@@ -86,15 +151,75 @@ func CT(cons TerminalConstructor, pat Pattern) Pattern {
 	return &patternCaptureTerm{pat: pat, cons: cons}
 }
 
-func newVariableConstructor(name string) NonTerminalConstructor {
-	return func(subs []Capture) (Capture, error) {
-		return &Variable{Name: name, Subs: subs}, nil
+// CX constructs a customed capture using user defined constructor, which
+// receives the matched text, its start and end positions and the rule name,
+// along with the captures inside.
+func CX(cons ContextConstructor, pat Pattern) Pattern {
+	return &patternCaptureContext{pat: pat, cons: cons}
+}
+
+// Cc captures the constant values, consuming no text.
+func Cc(values ...Capture) Pattern {
+	return &patternCaptureConst{values: values}
+}
+
+// Cf folds the captures inside from left to right, the first capture is the
+// initial accumulator. Nothing is captured if there is no capture inside.
+//
+// For example, `Cf(fold, J1(term, CT(op, S("+-"))))` folds the terms and
+// operators into left-associative expression trees.
+func Cf(fold Folder, pat Pattern) Pattern {
+	return &patternCaptureFold{pat: pat, fold: fold}
+}
+
+// Ct collects the captures inside into a Table.
+func Ct(pat Pattern) Pattern {
+	return &patternCaptureTable{pat: pat}
+}
+
+// Cb captures the text stored in named group, or the latest text in groups
+// if grpname is empty, as a Text. Text is empty if the group is not found.
+//
+// A runtime error occurs when grouping is disabled.
+func Cb(grpname string) Pattern {
+	return &patternCaptureBack{grpname: grpname}
+}
+
+// Carg captures the n-th (counting from zero) extra argument given in
+// Config.Arguments.
+//
+// A runtime error occurs when the argument is not given.
+func Carg(n int) Pattern {
+	return &patternCaptureArgument{n: n}
+}
+
+// Cmt calls fn when pat is matched, which decides if the match succeeds
+// and replaces the captures inside. Note that, fn is always called (even
+// when capturing is disabled, where no sub-captures are received).
+func Cmt(fn MatchTimeFunc, pat Pattern) Pattern {
+	return &patternCaptureMatchTime{pat: pat, fn: fn}
+}
+
+func newVariableConstructor(name string) ContextConstructor {
+	return func(cc CaptureContext, subs []Capture) (Capture, error) {
+		return &Variable{Name: name, Subs: subs, Start: cc.Start, End: cc.End}, nil
 	}
 }
 
-func newTokenConstructor(toktype int) TerminalConstructor {
-	return func(span string, pos Position) (Capture, error) {
-		return &Token{Type: toktype, Value: span, Position: pos}, nil
+// Gets the constructor of rule invoked by V in CLet.
+func newRuleConstructor(name string, rule Pattern) ContextConstructor {
+	mark, ok := rule.(*patternRule)
+	if !ok {
+		return newVariableConstructor(name)
+	}
+	if !mark.transparent {
+		return nil
+	}
+	return func(cc CaptureContext, subs []Capture) (Capture, error) {
+		if len(subs) == 1 {
+			return subs[0], nil
+		}
+		return &Variable{Name: name, Subs: subs, Start: cc.Start, End: cc.End}, nil
 	}
 }
 
@@ -102,7 +227,7 @@ func newTokenConstructor(toktype int) TerminalConstructor {
 func (pat *patternLet) match(ctx *context) error {
 	if !ctx.justReturned() {
 		// enter namespace
-		ctx.enter(pat.vars)
+		ctx.enter(pat.vars, pat.auto)
 		return ctx.call(pat.pat)
 	}
 
@@ -112,26 +237,48 @@ func (pat *patternLet) match(ctx *context) error {
 	return ctx.returns(ret)
 }
 
+// Marks of rule are transparent when matching.
+func (pat *patternRule) match(ctx *context) error {
+	return ctx.execute(pat.pat)
+}
+
 // Invokes vaiable and optionally captures it.
 func (pat *patternCaptureVariable) match(ctx *context) error {
 	// lookup and invoke
 	if !ctx.justReturned() {
-		callee := ctx.lookup(pat.varname)
+		callee, auto := ctx.scopes.lookupRule(pat.varname)
 		if callee == nil {
 			return errorUndefinedVar(pat.varname)
 		}
+		cons := pat.cons
+		if cons == nil && auto {
+			cons = newRuleConstructor(pat.varname, callee)
+		}
 
-		if pat.cons == nil {
+		_, isArgument := callee.(*patternClosure)
+		if ctx.config.SyntaxTree && !isArgument {
+			// build rule node instead of capturing
+			ctx.beginRule(pat.varname)
+		} else if ctx.config.SyntaxTree || cons == nil {
 			// won't capture the variable
+			ctx.rule = pat.varname
 			return ctx.execute(callee)
+		} else {
+			ctx.beginContext(cons, pat.varname)
 		}
-		ctx.begin(pat.cons)
-		return ctx.call(callee)
+		err := ctx.call(callee)
+		ctx.rule = pat.varname
+		return err
 	}
 
 	// finish capturing
 	ret := ctx.ret
-	err := ctx.end(ret.ok)
+	var err error
+	if ctx.config.SyntaxTree {
+		err = ctx.endRule(ret.ok, ret.n)
+	} else {
+		err = ctx.end(ret.ok, ret.n)
+	}
 	if err != nil {
 		return err
 	}
@@ -151,11 +298,12 @@ func (pat *patternCaptureToken) match(ctx *context) error {
 
 	head := ctx.tell()
 	ctx.consume(ret.n)
-	term, err := pat.cons(ctx.span(), head)
-	if err != nil {
-		return err
-	}
-	err = ctx.push(term)
+	err := ctx.push(&Token{
+		Type:     pat.toktype,
+		Value:    ctx.span(),
+		Position: head,
+		End:      ctx.tell(),
+	})
 	if err != nil {
 		return err
 	}
@@ -170,7 +318,7 @@ func (pat *patternCaptureCons) match(ctx *context) error {
 	}
 
 	ret := ctx.ret
-	err := ctx.end(ret.ok)
+	err := ctx.end(ret.ok, ret.n)
 	if err != nil {
 		return err
 	}
@@ -201,14 +349,201 @@ func (pat *patternCaptureTerm) match(ctx *context) error {
 	return ctx.commit()
 }
 
+// Captures using customed constructor with capture context.
+func (pat *patternCaptureContext) match(ctx *context) error {
+	if !ctx.justReturned() {
+		ctx.beginContext(pat.cons, ctx.rule)
+		return ctx.call(pat.pat)
+	}
+
+	ret := ctx.ret
+	err := ctx.end(ret.ok, ret.n)
+	if err != nil {
+		return err
+	}
+	return ctx.returns(ret)
+}
+
+// Captures the constant values.
+func (pat *patternCaptureConst) match(ctx *context) error {
+	for _, value := range pat.values {
+		if err := ctx.push(value); err != nil {
+			return err
+		}
+	}
+	return ctx.predicates(true)
+}
+
+// Captures the current position.
+func (pat *patternCapturePosition) match(ctx *context) error {
+	pos := ctx.tell()
+	if err := ctx.push(&pos); err != nil {
+		return err
+	}
+	return ctx.predicates(true)
+}
+
+// Folds the captures inside.
+func (pat *patternCaptureFold) match(ctx *context) error {
+	if !ctx.justReturned() {
+		ctx.begin(nil)
+		return ctx.call(pat.pat)
+	}
+
+	ret := ctx.ret
+	subs, err := ctx.collect()
+	if err != nil {
+		return err
+	}
+	if ret.ok && len(subs) > 0 {
+		acc := subs[0]
+		for _, cap := range subs[1:] {
+			if acc, err = pat.fold(acc, cap); err != nil {
+				return err
+			}
+		}
+		if err = ctx.push(acc); err != nil {
+			return err
+		}
+	}
+	return ctx.returns(ret)
+}
+
+// Collects the captures inside into table.
+func (pat *patternCaptureTable) match(ctx *context) error {
+	if !ctx.justReturned() {
+		ctx.begin(nil)
+		return ctx.call(pat.pat)
+	}
+
+	ret := ctx.ret
+	subs, err := ctx.collect()
+	if err != nil {
+		return err
+	}
+	if ret.ok {
+		if subs == nil {
+			subs = []Capture{}
+		}
+		if err = ctx.push(Table(subs)); err != nil {
+			return err
+		}
+	}
+	return ctx.returns(ret)
+}
+
+// Captures the text of group.
+func (pat *patternCaptureBack) match(ctx *context) error {
+	if ctx.config.DisableGrouping {
+		return errorReferDisabled
+	}
+
+	if err := ctx.push(Text(ctx.refer(pat.grpname))); err != nil {
+		return err
+	}
+	return ctx.predicates(true)
+}
+
+// Captures the extra argument.
+func (pat *patternCaptureArgument) match(ctx *context) error {
+	args := ctx.config.Arguments
+	if pat.n < 0 || pat.n >= len(args) {
+		return errorArgumentIndex(pat.n, len(args))
+	}
+
+	if err := ctx.push(args[pat.n]); err != nil {
+		return err
+	}
+	return ctx.predicates(true)
+}
+
+// Decides the match at match time.
+func (pat *patternCaptureMatchTime) match(ctx *context) error {
+	if !ctx.justReturned() {
+		ctx.begin(nil)
+		return ctx.call(pat.pat)
+	}
+
+	ret := ctx.ret
+	subs, err := ctx.collect()
+	if err != nil {
+		return err
+	}
+	if !ret.ok {
+		return ctx.predicates(false)
+	}
+
+	ok, caps, err := pat.fn(CaptureContext{
+		Rule:  ctx.rule,
+		Text:  ctx.next(ret.n),
+		Start: ctx.tell(),
+		End:   ctx.tellAt(ctx.at + ret.n),
+	}, subs)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ctx.predicates(false)
+	}
+	for _, cap := range caps {
+		if err = ctx.push(cap); err != nil {
+			return err
+		}
+	}
+	ctx.consume(ret.n)
+	return ctx.commit()
+}
+
 func (pat *patternLet) String() string {
 	strs := make([]string, 0, len(pat.vars))
 	for name, value := range pat.vars {
 		strs = append(strs, fmt.Sprintf("$%s := %s", name, value))
 	}
+	if pat.auto {
+		return fmt.Sprintf("clet (%s) in %s", strings.Join(strs, "; "), pat.pat)
+	}
 	return fmt.Sprintf("let (%s) in %s", strings.Join(strs, "; "), pat.pat)
 }
 
+func (pat *patternRule) String() string {
+	if pat.transparent {
+		return fmt.Sprintf("transparent{%s}", pat.pat)
+	}
+	return fmt.Sprintf("silent{%s}", pat.pat)
+}
+
+func (pat *patternCaptureConst) String() string {
+	strs := make([]string, len(pat.values))
+	for i, value := range pat.values {
+		strs[i] = fmt.Sprint(value)
+	}
+	return fmt.Sprintf("const(%s)", strings.Join(strs, ", "))
+}
+
+func (pat *patternCapturePosition) String() string {
+	return "position"
+}
+
+func (pat *patternCaptureFold) String() string {
+	return fmt.Sprintf("fold_%p{%s}", pat.fold, pat.pat)
+}
+
+func (pat *patternCaptureTable) String() string {
+	return fmt.Sprintf("table{%s}", pat.pat)
+}
+
+func (pat *patternCaptureBack) String() string {
+	return fmt.Sprintf("back%%%s%%", pat.grpname)
+}
+
+func (pat *patternCaptureArgument) String() string {
+	return fmt.Sprintf("arg%d", pat.n)
+}
+
+func (pat *patternCaptureMatchTime) String() string {
+	return fmt.Sprintf("matchtime_%p{%s}", pat.fn, pat.pat)
+}
+
 func (pat *patternCaptureVariable) String() string {
 	if pat.cons == nil {
 		return fmt.Sprintf("$%s", pat.varname)
@@ -227,3 +562,7 @@ func (pat *patternCaptureToken) String() string {
 func (pat *patternCaptureTerm) String() string {
 	return fmt.Sprintf("term_%p{%s}", pat.cons, pat.pat)
 }
+
+func (pat *patternCaptureContext) String() string {
+	return fmt.Sprintf("ctx_%p{%s}", pat.cons, pat.pat)
+}
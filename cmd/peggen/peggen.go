@@ -0,0 +1,110 @@
+// Command peggen generates a standalone Go matcher from a grammar source.
+//
+// Usage:
+//
+//     peggen [-format peg|abnf|w3c|iso] [-entry rule] [-pkg name] [-func name] [-o output] grammar
+//
+// The formats are the re-style grammar of peg.CompileGrammar (without defs),
+// the RFC 5234 ABNF, the W3C EBNF and the ISO/IEC 14977 EBNF. The generated
+// file defines the function and its Config variant, see peg.Generator.
+//
+// The entry rule is required by the abnf format, and defaults to the first
+// rule of the w3c and iso formats. The peg format takes no entry, the
+// grammar itself is the entry pattern.
+//
+// It is suitable for go:generate, for example:
+//
+//     //go:generate peggen -format abnf -entry uri -pkg uri -func MatchURI -o uri_peg.go uri.abnf
+package main // import "github.com/hucsmn/peg/cmd/peggen"
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hucsmn/peg"
+	"github.com/hucsmn/peg/abnf"
+	"github.com/hucsmn/peg/ebnf"
+)
+
+func main() {
+	format := flag.String("format", "peg", "grammar format: peg, abnf, w3c or iso")
+	entry := flag.String("entry", "", "entry rule: required by abnf, defaults to the first rule of w3c and iso, not allowed by peg")
+	pkg := flag.String("pkg", "main", "package name of the generated file")
+	fn := flag.String("func", "Match", "name of the generated function")
+	output := flag.String("o", "", "output file, defaults to the standard output")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: peggen [flags] grammar\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	src, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	pat, warnings, err := compile(*format, string(src), *entry)
+	if err != nil {
+		fatal(err)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", flag.Arg(0), w)
+	}
+
+	gen := &peg.Generator{Package: *pkg, Func: *fn}
+	var buf bytes.Buffer
+	err = gen.Generate(&buf, pat)
+	if err != nil {
+		fatal(err)
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = ioutil.WriteFile(*output, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// Compiles the grammar source, returns the pattern and the warnings.
+func compile(format, src, entry string) (peg.Pattern, []fmt.Stringer, error) {
+	var warnings []fmt.Stringer
+	switch format {
+	case "peg":
+		if entry != "" {
+			return nil, nil, fmt.Errorf("entry is not supported by the peg format")
+		}
+		pat, err := peg.CompileGrammar(src, nil)
+		return pat, nil, err
+	case "abnf":
+		if entry == "" {
+			return nil, nil, fmt.Errorf("entry is required by the abnf format")
+		}
+		pat, ws, err := abnf.Compile(src, entry)
+		for _, w := range ws {
+			warnings = append(warnings, w)
+		}
+		return pat, warnings, err
+	case "w3c", "iso":
+		compile := ebnf.CompileW3C
+		if format == "iso" {
+			compile = ebnf.CompileISO
+		}
+		pat, ws, err := compile(src, entry)
+		for _, w := range ws {
+			warnings = append(warnings, w)
+		}
+		return pat, warnings, err
+	default:
+		return nil, nil, fmt.Errorf("unknown grammar format %q", format)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "peggen: %s\n", err)
+	os.Exit(1)
+}
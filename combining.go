@@ -421,6 +421,7 @@ func (pat *patternAnyRuneUntil) match(ctx *context) error {
 		}
 
 		if !ctx.justReturned() {
+			ctx.locals.ncaps = len(ctx.capstack[len(ctx.capstack)-1].args)
 			return ctx.call(pat.pat)
 		}
 
@@ -429,6 +430,9 @@ func (pat *patternAnyRuneUntil) match(ctx *context) error {
 			// pattern searched
 			if !pat.without {
 				ctx.consume(ret.n)
+			} else if ctx.config.SyntaxTree {
+				// the unconsumed terminator leaves no syntax tree node
+				ctx.truncateCaptures(ctx.locals.ncaps)
 			}
 			return ctx.commit()
 		}
@@ -0,0 +1,620 @@
+package peg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// Predefined classes for %name, borrowed from LPeg's re module.
+	grammarClasses = map[string]Pattern{
+		"nl": T("\n"),
+		"a":  U("Letter"),
+		"A":  U("-Letter"),
+		"c":  U("Control"),
+		"C":  U("-Control"),
+		"d":  U("Digit"),
+		"D":  U("-Digit"),
+		"g":  U("Graphic", "-White_Space"),
+		"G":  Alt(U("-Graphic"), U("White_Space")),
+		"l":  U("Lower"),
+		"L":  U("-Lower"),
+		"p":  U("Punct"),
+		"P":  U("-Punct"),
+		"s":  U("White_Space"),
+		"S":  U("-White_Space"),
+		"u":  U("Upper"),
+		"U":  U("-Upper"),
+		"w":  U("Letter", "Digit"),
+		"W":  U("-Letter", "-Digit"),
+		"x":  R('0', '9', 'a', 'f', 'A', 'F'),
+		"X":  NR('0', '9', 'a', 'f', 'A', 'F'),
+	}
+)
+
+// SyntaxError is the error reported when compiling grammar source.
+type SyntaxError struct {
+	Position Position
+	Message  string
+}
+
+// Error method of the SyntaxError type.
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("peg: syntax error at %d:%d: %s",
+		err.Position.Line+1, err.Position.Column+1, err.Message)
+}
+
+// CompileGrammar compiles the textual grammar source into pattern.
+// The notation is modeled on the re module of LPeg:
+//
+//     grammar     <- S (definition+ / expression) !.
+//     definition  <- name S '<-' S expression
+//     expression  <- sequence ('/' S sequence)*
+//     sequence    <- prefix*
+//     prefix      <- '&' S prefix / '!' S prefix / suffix
+//     suffix      <- primary S (([+*?] / '^' [+-]? num
+//                    / '->' S (name / num) / '=>' S name) S)*
+//     primary     <- '(' S expression ')' / string / class / '.' / '%' name
+//                    / '{:' (name ':')? expression ':}' / '{' expression '}'
+//                    / '=' name / '<' name '>' / name !(S '<-')
+//     class       <- '[' '^'? item (!']' item)* ']'
+//     item        <- '%' name / . '-' [^\]] / .
+//     string      <- '"' [^"]* '"' / "'" [^']* "'"
+//     S           <- (%s / '--' [^\n]*)*
+//
+// A grammar is compiled to Let(rules, V(firstrule)). References to undefined
+// rules are reported, while free names in a single expression are compiled
+// to variables (V) left to the enclosing Let.
+//
+// Patterns are mapped as: `.` to Dot, `p*` to Q0, `p+` to Q1, `p?` to Q01,
+// `p^n` to Qnn, `p^+n` to Qn, `p^-n` to Q0n, `&p` to Test, `!p` to Not,
+// `{p}` to G, `{:name: p:}` to NG, `=name` to Ref, `name` to V and `<name>`
+// to CV (unlike re, where it is just a rule reference).
+//
+// The name after `%`, `->` and `=>` is looked up in defs:
+//
+//     %name      Pattern, or string matched literally
+//     p -> name  int (CK), NonTerminalConstructor (CC),
+//                TerminalConstructor (CT), ContextConstructor (CX),
+//                func(string, Position) error (Trigger)
+//     p -> num   CK(num, p)
+//     p => name  func(string) bool (Check), func(string) (int, bool) (Inject)
+//
+// The predefined classes %nl, %a, %c, %d, %g, %l, %p, %s, %u, %w, %x (and
+// the upper case complements) are available unless overridden by defs.
+//
+// Returns *SyntaxError with the line and column if the source is invalid.
+func CompileGrammar(src string, defs map[string]interface{}) (Pattern, error) {
+	c := &grammarCompiler{
+		src:   src,
+		defs:  defs,
+		pcalc: positionCalculator{text: src},
+	}
+	return c.compile()
+}
+
+// Recursive descent compiler of grammar source.
+type grammarCompiler struct {
+	src   string
+	at    int
+	defs  map[string]interface{}
+	pcalc positionCalculator
+
+	rules map[string]Pattern
+	refs  []grammarReference
+}
+
+// Rule reference and its location in source.
+type grammarReference struct {
+	name string
+	at   int
+}
+
+func (c *grammarCompiler) compile() (Pattern, error) {
+	c.skipSpaces()
+	if !c.atDefinition() {
+		pat, err := c.expression()
+		if err != nil {
+			return nil, err
+		}
+		if c.at < len(c.src) {
+			return nil, c.unexpected()
+		}
+		return pat, nil
+	}
+
+	var entry string
+	c.rules = make(map[string]Pattern)
+	for c.at < len(c.src) {
+		if !c.atDefinition() {
+			return nil, c.unexpected()
+		}
+		at := c.at
+		name := c.name()
+		c.skipSpaces()
+		c.at += len("<-")
+		c.skipSpaces()
+		if _, ok := c.rules[name]; ok {
+			return nil, c.errorAt(at, "rule %q is defined more than once", name)
+		}
+		pat, err := c.expression()
+		if err != nil {
+			return nil, err
+		}
+		if entry == "" {
+			entry = name
+		}
+		c.rules[name] = pat
+	}
+
+	for _, ref := range c.refs {
+		if _, ok := c.rules[ref.name]; !ok {
+			return nil, c.errorAt(ref.at, "rule %q is undefined", ref.name)
+		}
+	}
+	return Let(c.rules, V(entry)), nil
+}
+
+func (c *grammarCompiler) expression() (Pattern, error) {
+	pat, err := c.sequence()
+	if err != nil {
+		return nil, err
+	}
+	choices := []Pattern{pat}
+	for c.skip("/") {
+		c.skipSpaces()
+		pat, err = c.sequence()
+		if err != nil {
+			return nil, err
+		}
+		choices = append(choices, pat)
+	}
+	return Alt(choices...), nil
+}
+
+func (c *grammarCompiler) sequence() (Pattern, error) {
+	var pats []Pattern
+	for c.atPrefix() {
+		pat, err := c.prefix()
+		if err != nil {
+			return nil, err
+		}
+		pats = append(pats, pat)
+	}
+	return Seq(pats...), nil
+}
+
+func (c *grammarCompiler) prefix() (Pattern, error) {
+	switch {
+	case c.skip("&"):
+		c.skipSpaces()
+		pat, err := c.prefix()
+		if err != nil {
+			return nil, err
+		}
+		return Test(pat), nil
+	case c.skip("!"):
+		c.skipSpaces()
+		pat, err := c.prefix()
+		if err != nil {
+			return nil, err
+		}
+		return Not(pat), nil
+	default:
+		return c.suffix()
+	}
+}
+
+func (c *grammarCompiler) suffix() (Pattern, error) {
+	pat, err := c.primary()
+	if err != nil {
+		return nil, err
+	}
+	c.skipSpaces()
+	for {
+		switch {
+		case c.skip("*"):
+			pat = Q0(pat)
+		case c.skip("+"):
+			pat = Q1(pat)
+		case c.skip("?"):
+			pat = Q01(pat)
+		case c.skip("^"):
+			sign := ""
+			if c.skip("+") {
+				sign = "+"
+			} else if c.skip("-") {
+				sign = "-"
+			}
+			at := c.at
+			n, ok := c.number()
+			if !ok {
+				return nil, c.errorAt(at, "expect repetition number")
+			}
+			switch sign {
+			case "+":
+				pat = Qn(n, pat)
+			case "-":
+				pat = Q0n(n, pat)
+			default:
+				pat = Qnn(n, pat)
+			}
+		case c.skip("->"):
+			c.skipSpaces()
+			pat, err = c.capture(pat)
+			if err != nil {
+				return nil, err
+			}
+		case c.skip("=>"):
+			c.skipSpaces()
+			pat, err = c.injector(pat)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return pat, nil
+		}
+		c.skipSpaces()
+	}
+}
+
+func (c *grammarCompiler) capture(pat Pattern) (Pattern, error) {
+	at := c.at
+	if n, ok := c.number(); ok {
+		return CK(n, pat), nil
+	}
+	c.skip("%")
+	name := c.name()
+	if name == "" {
+		return nil, c.errorAt(at, "expect capture name or token type")
+	}
+	switch def := c.defs[name].(type) {
+	case int:
+		return CK(def, pat), nil
+	case NonTerminalConstructor:
+		return CC(def, pat), nil
+	case func([]Capture) (Capture, error):
+		return CC(def, pat), nil
+	case TerminalConstructor:
+		return CT(def, pat), nil
+	case func(string, Position) (Capture, error):
+		return CT(def, pat), nil
+	case ContextConstructor:
+		return CX(def, pat), nil
+	case func(CaptureContext, []Capture) (Capture, error):
+		return CX(def, pat), nil
+	case func(string, Position) error:
+		return Trigger(def, pat), nil
+	case nil:
+		return nil, c.errorAt(at, "capture %q is undefined", name)
+	default:
+		return nil, c.errorAt(at, "capture %q has invalid type %T", name, def)
+	}
+}
+
+func (c *grammarCompiler) injector(pat Pattern) (Pattern, error) {
+	at := c.at
+	c.skip("%")
+	name := c.name()
+	if name == "" {
+		return nil, c.errorAt(at, "expect injector name")
+	}
+	switch def := c.defs[name].(type) {
+	case func(string) bool:
+		return Check(def, pat), nil
+	case func(string) (int, bool):
+		return Inject(def, pat), nil
+	case nil:
+		return nil, c.errorAt(at, "injector %q is undefined", name)
+	default:
+		return nil, c.errorAt(at, "injector %q has invalid type %T", name, def)
+	}
+}
+
+func (c *grammarCompiler) primary() (Pattern, error) {
+	at := c.at
+	switch {
+	case c.skip("("):
+		c.skipSpaces()
+		pat, err := c.expression()
+		if err != nil {
+			return nil, err
+		}
+		if !c.skip(")") {
+			return nil, c.expect("')'", at)
+		}
+		return pat, nil
+	case c.peek("'") || c.peek("\""):
+		text, err := c.literal()
+		if err != nil {
+			return nil, err
+		}
+		return T(text), nil
+	case c.peek("["):
+		return c.class()
+	case c.skip("."):
+		return Dot, nil
+	case c.skip("%"):
+		return c.defined(at, false)
+	case c.skip("{:"):
+		grpname := ""
+		if c.atName() {
+			save := c.at
+			grpname = c.name()
+			if !c.skip(":") || c.peek("}") {
+				grpname = ""
+				c.at = save
+			}
+		}
+		c.skipSpaces()
+		pat, err := c.expression()
+		if err != nil {
+			return nil, err
+		}
+		if !c.skip(":}") {
+			return nil, c.expect("':}'", at)
+		}
+		if grpname == "" {
+			return G(pat), nil
+		}
+		return NG(grpname, pat), nil
+	case c.skip("{"):
+		c.skipSpaces()
+		pat, err := c.expression()
+		if err != nil {
+			return nil, err
+		}
+		if !c.skip("}") {
+			return nil, c.expect("'}'", at)
+		}
+		return G(pat), nil
+	case c.skip("="):
+		name := c.name()
+		if name == "" {
+			return nil, c.errorAt(c.at, "expect group name")
+		}
+		return Ref(name), nil
+	case c.skip("<"):
+		name := c.name()
+		if name == "" {
+			return nil, c.errorAt(c.at, "expect rule name")
+		}
+		if !c.skip(">") {
+			return nil, c.expect("'>'", at)
+		}
+		c.reference(name, at+1)
+		return CV(name), nil
+	case c.atName():
+		name := c.name()
+		c.reference(name, at)
+		return V(name), nil
+	default:
+		return nil, c.unexpected()
+	}
+}
+
+func (c *grammarCompiler) class() (Pattern, error) {
+	at := c.at
+	c.skip("[")
+	not := c.skip("^")
+
+	var runes []rune
+	var ranges []rune
+	var classes []Pattern
+	first := true
+	for first || !c.peek("]") {
+		first = false
+		if c.at >= len(c.src) {
+			return nil, c.expect("']'", at)
+		}
+		if c.skip("%") {
+			pat, err := c.defined(c.at-1, true)
+			if err != nil {
+				return nil, err
+			}
+			classes = append(classes, pat)
+			continue
+		}
+
+		low := c.at
+		r, n := utf8.DecodeRuneInString(c.src[c.at:])
+		c.at += n
+		if c.peek("-") && !strings.HasPrefix(c.src[c.at:], "-]") {
+			c.at++
+			high, n := utf8.DecodeRuneInString(c.src[c.at:])
+			if n == 0 {
+				return nil, c.expect("']'", at)
+			}
+			if high < r {
+				return nil, c.errorAt(low, "range %q-%q is reversed", r, high)
+			}
+			c.at += n
+			ranges = append(ranges, r, high)
+		} else {
+			runes = append(runes, r)
+		}
+	}
+	c.skip("]")
+
+	// simplified forms of the class
+	switch {
+	case len(classes) == 0 && len(ranges) == 0 && not:
+		return NS(string(runes)), nil
+	case len(classes) == 0 && len(runes) == 0 && not:
+		return NR(ranges[0], ranges[1], ranges[2:]...), nil
+	case len(classes) == 0 && len(ranges) == 0:
+		return S(string(runes)), nil
+	case len(classes) == 0 && len(runes) == 0:
+		return R(ranges[0], ranges[1], ranges[2:]...), nil
+	}
+
+	var choices []Pattern
+	if len(runes) > 0 {
+		choices = append(choices, S(string(runes)))
+	}
+	if len(ranges) > 0 {
+		choices = append(choices, R(ranges[0], ranges[1], ranges[2:]...))
+	}
+	choices = append(choices, classes...)
+	if not {
+		return Seq(Not(Alt(choices...)), Dot), nil
+	}
+	return Alt(choices...), nil
+}
+
+// Looks up pattern defined by %name, where at is the location of '%'.
+func (c *grammarCompiler) defined(at int, inclass bool) (Pattern, error) {
+	name := c.name()
+	if name == "" {
+		return nil, c.errorAt(c.at, "expect definition name")
+	}
+	if def, ok := c.defs[name]; ok {
+		switch def := def.(type) {
+		case Pattern:
+			return def, nil
+		case string:
+			if !inclass {
+				return T(def), nil
+			}
+		}
+		return nil, c.errorAt(at, "definition %q has invalid type %T", name, def)
+	}
+	if pat, ok := grammarClasses[name]; ok {
+		return pat, nil
+	}
+	return nil, c.errorAt(at, "definition %q is undefined", name)
+}
+
+func (c *grammarCompiler) literal() (string, error) {
+	at := c.at
+	quote := c.src[c.at : c.at+1]
+	c.at++
+	i := strings.Index(c.src[c.at:], quote)
+	if i < 0 {
+		c.at = len(c.src)
+		return "", c.expect(strconv.Quote(quote), at)
+	}
+	text := c.src[c.at : c.at+i]
+	c.at += i + 1
+	return text, nil
+}
+
+func (c *grammarCompiler) number() (int, bool) {
+	start := c.at
+	for c.at < len(c.src) && c.src[c.at] >= '0' && c.src[c.at] <= '9' {
+		c.at++
+	}
+	if c.at == start {
+		return 0, false
+	}
+	n, err := strconv.Atoi(c.src[start:c.at])
+	if err != nil {
+		c.at = start
+		return 0, false
+	}
+	return n, true
+}
+
+func (c *grammarCompiler) name() string {
+	start := c.at
+	for c.at < len(c.src) {
+		ch := c.src[c.at]
+		if ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') ||
+			(c.at > start && ch >= '0' && ch <= '9') {
+			c.at++
+		} else {
+			break
+		}
+	}
+	return c.src[start:c.at]
+}
+
+func (c *grammarCompiler) reference(name string, at int) {
+	c.refs = append(c.refs, grammarReference{name: name, at: at})
+}
+
+func (c *grammarCompiler) skipSpaces() {
+	for c.at < len(c.src) {
+		if strings.HasPrefix(c.src[c.at:], "--") {
+			i := strings.IndexByte(c.src[c.at:], '\n')
+			if i < 0 {
+				c.at = len(c.src)
+			} else {
+				c.at += i + 1
+			}
+			continue
+		}
+		switch c.src[c.at] {
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+			c.at++
+		default:
+			return
+		}
+	}
+}
+
+func (c *grammarCompiler) skip(s string) bool {
+	if c.peek(s) {
+		c.at += len(s)
+		return true
+	}
+	return false
+}
+
+func (c *grammarCompiler) peek(s string) bool {
+	return strings.HasPrefix(c.src[c.at:], s)
+}
+
+func (c *grammarCompiler) atName() bool {
+	if c.at >= len(c.src) {
+		return false
+	}
+	ch := c.src[c.at]
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+// Tests if a new definition starts here.
+func (c *grammarCompiler) atDefinition() bool {
+	save := c.at
+	defer func() { c.at = save }()
+	if c.name() == "" {
+		return false
+	}
+	c.skipSpaces()
+	return c.peek("<-")
+}
+
+// Tests if a prefix starts here.
+func (c *grammarCompiler) atPrefix() bool {
+	if c.at >= len(c.src) || c.atDefinition() {
+		return false
+	}
+	for _, s := range []string{"/", ")", ":}", "}"} {
+		if c.peek(s) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *grammarCompiler) errorAt(at int, format string, v ...interface{}) error {
+	return &SyntaxError{
+		Position: c.pcalc.calculate(at),
+		Message:  fmt.Sprintf(format, v...),
+	}
+}
+
+func (c *grammarCompiler) expect(what string, start int) error {
+	return c.errorAt(c.at, "expect %s to close the one at %d:%d", what,
+		c.pcalc.calculate(start).Line+1, c.pcalc.calculate(start).Column+1)
+}
+
+func (c *grammarCompiler) unexpected() error {
+	if c.at >= len(c.src) {
+		return c.errorAt(c.at, "unexpected end of grammar")
+	}
+	r, _ := utf8.DecodeRuneInString(c.src[c.at:])
+	return c.errorAt(c.at, "unexpected %q", r)
+}
@@ -0,0 +1,117 @@
+package peg
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Tests CompileGrammar.
+func TestCompileGrammar(t *testing.T) {
+	intcons := func(text string, pos Position) (Capture, error) {
+		i, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, err
+		}
+		return termInt(int32(i)), nil
+	}
+	defs := map[string]interface{}{
+		"digit":   R('0', '9'),
+		"arrow":   "->",
+		"NUM":     0,
+		"int":     TerminalConstructor(intcons),
+		"list":    newVariableConstructor("list"),
+		"nonzero": func(s string) bool { return s != "0" },
+	}
+	compile := func(src string) Pattern {
+		pat, err := CompileGrammar(src, defs)
+		if err != nil {
+			t.Fatalf("CompileGrammar(%q) => %s", src, err)
+		}
+		return pat
+	}
+
+	data := []patternTestData{
+		{"", true, 0, false, ``, ``, compile(``)},
+		{"abc", true, 3, false, ``, ``, compile(`'abc'`)},
+		{"abc", true, 3, false, ``, ``, compile(`"a" "b" "c"`)},
+		{"abc", false, 0, false, ``, ``, compile(`"a" "c"`)},
+		{"b", true, 1, false, ``, ``, compile(`"a" / "b"`)},
+		{"aaab", true, 3, false, ``, ``, compile(`"a"*`)},
+		{"b", false, 0, false, ``, ``, compile(`"a"+`)},
+		{"b", true, 0, false, ``, ``, compile(`"a"?`)},
+		{"aaa", true, 2, false, ``, ``, compile(`"a"^2`)},
+		{"a", false, 0, false, ``, ``, compile(`"a"^+2`)},
+		{"aaa", true, 2, false, ``, ``, compile(`"a"^-2`)},
+		{"ab", true, 1, false, ``, ``, compile(`"a" &"b"`)},
+		{"ab", false, 0, false, ``, ``, compile(`"a" !"b"`)},
+		{"ab", true, 2, false, ``, ``, compile(`. . -- comment`)},
+
+		{"x", true, 1, false, ``, ``, compile(`[a-z]`)},
+		{"-", true, 1, false, ``, ``, compile(`[a-z-]`)},
+		{"]", true, 1, false, ``, ``, compile(`[]a]`)},
+		{"x", false, 0, false, ``, ``, compile(`[^a-z]`)},
+		{"5", true, 1, false, ``, ``, compile(`[^a-z]`)},
+		{"5", true, 1, false, ``, ``, compile(`[a%digit]`)},
+		{"5", false, 0, false, ``, ``, compile(`[^a%d]`)},
+		{"b", true, 1, false, ``, ``, compile(`[^a%d]`)},
+		{" ", true, 1, false, ``, ``, compile(`%s`)},
+		{"->", true, 2, false, ``, ``, compile(`%arrow`)},
+
+		{"ab", true, 2, false, `"a","b"`, ``, compile(`{.} {.}`)},
+		{"aa", true, 2, false, `"x"="a"`, ``, compile(`{:x: . :} =x`)},
+		{"ab", false, 0, false, ``, ``, compile(`{:x: . :} =x`)},
+		{"12", true, 2, false, ``, `<0"1">, <0"2">`, compile(`(%digit -> NUM)*`)},
+		{"12", true, 2, false, ``, `<7"1">, <7"2">`, compile(`(%digit -> 7)*`)},
+		{"12", true, 2, false, ``, `<12>`, compile(`%digit+ -> int`)},
+		{"12", true, 2, false, ``, `list(<1>, <2>)`, compile(`((%digit -> int)* -> list)`)},
+		{"0", false, 0, false, ``, ``, compile(`%digit => nonzero`)},
+		{"1", true, 1, false, ``, ``, compile(`%digit => nonzero`)},
+
+		{"(a,b)", true, 5, false, ``, ``, compile(`
+			list <- '(' item (',' item)* ')'
+			item <- [a-z]+ / list`)},
+		{"(a,(b,c))", true, 9, false, ``, `item(), item(item(), item())`, compile(`
+			list <- '(' <item> (',' <item>)* ')'
+			item <- [a-z]+ / list`)},
+	}
+
+	for _, d := range data {
+		runPatternTestData(t, d)
+	}
+}
+
+// Tests syntax errors reported by CompileGrammar.
+func TestCompileGrammarErrors(t *testing.T) {
+	data := []struct {
+		src  string
+		line int
+		col  int
+	}{
+		{`(`, 1, 2},
+		{`'abc`, 1, 5},
+		{`[abc`, 1, 5},
+		{`[a-cz-a]`, 1, 5},
+		{`"a" )`, 1, 5},
+		{`%undefined`, 1, 1},
+		{`. -> undefined`, 1, 6},
+		{`. => undefined`, 1, 6},
+		{`. ^ x`, 1, 4},
+		{"a <- b\nb <- c", 2, 6},
+		{"a <- 'x'\na <- 'y'", 2, 1},
+		{"a <- 'x'\n  )", 2, 3},
+		{"a <- {:x: 'x'", 1, 14},
+	}
+
+	for _, d := range data {
+		_, err := CompileGrammar(d.src, nil)
+		synerr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("CompileGrammar(%q) => %v, expect syntax error", d.src, err)
+			continue
+		}
+		if synerr.Position.Line+1 != d.line || synerr.Position.Column+1 != d.col {
+			t.Errorf("CompileGrammar(%q) => %s, expect error at %d:%d",
+				d.src, synerr, d.line, d.col)
+		}
+	}
+}
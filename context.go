@@ -1,6 +1,9 @@
 package peg
 
-import "unicode/utf8"
+import (
+	"strings"
+	"unicode/utf8"
+)
 
 // Running state of pattern matching.
 type context struct {
@@ -18,50 +21,91 @@ type context struct {
 	locals localValues
 	isret  bool
 	ret    returnValues // allow accessing from pat.match(ctx)
+	rule   string       // innermost variable invoked
 
-	// Groups
-	groups      []string
-	namedGroups map[string]string
+	// Groups saved in current stack frame
+	groups []*Group
 
 	// Call stack
-	levels    int // execute(pat) won't push callstack, use additional counter instead
-	callstack []stackFrame
+	levels     int // execute(pat) won't push callstack, use additional counter instead
+	callstack  []stackFrame
+	lookaheads int // number of isolated frames in callstack
 
 	// Grammar tree construction
-	scopes   []map[string]Pattern
+	scopes   *namespace
 	capstack []captureThunk
+
+	// Semantic values computed by actions
+	valstack [][]interface{}
+
+	// Dynamic symbol tables
+	symbols   *symbolEntry
+	symscopes []*symbolEntry
+
+	// Hooks queued by OnCommit
+	deferred []deferredHook
 }
 
 // Local values of running pattern.
 type localValues struct {
-	i int // loop counter
+	i      int        // loop counter
+	scopes *namespace // saved namespaces
+	ncaps  int        // saved number of captures (or syntax tree nodes)
 
 	// to be extended
 }
 
 // Return values of pattern match
 type returnValues struct {
-	ok          bool
-	n           int
-	groups      []string
-	namedGroups map[string]string
+	ok     bool
+	n      int
+	groups []*Group
 }
 
 // Callstack frame.
 type stackFrame struct {
-	pat         Pattern
-	at          int
-	n           int
-	locals      localValues
-	levels      int
-	groups      []string
-	namedGroups map[string]string
+	pat      Pattern
+	at       int
+	n        int
+	locals   localValues
+	levels   int
+	rule     string
+	groups   []*Group
+	symbols  *symbolEntry
+	ncaps    int // number of captures (or syntax tree nodes) before the call
+	nvals    int // number of values computed before the call
+	ndefers  int // number of hooks queued before the call
+	isolated bool
+}
+
+// Namespace for variable definitions, linked to its upper level.
+type namespace struct {
+	vars  map[string]Pattern
+	auto  bool // rules captured automatically
+	upper *namespace
 }
 
 // Incomplete grammar tree construction.
 type captureThunk struct {
-	cons NonTerminalConstructor
-	args []Capture
+	cons    NonTerminalConstructor
+	ctxcons ContextConstructor
+	rule    string
+	args    []Capture
+}
+
+// Hook queued along with the matched text and its position.
+type deferredHook struct {
+	hook func(string, Position) error
+	text string
+	pos  Position
+}
+
+// Symbol added to a dynamic symbol set, entries are linked in the reversed
+// order of addition and shared between stack frames.
+type symbolEntry struct {
+	setname string
+	text    string
+	next    *symbolEntry
 }
 
 func newContext(pat Pattern, text string, config Config) *context {
@@ -76,21 +120,28 @@ func (ctx *context) reset(pat Pattern, text string, config Config) {
 	ctx.text = text
 	ctx.at = 0
 	ctx.n = 0
-	ctx.pcalc = positionCalculator{text: text}
+	ctx.pcalc = newPositionCalculator(text, config)
 
 	ctx.pat = pat
 	ctx.locals = localValues{}
 	ctx.isret = false
 	ctx.ret = returnValues{}
+	ctx.rule = ""
 
 	ctx.levels = 0
 	ctx.callstack = nil
+	ctx.lookaheads = 0
 
 	ctx.groups = nil
-	ctx.namedGroups = nil
 
 	ctx.scopes = nil
 	ctx.capstack = []captureThunk{{cons: nil, args: nil}}
+	ctx.valstack = [][]interface{}{nil}
+
+	ctx.symbols = nil
+	ctx.symscopes = nil
+
+	ctx.deferred = nil
 }
 
 // The main loop.
@@ -119,13 +170,17 @@ func (ctx *context) call(callee Pattern) error {
 		return errorCallstackOverflow
 	}
 	ctx.callstack = append(ctx.callstack, stackFrame{
-		pat:         ctx.pat,
-		at:          ctx.at,
-		n:           ctx.n,
-		locals:      ctx.locals,
-		levels:      ctx.levels,
-		groups:      ctx.groups,
-		namedGroups: ctx.namedGroups,
+		pat:     ctx.pat,
+		at:      ctx.at,
+		n:       ctx.n,
+		locals:  ctx.locals,
+		levels:  ctx.levels,
+		rule:    ctx.rule,
+		groups:  ctx.groups,
+		symbols: ctx.symbols,
+		ncaps:   len(ctx.capstack[len(ctx.capstack)-1].args),
+		nvals:   len(ctx.valstack[len(ctx.valstack)-1]),
+		ndefers: len(ctx.deferred),
 	})
 	ctx.levels++
 
@@ -138,11 +193,21 @@ func (ctx *context) call(callee Pattern) error {
 	ctx.isret = false
 	ctx.ret = returnValues{}
 	ctx.groups = nil
-	ctx.namedGroups = nil
 
 	return nil
 }
 
+// Invokes the callee as a lookahead, whose groups, captures, symbols and
+// values are discarded on return, even if matched.
+func (ctx *context) lookahead(callee Pattern) error {
+	if err := ctx.call(callee); err != nil {
+		return err
+	}
+	ctx.callstack[len(ctx.callstack)-1].isolated = true
+	ctx.lookaheads++
+	return nil
+}
+
 // Invokes the callee without snapshotting the matching state.
 // No text should be already consumed before an execute() call.
 func (ctx *context) execute(callee Pattern) error {
@@ -171,10 +236,9 @@ func (ctx *context) execute(callee Pattern) error {
 // without consuming any text.
 func (ctx *context) predicates(ok bool) error {
 	return ctx.returns(returnValues{
-		ok:          ok,
-		n:           0,
-		groups:      ctx.groups,
-		namedGroups: ctx.namedGroups,
+		ok:     ok,
+		n:      0,
+		groups: ctx.groups,
 	})
 }
 
@@ -182,10 +246,9 @@ func (ctx *context) predicates(ok bool) error {
 // and commits the text already consumed.
 func (ctx *context) commit() error {
 	return ctx.returns(returnValues{
-		ok:          true,
-		n:           ctx.n,
-		groups:      ctx.groups,
-		namedGroups: ctx.namedGroups,
+		ok:     true,
+		n:      ctx.n,
+		groups: ctx.groups,
 	})
 }
 
@@ -194,6 +257,11 @@ func (ctx *context) returns(ret returnValues) error {
 	ctx.isret = true
 	ctx.ret = ret
 
+	// text consumed by terminal patterns are kept in syntax tree
+	if ctx.config.SyntaxTree && ret.ok && ret.n > 0 && isTerminalPattern(ctx.pat) {
+		ctx.cut(ctx.pat, ctx.at-ret.n, ctx.at)
+	}
+
 	if len(ctx.callstack) > 0 {
 		// pop callstack
 		if len(ctx.callstack) < 1 || ctx.levels < 1 {
@@ -209,23 +277,28 @@ func (ctx *context) returns(ret returnValues) error {
 		ctx.n = frame.n
 		ctx.locals = frame.locals
 		ctx.levels = frame.levels
+		ctx.rule = frame.rule
 		ctx.groups = frame.groups
-		ctx.namedGroups = frame.namedGroups
+		if frame.isolated {
+			ctx.lookaheads--
+		}
+
+		// discard symbols, captures, values and queued hooks added by
+		// the dismatched or isolated callee
+		if !ret.ok || frame.isolated {
+			ctx.symbols = frame.symbols
+			ctx.truncateCaptures(frame.ncaps)
+			ctx.truncateValues(frame.nvals)
+			ctx.deferred = ctx.deferred[:frame.ndefers]
+		}
 
 		// update groups
-		if ret.ok {
+		if ret.ok && !frame.isolated {
 			if len(ctx.groups) == 0 {
 				ctx.groups = ret.groups
 			} else {
 				ctx.groups = append(ctx.groups, ret.groups...)
 			}
-			if len(ctx.namedGroups) == 0 {
-				ctx.namedGroups = ret.namedGroups
-			} else {
-				for n, g := range ret.namedGroups {
-					ctx.namedGroups[n] = g
-				}
-			}
 		}
 	} else {
 		// terminate pattern matching normally
@@ -254,10 +327,15 @@ func (ctx *context) consume(n int) {
 
 // Tell the position of cursor.
 func (ctx *context) tell() Position {
+	return ctx.tellAt(ctx.at)
+}
+
+// Tell the position of given offset.
+func (ctx *context) tellAt(offset int) Position {
 	if ctx.config.DisableLineColumnCounting {
-		return Position{Offest: ctx.at}
+		return Position{Offest: offset}
 	}
-	return ctx.pcalc.calculate(ctx.at)
+	return ctx.pcalc.calculate(offset)
 }
 
 // Tell the matched text.
@@ -289,83 +367,168 @@ func (ctx *context) nextRune() (r rune, n int) {
 }
 
 // Enters the given namespace. The upper level definitions could be overridden.
-func (ctx *context) enter(namespace map[string]Pattern) {
-	ctx.scopes = append(ctx.scopes, namespace)
+func (ctx *context) enter(vars map[string]Pattern, auto bool) {
+	ctx.scopes = &namespace{vars: vars, auto: auto, upper: ctx.scopes}
 }
 
 // Leaves current namespace.
 func (ctx *context) leave() {
-	ctx.scopes = ctx.scopes[:len(ctx.scopes)-1]
+	ctx.scopes = ctx.scopes.upper
 }
 
 // Looks up variable definition, gets nil if undefined.
 func (ctx *context) lookup(name string) Pattern {
-	for i := len(ctx.scopes) - 1; i >= 0; i-- {
-		namespace := ctx.scopes[i]
-		if pat, ok := namespace[name]; ok {
-			return pat
+	return ctx.scopes.lookup(name)
+}
+
+// Looks up variable definition from the namespace and its upper levels,
+// gets nil if undefined.
+func (ns *namespace) lookup(name string) Pattern {
+	pat, _ := ns.lookupRule(name)
+	return pat
+}
+
+// Looks up variable definition, also tells if it is defined by CLet.
+func (ns *namespace) lookupRule(name string) (Pattern, bool) {
+	for ; ns != nil; ns = ns.upper {
+		if pat, ok := ns.vars[name]; ok {
+			return pat, ns.auto
+		}
+	}
+	return nil, false
+}
+
+// Enters a new symbol scope, symbols added inside would be dropped on leaving.
+func (ctx *context) enterSymbolScope() {
+	ctx.symscopes = append(ctx.symscopes, ctx.symbols)
+}
+
+// Leaves current symbol scope.
+func (ctx *context) leaveSymbolScope() {
+	ctx.symbols = ctx.symscopes[len(ctx.symscopes)-1]
+	ctx.symscopes = ctx.symscopes[:len(ctx.symscopes)-1]
+}
+
+// Adds the text to the symbol set named setname.
+func (ctx *context) declare(setname, text string) {
+	ctx.symbols = &symbolEntry{
+		setname: setname,
+		text:    text,
+		next:    ctx.symbols,
+	}
+}
+
+// Searches the longest symbol in set named setname, which is the prefix
+// of the remaining text. Returns the length of symbol found.
+func (ctx *context) searchSymbol(setname string) (n int, ok bool) {
+	tail := ctx.text[ctx.at:]
+	for sym := ctx.symbols; sym != nil; sym = sym.next {
+		if sym.setname != setname || len(sym.text) < n {
+			continue
+		}
+		if strings.HasPrefix(tail, sym.text) && (!ok || len(sym.text) > n) {
+			n, ok = len(sym.text), true
+		}
+	}
+	return n, ok
+}
+
+// Queues the hook of OnCommit.
+func (ctx *context) queue(hook func(string, Position) error, text string, pos Position) {
+	if !ctx.hooking() {
+		return
+	}
+
+	ctx.deferred = append(ctx.deferred, deferredHook{hook: hook, text: text, pos: pos})
+}
+
+// Invokes the hooks queued after the first n hooks in order, then drops them.
+func (ctx *context) flush(n int) error {
+	hooks := ctx.deferred[n:]
+	ctx.deferred = ctx.deferred[:n]
+	for _, d := range hooks {
+		if err := d.hook(d.text, d.pos); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// Stores matched text to named group if grpname is abempty,
-// or push the text to groups if grpname is empty.
-func (ctx *context) group(grpname string) {
+// Saves matched text to a group named grpname, or an anonymous group if
+// grpname is empty. The groups saved in current stack frame after the first
+// nsubs groups are nested into the new group.
+func (ctx *context) group(grpname string, nsubs int) {
 	if ctx.config.DisableGrouping {
 		return
 	}
 
-	span := ctx.span()
-	if grpname != "" {
-		if ctx.namedGroups == nil {
-			ctx.namedGroups = map[string]string{grpname: span}
-		} else {
-			ctx.namedGroups[grpname] = span
-		}
-	} else {
-		ctx.groups = append(ctx.groups, span)
+	g := &Group{
+		Name:  grpname,
+		Text:  ctx.span(),
+		Start: ctx.tellAt(ctx.at - ctx.n),
+		End:   ctx.tell(),
 	}
+	if len(ctx.groups) > nsubs {
+		g.Subs = ctx.groups[nsubs:]
+	}
+	ctx.groups = append(ctx.groups[:nsubs:nsubs], g)
 }
 
-// Gets the text stored in named groups if grpname is abempty,
-// or gets the the lastest text in groups if grpname is empty.
+// Gets the text stored in the lastest group named grpname, or the lastest
+// anonymous group if grpname is empty.
 // Returns empty string when not found.
 func (ctx *context) refer(grpname string) string {
 	if ctx.config.DisableGrouping {
 		return ""
 	}
 
-	if grpname != "" {
-		g, ok := ctx.namedGroups[grpname]
-		if ok {
-			return g
-		}
-		for i := len(ctx.callstack) - 1; i >= 0; i-- {
-			g, ok := ctx.callstack[i].namedGroups[grpname]
-			if ok {
-				return g
-			}
+	if g := lastGroup(ctx.groups, grpname); g != nil {
+		return g.Text
+	}
+	for i := len(ctx.callstack) - 1; i >= 0; i-- {
+		if g := lastGroup(ctx.callstack[i].groups, grpname); g != nil {
+			return g.Text
 		}
+	}
+	return ""
+}
+
+// Gets the text stored in the anonymous group indexed by index in the
+// order they are saved, or counted from the lastest one if index is
+// negative. Returns empty string when not found.
+func (ctx *context) referIndex(index int) string {
+	if ctx.config.DisableGrouping {
 		return ""
 	}
 
-	if len(ctx.groups) != 0 {
-		return ctx.groups[len(ctx.groups)-1]
+	var groups []*Group
+	for i := range ctx.callstack {
+		groups = appendGroups(groups, ctx.callstack[i].groups, "")
 	}
-	for i := len(ctx.callstack) - 1; i >= 0; i-- {
-		groups := ctx.callstack[i].groups
-		if len(groups) != 0 {
-			return groups[len(groups)-1]
-		}
+	groups = appendGroups(groups, ctx.groups, "")
+	if index < 0 {
+		index += len(groups)
 	}
-	return ""
+	if index < 0 || index >= len(groups) {
+		return ""
+	}
+	return groups[index].Text
+}
+
+// Tells if the parse captures are constructed.
+func (ctx *context) capturing() bool {
+	return !ctx.config.DisableCapturing && !ctx.config.SyntaxTree && ctx.lookaheads == 0
+}
+
+// Tells if the hooks of Trigger are invoked.
+func (ctx *context) hooking() bool {
+	return ctx.lookaheads == 0
 }
 
 // Pushes a constructed capture (terminal or non-terminal)
 // to the current non-terminal construction.
 func (ctx *context) push(cap Capture) error {
-	if ctx.config.DisableCapturing {
+	if !ctx.capturing() {
 		return nil
 	}
 
@@ -378,9 +541,22 @@ func (ctx *context) push(cap Capture) error {
 	return nil
 }
 
+// Discards the captures (or the syntax tree nodes in SyntaxTree mode)
+// constructed after the first n captures of current construction.
+func (ctx *context) truncateCaptures(n int) {
+	if !ctx.capturing() && !ctx.config.SyntaxTree {
+		return
+	}
+
+	argsp := &ctx.capstack[len(ctx.capstack)-1].args
+	if len(*argsp) > n {
+		*argsp = (*argsp)[:n]
+	}
+}
+
 // Begins a non-terminal construction.
 func (ctx *context) begin(cons NonTerminalConstructor) {
-	if ctx.config.DisableCapturing {
+	if !ctx.capturing() {
 		return
 	}
 
@@ -390,9 +566,24 @@ func (ctx *context) begin(cons NonTerminalConstructor) {
 	})
 }
 
-// Finishes current non-terminal construction.
-func (ctx *context) end(matched bool) error {
-	if ctx.config.DisableCapturing {
+// Begins a construction using context constructor, rule is the name
+// reported to the constructor.
+func (ctx *context) beginContext(cons ContextConstructor, rule string) {
+	if !ctx.capturing() {
+		return
+	}
+
+	ctx.capstack = append(ctx.capstack, captureThunk{
+		ctxcons: cons,
+		rule:    rule,
+		args:    nil,
+	})
+}
+
+// Finishes current construction, n is the length of text matched from
+// the cursor.
+func (ctx *context) end(matched bool, n int) error {
+	if !ctx.capturing() {
 		return nil
 	}
 
@@ -407,12 +598,133 @@ func (ctx *context) end(matched bool) error {
 		return nil
 	}
 
-	if thunk.cons == nil {
+	var cap Capture
+	var err error
+	switch {
+	case thunk.ctxcons != nil:
+		cap, err = thunk.ctxcons(CaptureContext{
+			Rule:  thunk.rule,
+			Text:  ctx.text[ctx.at : ctx.at+n],
+			Start: ctx.tell(),
+			End:   ctx.tellAt(ctx.at + n),
+		}, thunk.args)
+	case thunk.cons != nil:
+		cap, err = thunk.cons(thunk.args)
+	default:
 		return errorNilConstructor
 	}
-	cap, err := thunk.cons(thunk.args)
 	if err != nil {
 		return err
 	}
 	return ctx.push(cap)
 }
+
+// Finishes the construction begun by begin(nil), gets the captures inside.
+func (ctx *context) collect() ([]Capture, error) {
+	if !ctx.capturing() {
+		return nil, nil
+	}
+
+	if len(ctx.capstack) < 2 {
+		return nil, errorCornerCase
+	}
+
+	thunk := ctx.capstack[len(ctx.capstack)-1]
+	ctx.capstack = ctx.capstack[:len(ctx.capstack)-1]
+	return thunk.args, nil
+}
+
+// Tells if the semantic values are computed.
+func (ctx *context) acting() bool {
+	return !ctx.config.DisableActions && ctx.lookaheads == 0
+}
+
+// Pushes a semantic value to the current action.
+func (ctx *context) pushValue(val interface{}) {
+	if !ctx.acting() {
+		return
+	}
+
+	valsp := &ctx.valstack[len(ctx.valstack)-1]
+	*valsp = append(*valsp, val)
+}
+
+// Begins collecting values for an action.
+func (ctx *context) beginValues() {
+	if !ctx.acting() {
+		return
+	}
+
+	ctx.valstack = append(ctx.valstack, nil)
+}
+
+// Finishes collecting values for an action, gets the values collected.
+func (ctx *context) endValues() []interface{} {
+	if !ctx.acting() {
+		return nil
+	}
+
+	vals := ctx.valstack[len(ctx.valstack)-1]
+	ctx.valstack = ctx.valstack[:len(ctx.valstack)-1]
+	return vals
+}
+
+// Discards the values computed after the first n values of current action.
+func (ctx *context) truncateValues(n int) {
+	valsp := &ctx.valstack[len(ctx.valstack)-1]
+	if len(*valsp) > n {
+		*valsp = (*valsp)[:n]
+	}
+}
+
+// Begins a rule node of syntax tree.
+func (ctx *context) beginRule(rule string) {
+	if !ctx.config.SyntaxTree {
+		return
+	}
+
+	ctx.capstack = append(ctx.capstack, captureThunk{
+		rule: rule,
+		args: nil,
+	})
+}
+
+// Finishes current rule node of syntax tree, n is the length of text
+// matched from the cursor.
+func (ctx *context) endRule(matched bool, n int) error {
+	if !ctx.config.SyntaxTree {
+		return nil
+	}
+
+	if len(ctx.capstack) < 2 {
+		return errorCornerCase
+	}
+
+	thunk := ctx.capstack[len(ctx.capstack)-1]
+	ctx.capstack = ctx.capstack[:len(ctx.capstack)-1]
+
+	if !matched {
+		return nil
+	}
+	node := newSyntaxNode(thunk.rule, thunk.args, ctx.at, ctx.at+n)
+	argsp := &ctx.capstack[len(ctx.capstack)-1].args
+	*argsp = append(*argsp, node)
+	return nil
+}
+
+// Adds the text consumed by terminal pattern to current rule node.
+func (ctx *context) cut(term Pattern, from, to int) {
+	argsp := &ctx.capstack[len(ctx.capstack)-1].args
+	*argsp = append(*argsp, &SyntaxNode{
+		Start: Position{Offest: from},
+		End:   Position{Offest: to},
+		term:  term,
+	})
+}
+
+// Builds the syntax tree of the matched text.
+func (ctx *context) syntaxTree(n int) *SyntaxNode {
+	root := newSyntaxNode("", ctx.capstack[0].args, 0, n)
+	root.locate(ctx)
+	return root
+}
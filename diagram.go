@@ -0,0 +1,676 @@
+package peg
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Layout metrics of railroad diagrams, in pixels.
+const (
+	railroadArc       = 10 // radius of arcs
+	railroadGap       = 10 // horizontal gap between items of sequences
+	railroadVSpace    = 8  // vertical space between branches
+	railroadBoxHeight = 22 // height of terminal boxes
+	railroadCharWidth = 8  // width of characters in monospace font
+	railroadLabelLine = 14 // height of labels of loops and groups
+	railroadPadding   = 20 // padding around the diagram
+)
+
+const railroadStyle = `<style>
+path { fill: none; stroke: #333; stroke-width: 2; }
+rect { fill: #fff; stroke: #333; stroke-width: 2; }
+rect.special { fill: #eee; }
+rect.group { fill: none; stroke: #999; stroke-width: 1; stroke-dasharray: 4 3; }
+text { font: 13px monospace; text-anchor: middle; fill: #000; }
+text.label { font-size: 11px; text-anchor: start; fill: #666; }
+text.loop { font-size: 11px; fill: #666; }
+a text { fill: #03c; }
+</style>`
+
+// Kinds of diagram nodes.
+const (
+	diagramTerminal  = iota // literal text or runes
+	diagramSpecial          // anchors, any rune, abort, etc.
+	diagramReference        // rule referred by variable
+	diagramSkip             // empty path
+	diagramSequence
+	diagramChoice
+	diagramOptional
+	diagramRepeat
+	diagramGroup // labeled group of lookaround, captures, etc.
+)
+
+// Diagram is the visual structure of a grammar, one diagram per rule, which
+// renders into Graphviz DOT, SVG or HTML railroad diagrams without external
+// tools.
+//
+// Rules are the variables defined by Let (including the nested ones), the
+// entry rule is named "". Templates are shown as rules with parameters.
+type Diagram struct {
+	rules []*diagramRule
+	ids   map[string]bool
+}
+
+// Rule of the grammar to be drawn.
+type diagramRule struct {
+	name   string
+	id     string
+	params []string
+	pat    Pattern
+	scope  map[string]*diagramRule
+	node   *diagramNode
+}
+
+// Node of the diagram tree.
+type diagramNode struct {
+	kind   int
+	label  string
+	target string // id of referred rule
+	subs   []*diagramNode
+}
+
+// Layout of railroad diagram node.
+type railroadBox struct {
+	node     *diagramNode
+	width    int
+	up, down int // extent above and below the line
+	offsets  []int
+	subs     []*railroadBox
+}
+
+// NewDiagram builds the diagram of the pattern.
+func NewDiagram(pat Pattern) *Diagram {
+	d := &Diagram{ids: make(map[string]bool)}
+	d.define("", nil, pat, nil)
+	for i := 0; i < len(d.rules); i++ {
+		rule := d.rules[i]
+		rule.node = d.convert(rule.pat, rule.scope)
+	}
+
+	// Omits the entry which simply refers to another rule.
+	if len(d.rules) > 1 {
+		if entry := d.rules[0].node; entry.kind == diagramReference && entry.target != "" {
+			d.rules = d.rules[1:]
+		}
+	}
+	return d
+}
+
+// Rules returns names of the rules in the order of diagrams.
+func (d *Diagram) Rules() []string {
+	names := make([]string, len(d.rules))
+	for i, rule := range d.rules {
+		names[i] = rule.name
+	}
+	return names
+}
+
+// WriteDOT writes all the rules as a Graphviz digraph, a cluster per rule.
+func (d *Diagram) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	roots := make(map[string]string, len(d.rules))
+	for i, rule := range d.rules {
+		roots[rule.id] = fmt.Sprintf("r%dn0", i)
+	}
+
+	fmt.Fprintf(bw, "digraph grammar {\n")
+	fmt.Fprintf(bw, "\tcompound=true;\n\tordering=out;\n")
+	fmt.Fprintf(bw, "\tnode [fontname=monospace, fontsize=12];\n")
+	var refs []string
+	for i, rule := range d.rules {
+		fmt.Fprintf(bw, "\tsubgraph cluster_%d {\n", i)
+		fmt.Fprintf(bw, "\t\tlabel=%s;\n", dotQuote(rule.title()))
+		n := 0
+		var write func(node *diagramNode) string
+		write = func(node *diagramNode) string {
+			id := fmt.Sprintf("r%dn%d", i, n)
+			n++
+			fmt.Fprintf(bw, "\t\t%s [%s];\n", id, node.dotAttributes())
+			for _, sub := range node.subs {
+				fmt.Fprintf(bw, "\t\t%s -> %s;\n", id, write(sub))
+			}
+			if node.kind == diagramReference {
+				if root, ok := roots[node.target]; ok {
+					refs = append(refs, fmt.Sprintf("\t%s -> %s [style=dashed, constraint=false];\n", id, root))
+				}
+			}
+			return id
+		}
+		write(rule.node)
+		fmt.Fprintf(bw, "\t}\n")
+	}
+	for _, ref := range refs {
+		bw.WriteString(ref)
+	}
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+// WriteSVG writes the railroad diagram of the rule as a standalone SVG.
+func (d *Diagram) WriteSVG(w io.Writer, name string) error {
+	for _, rule := range d.rules {
+		if rule.name == name {
+			bw := bufio.NewWriter(w)
+			bw.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+			rule.writeSVG(bw)
+			return bw.Flush()
+		}
+	}
+	return errorf("rule %q is not found in diagram", name)
+}
+
+// WriteHTML writes the railroad diagrams of all the rules as a standalone
+// HTML document, the referred rules are linked.
+func (d *Diagram) WriteHTML(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	bw.WriteString("<title>Grammar</title>\n")
+	bw.WriteString("<style>body { font-family: sans-serif; } h2 { font: bold 15px monospace; }</style>\n")
+	bw.WriteString("</head>\n<body>\n")
+	for _, rule := range d.rules {
+		fmt.Fprintf(bw, "<h2 id=\"%s\">%s</h2>\n", rule.id, html.EscapeString(rule.title()))
+		rule.writeSVG(bw)
+	}
+	bw.WriteString("</body>\n</html>\n")
+	return bw.Flush()
+}
+
+// Defines a rule with unique id.
+func (d *Diagram) define(name string, params []string, pat Pattern, scope map[string]*diagramRule) *diagramRule {
+	base := "rule"
+	if name != "" {
+		base = "rule-" + strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+				return r
+			}
+			return '_'
+		}, name)
+	}
+	id := base
+	for i := 2; d.ids[id]; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	d.ids[id] = true
+
+	rule := &diagramRule{name: name, id: id, params: params, pat: pat, scope: scope}
+	d.rules = append(d.rules, rule)
+	return rule
+}
+
+// Converts the pattern into diagram tree, the variables are resolved in scope.
+func (d *Diagram) convert(pat Pattern, scope map[string]*diagramRule) *diagramNode {
+	convert := func(pat Pattern) *diagramNode {
+		return d.convert(pat, scope)
+	}
+	leaf := func(kind int, label string) *diagramNode {
+		return &diagramNode{kind: kind, label: label}
+	}
+	group := func(label string, sub *diagramNode) *diagramNode {
+		return &diagramNode{kind: diagramGroup, label: label, subs: []*diagramNode{sub}}
+	}
+	repeat := func(label string, optional bool, sub *diagramNode) *diagramNode {
+		node := &diagramNode{kind: diagramRepeat, label: label, subs: []*diagramNode{sub}}
+		if optional {
+			node = &diagramNode{kind: diagramOptional, subs: []*diagramNode{node}}
+		}
+		return node
+	}
+	list := func(kind int, pats []Pattern) *diagramNode {
+		node := &diagramNode{kind: kind}
+		for _, pat := range pats {
+			node.subs = append(node.subs, convert(pat))
+		}
+		return node
+	}
+	reference := func(name string) *diagramNode {
+		node := leaf(diagramReference, name)
+		if rule := scope[name]; rule != nil {
+			node.target = rule.id
+		}
+		return node
+	}
+
+	switch pat := pat.(type) {
+	case *patternBoolean:
+		if pat.ok {
+			return leaf(diagramSkip, "")
+		}
+		return leaf(diagramSpecial, "fail")
+	case patternAnyRune:
+		return leaf(diagramSpecial, "any")
+	case patternEOFPredicate:
+		return leaf(diagramSpecial, "EOF")
+	case *patternLineAnchorPredicate:
+		if pat.linestart {
+			return leaf(diagramSpecial, "SOL")
+		}
+		return leaf(diagramSpecial, "EOL")
+	case *patternAbort:
+		return leaf(diagramSpecial, "abort "+strconv.Quote(pat.msg))
+	case *patternSkip:
+		return leaf(diagramSpecial, fmt.Sprintf("any <%d>", pat.n))
+	case *patternSymbolSet:
+		return leaf(diagramSpecial, "symbol "+pat.setname)
+
+	case *patternTextSet:
+		node := &diagramNode{kind: diagramChoice}
+		for _, s := range pat.sorted {
+			if pat.insensitive {
+				node.subs = append(node.subs, leaf(diagramTerminal, "I"+strconv.Quote(s)))
+			} else {
+				node.subs = append(node.subs, leaf(diagramTerminal, strconv.Quote(s)))
+			}
+		}
+		return node
+	case *patternBackwardPredicate:
+		return group("behind", leaf(diagramTerminal, strconv.Quote(pat.text)))
+	case *patternBackwardPredicateReferring:
+		return group("behind", convert(&patternTextReferring{
+			grpname: pat.grpname, indexed: pat.indexed, index: pat.index}))
+
+	case *patternSequence:
+		return list(diagramSequence, pat.pats)
+	case *patternAlternative:
+		return list(diagramChoice, pat.pats)
+	case *patternAnyRuneUntil:
+		if pat.without {
+			return group("until", convert(pat.pat))
+		}
+		return group("until, including", convert(pat.pat))
+	case *patternQualifierAtLeast:
+		switch pat.n {
+		case 0:
+			return repeat("", true, convert(pat.pat))
+		case 1:
+			return repeat("", false, convert(pat.pat))
+		default:
+			return repeat(fmt.Sprintf("%d+", pat.n), false, convert(pat.pat))
+		}
+	case *patternQualifierOptional:
+		return &diagramNode{kind: diagramOptional, subs: []*diagramNode{convert(pat.pat)}}
+	case *patternQualifierRange:
+		if pat.m == 0 && pat.n == 1 {
+			return &diagramNode{kind: diagramOptional, subs: []*diagramNode{convert(pat.pat)}}
+		}
+		label := fmt.Sprintf("%d..%d", pat.m, pat.n)
+		if pat.m == pat.n {
+			label = fmt.Sprintf("%d", pat.n)
+		}
+		return repeat(label, pat.m == 0, convert(pat.pat))
+
+	case *patternPredicate:
+		if pat.not {
+			return group("!", convert(pat.pat))
+		}
+		if pat.keep {
+			return group("peek", convert(pat.pat))
+		}
+		return group("&", convert(pat.pat))
+	case *patternAndPredicate:
+		node := &diagramNode{kind: diagramSequence}
+		for _, pat := range pat.pats {
+			node.subs = append(node.subs, group("&", convert(pat)))
+		}
+		return node
+	case *patternOrPredicate:
+		return group("&", list(diagramChoice, pat.pats))
+	case *patternIf:
+		return &diagramNode{kind: diagramChoice, subs: []*diagramNode{
+			{kind: diagramSequence, subs: []*diagramNode{group("&", convert(pat.cond)), convert(pat.yes)}},
+			{kind: diagramSequence, subs: []*diagramNode{group("!", convert(pat.cond)), convert(pat.no)}},
+		}}
+	case *patternSwitch:
+		node := &diagramNode{kind: diagramChoice}
+		for _, c := range pat.cases {
+			node.subs = append(node.subs, &diagramNode{kind: diagramSequence,
+				subs: []*diagramNode{group("&", convert(c.cond)), convert(c.then)}})
+		}
+		node.subs = append(node.subs, convert(pat.otherwise))
+		return node
+
+	case *patternGrouping:
+		if pat.grpname == "" {
+			return group("group", convert(pat.pat))
+		}
+		return group("group "+pat.grpname, convert(pat.pat))
+	case *patternTrigger:
+		return group("trigger", convert(pat.pat))
+	case *patternDeferredTrigger:
+		return group("on commit", convert(pat.pat))
+	case *patternCommit:
+		return group("commit", convert(pat.pat))
+	case *patternInjector:
+		switch origin := pat.origin.(type) {
+		case int:
+			return group(fmt.Sprintf("trunc %d", origin), convert(pat.pat))
+		case func(string) bool:
+			return group("check", convert(pat.pat))
+		default:
+			return group("inject", convert(pat.pat))
+		}
+
+	case *patternLet:
+		inner := make(map[string]*diagramRule, len(scope)+len(pat.vars))
+		for name, rule := range scope {
+			inner[name] = rule
+		}
+		names := make([]string, 0, len(pat.vars))
+		for name := range pat.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var params []string
+			body := pat.vars[name]
+			if tpl, ok := body.(*patternTemplate); ok {
+				params, body = tpl.params, tpl.body
+			}
+			inner[name] = d.define(name, params, body, inner)
+		}
+		for _, name := range names {
+			// parameters hide the variables outside
+			if rule := inner[name]; len(rule.params) != 0 {
+				rule.scope = make(map[string]*diagramRule, len(inner))
+				for k, v := range inner {
+					rule.scope[k] = v
+				}
+				for _, param := range rule.params {
+					rule.scope[param] = nil
+				}
+			}
+		}
+		return d.convert(pat.pat, inner)
+	case *patternRule:
+		if pat.transparent {
+			return group("transparent", convert(pat.pat))
+		}
+		return group("silent", convert(pat.pat))
+	case *patternCaptureVariable:
+		if pat.cons == nil {
+			return reference(pat.varname)
+		}
+		return group("capture", reference(pat.varname))
+	case *patternCaptureToken:
+		return group(fmt.Sprintf("token %d", pat.toktype), convert(pat.pat))
+	case *patternCaptureCons:
+		return group("capture", convert(pat.pat))
+	case *patternCaptureTerm:
+		return group("token", convert(pat.pat))
+	case *patternCaptureContext:
+		return group("capture", convert(pat.pat))
+	case *patternCaptureFold:
+		return group("fold", convert(pat.pat))
+	case *patternCaptureTable:
+		return group("table", convert(pat.pat))
+	case *patternCaptureMatchTime:
+		return group("match-time", convert(pat.pat))
+	case *patternTemplate:
+		return group(fmt.Sprintf("template(%s)", strings.Join(pat.params, ", ")), convert(pat.body))
+	case *patternCallTemplate:
+		callee := reference(pat.varname)
+		var params []string
+		if rule := scope[pat.varname]; rule != nil {
+			params = rule.params
+		}
+		node := &diagramNode{kind: diagramSequence}
+		for i, arg := range pat.args {
+			label := fmt.Sprintf("#%d", i+1)
+			if i < len(params) {
+				label = params[i]
+			}
+			node.subs = append(node.subs, group(label, convert(arg)))
+		}
+		if len(node.subs) == 0 {
+			node.subs = append(node.subs, leaf(diagramSkip, ""))
+		}
+		label := "call " + pat.varname
+		if pat.cons != nil {
+			label = "capture " + label
+		}
+		return &diagramNode{kind: diagramGroup, label: label, target: callee.target, subs: []*diagramNode{node}}
+
+	case *patternSymbolDeclare:
+		return group("symbol "+pat.setname, convert(pat.pat))
+	case *patternSymbolScope:
+		return group("symbol scope", convert(pat.pat))
+	case *patternAction:
+		return group("action", convert(pat.pat))
+	case *patternActionText:
+		return group("action", convert(pat.pat))
+	}
+	return leaf(diagramTerminal, pat.String())
+}
+
+func (rule *diagramRule) title() string {
+	if rule.name == "" {
+		return "(entry)"
+	}
+	if rule.params != nil {
+		return fmt.Sprintf("%s(%s)", rule.name, strings.Join(rule.params, ", "))
+	}
+	return rule.name
+}
+
+func (node *diagramNode) dotAttributes() string {
+	var attrs string
+	switch node.kind {
+	case diagramTerminal:
+		attrs = "shape=box, style=rounded, label=" + dotQuote(node.label)
+	case diagramSpecial:
+		attrs = "shape=box, style=\"rounded,filled\", fillcolor=lightgrey, label=" + dotQuote(node.label)
+	case diagramReference:
+		attrs = "shape=box, label=" + dotQuote(node.label)
+	case diagramSkip:
+		attrs = "shape=point"
+	case diagramSequence:
+		attrs = "shape=rarrow, label=\"seq\""
+	case diagramChoice:
+		attrs = "shape=diamond, label=\"alt\""
+	case diagramOptional:
+		attrs = "shape=circle, label=\"?\""
+	case diagramRepeat:
+		if node.label == "" {
+			attrs = "shape=circle, label=\"+\""
+		} else {
+			attrs = "shape=circle, label=" + dotQuote(node.label)
+		}
+	case diagramGroup:
+		if node.label == "&" || node.label == "!" || node.label == "behind" {
+			attrs = "shape=hexagon, label=" + dotQuote(node.label)
+		} else {
+			attrs = "shape=box, style=dashed, label=" + dotQuote(node.label)
+		}
+	}
+	return attrs
+}
+
+func dotQuote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	s = strings.Replace(s, "\n", "\\n", -1)
+	return "\"" + s + "\""
+}
+
+// Writes the rule as a SVG element.
+func (rule *diagramRule) writeSVG(w *bufio.Writer) {
+	box := layoutRailroad(rule.node)
+	width := box.width + 2*railroadPadding + 2*railroadGap
+	height := box.up + box.down + 2*railroadPadding
+	y := railroadPadding + box.up
+
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" "+
+		"width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n", width, height, width, height)
+	w.WriteString(railroadStyle)
+	w.WriteByte('\n')
+
+	// start and end marks
+	x0, x1 := railroadPadding, width-railroadPadding
+	fmt.Fprintf(w, "<path d=\"M%d %dv%d M%d %dh%d\"/>\n", x0, y-railroadArc, 2*railroadArc, x0, y, railroadGap)
+	fmt.Fprintf(w, "<path d=\"M%d %dh%d M%d %dv%d\"/>\n", x1-railroadGap, y, railroadGap, x1, y-railroadArc, 2*railroadArc)
+	box.draw(w, x0+railroadGap, y)
+	w.WriteString("</svg>\n")
+}
+
+func textWidth(s string) int {
+	return utf8.RuneCountInString(s) * railroadCharWidth
+}
+
+// Computes the layout of railroad diagram.
+func layoutRailroad(node *diagramNode) *railroadBox {
+	box := &railroadBox{node: node}
+	for _, sub := range node.subs {
+		box.subs = append(box.subs, layoutRailroad(sub))
+	}
+
+	switch node.kind {
+	case diagramTerminal, diagramSpecial, diagramReference:
+		box.width = textWidth(node.label) + 2*railroadArc
+		box.up, box.down = railroadBoxHeight/2, railroadBoxHeight/2
+	case diagramSkip:
+	case diagramSequence:
+		for i, sub := range box.subs {
+			if i > 0 {
+				box.width += railroadGap
+			}
+			box.width += sub.width
+			box.up = maxInt(box.up, sub.up)
+			box.down = maxInt(box.down, sub.down)
+		}
+	case diagramChoice, diagramOptional:
+		subs := box.subs
+		if node.kind == diagramOptional {
+			subs = append([]*railroadBox{{node: &diagramNode{kind: diagramSkip}}}, subs...)
+			box.subs = subs
+		}
+		offset := 0
+		for i, sub := range subs {
+			if i > 0 {
+				offset = maxInt(offset+subs[i-1].down+railroadVSpace+sub.up, offset+2*railroadArc)
+			}
+			box.offsets = append(box.offsets, offset)
+			box.width = maxInt(box.width, sub.width)
+		}
+		box.width += 4 * railroadArc
+		if len(subs) > 0 {
+			box.up = subs[0].up
+			box.down = offset + subs[len(subs)-1].down
+		}
+	case diagramRepeat:
+		sub := box.subs[0]
+		loop := maxInt(sub.down+railroadVSpace, 2*railroadArc)
+		box.offsets = []int{loop}
+		box.width = sub.width + 4*railroadArc
+		box.up = sub.up
+		box.down = loop
+		if node.label != "" {
+			box.width = maxInt(box.width, textWidth(node.label)+4*railroadArc)
+			box.down += railroadLabelLine
+		}
+	case diagramGroup:
+		sub := box.subs[0]
+		box.width = maxInt(sub.width+2*railroadGap, textWidth(node.label)+railroadGap)
+		box.up = sub.up + railroadGap + railroadLabelLine
+		box.down = sub.down + railroadGap
+	}
+	return box
+}
+
+// Draws the node, which is entered at (x, y) and exited at (x+width, y).
+func (box *railroadBox) draw(w *bufio.Writer, x, y int) {
+	r := railroadArc
+	node := box.node
+	switch node.kind {
+	case diagramTerminal, diagramSpecial, diagramReference:
+		rx, class := 0, ""
+		switch node.kind {
+		case diagramTerminal:
+			rx = r
+		case diagramSpecial:
+			rx, class = r, " class=\"special\""
+		}
+		if node.target != "" {
+			fmt.Fprintf(w, "<a xlink:href=\"#%s\">", node.target)
+		}
+		fmt.Fprintf(w, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" rx=\"%d\"%s/>",
+			x, y-box.up, box.width, box.up+box.down, rx, class)
+		fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\">%s</text>", x+box.width/2, y+4, html.EscapeString(node.label))
+		if node.target != "" {
+			w.WriteString("</a>")
+		}
+		w.WriteByte('\n')
+
+	case diagramSkip:
+
+	case diagramSequence:
+		for i, sub := range box.subs {
+			if i > 0 {
+				fmt.Fprintf(w, "<path d=\"M%d %dh%d\"/>\n", x, y, railroadGap)
+				x += railroadGap
+			}
+			sub.draw(w, x, y)
+			x += sub.width
+		}
+
+	case diagramChoice, diagramOptional:
+		for i, sub := range box.subs {
+			offset := box.offsets[i]
+			if i == 0 {
+				fmt.Fprintf(w, "<path d=\"M%d %dh%d\"/>\n", x, y, 2*r)
+			} else {
+				fmt.Fprintf(w, "<path d=\"M%d %da%d %d 0 0 1 %d %dV%da%d %d 0 0 0 %d %d\"/>\n",
+					x, y, r, r, r, r, y+offset-r, r, r, r, r)
+			}
+			sub.draw(w, x+2*r, y+offset)
+			if i == 0 {
+				fmt.Fprintf(w, "<path d=\"M%d %dH%d\"/>\n", x+2*r+sub.width, y, x+box.width)
+			} else {
+				fmt.Fprintf(w, "<path d=\"M%d %dH%da%d %d 0 0 0 %d %dV%da%d %d 0 0 1 %d %d\"/>\n",
+					x+2*r+sub.width, y+offset, x+box.width-2*r, r, r, r, -r, y+r, r, r, r, -r)
+			}
+		}
+
+	case diagramRepeat:
+		sub := box.subs[0]
+		loop := box.offsets[0]
+		fmt.Fprintf(w, "<path d=\"M%d %dh%d\"/>\n", x, y, 2*r)
+		sub.draw(w, x+2*r, y)
+		fmt.Fprintf(w, "<path d=\"M%d %dH%d\"/>\n", x+2*r+sub.width, y, x+box.width)
+		right := x + box.width - 2*r
+		fmt.Fprintf(w, "<path d=\"M%d %da%d %d 0 0 1 %d %dV%da%d %d 0 0 1 %d %dH%da%d %d 0 0 1 %d %dV%da%d %d 0 0 1 %d %d\"/>\n",
+			right, y, r, r, r, r, y+loop-r, r, r, -r, r, x+2*r, r, r, -r, -r, y+r, r, r, r, -r)
+		if node.label != "" {
+			fmt.Fprintf(w, "<text class=\"loop\" x=\"%d\" y=\"%d\">%s</text>\n",
+				x+box.width/2, y+loop+railroadLabelLine-2, html.EscapeString(node.label))
+		}
+
+	case diagramGroup:
+		sub := box.subs[0]
+		fmt.Fprintf(w, "<rect class=\"group\" x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" rx=\"%d\"/>\n",
+			x, y-box.up, box.width, box.up+box.down, r/2)
+		if node.target != "" {
+			fmt.Fprintf(w, "<a xlink:href=\"#%s\">", node.target)
+		}
+		fmt.Fprintf(w, "<text class=\"label\" x=\"%d\" y=\"%d\">%s</text>",
+			x+railroadGap/2, y-box.up+railroadLabelLine-2, html.EscapeString(node.label))
+		if node.target != "" {
+			w.WriteString("</a>")
+		}
+		w.WriteByte('\n')
+		fmt.Fprintf(w, "<path d=\"M%d %dh%d\"/>\n", x, y, railroadGap)
+		sub.draw(w, x+railroadGap, y)
+		fmt.Fprintf(w, "<path d=\"M%d %dH%d\"/>\n", x+railroadGap+sub.width, y, x+box.width)
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,140 @@
+package peg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Grammar of simple arithmetic expressions.
+var diagramTestGrammar = Let(map[string]Pattern{
+	"expr":   Seq(V("term"), Q0(Seq(S("+-"), V("term")))),
+	"term":   Seq(V("factor"), Q0(Seq(S("*/"), V("factor")))),
+	"factor": Alt(V("number"), Seq(T("("), V("expr"), T(")")), Call("list", V("number"))),
+	"number": Seq(Q1(R('0', '9')), Q01(Seq(T("."), Qmn(1, 3, R('0', '9')))), Not(T("."))),
+	"list":   Template([]string{"item"}, Seq(T("["), J0(V("item"), T(",")), T("]"))),
+}, Seq(V("expr"), EOF))
+
+// Checks if the text is well-formed XML.
+func checkXML(t *testing.T, text string) {
+	dec := xml.NewDecoder(strings.NewReader(text))
+	dec.Strict = false
+	dec.AutoClose = []string{"meta"}
+	dec.Entity = xml.HTMLEntity
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Errorf("invalid XML: %s\n%s", err, text)
+			return
+		}
+	}
+}
+
+func TestDiagram(t *testing.T) {
+	d := NewDiagram(diagramTestGrammar)
+	rules := []string{"", "expr", "factor", "list", "number", "term"}
+	if !reflect.DeepEqual(d.Rules(), rules) {
+		t.Errorf("Rules() => %q != %q", d.Rules(), rules)
+	}
+
+	var dot bytes.Buffer
+	if err := d.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"label=\"list(item)\"",
+		"shape=diamond, label=\"alt\"",
+		"shape=rarrow, label=\"seq\"",
+		"shape=circle, label=\"1..3\"",
+		"shape=hexagon, label=\"!\"",
+		"shape=box, label=\"term\"",
+		"shape=box, style=rounded, label=\"\\\"(\\\"\"",
+		"shape=box, style=\"rounded,filled\", fillcolor=lightgrey, label=\"EOF\"",
+		"[style=dashed, constraint=false]",
+	} {
+		if !strings.Contains(dot.String(), s) {
+			t.Errorf("WriteDOT() does not contain %q:\n%s", s, dot.String())
+		}
+	}
+	if strings.Count(dot.String(), "subgraph cluster_") != len(rules) {
+		t.Errorf("WriteDOT() does not contain %d clusters", len(rules))
+	}
+
+	var page bytes.Buffer
+	if err := d.WriteHTML(&page); err != nil {
+		t.Fatal(err)
+	}
+	checkXML(t, page.String())
+	if strings.Count(page.String(), "<svg ") != len(rules) {
+		t.Errorf("WriteHTML() does not contain %d diagrams", len(rules))
+	}
+	for _, s := range []string{
+		"<h2 id=\"rule-factor\">factor</h2>",
+		"<a xlink:href=\"#rule-number\">",
+		"<a xlink:href=\"#rule-list\"><text class=\"label\"",
+		"&#34;(&#34;",
+	} {
+		if !strings.Contains(page.String(), s) {
+			t.Errorf("WriteHTML() does not contain %q", s)
+		}
+	}
+
+	for _, rule := range rules {
+		var svg bytes.Buffer
+		if err := d.WriteSVG(&svg, rule); err != nil {
+			t.Errorf("WriteSVG(%q) => %s", rule, err)
+		}
+		checkXML(t, svg.String())
+	}
+	if err := d.WriteSVG(&bytes.Buffer{}, "undefined"); err == nil {
+		t.Errorf("WriteSVG(undefined) succeeded")
+	}
+
+	// diagrams are deterministic
+	var again bytes.Buffer
+	NewDiagram(diagramTestGrammar).WriteHTML(&again)
+	if again.String() != page.String() {
+		t.Errorf("WriteHTML() is not deterministic")
+	}
+}
+
+func TestDiagramShapes(t *testing.T) {
+	data := []struct {
+		pat   Pattern
+		rules []string
+		dot   []string
+	}{
+		{V("x"), []string{""}, []string{"label=\"x\""}},
+		{Let(map[string]Pattern{"x": T("a")}, V("x")), []string{"x"}, nil},
+		{Let(map[string]Pattern{"x": Let(map[string]Pattern{"x": T("a")}, V("x"))}, V("x")),
+			[]string{"x", "x"}, []string{"cluster_1"}},
+		{Seq(TS("a", "b"), Q0(T("c")), Q1(T("d")), Qn(3, T("e")), Qmn(0, 2, T("f"))),
+			[]string{""}, []string{"label=\"+\"", "label=\"3+\"", "label=\"0..2\"", "label=\"?\""}},
+		{And(T("a"), Or(T("b"), Dot), B("c"), If(SOL, EOL, True), Switch(T("x"), False, Abort("no"))),
+			[]string{""}, []string{"label=\"&\"", "label=\"behind\"", "label=\"fail\"", "label=\"abort \\\"no\\\"\""}},
+		{SymScope(Seq(Sym("id", NG("n", Dot)), TSym("id"), CK(1, Skip(2)), Trunc(1, Until(T("x"))))),
+			[]string{""}, []string{"label=\"symbol scope\"", "label=\"group n\"", "label=\"token 1\"",
+				"label=\"trunc 1\"", "label=\"until\""}},
+	}
+	for _, d := range data {
+		diagram := NewDiagram(d.pat)
+		if !reflect.DeepEqual(diagram.Rules(), d.rules) {
+			t.Errorf("NewDiagram(%s).Rules() => %q != %q", d.pat, diagram.Rules(), d.rules)
+		}
+		var dot, page bytes.Buffer
+		diagram.WriteDOT(&dot)
+		for _, s := range d.dot {
+			if !strings.Contains(dot.String(), s) {
+				t.Errorf("WriteDOT() of %s does not contain %q:\n%s", d.pat, s, dot.String())
+			}
+		}
+		diagram.WriteHTML(&page)
+		checkXML(t, page.String())
+	}
+}
@@ -0,0 +1,119 @@
+// Package ebnf compiles the Extended BNF grammars into PEG patterns.
+//
+// Two widespread dialects are supported: the W3C notation used by the XML,
+// XQuery and SPARQL specifications, and the ISO/IEC 14977 notation.
+//
+// Rules are compiled to variables of a Let namespace. Options are
+// translated into Q01, repetitions are translated into Q0, Q1 or Qnn, and
+// the exclusion A - B is translated into Seq(Not(B), A). Alternations are
+// translated into the ordered choices. As PEG never backtracks into a
+// successfully matched choice or repetition, and the exclusion rejects all
+// the text starting with the exception, the compiled pattern may reject
+// text accepted by the specification. The suspicious places are reported
+// as warnings.
+//
+// This package API is currently volatile.
+package ebnf // import "github.com/hucsmn/peg/ebnf"
+
+import (
+	"fmt"
+
+	"github.com/hucsmn/peg"
+	"github.com/hucsmn/peg/internal/bnf"
+)
+
+// Warning reports where the ordered choices or greedy qualifiers may change
+// the meaning of EBNF grammar.
+type Warning struct {
+	Position peg.Position
+	Rule     string
+	Message  string
+}
+
+func (w Warning) String() string {
+	return bnf.Warning(w).String()
+}
+
+// CompileW3C compiles W3C EBNF rules, then returns the pattern which invokes
+// the entry rule inside the namespace of rules. The first rule is used if
+// entry is empty.
+//
+// Returns *peg.SyntaxError if the source is invalid.
+func CompileW3C(src, entry string) (peg.Pattern, []Warning, error) {
+	g, err := parseW3C(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return compile(g, entry)
+}
+
+// RulesW3C compiles W3C EBNF rules to a namespace for peg.Let.
+//
+// Returns *peg.SyntaxError if the source is invalid.
+func RulesW3C(src string) (map[string]peg.Pattern, []Warning, error) {
+	g, err := parseW3C(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g.Patterns(), warnings(g), nil
+}
+
+// CompileISO compiles ISO/IEC 14977 EBNF rules, then returns the pattern
+// which invokes the entry rule inside the namespace of rules. The first rule
+// is used if entry is empty.
+//
+// Meta identifiers may contain spaces, which are normalized to single
+// spaces in the variable names.
+//
+// Returns *peg.SyntaxError if the source is invalid.
+func CompileISO(src, entry string) (peg.Pattern, []Warning, error) {
+	g, err := parseISO(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return compile(g, entry)
+}
+
+// RulesISO compiles ISO/IEC 14977 EBNF rules to a namespace for peg.Let.
+//
+// Returns *peg.SyntaxError if the source is invalid.
+func RulesISO(src string) (map[string]peg.Pattern, []Warning, error) {
+	g, err := parseISO(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g.Patterns(), warnings(g), nil
+}
+
+func compile(g *bnf.Grammar, entry string) (peg.Pattern, []Warning, error) {
+	if entry == "" {
+		if len(g.Names) == 0 {
+			return nil, nil, fmt.Errorf("ebnf: no rule is defined")
+		}
+		entry = g.Names[0]
+	}
+	if g.Lookup(entry) == nil {
+		return nil, nil, fmt.Errorf("ebnf: rule %q is undefined", entry)
+	}
+	return peg.Let(g.Patterns(), peg.V(entry)), warnings(g), nil
+}
+
+func warnings(g *bnf.Grammar) []Warning {
+	var ws []Warning
+	for _, w := range g.Analyze() {
+		ws = append(ws, Warning(w))
+	}
+	return ws
+}
+
+func isLetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
@@ -0,0 +1,192 @@
+package ebnf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hucsmn/peg"
+)
+
+type fullMatchTestData struct {
+	text string
+	full bool
+	pat  peg.Pattern
+}
+
+func runFullMatchTestData(t *testing.T, data fullMatchTestData) {
+	full := peg.IsFullMatched(data.pat, data.text)
+	if full != data.full {
+		t.Errorf("RESULT DISMATCH: IsFullMatched(%s, %q) => %t != %t\n",
+			data.pat, data.text, full, data.full)
+	}
+}
+
+func TestCompileW3C(t *testing.T) {
+	compile := func(src, entry string) peg.Pattern {
+		pat, _, err := CompileW3C(src, entry)
+		if err != nil {
+			t.Fatalf("CompileW3C(%q, %q) => %s", src, entry, err)
+		}
+		return pat
+	}
+
+	// excerpts of XML 1.0 specification.
+	xml := `
+[3]  S        ::= (#x20 | #x9 | #xD | #xA)+
+[4]  NameStartChar ::= ":" | [A-Z] | "_" | [a-z] | [#xC0-#xD6]
+[4a] NameChar ::= NameStartChar | "-" | "." | [0-9]
+[5]  Name     ::= NameStartChar (NameChar)*
+[14] CharData ::= [^<&]* - ([^<&]* ']]>' [^<&]*)
+[17] PITarget ::= Name - (('X' | 'x') ('M' | 'm') ('L' | 'l'))
+[40] STag     ::= '<' Name (S Attribute)* S? '>'  [ WFC: Unique Att Spec ]
+[41] Attribute ::= Name Eq AttValue  /* simplified */
+[25] Eq       ::= S? '=' S?
+[10] AttValue ::= '"' [^<&"]* '"' | "'" [^<&']* "'"
+`
+	stag := compile(xml, "STag")
+	pitarget := compile(xml, "PITarget")
+	first := compile(xml, "")
+
+	data := []fullMatchTestData{
+		{`<a>`, true, stag},
+		{`<a:b x="1" y = '2' >`, true, stag},
+		{`<a x=1>`, false, stag},
+		{`<1>`, false, stag},
+		{`abc`, true, pitarget},
+		{`xml`, false, pitarget},
+		{`xmlns`, false, pitarget}, // Not() rejects the text starting with "xml"
+		{" \t\r\n", true, first},
+	}
+	for _, d := range data {
+		runFullMatchTestData(t, d)
+	}
+}
+
+func TestCompileISO(t *testing.T) {
+	compile := func(src, entry string) peg.Pattern {
+		pat, _, err := CompileISO(src, entry)
+		if err != nil {
+			t.Fatalf("CompileISO(%q, %q) => %s", src, entry, err)
+		}
+		return pat
+	}
+
+	src := `
+(* a simple expression grammar (* with nested comment *) *)
+expression     = term, { ("+" | "-"), term } ;
+term           = factor, { ("*" | "/"), factor } ;
+factor         = [ "-" ], ( number | "(", expression, ")" ) ;
+number         = digit excluding zero, { digit } | "0" ;
+digit excluding zero = digit - "0" .
+digit          = "0" / "1" / "2" / "3" / "4" ! "5" | "6" | "7" | "8" | "9" ;
+`
+	expr := compile(src, "")
+	number := compile(src, "number")
+	triple := compile(`triple = 3 * "a", (/ "b" /), (: "c" :), ;`, "triple")
+
+	data := []fullMatchTestData{
+		{"1+2*3", true, expr},
+		{"-(10-2)/4", true, expr},
+		{"1+", false, expr},
+		{"0", true, number},
+		{"10", true, number},
+		{"01", false, number},
+		{"aaa", true, triple},
+		{"aaabccc", true, triple},
+		{"aa", false, triple},
+	}
+	for _, d := range data {
+		runFullMatchTestData(t, d)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	data := []struct {
+		iso  bool
+		src  string
+		line int
+		col  int
+	}{
+		{false, `a ::= (`, 1, 8},
+		{false, `a ::= "x`, 1, 9},
+		{false, `a ::= [a-`, 1, 10},
+		{false, `a ::= b`, 1, 7},
+		{false, "a ::= 'x'\na ::= 'y'", 2, 1},
+		{false, `a ::= 'x' /* comment`, 1, 21},
+		{false, `a = 'x'`, 1, 3},
+		{true, `a = (`, 1, 6},
+		{true, `a = "x" ; b = c ;`, 1, 15},
+		{true, `a = "x"`, 1, 8},
+		{true, `a = 3 "x" ;`, 1, 7},
+		{true, "a = 'x' ; (* comment", 1, 21},
+		{true, "a = 'x' ;\na = 'y' ;", 2, 1},
+	}
+	for _, d := range data {
+		var err error
+		if d.iso {
+			_, _, err = CompileISO(d.src, "")
+		} else {
+			_, _, err = CompileW3C(d.src, "")
+		}
+		synerr, ok := err.(*peg.SyntaxError)
+		if !ok {
+			t.Errorf("Compile(%q) => %v, expect syntax error", d.src, err)
+			continue
+		}
+		if synerr.Position.Line+1 != d.line || synerr.Position.Column+1 != d.col {
+			t.Errorf("Compile(%q) => %s, expect error at %d:%d",
+				d.src, synerr, d.line, d.col)
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	data := []struct {
+		iso      bool
+		src      string
+		warnings []string
+	}{
+		{false, `a ::= 'x' | 'y'`, nil},
+		{false, `a ::= 'x' | 'xy'`, []string{
+			`1:7: a: alternative 1 could match "x", which is a prefix of text matched by alternative 2`,
+		}},
+		{false, `a ::= 'x' | 'Xy'`, nil},
+		{false, `a ::= [a-z]* 'x'`, []string{
+			`1:7: a: repetition is greedy, it may consume text required by the following elements`,
+		}},
+		{false, `a ::= [^x]* 'x'`, nil},
+		{false, `a ::= [a-z]+ - 'if'`, []string{
+			`1:7: a: exclusion rejects all the text starting with "if", not only the text itself`,
+		}},
+		{false, `a ::= [a-z] - 'x'`, nil},
+		{true, `a = "x" | "xy" ;`, []string{
+			`1:5: a: alternative 1 could match "x", which is a prefix of text matched by alternative 2`,
+		}},
+		{true, `a = { "x" } | "y" ;`, []string{
+			`1:5: a: alternative 1 could match the empty string, the later alternatives are unreachable`,
+		}},
+		{true, `a = [ "x" ], "x" ;`, []string{
+			`1:5: a: repetition is greedy, it may consume text required by the following elements`,
+		}},
+	}
+	for _, d := range data {
+		var warnings []Warning
+		var err error
+		if d.iso {
+			_, warnings, err = RulesISO(d.src)
+		} else {
+			_, warnings, err = RulesW3C(d.src)
+		}
+		if err != nil {
+			t.Errorf("Rules(%q) => %s", d.src, err)
+			continue
+		}
+		var got []string
+		for _, w := range warnings {
+			got = append(got, w.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(d.warnings, "\n") {
+			t.Errorf("Rules(%q) warns %q, expect %q", d.src, got, d.warnings)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package ebnf
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hucsmn/peg/internal/bnf"
+)
+
+// Recursive descent parser of ISO/IEC 14977 EBNF.
+//
+//     syntax      = { rule } ;
+//     rule        = identifier, "=", definitions, ( ";" | "." ) ;
+//     definitions = single, { ( "|" | "/" | "!" ), single } ;
+//     single      = term, { ",", term } ;
+//     term        = factor, [ "-", factor ] ;
+//     factor      = [ integer, "*" ], primary ;
+//     primary     = "[", definitions, "]" | "(/", definitions, "/)"
+//                 | "{", definitions, "}" | "(:", definitions, ":)"
+//                 | "(", definitions, ")" | "?", special, "?"
+//                 | terminal | identifier | empty ;
+//
+// Comments (* ... *) may be nested.
+type isoParser struct {
+	bnf.Scanner
+	g *bnf.Grammar
+}
+
+func parseISO(src string) (*bnf.Grammar, error) {
+	p := &isoParser{
+		Scanner: bnf.Scanner{Src: src},
+		g:       bnf.NewGrammar(src, nil),
+	}
+	for {
+		err := p.skipSpaces()
+		if err != nil {
+			return nil, err
+		}
+		if p.EOF() {
+			break
+		}
+		err = p.rule()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.g.CheckUndefined(); err != nil {
+		return nil, err
+	}
+	return p.g, nil
+}
+
+func (p *isoParser) rule() error {
+	at := p.At
+	name := p.identifier()
+	if name == "" {
+		return p.Unexpected()
+	}
+	if err := p.skipSpaces(); err != nil {
+		return err
+	}
+	if !p.Skip("=") {
+		return p.Unexpected()
+	}
+	def, err := p.definitions()
+	if err != nil {
+		return err
+	}
+	if !p.Skip(";") && !p.Skip(".") {
+		return p.Unexpected()
+	}
+	if !p.g.Define(name, name, at, def) {
+		return p.Errorf(at, "rule %q is defined more than once", name)
+	}
+	return nil
+}
+
+func (p *isoParser) definitions() (*bnf.Node, error) {
+	if err := p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	at := p.At
+	sub, err := p.single()
+	if err != nil {
+		return nil, err
+	}
+	subs := []*bnf.Node{sub}
+	for p.Skip("|") || p.Skip("!") || (!p.Peek("/)") && p.Skip("/")) {
+		sub, err = p.single()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) == 1 {
+		return subs[0], nil
+	}
+	return &bnf.Node{Kind: bnf.Alternation, At: at, Subs: subs}, nil
+}
+
+func (p *isoParser) single() (*bnf.Node, error) {
+	if err := p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	at := p.At
+	sub, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	subs := []*bnf.Node{sub}
+	for p.Skip(",") {
+		sub, err = p.term()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) == 1 {
+		return subs[0], nil
+	}
+	return &bnf.Node{Kind: bnf.Concatenation, At: at, Subs: subs}, nil
+}
+
+func (p *isoParser) term() (*bnf.Node, error) {
+	if err := p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	at := p.At
+	sub, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	if !p.Skip("-") {
+		return sub, nil
+	}
+	except, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	return &bnf.Node{Kind: bnf.Exclusion, At: at, Subs: []*bnf.Node{sub, except}}, nil
+}
+
+func (p *isoParser) factor() (*bnf.Node, error) {
+	if err := p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	at := p.At
+	start := p.At
+	for !p.EOF() && isDigit(p.Src[p.At]) {
+		p.At++
+	}
+	if p.At == start {
+		return p.primary()
+	}
+	n, err := strconv.Atoi(p.Src[start:p.At])
+	if err != nil {
+		return nil, p.Errorf(start, "invalid repetition %q", p.Src[start:p.At])
+	}
+	if err = p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	if !p.Skip("*") {
+		return nil, p.Unexpected()
+	}
+	sub, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	return &bnf.Node{Kind: bnf.Repetition, At: at,
+		Subs: []*bnf.Node{sub}, Min: n, Max: n}, nil
+}
+
+func (p *isoParser) primary() (*bnf.Node, error) {
+	if err := p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	at := p.At
+	var n *bnf.Node
+	var err error
+	switch {
+	case p.Skip("(/"):
+		n, err = p.bracket(at, "/)", 0, 1)
+	case p.Skip("(:"):
+		n, err = p.bracket(at, ":)", 0, -1)
+	case p.Skip("["):
+		n, err = p.bracket(at, "]", 0, 1)
+	case p.Skip("{"):
+		n, err = p.bracket(at, "}", 0, -1)
+	case p.Skip("("):
+		n, err = p.definitions()
+		if err == nil && !p.Skip(")") {
+			err = p.Expect("')'", at)
+		}
+	case p.Peek("\"") || p.Peek("'") || p.Peek("?"):
+		quote := p.Src[p.At : p.At+1]
+		p.At++
+		i := strings.Index(p.Src[p.At:], quote)
+		if i < 0 {
+			p.At = len(p.Src)
+			return nil, p.Expect(strconv.Quote(quote), at)
+		}
+		text := p.Src[p.At : p.At+i]
+		p.At += i + 1
+		if quote == "?" {
+			n = &bnf.Node{Kind: bnf.Prose, At: at, Text: strings.TrimSpace(text)}
+		} else if text == "" {
+			return nil, p.Errorf(at, "empty terminal string")
+		} else {
+			n = &bnf.Node{Kind: bnf.Text, At: at, Text: text, Sensitive: true}
+		}
+	default:
+		if name := p.identifier(); name != "" {
+			n = &bnf.Node{Kind: bnf.RuleName, At: at, Name: name}
+		} else {
+			// empty sequence
+			n = &bnf.Node{Kind: bnf.Text, At: at, Sensitive: true}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return n, p.skipSpaces()
+}
+
+func (p *isoParser) bracket(at int, closing string, min, max int) (*bnf.Node, error) {
+	sub, err := p.definitions()
+	if err != nil {
+		return nil, err
+	}
+	if !p.Skip(closing) {
+		return nil, p.Expect(strconv.Quote(closing), at)
+	}
+	return &bnf.Node{Kind: bnf.Repetition, At: at,
+		Subs: []*bnf.Node{sub}, Min: min, Max: max}, nil
+}
+
+// Parses meta identifier, the inner white spaces are normalized.
+func (p *isoParser) identifier() string {
+	if p.EOF() || !isLetter(p.Src[p.At]) {
+		return ""
+	}
+	var words []string
+	for {
+		start := p.At
+		for !p.EOF() && (isLetter(p.Src[p.At]) || isDigit(p.Src[p.At]) || p.Src[p.At] == '_') {
+			p.At++
+		}
+		words = append(words, p.Src[start:p.At])
+
+		save := p.At
+		for p.Peek(" ") || p.Peek("\t") || p.Peek("\r") || p.Peek("\n") {
+			p.At++
+		}
+		if p.EOF() || !(isLetter(p.Src[p.At]) || isDigit(p.Src[p.At])) {
+			p.At = save
+			return strings.Join(words, " ")
+		}
+	}
+}
+
+// Skips white spaces and nested comments.
+func (p *isoParser) skipSpaces() error {
+	for !p.EOF() {
+		switch {
+		case strings.IndexByte(" \t\r\n\f\v", p.Src[p.At]) >= 0:
+			p.At++
+		case p.Peek("(*"):
+			at := p.At
+			depth := 0
+			for depth > 0 || p.At == at {
+				switch {
+				case p.EOF():
+					return p.Expect("'*)'", at)
+				case p.Skip("(*"):
+					depth++
+				case p.Skip("*)"):
+					depth--
+				default:
+					p.At++
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package ebnf
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hucsmn/peg/internal/bnf"
+)
+
+// Recursive descent parser of W3C EBNF.
+//
+//     grammar    ::= rule*
+//     rule       ::= ('[' [0-9]+ [a-z]* ']')? name '::=' alt
+//     alt        ::= seq ('|' seq)*
+//     seq        ::= exclusion+
+//     exclusion  ::= postfix ('-' postfix)?
+//     postfix    ::= primary ('?' | '*' | '+')*
+//     primary    ::= name | '(' alt ')' | string | class | '#x' [0-9a-fA-F]+
+//
+// Comments /* ... */ and constraint annotations like [ wfc: ... ] are
+// skipped as white spaces.
+type w3cParser struct {
+	bnf.Scanner
+	g *bnf.Grammar
+}
+
+func parseW3C(src string) (*bnf.Grammar, error) {
+	p := &w3cParser{
+		Scanner: bnf.Scanner{Src: src},
+		g:       bnf.NewGrammar(src, nil),
+	}
+	for {
+		err := p.skipSpaces()
+		if err != nil {
+			return nil, err
+		}
+		if p.EOF() {
+			break
+		}
+		err = p.rule()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.g.CheckUndefined(); err != nil {
+		return nil, err
+	}
+	return p.g, nil
+}
+
+func (p *w3cParser) rule() error {
+	p.ruleNumber()
+	if err := p.skipSpaces(); err != nil {
+		return err
+	}
+	at := p.At
+	name := p.name()
+	if name == "" {
+		return p.Unexpected()
+	}
+	if err := p.skipSpaces(); err != nil {
+		return err
+	}
+	if !p.Skip("::=") {
+		return p.Unexpected()
+	}
+	def, err := p.alternation()
+	if err != nil {
+		return err
+	}
+	if !p.g.Define(name, name, at, def) {
+		return p.Errorf(at, "rule %q is defined more than once", name)
+	}
+	return nil
+}
+
+func (p *w3cParser) alternation() (*bnf.Node, error) {
+	if err := p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	at := p.At
+	sub, err := p.sequence()
+	if err != nil {
+		return nil, err
+	}
+	subs := []*bnf.Node{sub}
+	for p.Skip("|") {
+		sub, err = p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) == 1 {
+		return subs[0], nil
+	}
+	return &bnf.Node{Kind: bnf.Alternation, At: at, Subs: subs}, nil
+}
+
+func (p *w3cParser) sequence() (*bnf.Node, error) {
+	if err := p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	at := p.At
+	var subs []*bnf.Node
+	for !p.EOF() && strings.IndexByte("|)", p.Src[p.At]) < 0 && !p.atRule() {
+		sub, err := p.exclusion()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+		if err = p.skipSpaces(); err != nil {
+			return nil, err
+		}
+	}
+	switch len(subs) {
+	case 0:
+		return nil, p.Unexpected()
+	case 1:
+		return subs[0], nil
+	default:
+		return &bnf.Node{Kind: bnf.Concatenation, At: at, Subs: subs}, nil
+	}
+}
+
+func (p *w3cParser) exclusion() (*bnf.Node, error) {
+	at := p.At
+	sub, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+	save := p.At
+	if err = p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	if !p.Skip("-") {
+		p.At = save
+		return sub, nil
+	}
+	if err = p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	except, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+	return &bnf.Node{Kind: bnf.Exclusion, At: at, Subs: []*bnf.Node{sub, except}}, nil
+}
+
+func (p *w3cParser) postfix() (*bnf.Node, error) {
+	at := p.At
+	sub, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		min, max := 0, 0
+		switch {
+		case p.Skip("?"):
+			min, max = 0, 1
+		case p.Skip("*"):
+			min, max = 0, -1
+		case p.Skip("+"):
+			min, max = 1, -1
+		default:
+			return sub, nil
+		}
+		sub = &bnf.Node{Kind: bnf.Repetition, At: at,
+			Subs: []*bnf.Node{sub}, Min: min, Max: max}
+	}
+}
+
+func (p *w3cParser) primary() (*bnf.Node, error) {
+	at := p.At
+	switch {
+	case p.EOF():
+		return nil, p.Unexpected()
+	case p.Skip("("):
+		sub, err := p.alternation()
+		if err != nil {
+			return nil, err
+		}
+		if !p.Skip(")") {
+			return nil, p.Expect("')'", at)
+		}
+		return sub, nil
+	case p.Peek("\"") || p.Peek("'"):
+		quote := p.Src[p.At : p.At+1]
+		p.At++
+		i := strings.Index(p.Src[p.At:], quote)
+		if i < 0 {
+			p.At = len(p.Src)
+			return nil, p.Expect(strconv.Quote(quote), at)
+		}
+		text := p.Src[p.At : p.At+i]
+		p.At += i + 1
+		return &bnf.Node{Kind: bnf.Text, At: at, Text: text, Sensitive: true}, nil
+	case p.Peek("#x"):
+		r, err := p.char()
+		if err != nil {
+			return nil, err
+		}
+		ranges := []bnf.RuneRange{{Low: r, High: r}}
+		return &bnf.Node{Kind: bnf.Class, At: at, Ranges: ranges}, nil
+	case p.Skip("["):
+		return p.class(at)
+	default:
+		name := p.name()
+		if name == "" {
+			return nil, p.Unexpected()
+		}
+		return &bnf.Node{Kind: bnf.RuleName, At: at, Name: name}, nil
+	}
+}
+
+// class ::= '[' '^'? (char ('-' char)?)+ ']'
+func (p *w3cParser) class(at int) (*bnf.Node, error) {
+	n := &bnf.Node{Kind: bnf.Class, At: at}
+	n.Negated = p.Skip("^")
+	for !p.Skip("]") {
+		if p.EOF() {
+			return nil, p.Expect("']'", at)
+		}
+		low, err := p.char()
+		if err != nil {
+			return nil, err
+		}
+		high := low
+		if p.Peek("-") && !p.Peek("-]") {
+			p.At++
+			high, err = p.char()
+			if err != nil {
+				return nil, err
+			}
+			if high < low {
+				return nil, p.Errorf(at, "invalid character range")
+			}
+		}
+		n.Ranges = append(n.Ranges, bnf.RuneRange{Low: low, High: high})
+	}
+	if len(n.Ranges) == 0 {
+		return nil, p.Errorf(at, "empty character class")
+	}
+	return n, nil
+}
+
+// Parses a literal character or #xN.
+func (p *w3cParser) char() (rune, error) {
+	start := p.At
+	if p.Skip("#x") {
+		for !p.EOF() && isHexDigit(p.Src[p.At]) {
+			p.At++
+		}
+		v, err := strconv.ParseInt(p.Src[start+2:p.At], 16, 32)
+		if err != nil || v > utf8.MaxRune {
+			return 0, p.Errorf(start, "invalid character %q", p.Src[start:p.At])
+		}
+		return rune(v), nil
+	}
+	r, size := utf8.DecodeRuneInString(p.Src[p.At:])
+	p.At += size
+	return r, nil
+}
+
+func (p *w3cParser) name() string {
+	start := p.At
+	for !p.EOF() {
+		ch := p.Src[p.At]
+		if isLetter(ch) || ch == '_' || (p.At > start && isDigit(ch)) {
+			p.At++
+		} else {
+			break
+		}
+	}
+	return p.Src[start:p.At]
+}
+
+// Skips the optional rule number like [12] or [4a].
+func (p *w3cParser) ruleNumber() bool {
+	save := p.At
+	if p.Skip("[") {
+		start := p.At
+		for !p.EOF() && isDigit(p.Src[p.At]) {
+			p.At++
+		}
+		digits := p.At > start
+		for !p.EOF() && isLetter(p.Src[p.At]) {
+			p.At++
+		}
+		if digits && p.Skip("]") {
+			return true
+		}
+	}
+	p.At = save
+	return false
+}
+
+// Tests if a new rule begins.
+func (p *w3cParser) atRule() bool {
+	save := p.At
+	defer func() { p.At = save }()
+	if p.ruleNumber() && p.skipSpaces() != nil {
+		return false
+	}
+	if p.name() == "" || p.skipSpaces() != nil {
+		return false
+	}
+	return p.Peek("::=")
+}
+
+// Skips white spaces, comments and constraint annotations.
+func (p *w3cParser) skipSpaces() error {
+	for !p.EOF() {
+		switch p.Src[p.At] {
+		case ' ', '\t', '\r', '\n':
+			p.At++
+		case '/':
+			if !p.Peek("/*") {
+				return nil
+			}
+			at := p.At
+			i := strings.Index(p.Src[p.At+2:], "*/")
+			if i < 0 {
+				p.At = len(p.Src)
+				return p.Expect("'*/'", at)
+			}
+			p.At += i + 4
+		case '[':
+			if !p.atAnnotation() {
+				return nil
+			}
+			at := p.At
+			i := strings.IndexByte(p.Src[p.At:], ']')
+			if i < 0 {
+				p.At = len(p.Src)
+				return p.Expect("']'", at)
+			}
+			p.At += i + 1
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Tests if the constraint annotation like [ wfc: ... ] or [ vc: ... ] begins.
+func (p *w3cParser) atAnnotation() bool {
+	s := strings.TrimLeft(p.Src[p.At+1:], " \t")
+	for _, prefix := range []string{"wfc:", "vc:", "WFC:", "VC:"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
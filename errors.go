@@ -14,6 +14,8 @@ var (
 	errorExecuteWhenConsumed = errorf("unable to execute pattern when some text already consumed by caller")
 	errorNilConstructor      = errorf("capture constructor is nil")
 	errorNilMainPattern      = errorf("the main pattern is nil")
+	errorNilTemplateBody     = errorf("the template body is nil")
+	errorInvokeTemplate      = errorf("template should be invoked by Call or CCall")
 
 	errorCaseInsensitive = func(name string) error {
 		return errorf("case insensitive is not implemented for %q", name)
@@ -30,6 +32,22 @@ var (
 	errorInvalidVarName = func(name string) error {
 		return errorf("variable name %q is invalid", name)
 	}
+
+	errorRedefinedVar = func(name string) error {
+		return errorf("variable %q is defined more than once", name)
+	}
+
+	errorNotTemplate = func(name string) error {
+		return errorf("variable %q is not a template", name)
+	}
+
+	errorArgumentIndex = func(n, nargs int) error {
+		return errorf("argument %d is out of range, %d arguments given", n, nargs)
+	}
+
+	errorTemplateArity = func(name string, nparams, nargs int) error {
+		return errorf("template %q requires %d arguments, but %d given", name, nparams, nargs)
+	}
 )
 
 type pegError struct {
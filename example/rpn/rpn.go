@@ -24,8 +24,9 @@ var (
 	rpnComment       = peg.Alt(
 		peg.Seq(peg.T("#"), peg.UntilB(rpnNewline)),
 		peg.Seq(peg.T("("), peg.UntilB(peg.T(")"))))
-	rpnVerb   = peg.Q1(peg.U("-White_Space"))
-	rpnNumber = peg.Alt(
+	rpnDelimiter = peg.Test(peg.Alt(rpnSpaces, peg.EOF))
+	rpnVerb      = peg.Q1(peg.U("-White_Space"))
+	rpnNumber    = peg.Alt(
 		peg.Seq(peg.TI("0x"), peg.Q1(peg.R('0', '9', 'a', 'f', 'A', 'F'))),
 		peg.Seq(peg.Q01(peg.S("+-")), peg.Q1(peg.R('0', '9'))))
 
@@ -82,7 +83,9 @@ func NewState(vocab map[string]Primitive) *State {
 
 func (state *State) Calculate(source string) {
 	// Send tokens to the channel by registering customed hooks,
-	// which are later invoked by peg.Match(pat, source).
+	// which are later invoked by peg.Match(pat, source). The hooks are
+	// deferred until the whole word is matched, so that the words of the
+	// dismatched branches are never sent.
 	words := make(chan peg.Token, 1)
 	ctx, cancel := context.WithCancel(context.Background())
 	makeHook := func(ctx context.Context, words chan<- peg.Token, toktype int) func(string, peg.Position) error {
@@ -103,10 +106,10 @@ func (state *State) Calculate(source string) {
 		}
 	}
 
-	rpnWord := peg.Alt(
-		peg.Trigger(makeHook(ctx, words, TokenNumber), rpnNumber),
-		peg.Trigger(makeHook(ctx, words, TokenVerb), rpnVerb),
-		rpnComment)
+	rpnWord := peg.Commit(peg.Alt(
+		peg.Seq(peg.OnCommit(makeHook(ctx, words, TokenNumber), rpnNumber), rpnDelimiter),
+		peg.Seq(peg.OnCommit(makeHook(ctx, words, TokenVerb), rpnVerb), rpnDelimiter),
+		rpnComment))
 	rpnMain := peg.Seq(rpnOptinalSpaces, peg.J0(rpnWord, rpnSpaces), rpnOptinalSpaces)
 
 	calc := &calculator{
@@ -0,0 +1,1039 @@
+package peg
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Maximum number of functions specialized for (pattern, namespaces) pairs.
+const maxGeneratedFunctions = 10000
+
+// Generator writes the Go source of a standalone matcher specialized for a
+// pattern, which saves both the construction of the pattern at startup and
+// the interpretation overhead at runtime.
+//
+// For a function name Match, the generated file defines:
+//
+//     func Match(text string) (*peg.Result, error)
+//     func MatchConfig(cfg peg.Config, text string) (*peg.Result, error)
+//
+// which return the same results, groups, captures and errors as
+// peg.Match(pat, text) and cfg.Match(pat, text). The generated file depends
+// only on the standard library and the public types of this package.
+//
+// Each user defined function of the pattern is written as the Go expression
+// mapped to it in Callbacks. Generate fails if a function is missing from
+// Callbacks, or mapped from several expressions, as the closures of one
+// function literal are.
+//
+// Cc and Carg are not supported, the captured values have no Go expressions.
+//
+// The callstack depth counted by the generated matcher may differ from the
+// interpreter when templates are invoked with variables as arguments,
+// thus CallstackLimit may be reached at different places.
+type Generator struct {
+	// Package name of the generated file, defaults to "main".
+	Package string
+
+	// Name of the generated function, defaults to "Match".
+	// Unexported identifiers of the generated file are prefixed with
+	// the lower-cased function name, so that matchers with different
+	// names could be generated into the same package.
+	Func string
+
+	// Go expressions of the user defined functions, e.g.
+	// map[string]interface{}{"newNumber": newNumber}.
+	Callbacks map[string]interface{}
+}
+
+// Namespaces of the variables during generation, interned as environments
+// holding all the visible variables.
+type genEnv struct {
+	vars map[string]genBinding
+}
+
+// Variable definition, env is non-nil for arguments of template, which are
+// bound to the namespaces of the call site.
+type genBinding struct {
+	pat  Pattern
+	env  *genEnv
+	rule string // variable resolved for the template argument
+	auto bool   // defined by CLet
+}
+
+// A pattern specialized in the given namespaces.
+type genNode struct {
+	pat     Pattern
+	env     *genEnv
+	closure bool
+}
+
+type generator struct {
+	pkg, fn, prefix string
+
+	callbacks map[uintptr]string
+	ambiguous map[uintptr]bool
+
+	envs  map[string]*genEnv
+	envID map[*genEnv]int
+	nodes []genNode
+	ids   map[genNode]int
+	terms map[string]int
+
+	decls   bytes.Buffer
+	ndecls  int
+	errvars map[string]string
+}
+
+// Generate writes the Go source of the matcher specialized for pat.
+func (gen *Generator) Generate(w io.Writer, pat Pattern) error {
+	if pat == nil {
+		return errorNilMainPattern
+	}
+	g, err := newGenerator(gen)
+	if err != nil {
+		return err
+	}
+
+	g.node(genNode{pat: pat, env: g.intern(nil)})
+	g.term(pat)
+	var funcs bytes.Buffer
+	for i := 0; i < len(g.nodes); i++ {
+		if len(g.nodes) > maxGeneratedFunctions {
+			return errorf("generate: too many specialized functions, " +
+				"templates may be instantiated recursively")
+		}
+		err = g.function(&funcs, i)
+		if err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by peg.Generator. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", g.pkg)
+	buf.WriteString("import (\n\t\"errors\"\n\t\"strings\"\n\t\"unicode\"\n" +
+		"\t\"unicode/utf8\"\n\n\t\"github.com/hucsmn/peg\"\n)\n")
+	buf.WriteString(g.replace(generatedEntries))
+	buf.WriteString(g.replace(generatedRuntime))
+	fmt.Fprintf(&buf, "\nvar (\n")
+	fmt.Fprintf(&buf, "%sErrCallstackOverflow = errors.New(%q)\n", g.prefix, errorCallstackOverflow.Error())
+	fmt.Fprintf(&buf, "%sErrRepeatLimit = errors.New(%q)\n", g.prefix, errorReachedRepeatLimit.Error())
+	fmt.Fprintf(&buf, "%sErrReferDisabled = errors.New(%q)\n", g.prefix, errorReferDisabled.Error())
+	fmt.Fprintf(&buf, "%sErrNilConstructor = errors.New(%q)\n", g.prefix, errorNilConstructor.Error())
+	fmt.Fprintf(&buf, "%sRootTerminal = %t\n", g.prefix, isTerminalPattern(pat))
+	buf.Write(g.decls.Bytes())
+	fmt.Fprintf(&buf, ")\n\n")
+	buf.Write(funcs.Bytes())
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return errorf("generate: %v", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func newGenerator(gen *Generator) (*generator, error) {
+	g := &generator{
+		pkg:       gen.Package,
+		fn:        gen.Func,
+		callbacks: make(map[uintptr]string),
+		ambiguous: make(map[uintptr]bool),
+		envs:      make(map[string]*genEnv),
+		envID:     make(map[*genEnv]int),
+		ids:       make(map[genNode]int),
+		terms:     make(map[string]int),
+		errvars:   make(map[string]string),
+	}
+	if g.pkg == "" {
+		g.pkg = "main"
+	}
+	if g.fn == "" {
+		g.fn = "Match"
+	}
+	if !isIdentifier(g.pkg) {
+		return nil, errorf("generate: invalid package name %q", g.pkg)
+	}
+	if !isIdentifier(g.fn) {
+		return nil, errorf("generate: invalid function name %q", g.fn)
+	}
+	r, n := utf8.DecodeRuneInString(g.fn)
+	g.prefix = string(unicode.ToLower(r)) + g.fn[n:]
+
+	names := make([]string, 0, len(gen.Callbacks))
+	for name := range gen.Callbacks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := reflect.ValueOf(gen.Callbacks[name])
+		if v.Kind() != reflect.Func {
+			return nil, errorf("generate: callback %q is not a function", name)
+		}
+		if v.IsNil() {
+			continue
+		}
+		if _, ok := g.callbacks[v.Pointer()]; ok {
+			g.ambiguous[v.Pointer()] = true
+		}
+		g.callbacks[v.Pointer()] = name
+	}
+	return g, nil
+}
+
+func (g *generator) replace(src string) string {
+	src = strings.Replace(src, "PREFIX", g.prefix, -1)
+	return strings.Replace(src, "FUNC", g.fn, -1)
+}
+
+// Interns the environment extended by the given bindings.
+func (g *generator) intern(upper *genEnv, bindings ...map[string]genBinding) *genEnv {
+	vars := make(map[string]genBinding)
+	if upper != nil {
+		for name, b := range upper.vars {
+			vars[name] = b
+		}
+	}
+	for _, m := range bindings {
+		for name, b := range m {
+			vars[name] = b
+		}
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var key bytes.Buffer
+	for _, name := range names {
+		b := vars[name]
+		envid := -1
+		if b.env != nil {
+			envid = g.envID[b.env]
+		}
+		fmt.Fprintf(&key, "%q=%s@%d:%q:%t;", name, patternIdentity(b.pat), envid, b.rule, b.auto)
+	}
+	if env, ok := g.envs[key.String()]; ok {
+		return env
+	}
+	env := &genEnv{vars: vars}
+	g.envs[key.String()] = env
+	g.envID[env] = len(g.envID)
+	return env
+}
+
+func patternIdentity(pat Pattern) string {
+	v := reflect.ValueOf(pat)
+	if v.Kind() == reflect.Ptr {
+		return fmt.Sprintf("%#x", v.Pointer())
+	}
+	return fmt.Sprintf("%T", pat)
+}
+
+// Gets the function name of specialized pattern.
+func (g *generator) node(node genNode) string {
+	id, ok := g.ids[node]
+	if !ok {
+		id = len(g.nodes)
+		g.ids[node] = id
+		g.nodes = append(g.nodes, node)
+	}
+	return fmt.Sprintf("m%d", id)
+}
+
+// Gets the identifier of terminal pattern, which merges the continuous
+// leaves of syntax tree.
+func (g *generator) term(pat Pattern) int {
+	key := patternIdentity(pat)
+	id, ok := g.terms[key]
+	if !ok {
+		id = len(g.terms)
+		g.terms[key] = id
+	}
+	return id
+}
+
+// Gets the specialized pattern invoked by variable.
+func (g *generator) variable(b genBinding, env *genEnv) genNode {
+	if b.env != nil {
+		return genNode{pat: b.pat, env: b.env, closure: true}
+	}
+	return genNode{pat: b.pat, env: env}
+}
+
+// Declares a package level variable, returns its name.
+func (g *generator) declare(format string, v ...interface{}) string {
+	name := fmt.Sprintf("%sVar%d", g.prefix, g.ndecls)
+	g.ndecls++
+	fmt.Fprintf(&g.decls, "%s = %s\n", name, fmt.Sprintf(format, v...))
+	return name
+}
+
+// Declares the error with the same message.
+func (g *generator) errorVar(err error) string {
+	msg := err.Error()
+	if name, ok := g.errvars[msg]; ok {
+		return name
+	}
+	name := g.declare("errors.New(%q)", msg)
+	g.errvars[msg] = name
+	return name
+}
+
+// Gets the Go expression of user defined function.
+func (g *generator) callback(fn interface{}, what string) (string, error) {
+	v := reflect.ValueOf(fn)
+	if !v.IsValid() || v.IsNil() {
+		return "", errorf("generate: %s is nil", what)
+	}
+	ptr := v.Pointer()
+	if g.ambiguous[ptr] {
+		return "", errorf("generate: %s %s is ambiguous in callbacks", what, g.callbacks[ptr])
+	}
+	name, ok := g.callbacks[ptr]
+	if !ok {
+		return "", errorf("generate: %s %#x is not named in callbacks", what, ptr)
+	}
+	return name, nil
+}
+
+// Writes the specialized function.
+func (g *generator) function(buf *bytes.Buffer, id int) error {
+	node := g.nodes[id]
+	comment := fmt.Sprint(node.pat)
+	if node.closure {
+		comment = "argument " + comment
+	}
+	comment = strings.Join(strings.Fields(comment), " ")
+	if utf8.RuneCountInString(comment) > 72 {
+		comment = string([]rune(comment)[:69]) + "..."
+	}
+
+	body := &genBody{g: g, env: node.env}
+	err := body.write(node)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(buf, "// %s\n", comment)
+	fmt.Fprintf(buf, "func (p *%sParser) m%d(at, depth int) (int, bool, error) {\n", g.prefix, id)
+	fmt.Fprintf(buf, "if p.overflow(depth) {\nreturn 0, false, %sErrCallstackOverflow\n}\n", g.prefix)
+	if body.cn {
+		buf.WriteString("var cn int\n")
+	}
+	if body.called {
+		buf.WriteString("var ok bool\nvar err error\n")
+	}
+	buf.Write(body.Bytes())
+	buf.WriteString("}\n\n")
+	return nil
+}
+
+// Body of a specialized function.
+type genBody struct {
+	bytes.Buffer
+	g      *generator
+	env    *genEnv
+	called bool // ok and err are used
+	cn     bool // cn is used
+}
+
+func (b *genBody) printf(format string, v ...interface{}) {
+	fmt.Fprintf(b, format, v...)
+}
+
+// Writes a call, which saves the groups and symbols of current function.
+// Sets ok and cn (if usecn) to the results.
+func (b *genBody) call(node genNode, at string, usecn bool) {
+	b.called = true
+	terminal := !node.closure && isTerminalPattern(node.pat)
+	cn := "_"
+	if usecn || terminal {
+		b.cn = true
+		cn = "cn"
+	}
+	b.printf("p.enter()\n%s, ok, err = p.%s(%s, depth+1)\n", cn, b.g.node(node), at)
+	b.printf("if err != nil {\nreturn 0, false, err\n}\np.leave(ok)\n")
+	if terminal {
+		b.printf("if ok && cn > 0 {\np.cut(%d, %s, cn)\n}\n", b.g.term(node.pat), at)
+	}
+}
+
+// Writes a call as a lookahead, which discards the groups, symbols,
+// captures and values of callee. Sets ok to the result.
+func (b *genBody) lookahead(node genNode) {
+	b.called = true
+	b.printf("p.enter()\np.lookaheads++\n_, ok, err = p.%s(at, depth+1)\n", b.g.node(node))
+	b.printf("if err != nil {\nreturn 0, false, err\n}\np.lookaheads--\np.leave(false)\n")
+}
+
+// Writes a tail invocation, without saving groups and symbols.
+func (b *genBody) execute(node genNode) {
+	if node.closure || !isTerminalPattern(node.pat) {
+		b.printf("return p.%s(at, depth+1)\n", b.g.node(node))
+		return
+	}
+	b.called, b.cn = true, true
+	b.printf("cn, ok, err = p.%s(at, depth+1)\n", b.g.node(node))
+	b.printf("if err != nil {\nreturn 0, false, err\n}\n")
+	b.printf("if ok && cn > 0 {\np.cut(%d, at, cn)\n}\nreturn cn, ok, nil\n", b.g.term(node.pat))
+}
+
+func (b *genBody) sub(pat Pattern) genNode {
+	return genNode{pat: pat, env: b.env}
+}
+
+// Writes a failure branch.
+func (b *genBody) failIf(cond string) {
+	b.printf("if %s {\nreturn 0, false, nil\n}\n", cond)
+}
+
+func (b *genBody) write(node genNode) error {
+	if node.closure {
+		b.call(genNode{pat: node.pat, env: node.env}, "at", true)
+		b.printf("return cn, ok, nil\n")
+		return nil
+	}
+
+	g := b.g
+	switch pat := node.pat.(type) {
+	case *patternBoolean:
+		b.printf("return 0, %t, nil\n", pat.ok)
+
+	case patternAnyRune:
+		b.printf("_, w := utf8.DecodeRuneInString(p.text[at:])\n")
+		b.printf("return w, w != 0, nil\n")
+
+	case *patternRuneSet:
+		has := fmt.Sprintf("strings.ContainsRune(%q, r)", string(pat.charset))
+		b.rune(negate(has, pat.not))
+
+	case *patternRuneRange:
+		conds := make([]string, len(pat.ranges))
+		for i, pair := range pat.ranges {
+			conds[i] = fmt.Sprintf("r >= %s && r <= %s", runeLiteral(pair.low), runeLiteral(pair.high))
+		}
+		has := "false"
+		if len(conds) > 0 {
+			has = "(" + strings.Join(conds, " || ") + ")"
+		}
+		b.rune(negate(has, pat.not))
+
+	case *patternUnicodeRanges:
+		has, err := b.unicode(pat)
+		if err != nil {
+			return err
+		}
+		b.rune(has)
+
+	case *patternUnicodeRangesWithExcluding:
+		include, err := b.unicode(&pat.include)
+		if err != nil {
+			return err
+		}
+		exclude, err := b.unicode(&pat.exclude)
+		if err != nil {
+			return err
+		}
+		b.rune(include + " && " + exclude)
+
+	case *patternText:
+		if pat.insensitive {
+			b.printf("if %sFoldCase(p.next(at, %d)) == %q {\n", g.prefix, len(pat.text), pat.text)
+		} else {
+			b.printf("if strings.HasPrefix(p.text[at:], %q) {\n", pat.text)
+		}
+		b.printf("return %d, true, nil\n}\nreturn 0, false, nil\n", len(pat.text))
+
+	case *patternBackwardPredicate:
+		b.printf("return 0, p.previous(at, %d) == %q, nil\n", len(pat.text), pat.text)
+
+	case *patternTextSet:
+		tree := g.declare("&%s", g.tree(pat.tree))
+		b.printf("n, ok := p.matchTree(at, %s, %t)\nreturn n, ok, nil\n", tree, pat.insensitive)
+
+	case *patternTextReferring:
+		b.printf("if p.cfg.DisableGrouping {\nreturn 0, false, %sErrReferDisabled\n}\n", g.prefix)
+		if pat.indexed {
+			b.printf("text := p.referIndex(%d)\n", pat.index)
+		} else {
+			b.printf("text := p.refer(%q)\n", pat.grpname)
+		}
+		b.printf("if strings.HasPrefix(p.text[at:], text) {\nreturn len(text), true, nil\n}\n")
+		b.printf("return 0, false, nil\n")
+
+	case *patternBackwardPredicateReferring:
+		b.printf("if p.cfg.DisableGrouping {\nreturn 0, false, %sErrReferDisabled\n}\n", g.prefix)
+		if pat.indexed {
+			b.printf("text := p.referIndex(%d)\n", pat.index)
+		} else {
+			b.printf("text := p.refer(%q)\n", pat.grpname)
+		}
+		b.printf("return 0, p.previous(at, len(text)) == text, nil\n")
+
+	case *patternSkip:
+		b.printf("n := 0\nfor i := 0; i < %d; i++ {\n", pat.n)
+		b.printf("_, w := utf8.DecodeRuneInString(p.text[at+n:])\n")
+		b.failIf("w == 0")
+		b.printf("n += w\n}\nreturn n, true, nil\n")
+
+	case *patternAnyRuneUntil:
+		if pat.without {
+			b.printf("k := len(p.capstack[len(p.capstack)-1].args)\n")
+		}
+		b.printf("n := 0\nfor i := 0; ; i++ {\n")
+		b.repeats()
+		b.call(b.sub(pat.pat), "at+n", !pat.without)
+		if pat.without {
+			b.printf("if ok {\nif p.cfg.SyntaxTree {\np.truncate(k)\n}\nreturn n, true, nil\n}\n")
+		} else {
+			b.printf("if ok {\nreturn n + cn, true, nil\n}\n")
+		}
+		b.printf("_, w := utf8.DecodeRuneInString(p.text[at+n:])\n")
+		b.failIf("w == 0")
+		b.printf("n += w\n}\n")
+
+	case *patternQualifierAtLeast:
+		b.printf("n := 0\nfor i := 0; ; i++ {\n")
+		b.repeats()
+		b.call(b.sub(pat.pat), "at+n", true)
+		b.printf("if !ok {\n")
+		if pat.n > 0 {
+			b.failIf(fmt.Sprintf("i < %d", pat.n))
+		}
+		b.printf("return n, true, nil\n}\nn += cn\n}\n")
+
+	case *patternQualifierOptional:
+		b.call(b.sub(pat.pat), "at", true)
+		b.printf("if !ok {\nreturn 0, true, nil\n}\nreturn cn, true, nil\n")
+
+	case *patternQualifierRange:
+		b.printf("n := 0\nfor i := 0; i < %d; i++ {\n", pat.n)
+		b.repeats()
+		b.call(b.sub(pat.pat), "at+n", true)
+		b.printf("if !ok {\n")
+		if pat.m > 0 {
+			b.failIf(fmt.Sprintf("i < %d", pat.m))
+		}
+		b.printf("return n, true, nil\n}\nn += cn\n}\nreturn n, true, nil\n")
+
+	case *patternSequence:
+		b.printf("n := 0\n")
+		for _, sub := range pat.pats {
+			b.call(b.sub(sub), "at+n", true)
+			b.failIf("!ok")
+			b.printf("n += cn\n")
+		}
+		b.printf("return n, true, nil\n")
+
+	case *patternAlternative:
+		last := len(pat.pats) - 1
+		for _, sub := range pat.pats[:last] {
+			b.call(b.sub(sub), "at", true)
+			b.printf("if ok {\nreturn cn, true, nil\n}\n")
+		}
+		b.execute(b.sub(pat.pats[last]))
+
+	case *patternLet:
+		vars := make(map[string]genBinding, len(pat.vars))
+		for name, sub := range pat.vars {
+			vars[name] = genBinding{pat: sub, auto: pat.auto}
+		}
+		env := g.intern(b.env, vars)
+		b.call(genNode{pat: pat.pat, env: env}, "at", true)
+		b.printf("return cn, ok, nil\n")
+
+	case *patternRule:
+		b.execute(b.sub(pat.pat))
+
+	case *patternCaptureVariable:
+		callee, ok := b.env.vars[pat.varname]
+		if !ok {
+			b.printf("return 0, false, %s\n", g.errorVar(errorUndefinedVar(pat.varname)))
+			break
+		}
+		// arguments of template are transparent to the syntax tree, except
+		// the variables resolved statically.
+		rule, node := pat.varname, true
+		if callee.env != nil {
+			node = callee.rule != ""
+			if node {
+				rule = callee.rule
+			}
+		}
+		// rules of CLet are captured unless explicitly captured or silent,
+		// while the variables passed as arguments are captured inside.
+		capture := pat.cons != nil
+		mark, marked := callee.pat.(*patternRule)
+		auto := callee.auto && (callee.env != nil || !capture) && (!marked || mark.transparent)
+		if !capture && !auto {
+			b.called, b.cn = true, true
+			b.beginRule(rule, node)
+			b.printf("cn, ok, err = p.%s(at, depth+1)\n", g.node(g.variable(callee, b.env)))
+			b.printf("if err != nil {\nreturn 0, false, err\n}\n")
+			b.endRule(node)
+			b.printf("return cn, ok, nil\n")
+			break
+		}
+		if capture {
+			b.begin(pat.varname)
+		}
+		b.beginRule(rule, node)
+		if auto {
+			b.beginAuto(rule, marked)
+		}
+		b.call(g.variable(callee, b.env), "at", true)
+		if auto {
+			b.end()
+		}
+		b.endRule(node)
+		if capture {
+			b.end()
+		}
+		b.printf("return cn, ok, nil\n")
+
+	case *patternCaptureToken:
+		b.call(b.sub(pat.pat), "at", true)
+		b.failIf("!ok")
+		b.printf("p.push(&peg.Token{Type: %d, Value: p.text[at : at+cn], Position: p.tell(at), End: p.tell(at + cn)})\n", pat.toktype)
+		b.printf("return cn, true, nil\n")
+
+	case *patternCaptureCons:
+		cons := "nil"
+		if pat.cons != nil {
+			var err error
+			cons, err = g.callback(pat.cons, "constructor")
+			if err != nil {
+				return err
+			}
+		}
+		b.printf("p.begin(%s)\n", cons)
+		b.call(b.sub(pat.pat), "at", true)
+		b.end()
+		b.printf("return cn, ok, nil\n")
+
+	case *patternCaptureTerm:
+		cons, err := g.callback(pat.cons, "constructor")
+		if err != nil {
+			return err
+		}
+		b.call(b.sub(pat.pat), "at", true)
+		b.failIf("!ok")
+		b.printf("cap, err := %s(p.text[at:at+cn], p.tell(at))\n", cons)
+		b.printf("if err != nil {\nreturn 0, false, err\n}\np.push(cap)\n")
+		b.printf("return cn, true, nil\n")
+
+	case *patternCaptureContext:
+		cons, err := g.callback(pat.cons, "constructor")
+		if err != nil {
+			return err
+		}
+		b.printf("p.beginContext(%s, p.rule)\n", cons)
+		b.call(b.sub(pat.pat), "at", true)
+		b.end()
+		b.printf("return cn, ok, nil\n")
+
+	case *patternCapturePosition:
+		b.printf("pos := p.tell(at)\np.push(&pos)\nreturn 0, true, nil\n")
+
+	case *patternCaptureTable:
+		b.printf("p.begin(nil)\n")
+		b.call(b.sub(pat.pat), "at", true)
+		b.printf("subs := p.collect()\n")
+		b.failIf("!ok")
+		b.printf("if subs == nil {\nsubs = []peg.Capture{}\n}\n")
+		b.printf("p.push(peg.Table(subs))\nreturn cn, true, nil\n")
+
+	case *patternCaptureFold:
+		fold, err := g.callback(pat.fold, "folder")
+		if err != nil {
+			return err
+		}
+		b.printf("p.begin(nil)\n")
+		b.call(b.sub(pat.pat), "at", true)
+		b.printf("subs := p.collect()\n")
+		b.failIf("!ok")
+		b.printf("if len(subs) > 0 {\nacc := subs[0]\n")
+		b.printf("for _, cap := range subs[1:] {\n")
+		b.printf("if acc, err = %s(acc, cap); err != nil {\nreturn 0, false, err\n}\n}\n", fold)
+		b.printf("p.push(acc)\n}\nreturn cn, true, nil\n")
+
+	case *patternCaptureBack:
+		b.printf("if p.cfg.DisableGrouping {\nreturn 0, false, %sErrReferDisabled\n}\n", g.prefix)
+		b.printf("p.push(peg.Text(p.refer(%q)))\nreturn 0, true, nil\n", pat.grpname)
+
+	case *patternCaptureMatchTime:
+		fn, err := g.callback(pat.fn, "match-time function")
+		if err != nil {
+			return err
+		}
+		b.printf("p.begin(nil)\n")
+		b.call(b.sub(pat.pat), "at", true)
+		b.printf("subs := p.collect()\n")
+		b.failIf("!ok")
+		b.printf("ok, caps, err := %s(peg.CaptureContext{\n", fn)
+		b.printf("Rule: p.rule, Text: p.text[at : at+cn], Start: p.tell(at), End: p.tell(at + cn),\n}, subs)\n")
+		b.printf("if err != nil || !ok {\nreturn 0, false, err\n}\n")
+		b.printf("for _, cap := range caps {\np.push(cap)\n}\nreturn cn, true, nil\n")
+
+	case *patternGrouping:
+		b.printf("i := len(p.groups)\n")
+		b.call(b.sub(pat.pat), "at", true)
+		b.failIf("!ok")
+		b.printf("p.group(%q, i, at, cn)\nreturn cn, true, nil\n", pat.grpname)
+
+	case *patternTrigger:
+		hook, err := g.callback(pat.trigger, "hook")
+		if err != nil {
+			return err
+		}
+		b.call(b.sub(pat.pat), "at", true)
+		b.failIf("!ok")
+		b.printf("if p.lookaheads == 0 {\n")
+		b.printf("if err = %s(p.text[at:at+cn], p.tell(at)); err != nil {\n", hook)
+		b.printf("return 0, false, err\n}\n}\nreturn cn, true, nil\n")
+
+	case *patternDeferredTrigger:
+		hook, err := g.callback(pat.trigger, "hook")
+		if err != nil {
+			return err
+		}
+		b.call(b.sub(pat.pat), "at", true)
+		b.failIf("!ok")
+		b.printf("p.queue(%s, at, cn)\nreturn cn, true, nil\n", hook)
+
+	case *patternCommit:
+		b.printf("i := len(p.deferred)\n")
+		b.call(b.sub(pat.pat), "at", true)
+		b.failIf("!ok")
+		b.printf("if err = p.flush(i); err != nil {\nreturn 0, false, err\n}\n")
+		b.printf("return cn, true, nil\n")
+
+	case *patternInjector:
+		b.call(b.sub(pat.pat), "at", true)
+		b.printf("if ok {\n")
+		switch origin := pat.origin.(type) {
+		case int:
+			b.printf("if k, ok := %sTrunc(%d, p.next(at, cn)); ok {\n", g.prefix, origin)
+			b.printf("if p.cfg.SyntaxTree {\np.clip(at + k)\n}\n")
+			b.printf("return k, true, nil\n}\n")
+		case func(string) bool:
+			fn, err := g.callback(origin, "checker")
+			if err != nil {
+				return err
+			}
+			b.printf("if s := p.next(at, cn); %s(s) {\n", fn)
+			b.printf("return len(s), true, nil\n}\n")
+		default:
+			fn, err := g.callback(origin, "injector")
+			if err != nil {
+				return err
+			}
+			b.printf("if k, ok := %s(p.next(at, cn)); ok {\n", fn)
+			b.printf("if p.cfg.SyntaxTree {\np.clip(at + k)\n}\n")
+			b.printf("return k, true, nil\n}\n")
+		}
+		b.printf("}\nreturn 0, false, nil\n")
+
+	case *patternLineAnchorPredicate:
+		b.printf("prev, next := p.previous(at, 1), p.next(at, 1)\n")
+		if pat.linestart {
+			b.printf("return 0, prev == \"\" || prev == \"\\n\" || (prev == \"\\r\" && next != \"\\n\"), nil\n")
+		} else {
+			b.printf("return 0, next == \"\" || next == \"\\r\" || (next == \"\\n\" && prev != \"\\r\"), nil\n")
+		}
+
+	case patternEOFPredicate:
+		b.printf("return 0, at >= len(p.text), nil\n")
+
+	case *patternPredicate:
+		if pat.keep {
+			b.printf("i := len(p.capstack[len(p.capstack)-1].args)\n")
+			b.call(b.sub(pat.pat), "at", false)
+			b.printf("if p.cfg.SyntaxTree {\np.truncate(i)\n}\n")
+		} else {
+			b.lookahead(b.sub(pat.pat))
+		}
+		b.printf("return 0, %s, nil\n", negate("ok", pat.not))
+
+	case *patternAndPredicate:
+		for _, sub := range pat.pats {
+			b.lookahead(b.sub(sub))
+			b.failIf("!ok")
+		}
+		b.printf("return 0, true, nil\n")
+
+	case *patternOrPredicate:
+		for _, sub := range pat.pats {
+			b.lookahead(b.sub(sub))
+			b.printf("if ok {\nreturn 0, true, nil\n}\n")
+		}
+		b.printf("return 0, false, nil\n")
+
+	case *patternAbort:
+		b.printf("return 0, false, p.abort(at, %q)\n", pat.msg)
+
+	case *patternIf:
+		b.lookahead(b.sub(pat.cond))
+		b.printf("if ok {\n")
+		b.execute(b.sub(pat.yes))
+		b.printf("}\n")
+		b.execute(b.sub(pat.no))
+
+	case *patternSwitch:
+		for _, c := range pat.cases {
+			b.lookahead(b.sub(c.cond))
+			b.printf("if ok {\n")
+			b.execute(b.sub(c.then))
+			b.printf("}\n")
+		}
+		b.execute(b.sub(pat.otherwise))
+
+	case *patternSymbolDeclare:
+		b.call(b.sub(pat.pat), "at", true)
+		b.failIf("!ok")
+		b.printf("p.declare(%q, p.text[at:at+cn])\nreturn cn, true, nil\n", pat.setname)
+
+	case *patternSymbolSet:
+		b.printf("n, ok := p.searchSymbol(at, %q)\nreturn n, ok, nil\n", pat.setname)
+
+	case *patternSymbolScope:
+		b.printf("p.enterSymbolScope()\n")
+		b.call(b.sub(pat.pat), "at", true)
+		b.printf("p.leaveSymbolScope()\nreturn cn, ok, nil\n")
+
+	case *patternAction:
+		fn, err := g.callback(pat.fn, "action")
+		if err != nil {
+			return err
+		}
+		b.printf("p.beginValues()\n")
+		b.call(b.sub(pat.pat), "at", true)
+		b.printf("vals := p.endValues()\n")
+		b.failIf("!ok")
+		b.printf("if p.acting() {\nval, err := %s(vals)\n", fn)
+		b.printf("if err != nil {\nreturn 0, false, err\n}\np.pushValue(val)\n}\n")
+		b.printf("return cn, true, nil\n")
+
+	case *patternActionText:
+		fn, err := g.callback(pat.fn, "action")
+		if err != nil {
+			return err
+		}
+		b.call(b.sub(pat.pat), "at", true)
+		b.failIf("!ok")
+		b.printf("if p.acting() {\nval, err := %s(p.text[at : at+cn])\n", fn)
+		b.printf("if err != nil {\nreturn 0, false, err\n}\np.pushValue(val)\n}\n")
+		b.printf("return cn, true, nil\n")
+
+	case *patternTemplate:
+		b.printf("return 0, false, %s\n", g.errorVar(errorInvokeTemplate))
+
+	case *patternCallTemplate:
+		return b.callTemplate(pat)
+
+	default:
+		return errorf("generate: unsupported pattern %s", pat)
+	}
+	return nil
+}
+
+// Resolves the template statically, as resolveTemplateArgument does.
+func (b *genBody) callTemplate(pat *patternCallTemplate) error {
+	g := b.g
+	callee, ok := b.env.vars[pat.varname]
+	if !ok {
+		b.printf("return 0, false, %s\n", g.errorVar(errorUndefinedVar(pat.varname)))
+		return nil
+	}
+	for callee.env != nil {
+		if v, ok := callee.pat.(*patternCaptureVariable); ok && v.cons == nil {
+			if resolved, ok := callee.env.vars[v.varname]; ok {
+				callee = resolved
+				continue
+			}
+		}
+		callee = genBinding{pat: callee.pat}
+	}
+	tpl, ok := callee.pat.(*patternTemplate)
+	if !ok {
+		b.printf("return 0, false, %s\n", g.errorVar(errorNotTemplate(pat.varname)))
+		return nil
+	}
+	if len(tpl.params) != len(pat.args) {
+		err := errorTemplateArity(pat.varname, len(tpl.params), len(pat.args))
+		b.printf("return 0, false, %s\n", g.errorVar(err))
+		return nil
+	}
+
+	// bind arguments to the namespaces of call site, variables passed as
+	// arguments are resolved at once to keep the instantiations finite.
+	vars := make(map[string]genBinding, len(tpl.params))
+	for i, param := range tpl.params {
+		arg := genBinding{pat: pat.args[i], env: b.env}
+		if v, ok := arg.pat.(*patternCaptureVariable); ok && v.cons == nil {
+			if resolved, ok := b.env.vars[v.varname]; ok {
+				arg = resolved
+				if arg.env == nil {
+					arg.env = b.env
+					arg.rule = v.varname
+				}
+			}
+		}
+		vars[param] = arg
+	}
+	env := g.intern(b.env, vars)
+	if pat.cons != nil {
+		b.begin(pat.varname)
+	}
+	b.beginRule(pat.varname, true)
+	b.call(genNode{pat: tpl.body, env: env}, "at", true)
+	b.endRule(true)
+	if pat.cons != nil {
+		b.end()
+	}
+	b.printf("return cn, ok, nil\n")
+	return nil
+}
+
+// Writes the matching of a single rune, has is the condition of r.
+func (b *genBody) rune(has string) {
+	b.printf("r, w := utf8.DecodeRuneInString(p.text[at:])\n")
+	b.printf("if w != 0 && %s {\nreturn w, true, nil\n}\nreturn 0, false, nil\n", has)
+}
+
+func (b *genBody) repeats() {
+	b.printf("if p.repeats(i) {\nreturn 0, false, %sErrRepeatLimit\n}\n", b.g.prefix)
+}
+
+// Begins the capture of Variable.
+func (b *genBody) begin(varname string) {
+	b.printf("p.beginContext(func(cc peg.CaptureContext, subs []peg.Capture) (peg.Capture, error) {\n")
+	b.printf("return &peg.Variable{Name: %q, Subs: subs, Start: cc.Start, End: cc.End}, nil\n}, %q)\n", varname, varname)
+}
+
+// Begins the capture of rule defined by CLet, which is collapsed into the
+// only capture inside if transparent.
+func (b *genBody) beginAuto(rule string, transparent bool) {
+	if !transparent {
+		b.begin(rule)
+		return
+	}
+	b.printf("p.beginContext(func(cc peg.CaptureContext, subs []peg.Capture) (peg.Capture, error) {\n")
+	b.printf("if len(subs) == 1 {\nreturn subs[0], nil\n}\n")
+	b.printf("return &peg.Variable{Name: %q, Subs: subs, Start: cc.Start, End: cc.End}, nil\n}, %q)\n", rule, rule)
+}
+
+// Sets the rule name of captures, and optionally begins the rule node of
+// syntax tree.
+func (b *genBody) beginRule(rule string, node bool) {
+	b.printf("rule := p.rule\np.rule = %q\n", rule)
+	if node {
+		b.printf("p.beginRule(%q)\n", rule)
+	}
+}
+
+func (b *genBody) endRule(node bool) {
+	b.printf("p.rule = rule\n")
+	if node {
+		b.printf("if err = p.endRule(ok, at, cn); err != nil {\nreturn 0, false, err\n}\n")
+	}
+}
+
+func (b *genBody) end() {
+	b.printf("if err = p.end(ok, at, cn); err != nil {\nreturn 0, false, err\n}\n")
+}
+
+// Gets the condition of r in unicode ranges.
+func (b *genBody) unicode(pat *patternUnicodeRanges) (string, error) {
+	var tables, slices []string
+	for _, name := range pat.names {
+		if r, ok := unicodeRangeAliases[name]; ok {
+			tables = append(tables, unicodeCategoryExpr(r))
+		} else if rs, ok := unicodeRangeSliceAliases[name]; ok {
+			if len(rs) == 0 || rs[0] != unicode.GraphicRanges[0] {
+				return "", errorUndefinedUnicodeRanges(name)
+			}
+			slices = append(slices, "unicode.GraphicRanges")
+		} else if _, ok := unicode.Properties[name]; ok {
+			tables = append(tables, fmt.Sprintf("unicode.Properties[%q]", name))
+		} else if _, ok := unicode.Scripts[name]; ok {
+			tables = append(tables, fmt.Sprintf("unicode.Scripts[%q]", name))
+		} else if _, ok := unicode.Categories[name]; ok {
+			tables = append(tables, fmt.Sprintf("unicode.Categories[%q]", name))
+		} else {
+			return "", errorUndefinedUnicodeRanges(name)
+		}
+	}
+
+	has := "false"
+	if len(tables)+len(slices) > 0 {
+		expr := fmt.Sprintf("[]*unicode.RangeTable{%s}", strings.Join(tables, ", "))
+		for _, s := range slices {
+			expr = fmt.Sprintf("append(%s, %s...)", expr, s)
+		}
+		has = fmt.Sprintf("unicode.In(r, %s...)", b.g.declare("%s", expr))
+	}
+	return negate(has, pat.not), nil
+}
+
+func unicodeCategoryExpr(table *unicode.RangeTable) string {
+	names := make([]string, 0, 1)
+	for name, r := range unicode.Categories {
+		if r == table {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("unicode.Categories[%q]", names[0])
+}
+
+// Gets the composite literal of prefix tree.
+func (g *generator) tree(tree prefixTree) string {
+	subs := make([]string, len(tree.subs))
+	for i := range tree.subs {
+		subs[i] = g.tree(tree.subs[i])
+	}
+	keys := make([]string, len(tree.keys))
+	for i := range tree.keys {
+		keys[i] = fmt.Sprintf("%q", tree.keys[i])
+	}
+	return fmt.Sprintf("%sTree{term: %t, width: %d, keys: []string{%s}, subs: []%sTree{%s}}",
+		g.prefix, tree.term, tree.width, strings.Join(keys, ", "),
+		g.prefix, strings.Join(subs, ", "))
+}
+
+func negate(cond string, not bool) string {
+	if not {
+		return "!" + cond
+	}
+	return cond
+}
+
+func runeLiteral(r rune) string {
+	if utf8.ValidRune(r) {
+		return fmt.Sprintf("%q", r)
+	}
+	return fmt.Sprintf("%d", r)
+}
+
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
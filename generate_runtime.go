@@ -0,0 +1,744 @@
+package peg
+
+// Source of the generated matcher entries, FUNC and PREFIX are replaced by
+// the function name and the prefix of the unexported identifiers.
+const generatedEntries = `
+// FUNC matches text using the default configuration, as peg.Match does.
+func FUNC(text string) (*peg.Result, error) {
+	return FUNCConfig(peg.Config{
+		CallstackLimit: peg.DefaultCallstackLimit,
+		RepeatLimit:    peg.DefaultRepeatLimit,
+	}, text)
+}
+
+// FUNCConfig matches text using the given configuration, as cfg.Match does.
+func FUNCConfig(cfg peg.Config, text string) (*peg.Result, error) {
+	p := &PREFIXParser{
+		cfg:      cfg,
+		text:     text,
+		capstack: []PREFIXThunk{{}},
+		valstack: [][]interface{}{nil},
+	}
+	n, ok, err := p.m0(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &peg.Result{}, nil
+	}
+	if err = p.flush(0); err != nil {
+		return nil, err
+	}
+	groups, named := PREFIXFlattenGroups(p.groups)
+	if cfg.SyntaxTree {
+		if PREFIXRootTerminal && n > 0 {
+			p.cut(0, 0, n)
+		}
+		return &peg.Result{
+			Ok:          true,
+			N:           n,
+			Groups:      groups,
+			NamedGroups: named,
+			GroupTree:   p.groups,
+			Tree:        p.tree(n),
+			Values:      p.valstack[0],
+		}, nil
+	}
+	return &peg.Result{
+		Ok:          true,
+		N:           n,
+		Groups:      groups,
+		NamedGroups: named,
+		GroupTree:   p.groups,
+		Captures:    p.capstack[0].args,
+		Values:      p.valstack[0],
+	}, nil
+}
+`
+
+// Source of the generated runtime, which mirrors the context of the
+// interpreter, PREFIX is replaced by the prefix of the unexported identifiers.
+const generatedRuntime = `
+// Running state of pattern matching.
+type PREFIXParser struct {
+	cfg    peg.Config
+	text   string
+	cached int
+	lnends []int
+
+	groups []*peg.Group
+	frames []PREFIXFrame
+	rule   string
+
+	lookaheads int
+
+	capstack []PREFIXThunk
+	valstack [][]interface{}
+
+	symbols   *PREFIXSymbol
+	symscopes []*PREFIXSymbol
+
+	deferred []PREFIXDeferred
+}
+
+// Saved state of the caller.
+type PREFIXFrame struct {
+	groups  []*peg.Group
+	symbols *PREFIXSymbol
+	ncaps   int
+	nvals   int
+	ndefers int
+}
+
+// Hook queued by OnCommit.
+type PREFIXDeferred struct {
+	hook func(string, peg.Position) error
+	text string
+	pos  peg.Position
+}
+
+// Incomplete grammar tree construction.
+type PREFIXThunk struct {
+	cons    func([]peg.Capture) (peg.Capture, error)
+	ctxcons func(peg.CaptureContext, []peg.Capture) (peg.Capture, error)
+	rule    string
+	args    []peg.Capture
+	terms   []int // terminal patterns of syntax tree leaves, -1 for rules
+}
+
+// Symbol added to a dynamic symbol set.
+type PREFIXSymbol struct {
+	setname string
+	text    string
+	next    *PREFIXSymbol
+}
+
+// Search structure for text sets.
+type PREFIXTree struct {
+	term  bool
+	width int
+	keys  []string
+	subs  []PREFIXTree
+}
+
+var PREFIXLineTerminators = []struct {
+	term peg.LineTerminators
+	text string
+}{
+	{peg.TerminateNEL, "\u0085"},
+	{peg.TerminateLS, "\u2028"},
+	{peg.TerminatePS, "\u2029"},
+	{peg.TerminateVT, "\v"},
+	{peg.TerminateFF, "\f"},
+}
+
+var PREFIXFoldCaseWorkAround = map[rune]rune{
+	'\u017f': '\u017f',
+	'\u212a': '\u212a',
+}
+
+func (p *PREFIXParser) overflow(depth int) bool {
+	return p.cfg.CallstackLimit > 0 && depth > p.cfg.CallstackLimit
+}
+
+func (p *PREFIXParser) repeats(i int) bool {
+	return p.cfg.RepeatLimit > 0 && i >= p.cfg.RepeatLimit
+}
+
+// Snapshots the groups, symbols, captures and values before invoking a callee.
+func (p *PREFIXParser) enter() {
+	p.frames = append(p.frames, PREFIXFrame{
+		groups:  p.groups,
+		symbols: p.symbols,
+		ncaps:   len(p.capstack[len(p.capstack)-1].args),
+		nvals:   len(p.valstack[len(p.valstack)-1]),
+		ndefers: len(p.deferred),
+	})
+	p.groups = nil
+}
+
+// Recovers the snapshot, merges the groups of the matched callee, or drops
+// the symbols, captures, values and queued hooks of the dismatched callee.
+func (p *PREFIXParser) leave(ok bool) {
+	frame := p.frames[len(p.frames)-1]
+	p.frames = p.frames[:len(p.frames)-1]
+	groups := p.groups
+	p.groups = frame.groups
+	if !ok {
+		p.symbols = frame.symbols
+		p.truncate(frame.ncaps)
+		if vals := &p.valstack[len(p.valstack)-1]; len(*vals) > frame.nvals {
+			*vals = (*vals)[:frame.nvals]
+		}
+		p.deferred = p.deferred[:frame.ndefers]
+		return
+	}
+	if len(p.groups) == 0 {
+		p.groups = groups
+	} else {
+		p.groups = append(p.groups, groups...)
+	}
+}
+
+func (p *PREFIXParser) next(at, n int) string {
+	tail := p.text[at:]
+	if len(tail) < n {
+		return tail
+	}
+	return tail[:n]
+}
+
+func (p *PREFIXParser) previous(at, n int) string {
+	if at < n {
+		return p.text[:at]
+	}
+	return p.text[at-n : at]
+}
+
+func (p *PREFIXParser) tell(at int) peg.Position {
+	if p.cfg.DisableLineColumnCounting {
+		return peg.Position{Offest: at}
+	}
+
+	for ; p.cached < at; p.cached++ {
+		switch p.text[p.cached] {
+		case '\n':
+			p.lnends = append(p.lnends, p.cached+1)
+		case '\r':
+			if !strings.HasPrefix(p.text[p.cached+1:], "\n") {
+				p.lnends = append(p.lnends, p.cached+1)
+			}
+		default:
+			if p.cfg.LineTerminators == 0 {
+				continue
+			}
+			for _, t := range PREFIXLineTerminators {
+				if p.cfg.LineTerminators&t.term != 0 && strings.HasPrefix(p.text[p.cached:], t.text) {
+					p.lnends = append(p.lnends, p.cached+len(t.text))
+					break
+				}
+			}
+		}
+	}
+
+	ln, lnstart := 0, 0
+	i, j := 0, len(p.lnends)
+	for i < j {
+		m := i + (j-i)/2
+		if at > p.lnends[m] {
+			i = m + 1
+		} else if at < p.lnends[m] {
+			j = m
+		} else {
+			i, j = m+1, -1
+		}
+	}
+	if j < 0 {
+		ln, lnstart = i, at
+	} else if i > 0 {
+		ln, lnstart = i, p.lnends[i-1]
+	}
+	return peg.Position{
+		Offest: at,
+		Line:   ln,
+		Column: p.column(p.text[lnstart:at]),
+	}
+}
+
+func (p *PREFIXParser) column(s string) int {
+	switch p.cfg.ColumnUnit {
+	case peg.ColumnBytes:
+		return len(s)
+	case peg.ColumnUTF16:
+		n := 0
+		for _, r := range s {
+			if r >= 0x10000 {
+				n += 2
+			} else {
+				n++
+			}
+		}
+		return n
+	case peg.ColumnTabStops:
+		width := p.cfg.TabWidth
+		if width <= 0 {
+			width = peg.DefaultTabWidth
+		}
+		n := 0
+		for _, r := range s {
+			if r == '\t' {
+				n += width - n%width
+			} else {
+				n++
+			}
+		}
+		return n
+	default:
+		return utf8.RuneCountInString(s)
+	}
+}
+
+func (p *PREFIXParser) abort(at int, msg string) error {
+	pos := p.tell(at)
+	return errors.New("peg: abort:" + pos.String() + ": " + msg)
+}
+
+func (p *PREFIXParser) group(grpname string, nsubs, at, n int) {
+	if p.cfg.DisableGrouping {
+		return
+	}
+
+	g := &peg.Group{
+		Name:  grpname,
+		Text:  p.text[at : at+n],
+		Start: p.tell(at),
+		End:   p.tell(at + n),
+	}
+	if len(p.groups) > nsubs {
+		g.Subs = p.groups[nsubs:]
+	}
+	p.groups = append(p.groups[:nsubs:nsubs], g)
+}
+
+func (p *PREFIXParser) refer(grpname string) string {
+	if g := PREFIXLastGroup(p.groups, grpname); g != nil {
+		return g.Text
+	}
+	for i := len(p.frames) - 1; i >= 0; i-- {
+		if g := PREFIXLastGroup(p.frames[i].groups, grpname); g != nil {
+			return g.Text
+		}
+	}
+	return ""
+}
+
+func (p *PREFIXParser) referIndex(index int) string {
+	var groups []*peg.Group
+	for i := range p.frames {
+		groups = PREFIXAppendGroups(groups, p.frames[i].groups, "")
+	}
+	groups = PREFIXAppendGroups(groups, p.groups, "")
+	if index < 0 {
+		index += len(groups)
+	}
+	if index < 0 || index >= len(groups) {
+		return ""
+	}
+	return groups[index].Text
+}
+
+func PREFIXAppendGroups(dst, groups []*peg.Group, grpname string) []*peg.Group {
+	for _, g := range groups {
+		dst = PREFIXAppendGroups(dst, g.Subs, grpname)
+		if g.Name == grpname {
+			dst = append(dst, g)
+		}
+	}
+	return dst
+}
+
+func PREFIXLastGroup(groups []*peg.Group, grpname string) *peg.Group {
+	for i := len(groups) - 1; i >= 0; i-- {
+		if groups[i].Name == grpname {
+			return groups[i]
+		}
+		if g := PREFIXLastGroup(groups[i].Subs, grpname); g != nil {
+			return g
+		}
+	}
+	return nil
+}
+
+func PREFIXFlattenGroups(groups []*peg.Group) (anonymous []string, named map[string]string) {
+	for _, g := range PREFIXAppendGroups(nil, groups, "") {
+		anonymous = append(anonymous, g.Text)
+	}
+	var walk func([]*peg.Group)
+	walk = func(groups []*peg.Group) {
+		for _, g := range groups {
+			walk(g.Subs)
+			if g.Name != "" {
+				if named == nil {
+					named = make(map[string]string)
+				}
+				named[g.Name] = g.Text
+			}
+		}
+	}
+	walk(groups)
+	return anonymous, named
+}
+
+// Discards the captures, or the syntax tree nodes, after the first n.
+func (p *PREFIXParser) truncate(n int) {
+	if !p.capturing() && !p.cfg.SyntaxTree {
+		return
+	}
+	top := &p.capstack[len(p.capstack)-1]
+	if len(top.args) > n {
+		top.args = top.args[:n]
+	}
+	if len(top.terms) > n {
+		top.terms = top.terms[:n]
+	}
+}
+
+func (p *PREFIXParser) capturing() bool {
+	return !p.cfg.DisableCapturing && !p.cfg.SyntaxTree && p.lookaheads == 0
+}
+
+func (p *PREFIXParser) push(cap peg.Capture) {
+	if !p.capturing() {
+		return
+	}
+	thunk := &p.capstack[len(p.capstack)-1]
+	thunk.args = append(thunk.args, cap)
+}
+
+func (p *PREFIXParser) begin(cons func([]peg.Capture) (peg.Capture, error)) {
+	if !p.capturing() {
+		return
+	}
+	p.capstack = append(p.capstack, PREFIXThunk{cons: cons})
+}
+
+func (p *PREFIXParser) beginContext(cons func(peg.CaptureContext, []peg.Capture) (peg.Capture, error), rule string) {
+	if !p.capturing() {
+		return
+	}
+	p.capstack = append(p.capstack, PREFIXThunk{ctxcons: cons, rule: rule})
+}
+
+func (p *PREFIXParser) end(matched bool, at, n int) error {
+	if !p.capturing() {
+		return nil
+	}
+
+	thunk := p.capstack[len(p.capstack)-1]
+	p.capstack = p.capstack[:len(p.capstack)-1]
+	if !matched {
+		return nil
+	}
+	var cap peg.Capture
+	var err error
+	switch {
+	case thunk.ctxcons != nil:
+		cap, err = thunk.ctxcons(peg.CaptureContext{
+			Rule:  thunk.rule,
+			Text:  p.text[at : at+n],
+			Start: p.tell(at),
+			End:   p.tell(at + n),
+		}, thunk.args)
+	case thunk.cons != nil:
+		cap, err = thunk.cons(thunk.args)
+	default:
+		return PREFIXErrNilConstructor
+	}
+	if err != nil {
+		return err
+	}
+	p.push(cap)
+	return nil
+}
+
+func (p *PREFIXParser) collect() []peg.Capture {
+	if !p.capturing() {
+		return nil
+	}
+
+	thunk := p.capstack[len(p.capstack)-1]
+	p.capstack = p.capstack[:len(p.capstack)-1]
+	return thunk.args
+}
+
+func (p *PREFIXParser) acting() bool {
+	return !p.cfg.DisableActions && p.lookaheads == 0
+}
+
+func (p *PREFIXParser) pushValue(val interface{}) {
+	if !p.acting() {
+		return
+	}
+	vals := &p.valstack[len(p.valstack)-1]
+	*vals = append(*vals, val)
+}
+
+func (p *PREFIXParser) beginValues() {
+	if !p.acting() {
+		return
+	}
+	p.valstack = append(p.valstack, nil)
+}
+
+func (p *PREFIXParser) endValues() []interface{} {
+	if !p.acting() {
+		return nil
+	}
+	vals := p.valstack[len(p.valstack)-1]
+	p.valstack = p.valstack[:len(p.valstack)-1]
+	return vals
+}
+
+func (p *PREFIXParser) queue(hook func(string, peg.Position) error, at, n int) {
+	if p.lookaheads != 0 {
+		return
+	}
+	p.deferred = append(p.deferred, PREFIXDeferred{hook: hook, text: p.text[at : at+n], pos: p.tell(at)})
+}
+
+func (p *PREFIXParser) flush(n int) error {
+	hooks := p.deferred[n:]
+	p.deferred = p.deferred[:n]
+	for _, d := range hooks {
+		if err := d.hook(d.text, d.pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *PREFIXParser) beginRule(rule string) {
+	if !p.cfg.SyntaxTree {
+		return
+	}
+	p.capstack = append(p.capstack, PREFIXThunk{rule: rule})
+}
+
+func (p *PREFIXParser) endRule(matched bool, at, n int) error {
+	if !p.cfg.SyntaxTree {
+		return nil
+	}
+
+	thunk := p.capstack[len(p.capstack)-1]
+	p.capstack = p.capstack[:len(p.capstack)-1]
+	if !matched {
+		return nil
+	}
+	top := &p.capstack[len(p.capstack)-1]
+	top.args = append(top.args, PREFIXSyntaxNode(thunk.rule, thunk.args, thunk.terms, at, at+n))
+	top.terms = append(top.terms, -1)
+	return nil
+}
+
+func (p *PREFIXParser) cut(term, at, n int) {
+	if !p.cfg.SyntaxTree {
+		return
+	}
+
+	top := &p.capstack[len(p.capstack)-1]
+	top.args = append(top.args, &peg.SyntaxNode{
+		Start: peg.Position{Offest: at},
+		End:   peg.Position{Offest: at + n},
+	})
+	top.terms = append(top.terms, term)
+}
+
+// Clips the syntax tree nodes to the text before limit.
+func (p *PREFIXParser) clip(limit int) {
+	top := &p.capstack[len(p.capstack)-1]
+	items, terms := top.args[:0], top.terms[:0]
+	for i, cap := range top.args {
+		item := cap.(*peg.SyntaxNode)
+		if item.Start.Offest > limit || (item.Start.Offest == limit && item.End.Offest > limit) {
+			continue
+		}
+		if item.End.Offest > limit {
+			PREFIXClipSyntaxNode(item, limit)
+		}
+		items, terms = append(items, item), append(terms, top.terms[i])
+	}
+	top.args, top.terms = items, terms
+}
+
+func PREFIXClipSyntaxNode(node *peg.SyntaxNode, limit int) {
+	node.End.Offest = limit
+	children := node.Children[:0]
+	for _, sub := range node.Children {
+		if sub.Start.Offest > limit || (sub.Start.Offest == limit && sub.End.Offest > limit) {
+			continue
+		}
+		if sub.End.Offest > limit {
+			PREFIXClipSyntaxNode(sub, limit)
+		}
+		children = append(children, sub)
+	}
+	node.Children = children
+}
+
+func (p *PREFIXParser) tree(n int) *peg.SyntaxNode {
+	root := PREFIXSyntaxNode("", p.capstack[0].args, p.capstack[0].terms, 0, n)
+	p.locate(root)
+	return root
+}
+
+func (p *PREFIXParser) locate(node *peg.SyntaxNode) {
+	node.Text = p.text[node.Start.Offest:node.End.Offest]
+	node.Start = p.tell(node.Start.Offest)
+	node.End = p.tell(node.End.Offest)
+	for _, sub := range node.Children {
+		p.locate(sub)
+	}
+}
+
+// Builds the syntax tree node as the interpreter does.
+func PREFIXSyntaxNode(rule string, items []peg.Capture, terms []int, from, to int) *peg.SyntaxNode {
+	node := &peg.SyntaxNode{
+		Rule:  rule,
+		Start: peg.Position{Offest: from},
+		End:   peg.Position{Offest: to},
+	}
+	at := from
+	var last *peg.SyntaxNode
+	lastterm := -1
+	for i, cap := range items {
+		item := cap.(*peg.SyntaxNode)
+		if terms[i] >= 0 && last != nil && lastterm == terms[i] && last.End.Offest == item.Start.Offest {
+			last.End = item.End
+			at = item.End.Offest
+			continue
+		}
+		if item.Start.Offest > at {
+			node.Children = append(node.Children, &peg.SyntaxNode{
+				Start: peg.Position{Offest: at},
+				End:   peg.Position{Offest: item.Start.Offest},
+			})
+		}
+		node.Children = append(node.Children, item)
+		last, lastterm = item, terms[i]
+		at = item.End.Offest
+	}
+	if at < to {
+		node.Children = append(node.Children, &peg.SyntaxNode{
+			Start: peg.Position{Offest: at},
+			End:   peg.Position{Offest: to},
+		})
+	}
+	return node
+}
+
+func (p *PREFIXParser) declare(setname, text string) {
+	p.symbols = &PREFIXSymbol{setname: setname, text: text, next: p.symbols}
+}
+
+func (p *PREFIXParser) searchSymbol(at int, setname string) (n int, ok bool) {
+	tail := p.text[at:]
+	for sym := p.symbols; sym != nil; sym = sym.next {
+		if sym.setname != setname || len(sym.text) < n {
+			continue
+		}
+		if strings.HasPrefix(tail, sym.text) && (!ok || len(sym.text) > n) {
+			n, ok = len(sym.text), true
+		}
+	}
+	return n, ok
+}
+
+func (p *PREFIXParser) enterSymbolScope() {
+	p.symscopes = append(p.symscopes, p.symbols)
+}
+
+func (p *PREFIXParser) leaveSymbolScope() {
+	p.symbols = p.symscopes[len(p.symscopes)-1]
+	p.symscopes = p.symscopes[:len(p.symscopes)-1]
+}
+
+func (p *PREFIXParser) matchTree(at int, tree *PREFIXTree, insensitive bool) (int, bool) {
+	type state struct {
+		n    int
+		tree *PREFIXTree
+	}
+
+	back := false
+	stack := []state{{0, tree}}
+	for len(stack) > 0 {
+		st := stack[len(stack)-1]
+		if back {
+			stack = stack[:len(stack)-1]
+			if st.tree.term {
+				return st.n, true
+			}
+			continue
+		}
+
+		s := p.next(at, st.n+st.tree.width)[st.n:]
+		if insensitive {
+			s = PREFIXFoldCase(s)
+		}
+		i, ok := st.tree.search(s)
+		if !ok {
+			back = true
+			continue
+		}
+		stack = append(stack, state{st.n + st.tree.width, &st.tree.subs[i]})
+	}
+	return 0, false
+}
+
+func (tree *PREFIXTree) search(s string) (int, bool) {
+	if len(s) != tree.width {
+		return 0, false
+	}
+
+	i, j := 0, len(tree.keys)
+	for i < j {
+		m := i + (j-i)/2
+		if s == tree.keys[m] {
+			return m, true
+		} else if s > tree.keys[m] {
+			i = m + 1
+		} else {
+			j = m
+		}
+	}
+	return 0, false
+}
+
+func PREFIXTrunc(maxrune int, s string) (int, bool) {
+	if maxrune < 0 {
+		return 0, false
+	} else if maxrune == 0 {
+		return 0, true
+	}
+	if len(s) < maxrune {
+		return len(s), true
+	}
+
+	n := 0
+	for i := range s {
+		if n >= maxrune {
+			return i, true
+		}
+		n++
+	}
+	return len(s), true
+}
+
+func PREFIXFoldCase(s string) string {
+	encoded := make([]byte, 0, 16)
+	buf := make([]byte, 4)
+	for i, r := range s {
+		if r == unicode.ReplacementChar {
+			encoded = append(encoded, s[i])
+		} else {
+			n := utf8.EncodeRune(buf, PREFIXRuneFoldCase(r))
+			encoded = append(encoded, buf[:n]...)
+		}
+	}
+	return string(encoded)
+}
+
+func PREFIXRuneFoldCase(r rune) rune {
+	if w, ok := PREFIXFoldCaseWorkAround[r]; ok {
+		return w
+	}
+
+	r0 := unicode.SimpleFold(r)
+	if r0 == r {
+		return r
+	}
+	for r0 > r {
+		r0 = unicode.SimpleFold(r0)
+	}
+	return r0
+}
+`
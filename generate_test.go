@@ -0,0 +1,338 @@
+package peg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Callbacks shared by the patterns and the generated program.
+const generateTestCallbacks = `
+func genJoin(subs []peg.Capture) (peg.Capture, error) {
+	return &peg.Variable{Name: "joined", Subs: subs}, nil
+}
+
+func genUpper(s string, pos peg.Position) (peg.Capture, error) {
+	return &peg.Token{Type: len(s), Value: strings.ToUpper(s), Position: pos}, nil
+}
+
+func genSpan(cc peg.CaptureContext, subs []peg.Capture) (peg.Capture, error) {
+	name := cc.Rule + "@" + cc.Start.String() + "-" + cc.End.String()
+	return &peg.Variable{Name: name, Subs: subs}, nil
+}
+
+func genHook(s string, pos peg.Position) error {
+	if s == "stop" {
+		return errors.New("stopped at " + pos.String())
+	}
+	return nil
+}
+
+func genHalf(s string) (int, bool) {
+	return len(s) / 2, len(s) > 1
+}
+
+func genEven(s string) bool {
+	return len(s)%2 == 0
+}
+
+func genFold(acc, cap peg.Capture) (peg.Capture, error) {
+	return &peg.Variable{Name: "fold", Subs: []peg.Capture{acc, cap}}, nil
+}
+
+func genEvenSubs(cc peg.CaptureContext, subs []peg.Capture) (bool, []peg.Capture, error) {
+	return len(subs)%2 == 0, append(subs, peg.Text(cc.Text)), nil
+}
+
+func genSum(vals []interface{}) (interface{}, error) {
+	sum := 0
+	for _, val := range vals {
+		sum += val.(int)
+	}
+	return sum, nil
+}
+
+func genLen(s string) (interface{}, error) {
+	return len(s), nil
+}
+`
+
+func genJoin(subs []Capture) (Capture, error) {
+	return &Variable{Name: "joined", Subs: subs}, nil
+}
+
+func genUpper(s string, pos Position) (Capture, error) {
+	return &Token{Type: len(s), Value: strings.ToUpper(s), Position: pos}, nil
+}
+
+func genSpan(cc CaptureContext, subs []Capture) (Capture, error) {
+	name := cc.Rule + "@" + cc.Start.String() + "-" + cc.End.String()
+	return &Variable{Name: name, Subs: subs}, nil
+}
+
+func genHook(s string, pos Position) error {
+	if s == "stop" {
+		return fmt.Errorf("stopped at %s", pos.String())
+	}
+	return nil
+}
+
+func genHalf(s string) (int, bool) {
+	return len(s) / 2, len(s) > 1
+}
+
+func genEven(s string) bool {
+	return len(s)%2 == 0
+}
+
+func genFold(acc, cap Capture) (Capture, error) {
+	return &Variable{Name: "fold", Subs: []Capture{acc, cap}}, nil
+}
+
+func genEvenSubs(cc CaptureContext, subs []Capture) (bool, []Capture, error) {
+	return len(subs)%2 == 0, append(subs, Text(cc.Text)), nil
+}
+
+func genSum(vals []interface{}) (interface{}, error) {
+	sum := 0
+	for _, val := range vals {
+		sum += val.(int)
+	}
+	return sum, nil
+}
+
+func genLen(s string) (interface{}, error) {
+	return len(s), nil
+}
+
+var (
+	generateTestCallbackMap = map[string]interface{}{
+		"genJoin":     genJoin,
+		"genUpper":    genUpper,
+		"genSpan":     genSpan,
+		"genHook":     genHook,
+		"genHalf":     genHalf,
+		"genEven":     genEven,
+		"genFold":     genFold,
+		"genEvenSubs": genEvenSubs,
+		"genSum":      genSum,
+		"genLen":      genLen,
+	}
+
+	generateTestConfigs = []Config{
+		defaultConfig,
+		{CallstackLimit: 5, RepeatLimit: 3},
+		{CallstackLimit: 100, RepeatLimit: 100, DisableLineColumnCounting: true,
+			DisableGrouping: true, DisableCapturing: true},
+		{CallstackLimit: 100, RepeatLimit: 100, SyntaxTree: true},
+		{CallstackLimit: 100, RepeatLimit: 100, ColumnUnit: ColumnUTF16, LineTerminators: TerminateUnicode},
+		{CallstackLimit: 100, RepeatLimit: 100, ColumnUnit: ColumnTabStops, TabWidth: 4, LineTerminators: TerminateLS},
+	}
+)
+
+type generateTestData struct {
+	pat   Pattern
+	texts []string
+}
+
+func dumpGenerateResult(r *Result, err error) string {
+	if err != nil {
+		return fmt.Sprintf("error %s", err)
+	}
+	tree, _ := json.Marshal(r.GroupTree)
+	return fmt.Sprintf("%t %d %q %q %s %v %v %v", r.Ok, r.N, r.Groups, r.NamedGroups, tree, r.Captures, r.Tree, r.Values)
+}
+
+// Tests generated matchers against the interpreter.
+func TestGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip compiling generated code in short mode")
+	}
+	gocmd := filepath.Join(runtime.GOROOT(), "bin", "go")
+	if _, err := os.Stat(gocmd); err != nil {
+		t.Skip("go command is not found")
+	}
+
+	scope := map[string]Pattern{
+		"list": Template([]string{"item", "sep"},
+			Seq(T("["), J0(V("item"), V("sep")), T("]"))),
+		"wrap": Template([]string{"item"},
+			Seq(T("("), V("item"), T(")"))),
+		"twice": Template([]string{"tpl", "item"},
+			Seq(Call("tpl", V("item")), Call("tpl", V("item")))),
+		"item":  T("x"),
+		"digit": CK(0, R('0', '9')),
+		"expr":  Alt(Seq(T("("), CV("expr"), T(")")), CK(1, Q1(R('a', 'z')))),
+	}
+	data := []generateTestData{
+		{Seq(T("ab"), TI("Cd"), Dot, S("xyz"), NS("xyz"), R('0', '9', 'a', 'f'), NR('0', '9')),
+			[]string{"abcDxx!1-", "abCDzz05", "ab"}},
+		{Seq(U("Lu", "Greek"), U("-Letter"), U("Print", "-Digit"), EOF),
+			[]string{"Aα-!", "A--!", "Ω-z", "Ω-1"}},
+		{Q1(Alt(TS("a", "ab", "abc", ""), TSI("Hello", "hel"), SOL, EOL)),
+			[]string{"abcab", "HELLOhel", "abd"}},
+		{Seq(Q0(S(" \n")), Q1(CK(2, Q1(R('a', 'z')))), Skip(2), Until(T(";")), UntilB(T(".")), Qmn(1, 3, T("-"))),
+			[]string{"\n ab12xx;yy.--", "ab1", "ab12;;.----"}},
+		{Seq(NG("a", Q1(T("a"))), G(Q0(T("b"))), Ref("a"), Ref(""), RefB("a"), Test(T("c")), Not(T("cd")),
+			And(T("c"), Dot), Or(T("x"), T("c")), B("c")),
+			[]string{"aabbaabbc", "aabbaabbcd", "ab"}},
+		{Seq(If(T("a"), T("ab"), T("b")), Switch(T("1"), T("1"), T("2"), T("22"), Abort("bad"))),
+			[]string{"ab1", "b22", "ab3"}},
+		{Q0(Alt(CC(genJoin, Seq(CT(genUpper, T("up")), CT(genUpper, T("s")))),
+			Trigger(genHook, Q1(R('a', 'z'))), T(" "))),
+			[]string{"ups ups", "go stop", "ups\nstop"}},
+		{Seq(Inject(genHalf, Q1(T("a"))), Check(genEven, Q1(T("b"))), Trunc(2, Q0(T("c")))),
+			[]string{"aaaabbccc", "aabbb", "abbc"}},
+		{SymScope(Seq(Sym("v", Q1(R('a', 'z'))), T("="), TSym("v"), SymScope(Sym("v", T("x"))), TSym("v"))),
+			[]string{"ab=abab", "ab=abx", "x=x"}},
+		{Let(scope, Seq(Call("list", V("digit"), T(",")), CCall("wrap", V("item")),
+			Call("twice", V("wrap"), V("item")), CV("expr"))),
+			[]string{"[1,2](x)(x)(x)((ab))", "[](x)(x)(x)c", "[1,2](x)(x)(x)((ab)"}},
+		{Let(scope, Seq(V("undefined"))), []string{""}},
+		{Let(scope, Call("item")), []string{""}},
+		{Let(scope, Call("wrap", T("a"), T("b"))), []string{""}},
+		{V("wrap"), []string{""}},
+		{Let(map[string]Pattern{"rec": Seq(V("rec"), T("a"))}, V("rec")), []string{"a"}},
+		{Let(map[string]Pattern{"tpl": scope["wrap"]}, V("tpl")), []string{""}},
+		{Q0(True), []string{""}},
+		{Let(map[string]Pattern{
+			"word": CX(genSpan, Q1(R('a', 'z'))),
+			"line": Seq(V("word"), Q0(Seq(T(" "), CV("word")))),
+			"text": J1(CX(genSpan, V("line")), T("\n")),
+		}, CX(genSpan, V("text"))), []string{"ab cd\nef", "x\r\n\ny z", "x\n\ny"}},
+		{CLet(map[string]Pattern{
+			"ws":   Silent(Q0(T(" "))),
+			"num":  CK(0, Q1(R('0', '9'))),
+			"atom": Transparent(Alt(V("num"), V("list"))),
+			"list": Seq(T("("), V("ws"), J0(V("atom"), V("ws")), T(")")),
+			"many": Template([]string{"item"}, J1(V("item"), T(","))),
+			"top":  Seq(Call("many", V("atom")), CV("ws"), CCall("many", CV("num"))),
+		}, V("top")), []string{"1,(2 ()) 3", "(1),2 3,4", "1,"}},
+		{Seq(Cp, Cf(genFold, J1(CK(0, R('0', '9')), CK(1, S("+-")))), T(";"),
+			Ct(Q0(CK(2, R('a', 'z')))), NG("x", Q0(T("x"))), Cb("x"), Cmt(genEvenSubs, Q0(CK(3, T("y")))), Cp),
+			[]string{"1+2-3;abxxyy", ";", "1;xyyy", "1+;"}},
+		{Seq(Action(genSum, J1(Alt(Seq(ActionText(genLen, Q1(T("a"))), T("!")), ActionText(genLen, Q1(R('a', 'z')))),
+			T(","))), ActionText(genLen, Q0(T(";")))),
+			[]string{"aa!,ab,aaa;;", "a", "aaa,"}},
+		{Seq(Test(Seq(NG("a", CK(1, T("x"))), Trigger(genHook, Q1(R('a', 'z'))))), If(Sym("s", CK(2, T("x"))), T("x"), T("y")),
+			Peek(Seq(NG("b", CK(3, T("x"))), ActionText(genLen, Dot))), Or(TSym("s"), CK(4, T("x"))), Q1(CK(5, Dot))),
+			[]string{"xstop", "xx", "y"}},
+		{Seq(Q0(Commit(Seq(OnCommit(genHook, Q1(R('a', 'z'))), T(";")))), Test(OnCommit(genHook, T("stop"))),
+			Alt(Seq(OnCommit(genHook, Q1(R('a', 'z'))), T("!")), OnCommit(genHook, T("s")))),
+			[]string{"ab;stop", "ab;stop;", "stop!", "x"}},
+		{Seq(J1(G(Seq(NG("k", Q1(R('a', 'z'))), T("="), G(Q1(R('0', '9'))))), T("\n")), T(";"),
+			RefN(1), RefN(-3), RefBN(0), Test(NG("k", T("x")))),
+			[]string{"a=1\nb=2;a=1a=1x", "a=1\nb=2;b=2a", "a=1\nbc=22;bc=22b", "a=1;a=1", ""}},
+		{J0(CX(genSpan, G(Q1(Alt(R('a', 'z'), S("\t\U0001F600"))))), Q1(S("\r\n\u0085\u2028\u2029\v\f"))),
+			[]string{"a\tb\u2028\tc\u0085\U0001F600x\r\nd\ve", "\t\tz\u2029q\fr"}},
+		{Let(map[string]Pattern{"name": Q1(R('a', 'z')), "num": Q1(R('0', '9'))},
+			Seq(Alt(Seq(V("name"), T("!")), Seq(Test(V("name")), Peek(V("name")), Until(T(";")))), T(";"),
+				Not(V("num")), Q0(Seq(V("name"), Test(T("1")))), Q0(Dot))),
+			[]string{"ab;x", "ab!", "1;", "ab;a1", "ab;;b"}},
+		{Let(map[string]Pattern{"name": Q1(R('a', 'z')), "num": Q1(R('0', '9'))},
+			Seq(Trunc(2, Q0(T("c"))), T("cd"), Inject(genHalf, Seq(V("name"), V("num"))), Q0(Dot))),
+			[]string{"cccdab12", "cccdabcd1", "ccdx1", "cccd"}},
+	}
+
+	dir, err := ioutil.TempDir(".", "_generate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var main, expected bytes.Buffer
+	main.WriteString("package main\n\nimport (\n\t\"encoding/json\"\n\t\"errors\"\n\t\"fmt\"\n\t\"strings\"\n\n" +
+		"\t\"github.com/hucsmn/peg\"\n)\n")
+	main.WriteString(generateTestCallbacks)
+	main.WriteString("\nfunc dump(r *peg.Result, err error) string {\n" +
+		"\tif err != nil {\n\t\treturn fmt.Sprintf(\"error %s\", err)\n\t}\n" +
+		"\ttree, _ := json.Marshal(r.GroupTree)\n" +
+		"\treturn fmt.Sprintf(\"%t %d %q %q %s %v %v %v\", r.Ok, r.N, r.Groups, r.NamedGroups, tree, r.Captures, r.Tree, r.Values)\n}\n")
+	main.WriteString("\nvar configs = []peg.Config{\n")
+	for _, cfg := range generateTestConfigs {
+		main.WriteString(strings.Replace(fmt.Sprintf("\t%#v,\n", cfg), "peg.Config", "", 1))
+	}
+	main.WriteString("}\n\nfunc main() {\n\tvar r *peg.Result\n\tvar err error\n")
+	for i, d := range data {
+		gen := &Generator{Func: fmt.Sprintf("Match%d", i), Callbacks: generateTestCallbackMap}
+		var src bytes.Buffer
+		if err := gen.Generate(&src, d.pat); err != nil {
+			t.Fatalf("Generate(%s) => %s", d.pat, err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("match%d.go", i)), src.Bytes(), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, text := range d.texts {
+			for j, cfg := range generateTestConfigs {
+				fmt.Fprintf(&main, "\tr, err = Match%dConfig(configs[%d], %q)\n", i, j, text)
+				fmt.Fprintf(&main, "\tfmt.Println(dump(r, err))\n")
+				fmt.Fprintf(&expected, "%s\n", dumpGenerateResult(cfg.Match(d.pat, text)))
+			}
+		}
+	}
+	main.WriteString("}\n")
+	err = ioutil.WriteFile(filepath.Join(dir, "main.go"), main.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	out, err := exec.Command(gocmd, append([]string{"run"}, files...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run => %s\n%s", err, out)
+	}
+	got := strings.Split(string(out), "\n")
+	want := strings.Split(expected.String(), "\n")
+	k := 0
+	for i, d := range data {
+		for _, text := range d.texts {
+			for j := range generateTestConfigs {
+				if k >= len(got) || got[k] != want[k] {
+					t.Errorf("RESULT DISMATCH: Match%dConfig(configs[%d], %q) of %s => %q != %q\n",
+						i, j, text, d.pat, strings.Join(got[k:k+1], ""), want[k])
+				}
+				k++
+			}
+		}
+	}
+}
+
+// Tests errors of Generator.
+func TestGenerateErrors(t *testing.T) {
+	closure := func(name string) NonTerminalConstructor {
+		return func(subs []Capture) (Capture, error) {
+			return &Variable{Name: name, Subs: subs}, nil
+		}
+	}
+	rec := Let(map[string]Pattern{
+		"tpl": Template([]string{"x"}, Alt(T("a"), Call("tpl", Seq(V("x"), V("x"))))),
+	}, Call("tpl", T("b")))
+
+	data := []struct {
+		gen Generator
+		pat Pattern
+	}{
+		{Generator{Func: "1st"}, True},
+		{Generator{Package: "a-b"}, True},
+		{Generator{}, nil},
+		{Generator{}, CC(genJoin, True)},
+		{Generator{Callbacks: map[string]interface{}{"genJoin": "genJoin"}}, CC(genJoin, True)},
+		{Generator{Callbacks: map[string]interface{}{"a": closure("a"), "b": closure("b")}},
+			CC(closure("a"), True)},
+		{Generator{}, rec},
+	}
+	for _, d := range data {
+		err := d.gen.Generate(ioutil.Discard, d.pat)
+		if err == nil {
+			t.Errorf("Generate(%s) succeeded, expect error", d.pat)
+		}
+	}
+}
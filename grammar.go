@@ -0,0 +1,57 @@
+package peg
+
+// Import returns a namespace containing the variables of given namespace,
+// whose names are prefixed with "prefix." (e.g. "net.IPv4").
+//
+// The imported variables are evaluated inside the original namespace,
+// thus the variables they refer to are never confused with the ones
+// defined by the importer.
+func Import(prefix string, vars map[string]Pattern) map[string]Pattern {
+	imported := make(map[string]Pattern, len(vars))
+	for name := range vars {
+		imported[prefix+"."+name] = Let(vars, V(name))
+	}
+	return imported
+}
+
+// Extend derives a dialect from the base namespace, overriding or adding
+// the given variable definitions, while reusing the rest of base.
+//
+// Variables are late bound. That is, references inside the base definitions
+// are resolved to the overridden definitions when invoked through the
+// derived namespace. The overridden base definition of variable name is
+// still available as "super.name" inside the overriding definition.
+//
+// Panics if any variable definition is nil.
+func Extend(base, overrides map[string]Pattern) map[string]Pattern {
+	derived := make(map[string]Pattern, len(base)+len(overrides))
+	for name, pat := range base {
+		derived[name] = pat
+	}
+	for name, pat := range overrides {
+		if pat == nil {
+			panic(errorUndefinedVar(name))
+		}
+		if super, ok := base[name]; ok {
+			pat = Let(map[string]Pattern{"super." + name: super}, pat)
+		}
+		derived[name] = pat
+	}
+	return derived
+}
+
+// Merge merges the namespaces into a new namespace.
+//
+// Panics if any variable name is defined more than once.
+func Merge(namespaces ...map[string]Pattern) map[string]Pattern {
+	merged := make(map[string]Pattern)
+	for _, vars := range namespaces {
+		for name, pat := range vars {
+			if _, ok := merged[name]; ok {
+				panic(errorRedefinedVar(name))
+			}
+			merged[name] = pat
+		}
+	}
+	return merged
+}
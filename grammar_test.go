@@ -0,0 +1,63 @@
+package peg
+
+import "testing"
+
+// Tests Import, Extend, Merge.
+func TestGrammarComposition(t *testing.T) {
+	num := map[string]Pattern{
+		"digit": R('0', '9'),
+		"int":   Q1(V("digit")),
+	}
+	base := map[string]Pattern{
+		"expr":  J1(V("term"), T("+")),
+		"term":  Alt(V("value"), Seq(T("("), V("expr"), T(")"))),
+		"value": R('0', '9'),
+	}
+	letters := Extend(base, map[string]Pattern{
+		"value": R('a', 'z'),
+	})
+	mixed := Extend(base, map[string]Pattern{
+		"value": Alt(R('a', 'z'), V("super.value")),
+	})
+	hexes := Extend(mixed, map[string]Pattern{
+		"value": Alt(T("0x"), V("super.value")),
+	})
+	captured := Extend(base, map[string]Pattern{
+		"value": CK(0, V("super.value")),
+	})
+
+	data := []patternTestData{
+		{"123", true, 3, false, ``, ``, Let(Import("num", num), V("num.int"))},
+		{"123", true, 3, false, ``, `num.int()`, Let(Import("num", num), CV("num.int"))},
+		{"", false, 0, true, ``, ``, Let(Import("num", num), V("int"))},
+
+		// imported variables refer to their own namespace.
+		{"123", true, 3, false, ``, ``,
+			Let(Merge(Import("num", num), map[string]Pattern{"digit": False}), V("num.int"))},
+
+		// variables are late bound.
+		{"1+(2+3)", true, 7, false, ``, ``, Let(base, Seq(V("expr"), EOF))},
+		{"a+(b+c)", false, 0, false, ``, ``, Let(base, Seq(V("expr"), EOF))},
+		{"a+(b+c)", true, 7, false, ``, ``, Let(letters, Seq(V("expr"), EOF))},
+		{"1+(2+3)", false, 0, false, ``, ``, Let(letters, Seq(V("expr"), EOF))},
+		{"a+(1+c)", true, 7, false, ``, ``, Let(mixed, Seq(V("expr"), EOF))},
+		{"a+(0x+1)", true, 8, false, ``, ``, Let(hexes, Seq(V("expr"), EOF))},
+		{"1+2", true, 3, false, ``, `<0"1">, <0"2">`, Let(captured, Seq(V("expr"), EOF))},
+
+		// the base namespace is untouched.
+		{"0x", false, 0, false, ``, ``, Let(mixed, Seq(V("expr"), EOF))},
+	}
+
+	for _, d := range data {
+		runPatternTestData(t, d)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Merge(%v, %v) should panic", num, num)
+			}
+		}()
+		Merge(num, num)
+	}()
+}
@@ -14,10 +14,20 @@ type (
 		trigger func(string, Position) error
 	}
 
+	patternDeferredTrigger struct {
+		pat     Pattern
+		trigger func(string, Position) error
+	}
+
+	patternCommit struct {
+		pat Pattern
+	}
+
 	patternInjector struct {
 		pat    Pattern
 		label  string
 		inject func(string) (n int, ok bool)
+		origin interface{} // the user function, or maxrune of Trunc
 	}
 )
 
@@ -27,14 +37,71 @@ func G(pat Pattern) Pattern {
 }
 
 // NG saves the the matched text to a group named grpname.
+//
+// Every text saved under the same name is kept in order, see
+// Result.AllGroups.
 func NG(grpname string, pat Pattern) Pattern {
 	return &patternGrouping{pat: pat, grpname: grpname}
 }
 
+// AllGroups returns the groups named grpname, or the anonymous groups if
+// grpname is empty, in the order they are saved. The inner groups are saved
+// before the outer ones.
+func (r *Result) AllGroups(grpname string) []*Group {
+	return appendGroups(nil, r.GroupTree, grpname)
+}
+
+// Appends the groups named grpname in the order they are saved.
+func appendGroups(dst, groups []*Group, grpname string) []*Group {
+	for _, g := range groups {
+		dst = appendGroups(dst, g.Subs, grpname)
+		if g.Name == grpname {
+			dst = append(dst, g)
+		}
+	}
+	return dst
+}
+
+// Finds the lastest saved group named grpname.
+func lastGroup(groups []*Group, grpname string) *Group {
+	for i := len(groups) - 1; i >= 0; i-- {
+		if groups[i].Name == grpname {
+			return groups[i]
+		}
+		if g := lastGroup(groups[i].Subs, grpname); g != nil {
+			return g
+		}
+	}
+	return nil
+}
+
+// Flattens the groups into the anonymous texts and the last text of each
+// name, in the order they are saved.
+func flattenGroups(groups []*Group) (anonymous []string, named map[string]string) {
+	for _, g := range appendGroups(nil, groups, "") {
+		anonymous = append(anonymous, g.Text)
+	}
+	var walk func([]*Group)
+	walk = func(groups []*Group) {
+		for _, g := range groups {
+			walk(g.Subs)
+			if g.Name != "" {
+				if named == nil {
+					named = make(map[string]string)
+				}
+				named[g.Name] = g.Text
+			}
+		}
+	}
+	walk(groups)
+	return anonymous, named
+}
+
 // Trigger invokes user defined hook with the text matched.
 //
 // Note that, the hook could be triggered when the inner pattern was matched
-// while the parent pattern is later proved to be dismatched.
+// while the parent pattern is later proved to be dismatched, see OnCommit.
+// The hook is never triggered inside lookaheads.
 func Trigger(hook func(string, Position) error, pat Pattern) Pattern {
 	return &patternTrigger{
 		pat:     pat,
@@ -42,6 +109,22 @@ func Trigger(hook func(string, Position) error, pat Pattern) Pattern {
 		trigger: hook}
 }
 
+// OnCommit queues user defined hook along with the text matched and its
+// position. The queued hooks are dropped if any parent pattern is later
+// proved to be dismatched, and invoked in order only when the whole match
+// succeeds, or when the enclosing Commit pattern is matched.
+//
+// The hook is never queued inside lookaheads.
+func OnCommit(hook func(string, Position) error, pat Pattern) Pattern {
+	return &patternDeferredTrigger{pat: pat, trigger: hook}
+}
+
+// Commit invokes the hooks queued by OnCommit inside pat once pat is
+// matched, instead of waiting for the whole match to succeed.
+func Commit(pat Pattern) Pattern {
+	return &patternCommit{pat: pat}
+}
+
 // Inject attaches an injector to given pattern which checks the matched text
 // after matched, then determines whether anything should be matched and
 // how many bytes to consume.
@@ -56,6 +139,7 @@ func Inject(fn func(string) (int, bool), pat Pattern) Pattern {
 		pat:    pat,
 		label:  fmt.Sprintf("inject_%p", fn),
 		inject: fn,
+		origin: fn,
 	}
 }
 
@@ -71,6 +155,7 @@ func Check(fn func(string) bool, pat Pattern) Pattern {
 		pat:    pat,
 		label:  fmt.Sprintf("check_%p", fn),
 		inject: newCheckInjector(fn),
+		origin: fn,
 	}
 }
 
@@ -90,6 +175,7 @@ func Trunc(maxrune int, pat Pattern) Pattern {
 		pat:    pat,
 		label:  fmt.Sprintf("trunc_%d", maxrune),
 		inject: newTruncateInjector(maxrune),
+		origin: maxrune,
 	}
 }
 
@@ -120,6 +206,7 @@ func newTruncateInjector(maxrune int) func(string) (int, bool) {
 // Captures text to a group.
 func (pat *patternGrouping) match(ctx *context) error {
 	if !ctx.justReturned() {
+		ctx.locals.i = len(ctx.groups)
 		return ctx.call(pat.pat)
 	}
 
@@ -128,7 +215,7 @@ func (pat *patternGrouping) match(ctx *context) error {
 		return ctx.predicates(false)
 	}
 	ctx.consume(ret.n)
-	ctx.group(pat.grpname)
+	ctx.group(pat.grpname, ctx.locals.i)
 	return ctx.commit()
 }
 
@@ -145,13 +232,48 @@ func (pat *patternTrigger) match(ctx *context) error {
 
 	head := ctx.tell()
 	ctx.consume(ret.n)
-	err := pat.trigger(ctx.span(), head)
-	if err != nil {
-		return err
+	if ctx.hooking() {
+		err := pat.trigger(ctx.span(), head)
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.commit()
+}
+
+// Captures text to queue a hook.
+func (pat *patternDeferredTrigger) match(ctx *context) error {
+	if !ctx.justReturned() {
+		return ctx.call(pat.pat)
 	}
+
+	ret := ctx.ret
+	if !ret.ok {
+		return ctx.predicates(false)
+	}
+
+	head := ctx.tell()
+	ctx.consume(ret.n)
+	ctx.queue(pat.trigger, ctx.span(), head)
 	return ctx.commit()
 }
 
+// Invokes the hooks queued inside.
+func (pat *patternCommit) match(ctx *context) error {
+	if !ctx.justReturned() {
+		ctx.locals.i = len(ctx.deferred)
+		return ctx.call(pat.pat)
+	}
+
+	ret := ctx.ret
+	if ret.ok {
+		if err := ctx.flush(ctx.locals.i); err != nil {
+			return err
+		}
+	}
+	return ctx.returns(ret)
+}
+
 // Further validate matched text, determines how many bytes to consume.
 func (pat *patternInjector) match(ctx *context) error {
 	if !ctx.justReturned() {
@@ -161,6 +283,7 @@ func (pat *patternInjector) match(ctx *context) error {
 	ret := ctx.ret
 	if ret.ok {
 		if n, ok := pat.inject(ctx.next(ret.n)); ok {
+			ctx.clip(ctx.at + n)
 			ctx.consume(n)
 			return ctx.commit()
 		}
@@ -179,6 +302,14 @@ func (pat *patternTrigger) String() string {
 	return fmt.Sprintf("%s(%s)", pat.label, pat.pat)
 }
 
+func (pat *patternDeferredTrigger) String() string {
+	return fmt.Sprintf("oncommit_%p(%s)", pat.trigger, pat.pat)
+}
+
+func (pat *patternCommit) String() string {
+	return fmt.Sprintf("commit{%s}", pat.pat)
+}
+
 func (pat *patternInjector) String() string {
 	return fmt.Sprintf("%s(%s)", pat.label, pat.pat)
 }
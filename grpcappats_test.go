@@ -93,6 +93,74 @@ func TestGroupInjectAndRefer(t *testing.T) {
 	}
 }
 
+// Tests RefN, RefBN, Result.GroupTree and Result.AllGroups.
+func TestGroupTree(t *testing.T) {
+	x, y := T("x"), T("y")
+	data := []patternTestData{
+		{"xyxy", true, 4, false, `"x","y"`, ``, Seq(G(x), G(y), RefN(0), RefN(1))},
+		{"xyyx", true, 4, false, `"x","y"`, ``, Seq(G(x), G(y), RefN(-1), RefN(-2))},
+		{"xyyx", false, 0, false, ``, ``, Seq(G(x), G(y), RefN(0))},
+		{"xy", true, 2, false, `"x","y"`, ``, Seq(G(x), G(y), RefN(2), RefN(-3))},
+		{"xyxxy", true, 5, false, `"x","xy"`, ``, Seq(G(Seq(G(x), y)), RefN(0), RefN(1))},
+		{"xyx", true, 3, false, `"x","xyx"`, ``, Seq(G(Seq(G(x), y, RefN(0))), RefBN(0))},
+		{"xyx", false, 0, false, ``, ``, Seq(G(Seq(G(x), y)), x, RefBN(1))},
+		{"xyy", true, 3, false, `"y"`, ``, Seq(Alt(Seq(G(x), x), Seq(x, G(y))), RefN(0))},
+	}
+	for _, d := range data {
+		runPatternTestData(t, d)
+	}
+
+	format := func(groups []*Group) string {
+		var f func([]*Group) string
+		f = func(groups []*Group) string {
+			strs := make([]string, len(groups))
+			for i, g := range groups {
+				strs[i] = fmt.Sprintf("%s%q@%s-%s", g.Name, g.Text, g.Start.String(), g.End.String())
+				if len(g.Subs) > 0 {
+					strs[i] += "{" + f(g.Subs) + "}"
+				}
+			}
+			return strings.Join(strs, " ")
+		}
+		return f(groups)
+	}
+	texts := func(groups []*Group) string {
+		strs := make([]string, len(groups))
+		for i, g := range groups {
+			strs[i] = g.Text
+		}
+		return strings.Join(strs, ",")
+	}
+
+	word := Q1(R('a', 'z'))
+	pair := G(Seq(NG("k", word), T("="), NG("v", Alt(G(Q1(R('0', '9'))), word))))
+	trees := []struct {
+		pat  Pattern
+		text string
+		tree string
+		k, v string
+	}{
+		{J0(pair, T("\n")), "a=1\nb=x\nc=23", `"a=1"@1:1+0-1:4+3{k"a"@1:1+0-1:2+1 v"1"@1:3+2-1:4+3{"1"@1:3+2-1:4+3}} ` +
+			`"b=x"@2:1+4-2:4+7{k"b"@2:1+4-2:2+5 v"x"@2:3+6-2:4+7} ` +
+			`"c=23"@3:1+8-3:5+12{k"c"@3:1+8-3:2+9 v"23"@3:3+10-3:5+12{"23"@3:3+10-3:5+12}}`, "a,b,c", "1,x,23"},
+		{Seq(NG("k", word), Alt(Seq(T("="), NG("v", word), T(";")), T("=")), Test(NG("k", word))), "a=b", `k"a"@1:1+0-1:2+1`, "a", ""},
+		{If(G(x), Seq(G(x), G(y)), False), "xy", `"x"@1:1+0-1:2+1 "y"@1:2+1-1:3+2`, "", ""},
+	}
+	for _, d := range trees {
+		r, err := Match(d.pat, d.text)
+		if err != nil || !r.Ok {
+			t.Errorf("Match(%s, %q) => %v, %v", d.pat, d.text, r, err)
+			continue
+		}
+		if got := format(r.GroupTree); got != d.tree {
+			t.Errorf("Match(%s, %q).GroupTree => %s, expect %s", d.pat, d.text, got, d.tree)
+		}
+		if k, v := texts(r.AllGroups("k")), texts(r.AllGroups("v")); k != d.k || v != d.v {
+			t.Errorf("Match(%s, %q).AllGroups => %q %q, expect %q %q", d.pat, d.text, k, v, d.k, d.v)
+		}
+	}
+}
+
 // Tests Let, Var, Cvar, Ctoken.
 func TestGrammar(t *testing.T) {
 	scope := map[string]Pattern{
@@ -153,6 +221,45 @@ func TestGrammar(t *testing.T) {
 	}
 }
 
+// Tests CLet, Silent, Transparent.
+func TestRuleCapture(t *testing.T) {
+	scope := map[string]Pattern{
+		"ws":     Silent(Q0(S(" "))),
+		"num":    CK(0, Q1(R('0', '9'))),
+		"atom":   Transparent(Alt(V("num"), V("list"))),
+		"list":   Seq(T("("), V("ws"), J0(V("atom"), V("ws")), V("ws"), T(")")),
+		"pair":   Seq(V("num"), T("="), V("num")),
+		"digits": Call("many", V("num")),
+		"many":   Template([]string{"item"}, J1(V("item"), T(","))),
+	}
+	data := []patternTestData{
+		{"1", true, 1, false, ``, `num(<0"1">)`, CLet(scope, V("num"))},
+		{" ", true, 1, false, ``, ``, CLet(scope, V("ws"))},
+		{"1=2", true, 3, false, ``, `pair(num(<0"1">), num(<0"2">))`, CLet(scope, V("pair"))},
+
+		// transparent rules are collapsed if there is only one capture inside.
+		{"1", true, 1, false, ``, `num(<0"1">)`, CLet(scope, V("atom"))},
+		{"( 1 (2) () )", true, 12, false, ``, `list(num(<0"1">), list(num(<0"2">)), list())`, CLet(scope, V("atom"))},
+
+		// explicit captures are kept.
+		{"1", true, 1, false, ``, `atom(num(<0"1">))`, CLet(scope, CV("atom"))},
+		{" ", true, 1, false, ``, `ws()`, CLet(scope, CV("ws"))},
+
+		// variables passed to templates are captured, parameters are not.
+		{"1,2", true, 3, false, ``, `digits(num(<0"1">), num(<0"2">))`, CLet(scope, V("digits"))},
+
+		// variables defined by Let are not captured automatically.
+		{"1=2", true, 3, false, ``, `<0"1">, <0"2">`, CLet(scope, Let(map[string]Pattern{
+			"pair": Seq(CK(0, Dot), T("="), CK(0, Dot)),
+		}, V("pair")))},
+		{"1=2", true, 3, false, ``, `<0"1">, <0"2">`, Let(scope, V("pair"))},
+	}
+
+	for _, d := range data {
+		runPatternTestData(t, d)
+	}
+}
+
 // Tests Cterm, Ccons.
 
 type termInt int32
@@ -249,6 +356,171 @@ func TestCustomedConstructor(t *testing.T) {
 	}
 }
 
+// Tests CX and the positions of Variable and Token.
+func TestCaptureContext(t *testing.T) {
+	var contexts []string
+	record := func(cc CaptureContext, subs []Capture) (Capture, error) {
+		contexts = append(contexts, fmt.Sprintf("%s %q %s-%s %d",
+			cc.Rule, cc.Text, cc.Start.String(), cc.End.String(), len(subs)))
+		return termOp(cc.Text), nil
+	}
+	pat := Let(map[string]Pattern{
+		"ws":    Q0(S(" \n")),
+		"ident": Seq(CX(record, Q1(R('a', 'z'))), V("ws")),
+		"call":  Seq(V("ident"), T("("), V("ws"), Q0(CV("call")), T(")"), V("ws")),
+	}, CX(record, CV("call")))
+
+	caps, err := Parse(pat, "f(\n  g() h(\n))")
+	if err != nil || len(caps) != 1 {
+		t.Fatalf("Parse => %v, %s", caps, err)
+	}
+	want := []string{
+		`ident "f" 1:1+0-1:2+1 0`,
+		`ident "g" 2:3+5-2:4+6 0`,
+		`ident "h" 2:7+9-2:8+10 0`,
+		` "f(\n  g() h(\n))" 1:1+0-3:3+14 1`, // outside of variables
+	}
+	if fmt.Sprint(contexts) != fmt.Sprint(want) {
+		t.Errorf("contexts => %q, expect %q", contexts, want)
+	}
+
+	caps, err = Parse(Let(map[string]Pattern{
+		"pair": Seq(CK(1, Q1(R('a', 'z'))), T("\n"), CK(2, Q1(R('0', '9')))),
+	}, Seq(T(" "), CV("pair"))), " ab\n12")
+	if err != nil || len(caps) != 1 {
+		t.Fatalf("Parse => %v, %s", caps, err)
+	}
+	v := caps[0].(*Variable)
+	tok := v.Subs[1].(*Token)
+	if v.Start.String() != "1:2+1" || v.End.String() != "2:3+6" ||
+		tok.Position.String() != "2:1+4" || tok.End.String() != "2:3+6" {
+		t.Errorf("positions of %s => %s-%s, %s-%s", v, v.Start.String(), v.End.String(),
+			tok.Position.String(), tok.End.String())
+	}
+}
+
+// Tests that captures of the dismatched patterns are discarded.
+func TestCaptureBacktracking(t *testing.T) {
+	x, y, z := T("x"), T("y"), T("z")
+	data := []patternTestData{
+		{"xz", true, 2, false, ``, `<2"x">`, Alt(Seq(CK(1, x), y), Seq(CK(2, x), z))},
+		{"xy", true, 2, false, ``, `<1"x">`, Alt(Seq(CK(1, x), y), Seq(CK(2, x), z))},
+		{"xxxz", true, 2, false, ``, `<1"x">`, Q0(Seq(CK(1, x), x))},
+		{"xyx", true, 2, false, ``, `<1"x">, <2"y">`, Q0(Alt(Seq(CK(1, x), CK(2, y)), Seq(CK(3, x), z)))},
+		{"x", true, 0, false, ``, ``, Q01(Seq(CK(1, x), y))},
+		{"x", true, 0, false, ``, ``, Not(Seq(CK(1, x), y))},
+		{"xz", true, 2, false, ``, `(<2"x">, <3"z">)`,
+			Alt(CC(nil, Seq(CK(1, x), y)), CC(func(caps []Capture) (Capture, error) {
+				return &Variable{Subs: caps}, nil
+			}, Seq(CK(2, x), CK(3, z))))},
+		{"xz", true, 2, false, ``, `<2"x">, <3"z">`,
+			Let(map[string]Pattern{"a": Seq(CK(1, x), V("b"), y), "b": CK(4, z)},
+				Alt(V("a"), Seq(CK(2, x), CK(3, z))))},
+	}
+
+	for _, d := range data {
+		runPatternTestData(t, d)
+	}
+}
+
+// Tests that groups and captures made inside predicates are discarded,
+// unless by Peek.
+func TestPredicateIsolation(t *testing.T) {
+	x, y := T("x"), T("y")
+	data := []patternTestData{
+		{"xy", true, 2, false, ``, `<2"x">`, Seq(Test(CK(1, x)), CK(2, x), y)},
+		{"xy", true, 2, false, `"x"`, ``, Seq(Test(NG("a", x)), G(x), y)},
+		{"xy", true, 2, false, ``, ``, Seq(Not(Seq(CK(1, x), x)), x, y)},
+		{"xy", true, 2, false, ``, ``, Seq(And(CK(1, x), NG("a", Dot)), Or(CK(2, y), CK(3, x)), x, y)},
+		{"xy", true, 1, false, ``, `<2"x">`, If(CK(1, x), CK(2, x), y)},
+		{"xy", true, 1, false, ``, `<3"x">`, Switch(CK(1, y), CK(2, y), NG("a", x), CK(3, x))},
+		{"xx", true, 1, false, ``, `<1"x">`, When(Seq(NG("a", x), Ref("a")), CK(1, x))},
+		{"xx", false, 0, false, ``, ``, Seq(Test(Sym("s", x)), x, TSym("s"))},
+
+		// Peek keeps the groups and captures.
+		{"xy", true, 2, false, `"a"="x"`, `<1"x">, <2"x">`, Seq(Peek(NG("a", CK(1, x))), CK(2, x), y)},
+		{"xy", true, 0, false, ``, ``, Test(Peek(CK(1, x)))},
+	}
+	for _, d := range data {
+		runPatternTestData(t, d)
+	}
+
+	var hooked []string
+	hook := func(s string, pos Position) error {
+		hooked = append(hooked, s)
+		return nil
+	}
+	value := func(s string) (interface{}, error) {
+		return s, nil
+	}
+	r, err := Match(Seq(Test(Trigger(hook, x)), Test(ActionText(value, x)), Trigger(hook, Peek(x))), "x")
+	if err != nil || !r.Ok || len(hooked) != 1 || r.Values != nil {
+		t.Errorf("Match => %v, %v, hooked %q", r, err, hooked)
+	}
+}
+
+// Tests Cc, Cp, Cf, Ct, Cb, Carg and Cmt.
+func TestLPegCaptures(t *testing.T) {
+	intcons := func(text string, pos Position) (Capture, error) {
+		i, err := strconv.ParseInt(text, 10, 32)
+		return termInt(int32(i)), err
+	}
+	binop := func(acc, cap Capture) (Capture, error) {
+		if op, ok := cap.(termOp); ok {
+			return &Variable{Name: string(op), Subs: []Capture{acc}}, nil
+		}
+		v := acc.(*Variable)
+		v.Subs = append(v.Subs, cap)
+		return v, nil
+	}
+	small := func(cc CaptureContext, subs []Capture) (bool, []Capture, error) {
+		if len(subs) != 1 || subs[0].(termInt) >= 10 {
+			return false, nil, nil
+		}
+		return true, []Capture{termInt(-subs[0].(termInt))}, nil
+	}
+	num := CT(intcons, Q1(R('0', '9')))
+	op := CT(func(s string, _ Position) (Capture, error) { return termOp(s), nil }, S("+-"))
+	cfg := Config{Arguments: []Capture{termOp("arg")}}
+
+	data := []struct {
+		pat  Pattern
+		text string
+		caps string
+	}{
+		{Cf(binop, J1(num, op)), "1-2+3", `[+(-(1, 2), 3)]`},
+		{Cf(binop, J1(num, op)), "1", `[1]`},
+		{Seq(Cf(binop, Q0(num)), T(";")), ";", `[]`},
+		{Seq(Cc(termOp("a"), termInt(1)), T("x"), Cc()), "x", `[a 1]`},
+		{Seq(T("ab\nc"), Cp), "ab\nc", `[2:2+4]`},
+		{Ct(J0(num, T(","))), "1,2", `[{1, 2}]`},
+		{Ct(J0(num, T(","))), "", `[{}]`},
+		{Seq(NG("q", S("'\"")), Cb("q"), Cb("none")), "'", `["'" ""]`},
+		{Carg(0), "", `[arg]`},
+		{J0(Cmt(small, num), T(" ")), "1 2", `[-1 -2]`},
+		{Seq(Cmt(small, num), T(" "), Cmt(small, num)), "3 12", ``},
+	}
+	for _, d := range data {
+		caps, err := cfg.Parse(d.pat, d.text)
+		if err != nil {
+			if d.caps != "" {
+				t.Errorf("Parse(%s, %q) => %s", d.pat, d.text, err)
+			}
+			continue
+		}
+		if got := fmt.Sprint(caps); got != d.caps {
+			t.Errorf("Parse(%s, %q) => %s, expect %s", d.pat, d.text, got, d.caps)
+		}
+	}
+
+	if _, err := Parse(Carg(1), ""); err == nil {
+		t.Errorf("Parse(%s) => no error", Carg(1))
+	}
+	if _, err := (Config{DisableGrouping: true}).Parse(Cb(""), ""); err == nil {
+		t.Errorf("Parse(%s) => no error when grouping is disabled", Cb(""))
+	}
+}
+
 // Test Trigger.
 func TestTrigger(t *testing.T) {
 	storing := func(pat Pattern) func(ctx *sideEffectsTestContext) Pattern {
@@ -273,3 +545,66 @@ func TestTrigger(t *testing.T) {
 		runSideEffectsTestData(t, ctx, d)
 	}
 }
+
+// Test OnCommit and Commit.
+func TestOnCommit(t *testing.T) {
+	storing := func(pat func(store func(Pattern) Pattern) Pattern) func(ctx *sideEffectsTestContext) Pattern {
+		return func(ctx *sideEffectsTestContext) Pattern {
+			return pat(func(pat Pattern) Pattern {
+				return OnCommit(func(s string, pos Position) error {
+					ctx.store(s)
+					return nil
+				}, pat)
+			})
+		}
+	}
+	x, y := T("x"), T("y")
+
+	data := []sideEffectsTestData{
+		{"x", true, 1, false, "{'x'}", storing(func(on func(Pattern) Pattern) Pattern {
+			return on(x)
+		})},
+		{"x", false, 0, false, "{}", storing(func(on func(Pattern) Pattern) Pattern {
+			return Seq(on(x), y)
+		})},
+		{"xx", true, 2, false, "{'x', 'xx'}", storing(func(on func(Pattern) Pattern) Pattern {
+			return on(Seq(on(x), x))
+		})},
+		{"xz", true, 2, false, "{'x', 'z'}", storing(func(on func(Pattern) Pattern) Pattern {
+			return Seq(Alt(Seq(on(x), y), on(x)), on(Dot))
+		})},
+		{"xxy", true, 1, false, "{'x'}", storing(func(on func(Pattern) Pattern) Pattern {
+			return Q0(Seq(on(x), Not(y)))
+		})},
+		{"xy", true, 2, false, "{'y'}", storing(func(on func(Pattern) Pattern) Pattern {
+			return Seq(Test(on(x)), x, on(y))
+		})},
+
+		// Commit invokes the queued hooks once matched.
+		{"x", false, 0, false, "{'x'}", storing(func(on func(Pattern) Pattern) Pattern {
+			return Seq(Commit(on(x)), y)
+		})},
+		{"xy", true, 2, false, "{'y', 'x'}", storing(func(on func(Pattern) Pattern) Pattern {
+			return Seq(on(x), Commit(on(y)))
+		})},
+		{"xz", true, 2, false, "{'x', 'z'}", storing(func(on func(Pattern) Pattern) Pattern {
+			return Seq(Commit(Alt(Seq(on(x), y), on(x))), on(Dot))
+		})},
+	}
+
+	ctx := newSideEffectsTestContext()
+	for _, d := range data {
+		runSideEffectsTestData(t, ctx, d)
+	}
+
+	// errors of the hooks are reported.
+	failing := OnCommit(func(s string, pos Position) error {
+		return fmt.Errorf("failure")
+	}, T("x"))
+	if _, err := Match(failing, "x"); err == nil {
+		t.Errorf("Match(%s, %q) => no error", failing, "x")
+	}
+	if r, err := Match(Seq(failing, False), "x"); err != nil || r.Ok {
+		t.Errorf("Match(%s, %q) => %v, %v", failing, "x", r, err)
+	}
+}
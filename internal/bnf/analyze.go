@@ -0,0 +1,506 @@
+package bnf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hucsmn/peg"
+)
+
+const (
+	// Maximum number of strings in a finite language being analyzed.
+	maxLanguageSize = 256
+
+	// Maximum steps of prefix matching before giving up.
+	maxMatchSteps = 10000
+)
+
+// Warning reports where the ordered choices or greedy qualifiers may change
+// the meaning of grammar.
+type Warning struct {
+	Position peg.Position
+	Rule     string
+	Message  string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s",
+		w.Position.Line+1, w.Position.Column+1, w.Rule, w.Message)
+}
+
+// Static analyzer looking for the places where PEG differs from BNF.
+type analyzer struct {
+	g        *Grammar
+	rule     *Rule
+	warnings []Warning
+	visiting map[string]bool
+	steps    int
+}
+
+// Analyze reports suspicious alternations and repetitions in the grammar.
+func (g *Grammar) Analyze() []Warning {
+	a := &analyzer{g: g, visiting: make(map[string]bool)}
+	for _, name := range g.Names {
+		a.rule = g.Rules[name]
+		a.rule.Def.Walk(a.check)
+	}
+	sort.Stable(warningsSorter(a.warnings))
+	return a.warnings
+}
+
+func (a *analyzer) warn(at int, format string, v ...interface{}) {
+	a.warnings = append(a.warnings, Warning{
+		Position: Position(a.g.Src, at),
+		Rule:     a.rule.Name,
+		Message:  fmt.Sprintf(format, v...),
+	})
+}
+
+func (a *analyzer) check(n *Node) {
+	switch n.Kind {
+	case Alternation:
+		a.checkAlternation(n)
+	case Concatenation:
+		a.checkConcatenation(n)
+	case Exclusion:
+		a.checkExclusion(n)
+	}
+}
+
+// Ordered choice commits to the first alternative matched, even if a later
+// one could match longer text.
+func (a *analyzer) checkAlternation(n *Node) {
+	for i, sub := range n.Subs[:len(n.Subs)-1] {
+		if a.nullable(sub) {
+			a.warn(sub.At,
+				"alternative %d could match the empty string, "+
+					"the later alternatives are unreachable", i+1)
+			return
+		}
+	}
+
+	for i := 0; i < len(n.Subs)-1; i++ {
+		lang, ok := a.language(n.Subs[i])
+		if !ok {
+			continue
+		}
+		for j := i + 1; j < len(n.Subs); j++ {
+			for _, s := range lang {
+				a.steps = 0
+				if s != "" && a.properPrefix([]*Node{n.Subs[j]}, s) {
+					a.warn(n.Subs[i].At,
+						"alternative %d could match %q, "+
+							"which is a prefix of text matched by alternative %d",
+						i+1, s, j+1)
+					break
+				}
+			}
+		}
+	}
+}
+
+// Repetitions and options are greedy, they never give back text to the
+// following elements.
+func (a *analyzer) checkConcatenation(n *Node) {
+	for i, sub := range n.Subs[:len(n.Subs)-1] {
+		if sub.Kind != Repetition || sub.Min == sub.Max {
+			continue
+		}
+		rest := &Node{Kind: Concatenation, At: sub.At, Subs: n.Subs[i+1:]}
+		if overlaps(a.first(sub.Subs[0]), a.first(rest)) {
+			a.warn(sub.At, "repetition is greedy, "+
+				"it may consume text required by the following elements")
+		}
+	}
+}
+
+// Negative lookahead rejects all the text starting with the exception, not
+// only the exception itself.
+func (a *analyzer) checkExclusion(n *Node) {
+	lang, ok := a.language(n.Subs[1])
+	if !ok {
+		return
+	}
+	for _, s := range lang {
+		a.steps = 0
+		if s != "" && a.properPrefix([]*Node{n.Subs[0]}, s) {
+			a.warn(n.At, "exclusion rejects all the text starting with %q, "+
+				"not only the text itself", s)
+			return
+		}
+	}
+}
+
+// Tests if node could match the empty string.
+func (a *analyzer) nullable(n *Node) bool {
+	switch n.Kind {
+	case Alternation:
+		for _, sub := range n.Subs {
+			if a.nullable(sub) {
+				return true
+			}
+		}
+		return false
+	case Concatenation:
+		for _, sub := range n.Subs {
+			if !a.nullable(sub) {
+				return false
+			}
+		}
+		return true
+	case Repetition:
+		return n.Min == 0 || a.nullable(n.Subs[0])
+	case RuleName:
+		r := a.g.Lookup(n.Name)
+		if r == nil || a.visiting[n.Name] {
+			return false
+		}
+		a.visiting[n.Name] = true
+		defer delete(a.visiting, n.Name)
+		return a.nullable(r.Def)
+	case Text:
+		return n.Text == ""
+	case Exclusion:
+		return a.nullable(n.Subs[0])
+	default:
+		return false
+	}
+}
+
+func overlaps(x, y []RuneRange) bool {
+	for _, rx := range x {
+		for _, ry := range y {
+			if rx.Low <= ry.High && ry.Low <= rx.High {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Calculates the set of runes that text matched by node could start with.
+func (a *analyzer) first(n *Node) []RuneRange {
+	switch n.Kind {
+	case Alternation:
+		var set []RuneRange
+		for _, sub := range n.Subs {
+			set = append(set, a.first(sub)...)
+		}
+		return set
+	case Concatenation:
+		var set []RuneRange
+		for _, sub := range n.Subs {
+			set = append(set, a.first(sub)...)
+			if !a.nullable(sub) {
+				break
+			}
+		}
+		return set
+	case Repetition:
+		if n.Max == 0 {
+			return nil
+		}
+		return a.first(n.Subs[0])
+	case RuleName:
+		r := a.g.Lookup(n.Name)
+		if r == nil || a.visiting[n.Name] {
+			return nil
+		}
+		a.visiting[n.Name] = true
+		defer delete(a.visiting, n.Name)
+		return a.first(r.Def)
+	case Text:
+		if n.Text == "" {
+			return nil
+		}
+		r, _ := utf8.DecodeRuneInString(n.Text)
+		set := []RuneRange{{r, r}}
+		if !n.Sensitive {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				set = append(set, RuneRange{f, f})
+			}
+		}
+		return set
+	case Class:
+		if n.Negated {
+			return complement(n.Ranges)
+		}
+		return n.Ranges
+	case Exclusion:
+		return a.first(n.Subs[0])
+	default:
+		return nil
+	}
+}
+
+// Calculates the finite language of node, with case-insensitive text in
+// lower case. Fails if the language is infinite or too large.
+func (a *analyzer) language(n *Node) ([]string, bool) {
+	switch n.Kind {
+	case Alternation:
+		var lang []string
+		for _, sub := range n.Subs {
+			l, ok := a.language(sub)
+			if !ok || len(lang)+len(l) > maxLanguageSize {
+				return nil, false
+			}
+			lang = append(lang, l...)
+		}
+		return lang, true
+	case Concatenation:
+		lang := []string{""}
+		for _, sub := range n.Subs {
+			l, ok := a.language(sub)
+			if !ok {
+				return nil, false
+			}
+			lang, ok = product(lang, l)
+			if !ok {
+				return nil, false
+			}
+		}
+		return lang, true
+	case Repetition:
+		if n.Max < 0 {
+			return nil, false
+		}
+		l, ok := a.language(n.Subs[0])
+		if !ok {
+			return nil, false
+		}
+		var lang []string
+		rep := []string{""}
+		for i := 0; i <= n.Max; i++ {
+			if i >= n.Min {
+				if len(lang)+len(rep) > maxLanguageSize {
+					return nil, false
+				}
+				lang = append(lang, rep...)
+			}
+			if i < n.Max {
+				rep, ok = product(rep, l)
+				if !ok {
+					return nil, false
+				}
+			}
+		}
+		return lang, true
+	case RuleName:
+		r := a.g.Lookup(n.Name)
+		if r == nil || a.visiting[n.Name] {
+			return nil, false
+		}
+		a.visiting[n.Name] = true
+		defer delete(a.visiting, n.Name)
+		return a.language(r.Def)
+	case Text:
+		if n.Sensitive {
+			return []string{n.Text}, true
+		}
+		return []string{strings.ToLower(n.Text)}, true
+	case Class:
+		if n.Negated {
+			return nil, false
+		}
+		var lang []string
+		for _, rr := range n.Ranges {
+			if len(lang)+int(rr.High-rr.Low) >= maxLanguageSize {
+				return nil, false
+			}
+			for r := rr.Low; r <= rr.High; r++ {
+				lang = append(lang, string(r))
+			}
+		}
+		return lang, true
+	case Exclusion:
+		lang, ok := a.language(n.Subs[0])
+		if !ok {
+			return nil, false
+		}
+		except, ok := a.language(n.Subs[1])
+		if !ok {
+			return nil, false
+		}
+		var rest []string
+		for _, s := range lang {
+			if !contains(except, s) {
+				rest = append(rest, s)
+			}
+		}
+		return rest, true
+	default:
+		return nil, false
+	}
+}
+
+func contains(lang []string, s string) bool {
+	for _, t := range lang {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Calculates the complement of rune ranges.
+func complement(ranges []RuneRange) []RuneRange {
+	sorted := append([]RuneRange(nil), ranges...)
+	sort.Sort(rangesSorter(sorted))
+	var set []RuneRange
+	low := rune(0)
+	for _, rr := range sorted {
+		if rr.Low > low {
+			set = append(set, RuneRange{low, rr.Low - 1})
+		}
+		if rr.High+1 > low {
+			low = rr.High + 1
+		}
+	}
+	if low <= unicode.MaxRune {
+		set = append(set, RuneRange{low, unicode.MaxRune})
+	}
+	return set
+}
+
+func product(x, y []string) ([]string, bool) {
+	if len(x)*len(y) > maxLanguageSize {
+		return nil, false
+	}
+	lang := make([]string, 0, len(x)*len(y))
+	for _, s := range x {
+		for _, t := range y {
+			lang = append(lang, s+t)
+		}
+	}
+	return lang, true
+}
+
+// Tests if s is a proper prefix of some text matched by the
+// sequence of nodes, by backtracking over the syntax tree.
+func (a *analyzer) properPrefix(seq []*Node, s string) bool {
+	a.steps++
+	if a.steps > maxMatchSteps {
+		return false
+	}
+	if s == "" {
+		for _, n := range seq {
+			if len(a.first(n)) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	if len(seq) == 0 {
+		return false
+	}
+
+	n, rest := seq[0], seq[1:]
+	switch n.Kind {
+	case Alternation:
+		for _, sub := range n.Subs {
+			if a.properPrefix(prepend(rest, sub), s) {
+				return true
+			}
+		}
+		return false
+	case Concatenation:
+		return a.properPrefix(prepend(rest, n.Subs...), s)
+	case Repetition:
+		if n.Min > 0 {
+			next := &Node{Kind: Repetition, At: n.At, Subs: n.Subs,
+				Min: n.Min - 1, Max: n.Max - 1}
+			if n.Max < 0 {
+				next.Max = -1
+			}
+			return a.properPrefix(prepend(rest, n.Subs[0], next), s)
+		}
+		if a.properPrefix(rest, s) {
+			return true
+		}
+		if n.Max == 0 {
+			return false
+		}
+		next := &Node{Kind: Repetition, At: n.At, Subs: n.Subs, Max: n.Max - 1}
+		if n.Max < 0 {
+			next.Max = -1
+		}
+		return a.properPrefix(prepend(rest, n.Subs[0], next), s)
+	case RuleName:
+		r := a.g.Lookup(n.Name)
+		if r == nil {
+			return false
+		}
+		return a.properPrefix(prepend(rest, r.Def), s)
+	case Text:
+		t := n.Text
+		if !n.Sensitive {
+			t = strings.ToLower(t)
+		}
+		if len(s) < len(t) {
+			return strings.HasPrefix(t, s)
+		}
+		if !strings.HasPrefix(s, t) {
+			return false
+		}
+		return a.properPrefix(rest, s[len(t):])
+	case Class:
+		r, size := utf8.DecodeRuneInString(s)
+		for _, f := range []rune{r, unicode.ToUpper(r)} {
+			if inRanges(f, n.Ranges) != n.Negated {
+				return a.properPrefix(rest, s[size:])
+			}
+		}
+		return false
+	case Exclusion:
+		return a.properPrefix(prepend(rest, n.Subs[0]), s)
+	default:
+		return false
+	}
+}
+
+func prepend(seq []*Node, nodes ...*Node) []*Node {
+	result := make([]*Node, 0, len(nodes)+len(seq))
+	return append(append(result, nodes...), seq...)
+}
+
+func inRanges(r rune, ranges []RuneRange) bool {
+	for _, rr := range ranges {
+		if r >= rr.Low && r <= rr.High {
+			return true
+		}
+	}
+	return false
+}
+
+// Sorts warnings by position.
+type warningsSorter []Warning
+
+func (ws warningsSorter) Len() int {
+	return len(ws)
+}
+
+func (ws warningsSorter) Less(i, j int) bool {
+	return ws[i].Position.Offest < ws[j].Position.Offest
+}
+
+func (ws warningsSorter) Swap(i, j int) {
+	ws[i], ws[j] = ws[j], ws[i]
+}
+
+// Sorts rune ranges by lower bound.
+type rangesSorter []RuneRange
+
+func (rs rangesSorter) Len() int {
+	return len(rs)
+}
+
+func (rs rangesSorter) Less(i, j int) bool {
+	return rs[i].Low < rs[j].Low
+}
+
+func (rs rangesSorter) Swap(i, j int) {
+	rs[i], rs[j] = rs[j], rs[i]
+}
@@ -0,0 +1,241 @@
+// Package bnf provides the syntax tree shared by the BNF-like grammar
+// frontends, and compiles it into PEG patterns.
+package bnf // import "github.com/hucsmn/peg/internal/bnf"
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hucsmn/peg"
+)
+
+// Kind of syntax tree node.
+type Kind int
+
+// Kinds of syntax tree node.
+const (
+	Alternation   Kind = iota // Subs[0] / Subs[1] / ...
+	Concatenation             // Subs[0] Subs[1] ...
+	Repetition                // Min*Max Subs[0], Max < 0 for infinity
+	RuleName                  // Name
+	Text                      // Text, Sensitive
+	Class                     // Ranges, Negated
+	Exclusion                 // Subs[0] - Subs[1]
+	Prose                     // Text, never matches
+)
+
+// Node is the syntax tree of rule definitions.
+type Node struct {
+	Kind Kind
+	At   int
+	Subs []*Node
+
+	Name      string
+	Text      string
+	Sensitive bool
+	Ranges    []RuneRange
+	Negated   bool
+	Min, Max  int
+}
+
+// RuneRange is a closed interval of runes.
+type RuneRange struct {
+	Low, High rune
+}
+
+// Rule is a named definition.
+type Rule struct {
+	Name string // the name as it was first defined
+	At   int
+	Def  *Node
+}
+
+// Grammar is a set of rules parsed from source.
+type Grammar struct {
+	Src   string
+	Names []string         // keys of rules in the order of definition
+	Rules map[string]*Rule // indexed by variable names
+	Base  *Grammar         // predefined rules
+}
+
+// NewGrammar creates an empty grammar.
+func NewGrammar(src string, base *Grammar) *Grammar {
+	return &Grammar{Src: src, Rules: make(map[string]*Rule), Base: base}
+}
+
+// Define adds a new rule, returns false if it is already defined.
+func (g *Grammar) Define(key, name string, at int, def *Node) bool {
+	if _, ok := g.Rules[key]; ok {
+		return false
+	}
+	g.Names = append(g.Names, key)
+	g.Rules[key] = &Rule{Name: name, At: at, Def: def}
+	return true
+}
+
+// Lookup looks up rule by variable name, including the predefined rules.
+func (g *Grammar) Lookup(key string) *Rule {
+	if r, ok := g.Rules[key]; ok {
+		return r
+	}
+	if g.Base != nil {
+		return g.Base.Lookup(key)
+	}
+	return nil
+}
+
+// CheckUndefined reports the first reference to undefined rule.
+func (g *Grammar) CheckUndefined() error {
+	var err error
+	for _, key := range g.Names {
+		g.Rules[key].Def.Walk(func(n *Node) {
+			if err == nil && n.Kind == RuleName && g.Lookup(n.Name) == nil {
+				err = Errorf(g.Src, n.At, "rule %q is undefined", n.Name)
+			}
+		})
+	}
+	return err
+}
+
+// Patterns compiles rules into a namespace, including the predefined rules
+// not overridden.
+func (g *Grammar) Patterns() map[string]peg.Pattern {
+	pats := make(map[string]peg.Pattern, len(g.Rules))
+	if g.Base != nil {
+		pats = g.Base.Patterns()
+	}
+	for key, r := range g.Rules {
+		pats[key] = r.Def.Pattern()
+	}
+	return pats
+}
+
+// Pattern compiles the syntax tree.
+func (n *Node) Pattern() peg.Pattern {
+	switch n.Kind {
+	case Alternation:
+		return peg.Alt(patterns(n.Subs)...)
+	case Concatenation:
+		return peg.Seq(patterns(n.Subs)...)
+	case Repetition:
+		pat := n.Subs[0].Pattern()
+		if n.Max < 0 {
+			return peg.Qn(n.Min, pat)
+		}
+		return peg.Qmn(n.Min, n.Max, pat)
+	case RuleName:
+		return peg.V(n.Name)
+	case Text:
+		if n.Sensitive {
+			return peg.T(n.Text)
+		}
+		return peg.TI(n.Text)
+	case Class:
+		if len(n.Ranges) == 1 && !n.Negated && n.Ranges[0].Low == n.Ranges[0].High {
+			return peg.T(string(n.Ranges[0].Low))
+		}
+		bounds := make([]rune, 0, 2*len(n.Ranges))
+		for _, r := range n.Ranges {
+			bounds = append(bounds, r.Low, r.High)
+		}
+		if n.Negated {
+			return peg.NR(bounds[0], bounds[1], bounds[2:]...)
+		}
+		return peg.R(bounds[0], bounds[1], bounds[2:]...)
+	case Exclusion:
+		return peg.Seq(peg.Not(n.Subs[1].Pattern()), n.Subs[0].Pattern())
+	default:
+		return peg.False
+	}
+}
+
+func patterns(nodes []*Node) []peg.Pattern {
+	pats := make([]peg.Pattern, len(nodes))
+	for i := range nodes {
+		pats[i] = nodes[i].Pattern()
+	}
+	return pats
+}
+
+// Walk walks the syntax tree in depth-first order.
+func (n *Node) Walk(fn func(*Node)) {
+	fn(n)
+	for _, sub := range n.Subs {
+		sub.Walk(fn)
+	}
+}
+
+// Scanner provides the common helpers of hand-written parsers.
+type Scanner struct {
+	Src string
+	At  int
+}
+
+// Skip consumes s if the source continues with it.
+func (s *Scanner) Skip(prefix string) bool {
+	if s.Peek(prefix) {
+		s.At += len(prefix)
+		return true
+	}
+	return false
+}
+
+// Peek tests if the source continues with s.
+func (s *Scanner) Peek(prefix string) bool {
+	return strings.HasPrefix(s.Src[s.At:], prefix)
+}
+
+// EOF tests if the whole source is consumed.
+func (s *Scanner) EOF() bool {
+	return s.At >= len(s.Src)
+}
+
+// Errorf reports a syntax error at given offset.
+func (s *Scanner) Errorf(at int, format string, v ...interface{}) error {
+	return Errorf(s.Src, at, format, v...)
+}
+
+// Expect reports the missing closing delimiter of the one at start.
+func (s *Scanner) Expect(what string, start int) error {
+	pos := Position(s.Src, start)
+	return s.Errorf(s.At, "expect %s to close the one at %d:%d",
+		what, pos.Line+1, pos.Column+1)
+}
+
+// Unexpected reports the unexpected rune at current offset.
+func (s *Scanner) Unexpected() error {
+	if s.EOF() {
+		return s.Errorf(s.At, "unexpected end of grammar")
+	}
+	r, _ := utf8.DecodeRuneInString(s.Src[s.At:])
+	return s.Errorf(s.At, "unexpected %q", r)
+}
+
+// Errorf creates *peg.SyntaxError at given offset of src.
+func Errorf(src string, at int, format string, v ...interface{}) error {
+	return &peg.SyntaxError{
+		Position: Position(src, at),
+		Message:  fmt.Sprintf(format, v...),
+	}
+}
+
+// Position calculates the line-column position of offset.
+func Position(src string, offset int) peg.Position {
+	pos := peg.Position{Offest: offset}
+	lnstart := 0
+	for i := 0; i < offset; i++ {
+		switch src[i] {
+		case '\n':
+			pos.Line++
+			lnstart = i + 1
+		case '\r':
+			if i+1 >= len(src) || src[i+1] != '\n' {
+				pos.Line++
+				lnstart = i + 1
+			}
+		}
+	}
+	pos.Column = utf8.RuneCountInString(src[lnstart:offset])
+	return pos
+}
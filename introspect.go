@@ -0,0 +1,588 @@
+package peg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// Kind is the kind of pattern, see the Kind method of Pattern.
+type Kind int
+
+// Kinds of the built-in patterns.
+const (
+	KindBoolean       Kind = iota // True, False
+	KindAnyRune                   // Dot
+	KindEOF                       // EOF
+	KindLineAnchor                // SOL, EOL
+	KindText                      // T, TI
+	KindBackward                  // B
+	KindTextSet                   // TS, TSI
+	KindRefer                     // Ref
+	KindReferBackward             // RefB
+	KindRuneSet                   // S, NS
+	KindRuneRange                 // R, NR
+	KindUnicodeRanges             // U
+	KindSequence                  // Seq
+	KindAlternative               // Alt
+	KindSkip                      // Skip
+	KindUntil                     // Until, UntilB
+	KindQualifier                 // Q0, Q1, Qn, Q01, Q0n, Qnn, Qmn
+	KindPredicate                 // Test, Not
+	KindAnd                       // And
+	KindOr                        // Or
+	KindAbort                     // Abort
+	KindIf                        // If, When
+	KindSwitch                    // Switch
+	KindGroup                     // G, NG
+	KindTrigger                   // Trigger
+	KindInject                    // Inject
+	KindCheck                     // Check
+	KindTrunc                     // Trunc
+	KindLet                       // Let, CLet
+	KindRule                      // Silent, Transparent
+	KindVariable                  // V, CV
+	KindToken                     // CK
+	KindCons                      // CC
+	KindTerm                      // CT
+	KindContext                   // CX
+	KindConst                     // Cc
+	KindPosition                  // Cp
+	KindFold                      // Cf
+	KindTable                     // Ct
+	KindBackCapture               // Cb
+	KindArgument                  // Carg
+	KindMatchTime                 // Cmt
+	KindTemplate                  // Template
+	KindCall                      // Call, CCall
+	KindSymbol                    // Sym
+	KindSymbolSet                 // TSym
+	KindSymbolScope               // SymScope
+	KindAction                    // Action
+	KindActionText                // ActionText
+	KindOnCommit                  // OnCommit
+	KindCommit                    // Commit
+)
+
+var kindNames = [...]string{
+	KindBoolean:       "Boolean",
+	KindAnyRune:       "AnyRune",
+	KindEOF:           "EOF",
+	KindLineAnchor:    "LineAnchor",
+	KindText:          "Text",
+	KindBackward:      "Backward",
+	KindTextSet:       "TextSet",
+	KindRefer:         "Refer",
+	KindReferBackward: "ReferBackward",
+	KindRuneSet:       "RuneSet",
+	KindRuneRange:     "RuneRange",
+	KindUnicodeRanges: "UnicodeRanges",
+	KindSequence:      "Sequence",
+	KindAlternative:   "Alternative",
+	KindSkip:          "Skip",
+	KindUntil:         "Until",
+	KindQualifier:     "Qualifier",
+	KindPredicate:     "Predicate",
+	KindAnd:           "And",
+	KindOr:            "Or",
+	KindAbort:         "Abort",
+	KindIf:            "If",
+	KindSwitch:        "Switch",
+	KindGroup:         "Group",
+	KindTrigger:       "Trigger",
+	KindInject:        "Inject",
+	KindCheck:         "Check",
+	KindTrunc:         "Trunc",
+	KindLet:           "Let",
+	KindRule:          "Rule",
+	KindVariable:      "Variable",
+	KindToken:         "Token",
+	KindCons:          "Cons",
+	KindTerm:          "Term",
+	KindContext:       "Context",
+	KindConst:         "Const",
+	KindPosition:      "Position",
+	KindFold:          "Fold",
+	KindTable:         "Table",
+	KindBackCapture:   "BackCapture",
+	KindArgument:      "Argument",
+	KindMatchTime:     "MatchTime",
+	KindTemplate:      "Template",
+	KindCall:          "Call",
+	KindSymbol:        "Symbol",
+	KindSymbolSet:     "SymbolSet",
+	KindSymbolScope:   "SymbolScope",
+	KindAction:        "Action",
+	KindActionText:    "ActionText",
+	KindOnCommit:      "OnCommit",
+	KindCommit:        "Commit",
+}
+
+// Parameters contains the parameters of a pattern, only the fields related to
+// the kind of pattern are set.
+type Parameters struct {
+	// KindBoolean: True or False. KindLineAnchor: SOL or EOL.
+	// KindRule: Transparent or Silent.
+	// KindRefer, KindReferBackward: referring by index (RefN or RefBN).
+	Bool bool
+
+	// Negated KindRuneSet, KindRuneRange or KindPredicate (Not).
+	Not bool
+
+	// Case insensitive KindText or KindTextSet.
+	Insensitive bool
+
+	// KindUntil consuming the terminator (UntilB).
+	Inclusive bool
+
+	// Capturing KindVariable (CV), KindCall (CCall) or KindLet (CLet),
+	// or non-isolated KindPredicate (Peek).
+	Capture bool
+
+	// KindText, KindBackward: the literal text.
+	// KindAbort: the error message.
+	Text string
+
+	// KindTextSet: the sorted text set, case folded if insensitive.
+	Texts []string
+
+	// KindRuneSet: the runes in set.
+	Runes []rune
+
+	// KindRuneRange: pairs of the low and high runes.
+	Ranges [][2]rune
+
+	// KindUnicodeRanges: the range names, '-' prefixed if excluded.
+	// KindLet: the sorted variable names.
+	// KindTemplate: the formal parameters.
+	Names []string
+
+	// KindRefer, KindReferBackward, KindGroup, KindBackCapture: the group name.
+	// KindVariable, KindCall: the variable name.
+	// KindSymbol, KindSymbolSet: the symbol set name.
+	Name string
+
+	// KindQualifier: the bounds, Max is negative if unbounded.
+	// KindSkip: the number of runes, as Min and Max.
+	// KindTrunc: the maximum runes, as Min and Max.
+	// KindArgument: the index of argument, as Min and Max.
+	// KindRefer, KindReferBackward: the index of group, as Min and Max.
+	Min, Max int
+
+	// KindToken: the token type.
+	Type int
+
+	// KindTrigger, KindInject, KindCheck, KindCons, KindTerm, KindContext,
+	// KindFold, KindMatchTime, KindAction, KindActionText, KindOnCommit:
+	// the user defined function.
+	Func interface{}
+
+	// KindConst: the constant values.
+	Values []Capture
+}
+
+// String returns the name of kind.
+func (kind Kind) String() string {
+	if kind >= 0 && int(kind) < len(kindNames) {
+		return kindNames[kind]
+	}
+	return "Unknown"
+}
+
+// Children returns the sub-patterns in order.
+//
+// The children of If are cond, yes and no. The children of Switch are the
+// cond-then pairs followed by otherwise. The children of Let are the
+// variable definitions (in the order of Params(pat).Names) followed by
+// the entry.
+func Children(pat Pattern) []Pattern {
+	switch pat := pat.(type) {
+	case *patternSequence:
+		return append([]Pattern(nil), pat.pats...)
+	case *patternAlternative:
+		return append([]Pattern(nil), pat.pats...)
+	case *patternAndPredicate:
+		return append([]Pattern(nil), pat.pats...)
+	case *patternOrPredicate:
+		return append([]Pattern(nil), pat.pats...)
+	case *patternCallTemplate:
+		return append([]Pattern(nil), pat.args...)
+	case *patternAnyRuneUntil:
+		return []Pattern{pat.pat}
+	case *patternQualifierAtLeast:
+		return []Pattern{pat.pat}
+	case *patternQualifierOptional:
+		return []Pattern{pat.pat}
+	case *patternQualifierRange:
+		return []Pattern{pat.pat}
+	case *patternPredicate:
+		return []Pattern{pat.pat}
+	case *patternGrouping:
+		return []Pattern{pat.pat}
+	case *patternTrigger:
+		return []Pattern{pat.pat}
+	case *patternDeferredTrigger:
+		return []Pattern{pat.pat}
+	case *patternCommit:
+		return []Pattern{pat.pat}
+	case *patternInjector:
+		return []Pattern{pat.pat}
+	case *patternCaptureToken:
+		return []Pattern{pat.pat}
+	case *patternCaptureCons:
+		return []Pattern{pat.pat}
+	case *patternCaptureTerm:
+		return []Pattern{pat.pat}
+	case *patternCaptureContext:
+		return []Pattern{pat.pat}
+	case *patternCaptureFold:
+		return []Pattern{pat.pat}
+	case *patternCaptureTable:
+		return []Pattern{pat.pat}
+	case *patternCaptureMatchTime:
+		return []Pattern{pat.pat}
+	case *patternTemplate:
+		return []Pattern{pat.body}
+	case *patternSymbolDeclare:
+		return []Pattern{pat.pat}
+	case *patternSymbolScope:
+		return []Pattern{pat.pat}
+	case *patternAction:
+		return []Pattern{pat.pat}
+	case *patternActionText:
+		return []Pattern{pat.pat}
+	case *patternRule:
+		return []Pattern{pat.pat}
+	case *patternIf:
+		return []Pattern{pat.cond, pat.yes, pat.no}
+	case *patternSwitch:
+		subs := make([]Pattern, 0, 2*len(pat.cases)+1)
+		for _, c := range pat.cases {
+			subs = append(subs, c.cond, c.then)
+		}
+		return append(subs, pat.otherwise)
+	case *patternLet:
+		names := sortedVarNames(pat.vars)
+		subs := make([]Pattern, 0, len(names)+1)
+		for _, name := range names {
+			subs = append(subs, pat.vars[name])
+		}
+		return append(subs, pat.pat)
+	case *patternClosure:
+		return Children(pat.pat)
+	}
+	return nil
+}
+
+// Params returns the parameters of pattern.
+func Params(pat Pattern) Parameters {
+	switch pat := pat.(type) {
+	case *patternBoolean:
+		return Parameters{Bool: pat.ok}
+	case *patternLineAnchorPredicate:
+		return Parameters{Bool: pat.linestart}
+	case *patternText:
+		return Parameters{Insensitive: pat.insensitive, Text: pat.text}
+	case *patternBackwardPredicate:
+		return Parameters{Text: pat.text}
+	case *patternAbort:
+		return Parameters{Text: pat.msg}
+	case *patternTextSet:
+		return Parameters{Insensitive: pat.insensitive, Texts: append([]string(nil), pat.sorted...)}
+	case *patternTextReferring:
+		if pat.indexed {
+			return Parameters{Bool: true, Min: pat.index, Max: pat.index}
+		}
+		return Parameters{Name: pat.grpname}
+	case *patternBackwardPredicateReferring:
+		if pat.indexed {
+			return Parameters{Bool: true, Min: pat.index, Max: pat.index}
+		}
+		return Parameters{Name: pat.grpname}
+	case *patternRuneSet:
+		return Parameters{Not: pat.not, Runes: append([]rune(nil), pat.charset...)}
+	case *patternRuneRange:
+		ranges := make([][2]rune, len(pat.ranges))
+		for i, pair := range pat.ranges {
+			ranges[i] = [2]rune{pair.low, pair.high}
+		}
+		return Parameters{Not: pat.not, Ranges: ranges}
+	case *patternUnicodeRanges:
+		return Parameters{Names: unicodeRangeNames(nil, pat)}
+	case *patternUnicodeRangesWithExcluding:
+		return Parameters{Names: unicodeRangeNames(unicodeRangeNames(nil, &pat.include), &pat.exclude)}
+	case *patternSkip:
+		return Parameters{Min: pat.n, Max: pat.n}
+	case *patternAnyRuneUntil:
+		return Parameters{Inclusive: !pat.without}
+	case *patternQualifierAtLeast:
+		return Parameters{Min: pat.n, Max: -1}
+	case *patternQualifierOptional:
+		return Parameters{Min: 0, Max: 1}
+	case *patternQualifierRange:
+		return Parameters{Min: pat.m, Max: pat.n}
+	case *patternPredicate:
+		return Parameters{Not: pat.not, Capture: pat.keep}
+	case *patternGrouping:
+		return Parameters{Name: pat.grpname}
+	case *patternTrigger:
+		return Parameters{Func: pat.trigger}
+	case *patternDeferredTrigger:
+		return Parameters{Func: pat.trigger}
+	case *patternInjector:
+		if maxrune, ok := pat.origin.(int); ok {
+			return Parameters{Min: maxrune, Max: maxrune}
+		}
+		return Parameters{Func: pat.origin}
+	case *patternLet:
+		return Parameters{Names: sortedVarNames(pat.vars), Capture: pat.auto}
+	case *patternRule:
+		return Parameters{Bool: pat.transparent}
+	case *patternCaptureVariable:
+		return Parameters{Name: pat.varname, Capture: pat.cons != nil}
+	case *patternCaptureToken:
+		return Parameters{Type: pat.toktype}
+	case *patternCaptureCons:
+		return Parameters{Func: pat.cons}
+	case *patternCaptureTerm:
+		return Parameters{Func: pat.cons}
+	case *patternCaptureContext:
+		return Parameters{Func: pat.cons}
+	case *patternCaptureConst:
+		return Parameters{Values: append([]Capture(nil), pat.values...)}
+	case *patternCaptureFold:
+		return Parameters{Func: pat.fold}
+	case *patternCaptureBack:
+		return Parameters{Name: pat.grpname}
+	case *patternCaptureArgument:
+		return Parameters{Min: pat.n, Max: pat.n}
+	case *patternCaptureMatchTime:
+		return Parameters{Func: pat.fn}
+	case *patternTemplate:
+		return Parameters{Names: append([]string(nil), pat.params...)}
+	case *patternCallTemplate:
+		return Parameters{Name: pat.varname, Capture: pat.cons != nil}
+	case *patternSymbolDeclare:
+		return Parameters{Name: pat.setname}
+	case *patternSymbolSet:
+		return Parameters{Name: pat.setname}
+	case *patternAction:
+		return Parameters{Func: pat.fn}
+	case *patternActionText:
+		return Parameters{Func: pat.fn}
+	case *patternClosure:
+		return Params(pat.pat)
+	}
+	return Parameters{}
+}
+
+// Walk traverses the pattern tree in depth-first order, calls fn for each
+// pattern before its children. The children are skipped if fn returns
+// false.
+//
+// Note that, the variables are not followed.
+func Walk(pat Pattern, fn func(Pattern) bool) {
+	if !fn(pat) {
+		return
+	}
+	for _, sub := range Children(pat) {
+		Walk(sub, fn)
+	}
+}
+
+// Equal reports whether the patterns are structurally equal, that is,
+// having the same kinds, parameters and children. The user defined
+// functions are compared by their code pointers.
+//
+// Note that, different constructors could build structurally equal
+// patterns, such as Q01(pat) and Qmn(0, 1, pat).
+func Equal(a, b Pattern) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil || a.Kind() != b.Kind() {
+		return false
+	}
+
+	pa, pb := Params(a), Params(b)
+	if funcPointer(pa.Func) != funcPointer(pb.Func) {
+		return false
+	}
+	pa.Func, pb.Func = nil, nil
+	if !reflect.DeepEqual(pa, pb) {
+		return false
+	}
+
+	subsa, subsb := Children(a), Children(b)
+	if len(subsa) != len(subsb) {
+		return false
+	}
+	for i := range subsa {
+		if !Equal(subsa[i], subsb[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash returns the structural hash of pattern, structurally equal patterns
+// have the same hash.
+func Hash(pat Pattern) uint64 {
+	h := fnv.New64a()
+	writeHash(h, pat)
+	return h.Sum64()
+}
+
+func writeHash(w io.Writer, pat Pattern) {
+	writeInt := func(n int64) {
+		var buf [binary.MaxVarintLen64]byte
+		w.Write(buf[:binary.PutVarint(buf[:], n)])
+	}
+	writeString := func(s string) {
+		writeInt(int64(len(s)))
+		io.WriteString(w, s)
+	}
+	writeBool := func(b bool) {
+		if b {
+			writeInt(1)
+		} else {
+			writeInt(0)
+		}
+	}
+
+	if pat == nil {
+		writeInt(-1)
+		return
+	}
+	params := Params(pat)
+	writeInt(int64(pat.Kind()))
+	writeBool(params.Bool)
+	writeBool(params.Not)
+	writeBool(params.Insensitive)
+	writeBool(params.Inclusive)
+	writeBool(params.Capture)
+	writeString(params.Text)
+	writeInt(int64(len(params.Texts)))
+	for _, s := range params.Texts {
+		writeString(s)
+	}
+	writeString(string(params.Runes))
+	writeInt(int64(len(params.Ranges)))
+	for _, pair := range params.Ranges {
+		writeInt(int64(pair[0]))
+		writeInt(int64(pair[1]))
+	}
+	writeInt(int64(len(params.Names)))
+	for _, s := range params.Names {
+		writeString(s)
+	}
+	writeString(params.Name)
+	writeInt(int64(params.Min))
+	writeInt(int64(params.Max))
+	writeInt(int64(params.Type))
+	writeInt(int64(funcPointer(params.Func)))
+	writeInt(int64(len(params.Values)))
+	for _, value := range params.Values {
+		writeString(fmt.Sprintf("%T", value))
+	}
+
+	subs := Children(pat)
+	writeInt(int64(len(subs)))
+	for _, sub := range subs {
+		writeHash(w, sub)
+	}
+}
+
+func funcPointer(fn interface{}) uintptr {
+	v := reflect.ValueOf(fn)
+	if !v.IsValid() || v.Kind() != reflect.Func || v.IsNil() {
+		return 0
+	}
+	return v.Pointer()
+}
+
+func sortedVarNames(vars map[string]Pattern) []string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func unicodeRangeNames(names []string, pat *patternUnicodeRanges) []string {
+	for _, name := range pat.names {
+		if pat.not {
+			name = "-" + name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func (pat *patternBoolean) Kind() Kind                    { return KindBoolean }
+func (pat patternAnyRune) Kind() Kind                     { return KindAnyRune }
+func (pat patternEOFPredicate) Kind() Kind                { return KindEOF }
+func (pat *patternLineAnchorPredicate) Kind() Kind        { return KindLineAnchor }
+func (pat *patternText) Kind() Kind                       { return KindText }
+func (pat *patternBackwardPredicate) Kind() Kind          { return KindBackward }
+func (pat *patternTextSet) Kind() Kind                    { return KindTextSet }
+func (pat *patternTextReferring) Kind() Kind              { return KindRefer }
+func (pat *patternBackwardPredicateReferring) Kind() Kind { return KindReferBackward }
+func (pat *patternRuneSet) Kind() Kind                    { return KindRuneSet }
+func (pat *patternRuneRange) Kind() Kind                  { return KindRuneRange }
+func (pat *patternUnicodeRanges) Kind() Kind              { return KindUnicodeRanges }
+func (pat *patternUnicodeRangesWithExcluding) Kind() Kind { return KindUnicodeRanges }
+func (pat *patternSequence) Kind() Kind                   { return KindSequence }
+func (pat *patternAlternative) Kind() Kind                { return KindAlternative }
+func (pat *patternSkip) Kind() Kind                       { return KindSkip }
+func (pat *patternAnyRuneUntil) Kind() Kind               { return KindUntil }
+func (pat *patternQualifierAtLeast) Kind() Kind           { return KindQualifier }
+func (pat *patternQualifierOptional) Kind() Kind          { return KindQualifier }
+func (pat *patternQualifierRange) Kind() Kind             { return KindQualifier }
+func (pat *patternPredicate) Kind() Kind                  { return KindPredicate }
+func (pat *patternAndPredicate) Kind() Kind               { return KindAnd }
+func (pat *patternOrPredicate) Kind() Kind                { return KindOr }
+func (pat *patternAbort) Kind() Kind                      { return KindAbort }
+func (pat *patternIf) Kind() Kind                         { return KindIf }
+func (pat *patternSwitch) Kind() Kind                     { return KindSwitch }
+func (pat *patternGrouping) Kind() Kind                   { return KindGroup }
+func (pat *patternTrigger) Kind() Kind                    { return KindTrigger }
+func (pat *patternLet) Kind() Kind                        { return KindLet }
+func (pat *patternRule) Kind() Kind                       { return KindRule }
+func (pat *patternCaptureVariable) Kind() Kind            { return KindVariable }
+func (pat *patternCaptureToken) Kind() Kind               { return KindToken }
+func (pat *patternCaptureCons) Kind() Kind                { return KindCons }
+func (pat *patternCaptureTerm) Kind() Kind                { return KindTerm }
+func (pat *patternCaptureContext) Kind() Kind             { return KindContext }
+func (pat *patternCaptureConst) Kind() Kind               { return KindConst }
+func (pat *patternCapturePosition) Kind() Kind            { return KindPosition }
+func (pat *patternCaptureFold) Kind() Kind                { return KindFold }
+func (pat *patternCaptureTable) Kind() Kind               { return KindTable }
+func (pat *patternCaptureBack) Kind() Kind                { return KindBackCapture }
+func (pat *patternCaptureArgument) Kind() Kind            { return KindArgument }
+func (pat *patternCaptureMatchTime) Kind() Kind           { return KindMatchTime }
+func (pat *patternTemplate) Kind() Kind                   { return KindTemplate }
+func (pat *patternCallTemplate) Kind() Kind               { return KindCall }
+func (pat *patternClosure) Kind() Kind                    { return pat.pat.Kind() }
+func (pat *patternSymbolDeclare) Kind() Kind              { return KindSymbol }
+func (pat *patternSymbolSet) Kind() Kind                  { return KindSymbolSet }
+func (pat *patternSymbolScope) Kind() Kind                { return KindSymbolScope }
+func (pat *patternAction) Kind() Kind                     { return KindAction }
+func (pat *patternActionText) Kind() Kind                 { return KindActionText }
+func (pat *patternDeferredTrigger) Kind() Kind            { return KindOnCommit }
+func (pat *patternCommit) Kind() Kind                     { return KindCommit }
+
+func (pat *patternInjector) Kind() Kind {
+	switch pat.origin.(type) {
+	case int:
+		return KindTrunc
+	case func(string) bool:
+		return KindCheck
+	default:
+		return KindInject
+	}
+}
@@ -0,0 +1,154 @@
+package peg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKindAndParams(t *testing.T) {
+	data := []struct {
+		pat    Pattern
+		kind   Kind
+		params Parameters
+		nsubs  int
+	}{
+		{True, KindBoolean, Parameters{Bool: true}, 0},
+		{Dot, KindAnyRune, Parameters{}, 0},
+		{EOF, KindEOF, Parameters{}, 0},
+		{SOL, KindLineAnchor, Parameters{Bool: true}, 0},
+		{TI("ab"), KindText, Parameters{Insensitive: true, Text: "AB"}, 0},
+		{B("ab"), KindBackward, Parameters{Text: "ab"}, 0},
+		{TS("b", "a"), KindTextSet, Parameters{Texts: []string{"a", "b"}}, 0},
+		{Ref("g"), KindRefer, Parameters{Name: "g"}, 0},
+		{RefB("g"), KindReferBackward, Parameters{Name: "g"}, 0},
+		{NS("ab"), KindRuneSet, Parameters{Not: true, Runes: []rune("ab")}, 0},
+		{R('a', 'z', '0', '9'), KindRuneRange, Parameters{Ranges: [][2]rune{{'a', 'z'}, {'0', '9'}}}, 0},
+		{U("Lu", "-Greek"), KindUnicodeRanges, Parameters{Names: []string{"Lu", "-Greek"}}, 0},
+		{Seq(T("a"), T("b")), KindSequence, Parameters{}, 2},
+		{Alt(T("a"), T("b"), T("c")), KindAlternative, Parameters{}, 3},
+		{Skip(3), KindSkip, Parameters{Min: 3, Max: 3}, 0},
+		{UntilB(T("a")), KindUntil, Parameters{Inclusive: true}, 1},
+		{Q1(T("a")), KindQualifier, Parameters{Min: 1, Max: -1}, 1},
+		{Q01(T("a")), KindQualifier, Parameters{Min: 0, Max: 1}, 1},
+		{Qmn(2, 3, T("a")), KindQualifier, Parameters{Min: 2, Max: 3}, 1},
+		{Not(T("a")), KindPredicate, Parameters{Not: true}, 1},
+		{And(T("a"), T("b")), KindAnd, Parameters{}, 2},
+		{Or(T("a"), T("b")), KindOr, Parameters{}, 2},
+		{Abort("msg"), KindAbort, Parameters{Text: "msg"}, 0},
+		{If(T("a"), T("b"), T("c")), KindIf, Parameters{}, 3},
+		{Switch(T("a"), T("b"), T("c"), T("d")), KindSwitch, Parameters{}, 5},
+		{NG("g", T("a")), KindGroup, Parameters{Name: "g"}, 1},
+		{Trunc(2, T("a")), KindTrunc, Parameters{Min: 2, Max: 2}, 1},
+		{Let(map[string]Pattern{"b": T("b"), "a": T("a")}, V("a")), KindLet, Parameters{Names: []string{"a", "b"}}, 3},
+		{CLet(map[string]Pattern{"a": T("a")}, V("a")), KindLet, Parameters{Names: []string{"a"}, Capture: true}, 2},
+		{Transparent(T("a")), KindRule, Parameters{Bool: true}, 1},
+		{Silent(T("a")), KindRule, Parameters{}, 1},
+		{CV("a"), KindVariable, Parameters{Name: "a", Capture: true}, 0},
+		{CK(3, T("a")), KindToken, Parameters{Type: 3}, 1},
+		{Template([]string{"x"}, V("x")), KindTemplate, Parameters{Names: []string{"x"}}, 1},
+		{Call("tpl", T("a"), T("b")), KindCall, Parameters{Name: "tpl"}, 2},
+		{Sym("s", T("a")), KindSymbol, Parameters{Name: "s"}, 1},
+		{TSym("s"), KindSymbolSet, Parameters{Name: "s"}, 0},
+		{SymScope(T("a")), KindSymbolScope, Parameters{}, 1},
+	}
+	for _, d := range data {
+		if d.pat.Kind() != d.kind {
+			t.Errorf("%s.Kind() => %s != %s", d.pat, d.pat.Kind(), d.kind)
+		}
+		if params := Params(d.pat); !reflect.DeepEqual(params, d.params) {
+			t.Errorf("Params(%s) => %+v != %+v", d.pat, params, d.params)
+		}
+		if n := len(Children(d.pat)); n != d.nsubs {
+			t.Errorf("len(Children(%s)) => %d != %d", d.pat, n, d.nsubs)
+		}
+	}
+
+	for _, d := range []struct {
+		pat  Pattern
+		kind Kind
+		fn   interface{}
+	}{
+		{Trigger(genHook, Dot), KindTrigger, genHook},
+		{Inject(genHalf, Dot), KindInject, genHalf},
+		{Check(genEven, Dot), KindCheck, genEven},
+		{CC(genJoin, Dot), KindCons, genJoin},
+		{CT(genUpper, Dot), KindTerm, genUpper},
+	} {
+		params := Params(d.pat)
+		if d.pat.Kind() != d.kind || funcPointer(params.Func) != funcPointer(d.fn) {
+			t.Errorf("%s => %s %+v", d.pat, d.pat.Kind(), params)
+		}
+	}
+
+	if Kind(-1).String() != "Unknown" || KindSymbolScope.String() != "SymbolScope" {
+		t.Errorf("unexpected Kind.String()")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	pat := Seq(T("a"), Q0(Alt(V("x"), NG("g", T("b")))), Not(Dot))
+
+	var kinds []Kind
+	Walk(pat, func(pat Pattern) bool {
+		kinds = append(kinds, pat.Kind())
+		return true
+	})
+	want := []Kind{KindSequence, KindText, KindQualifier, KindAlternative, KindVariable,
+		KindGroup, KindText, KindPredicate, KindAnyRune}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("Walk(%s) => %v != %v", pat, kinds, want)
+	}
+
+	kinds = nil
+	Walk(pat, func(pat Pattern) bool {
+		kinds = append(kinds, pat.Kind())
+		return pat.Kind() != KindQualifier
+	})
+	want = []Kind{KindSequence, KindText, KindQualifier, KindPredicate, KindAnyRune}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("Walk(%s) with skipping => %v != %v", pat, kinds, want)
+	}
+}
+
+func TestEqualAndHash(t *testing.T) {
+	build := func(n int) Pattern {
+		return Let(map[string]Pattern{
+			"a": Seq(T("a"), Q0(R('0', '9')), CC(genJoin, V("b"))),
+			"b": Alt(TS("x", "y"), U("L", "-Lu"), Skip(n)),
+		}, V("a"))
+	}
+	equals := [][2]Pattern{
+		{build(1), build(1)},
+		{Q01(T("a")), Qmn(0, 1, T("a"))},
+		{TS("b", "a"), TS("a", "b")},
+		{T(""), True},
+	}
+	for _, pair := range equals {
+		if !Equal(pair[0], pair[1]) {
+			t.Errorf("Equal(%s, %s) => false", pair[0], pair[1])
+		}
+		if Hash(pair[0]) != Hash(pair[1]) {
+			t.Errorf("Hash(%s) != Hash(%s)", pair[0], pair[1])
+		}
+	}
+
+	differents := [][2]Pattern{
+		{build(1), build(2)},
+		{T("a"), TI("a")},
+		{S("ab"), NS("ab")},
+		{Seq(T("a"), T("b")), Seq(T("b"), T("a"))},
+		{CC(genJoin, Dot), CT(genUpper, Dot)},
+		{Trigger(genHook, Dot), Trigger(func(string, Position) error { return nil }, Dot)},
+		{V("a"), CV("a")},
+		{Q0(T("a")), Q1(T("a"))},
+		{True, nil},
+	}
+	for _, pair := range differents {
+		if Equal(pair[0], pair[1]) {
+			t.Errorf("Equal(%v, %v) => true", pair[0], pair[1])
+		}
+		if pair[1] != nil && Hash(pair[0]) == Hash(pair[1]) {
+			t.Errorf("Hash(%s) == Hash(%s)", pair[0], pair[1])
+		}
+	}
+}
@@ -24,11 +24,15 @@
 // whether grouping or capturing is enabled. The default config enables
 // both grouping and capturing, while limits for recursion and repeat are
 // setup to DefaultCallstackLimit and DefaultRepeatLimit.
+// It also tells how the line-column numbers of positions are counted, in
+// runes, bytes, UTF-16 code units or tab-expanded columns, and which line
+// terminators are counted besides "\r", "\n" and "\r\n".
 //
 // The result of `config.Match(pat, text)` contains:
 // whether pattern was matched, how many bytes were matched,
 // the saved groups and the parser captures.
-// Saved groups are text pieces captured with an optional name.
+// Saved groups are text pieces captured with an optional name, along with
+// their ranges and the groups saved inside them.
 // Parser captures are parse trees or user defined structures constructed
 // during the parsing process.
 //
@@ -48,10 +52,11 @@
 //
 //     Seq(sequence...), Alt(choices...)
 //
-// Predicators test if pattern would be matched, but consume no text:
+// Predicators test if pattern would be matched, but consume no text. The
+// lookaheads leave no groups, captures or hooks behind, except for Peek:
 //
 //     True, False, SOL, EOL, EOF
-//     B(text), Test(cond), Not(cond), And(assertions...), Or(posiblities...), Abort(msg)
+//     B(text), Test(cond), Not(cond), Peek(cond), And(assertions...), Or(posiblities...), Abort(msg)
 //     When(cond, pat), If(cond, yes, no), Switch(cond, pat, ..., [otherwise])
 //
 // Available pattern qualifiers are:
@@ -68,14 +73,75 @@
 // Functionalities for groups, references, triggers and injectors:
 //
 //     G(pat), NG(groupname, pat)
-//     Ref(groupname), RefB(groupname)
-//     Trigger(hook, pat), Inject(injector, pat)
-//     Check(checker, pat), Trunc(maxrune, pat)
+//     Ref(groupname), RefB(groupname), RefN(index), RefBN(index)
+//     Trigger(hook, pat), OnCommit(hook, pat), Commit(pat)
+//     Inject(injector, pat), Check(checker, pat), Trunc(maxrune, pat)
 //
 // Functionalities for grammars and parsing captures:
 //
 //     Let(scope, pat), V(varname), CV(varname), CK(tokentype, pat)
-//     CC(nontermcons, pat), CT(termcons, pat)
+//     CLet(scope, pat), Silent(pat), Transparent(pat)
+//     CC(nontermcons, pat), CT(termcons, pat), CX(ctxcons, pat)
+//     Cc(values...), Cp, Cf(folder, pat), Ct(pat), Cb(groupname), Carg(n), Cmt(fn, pat)
+//     Template(params, body), Call(varname, args...), CCall(varname, args...)
+//     Import(prefix, scope), Extend(base, overrides), Merge(scopes...)
+//     CompileGrammar(source, defs), abnf.Compile(source, entry)
+//     ebnf.CompileW3C(source, entry), ebnf.CompileISO(source, entry)
+//
+// Semantic values of plain Go types are computed by actions, like yacc:
+//
+//     Action(fn, pat), ActionText(fn, pat), Eval(pat, text)
+//
+// Functionalities for runtime symbol tables:
+//
+//     Sym(setname, pat), TSym(setname), SymScope(pat)
+//
+// Standalone matchers are generated as Go source for `go generate`,
+// which run without constructing and interpreting the patterns:
+//
+//     Generator{Package, Func, Callbacks}.Generate(writer, pat)
+//     peggen [-format peg|abnf|w3c|iso] [-entry rule] [-pkg name] [-func name] [-o output] grammar
+//
+// Patterns are serialized into a parseable text form by `MarshalText`,
+// with callbacks referred by the names registered:
+//
+//     Register(name, fn), NewRegistry().Register(name, fn)
+//     reg.Marshal(pat), reg.Unmarshal(text), UnmarshalPattern(text), TextPattern{pat}
+//
+// Grammars are drawn as Graphviz DOT or railroad diagrams in SVG/HTML,
+// one diagram per rule, without external tools:
+//
+//     NewDiagram(pat).Rules(), .WriteDOT(writer), .WriteSVG(writer, rule), .WriteHTML(writer)
+//
+// Patterns are inspected by their kinds, parameters and children, and
+// rewritten bottom-up:
+//
+//     pat.Kind(), Params(pat), Children(pat), Walk(pat, fn), Equal(a, b), Hash(pat)
+//     Transform(pat, fn)
+//
+// Type-safe captures are built by generics (Go 1.18 or later) in package typed:
+//
+//     typed.Token(pat, fn), typed.Seq2(a, b), typed.Alt(rules...), typed.Many(rule), typed.Map(rule, fn)
+//     typed.Define(defs, name, rule), typed.Let(defs, typed.Var[T](name)), typed.ParseAs(rule, text)
+//
+// Captures of CV and NG are bound to the fields of annotated structs by reflection:
+//
+//     Bind(&target, pat, text), `peg:"name"`, `peg:"@pos"`, `peg:"@text"`, `peg:"-"`
+//
+// Lossless concrete syntax trees, keeping white spaces and comments, are
+// built in the SyntaxTree mode:
+//
+//     ParseTree(pat, text), Config{SyntaxTree: true}.Match(pat, text), tree.Leaves()
+//
+// Captures are searched by the queries modeled on tree-sitter:
+//
+//     CompileQuery(`(call . <1 "print"> (args) @args)`), query.FindAll(captures), query.Each(captures, fn)
+//
+// Captures are encoded into JSON, with the customed types registered, and
+// printed as indented trees:
+//
+//     MarshalCaptures(captures), UnmarshalCaptures(data), RegisterCapture(name, sample)
+//     FormatCaptures(captures)
 //
 // Common mistakes
 //
@@ -122,6 +188,7 @@ package peg // import "github.com/hucsmn/peg"
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -131,6 +198,9 @@ const (
 	DefaultRepeatLimit    = 500
 )
 
+// DefaultTabWidth is the width of tab stops used by ColumnTabStops.
+const DefaultTabWidth = 8
+
 var (
 	defaultConfig = Config{
 		CallstackLimit:            DefaultCallstackLimit,
@@ -138,6 +208,8 @@ var (
 		DisableLineColumnCounting: false,
 		DisableGrouping:           false,
 		DisableCapturing:          false,
+		SyntaxTree:                false,
+		DisableActions:            false,
 	}
 )
 
@@ -146,6 +218,8 @@ type (
 	Pattern interface {
 		match(ctx *context) error
 		String() string
+		Kind() Kind
+		MarshalText() ([]byte, error)
 	}
 
 	// Config contains configration for pattern matching.
@@ -159,11 +233,31 @@ type (
 		// Determines if the position calculation is disabled.
 		DisableLineColumnCounting bool
 
+		// Determines how the column numbers of positions are counted, and
+		// the width of tab stops for ColumnTabStops (DefaultTabWidth if
+		// zero or negative).
+		ColumnUnit ColumnUnit
+		TabWidth   int
+
+		// Determines the line terminators counted in addition to "\r",
+		// "\n" and "\r\n".
+		LineTerminators LineTerminators
+
 		// Determines if grouping is disabled.
 		DisableGrouping bool
 
 		// Determines if parse tree capturing is disabled.
 		DisableCapturing bool
+
+		// Determines if the lossless concrete syntax tree is built into
+		// Result.Tree, instead of the parse captures.
+		SyntaxTree bool
+
+		// Extra arguments captured by Carg.
+		Arguments []Capture
+
+		// Determines if the semantic actions are disabled.
+		DisableActions bool
 	}
 
 	// Result stores the results from pattern matching.
@@ -172,35 +266,82 @@ type (
 		Ok bool
 		N  int
 
-		// Grouped text pieces with optional names.
+		// Grouped text pieces with optional names, in the order they are
+		// saved. Only the last piece is kept for each name in NamedGroups.
 		Groups      []string
 		NamedGroups map[string]string
 
+		// All the saved groups, nested as they are saved, see AllGroups.
+		GroupTree []*Group
+
 		// Parse captures.
 		Captures []Capture
+
+		// Concrete syntax tree, built if Config.SyntaxTree is set.
+		Tree *SyntaxNode
+
+		// Semantic values computed by the outermost actions.
+		Values []interface{}
 	}
 
 	// Capture stores structures from parse capturing.
 	// User defined structures (the types implemented Capture interface other
 	// than the predefined Variable type and Token type) are constructed by
-	// customed TerminalConstructor or NonTerminalConstructor.
+	// customed TerminalConstructor, NonTerminalConstructor or
+	// ContextConstructor.
+	//
+	// Captures constructed inside a pattern are discarded on backtracking,
+	// once the pattern is proved to be dismatched.
 	Capture interface {
 		// IsTerminal tells if it is a terminal type.
 		IsTerminal() bool
 	}
 
-	// Variable is a predefined non-terminal type for PEG variable capturing.
+	// Variable is a predefined non-terminal type for PEG variable capturing,
+	// with the positions where the variable starts and ends.
 	Variable struct {
-		Name string
-		Subs []Capture
+		Name  string
+		Subs  []Capture
+		Start Position
+		End   Position
 	}
 
 	// Token is a predefined terminal type stores a piece of typed text
-	// and its position in the source text.
+	// and its start position (and end position) in the source text.
 	Token struct {
 		Type     int
 		Value    string
 		Position Position
+		End      Position
+	}
+
+	// Table is a predefined non-terminal type for the captures collected
+	// by Ct.
+	Table []Capture
+
+	// Text is a predefined terminal type of the text captured by Cb.
+	Text string
+
+	// Group is a text piece saved by G or NG, with its range in the source
+	// text and the groups saved inside it.
+	Group struct {
+		Name  string // empty for anonymous groups
+		Text  string
+		Start Position
+		End   Position
+		Subs  []*Group
+	}
+
+	// CaptureContext describes the capture under construction.
+	CaptureContext struct {
+		// Name of the variable captured by CV, or the innermost variable
+		// invoking the capture. Empty if invoked outside of any variable.
+		Rule string
+
+		// Matched text and its range in the source text.
+		Text  string
+		Start Position
+		End   Position
 	}
 
 	// TerminalConstructor is customed terminal type constructor.
@@ -208,6 +349,27 @@ type (
 
 	// NonTerminalConstructor is customed non-terminal type constructor.
 	NonTerminalConstructor func([]Capture) (Capture, error)
+
+	// ContextConstructor is customed capture constructor, which receives
+	// the capture context along with the sub-captures.
+	ContextConstructor func(CaptureContext, []Capture) (Capture, error)
+
+	// Folder folds the captures of Cf from left to right, the first capture
+	// is the initial accumulator.
+	Folder func(acc Capture, cap Capture) (Capture, error)
+
+	// MatchTimeFunc decides the match of Cmt when the pattern is matched,
+	// it receives the capture context along with the sub-captures, and
+	// returns the captures replacing the sub-captures.
+	MatchTimeFunc func(CaptureContext, []Capture) (bool, []Capture, error)
+
+	// ActionFunc computes the semantic value of Action from the values
+	// computed inside.
+	ActionFunc func(vals []interface{}) (interface{}, error)
+
+	// TextActionFunc computes the semantic value of ActionText from the
+	// matched text.
+	TextActionFunc func(text string) (interface{}, error)
 )
 
 // MatchedPrefix returns the matched prefix of text when successfully matched.
@@ -244,6 +406,8 @@ func (cfg Config) MatchedPrefix(pat Pattern, text string) (prefix string, ok boo
 	config := cfg
 	config.DisableLineColumnCounting = true
 	config.DisableCapturing = true
+	config.SyntaxTree = false
+	config.DisableActions = true
 	r, err := config.Match(pat, text)
 	if err != nil || !r.Ok {
 		return "", false
@@ -261,6 +425,8 @@ func (cfg Config) IsFullMatched(pat Pattern, text string) bool {
 	config := cfg
 	config.DisableLineColumnCounting = true
 	config.DisableCapturing = true
+	config.SyntaxTree = false
+	config.DisableActions = true
 	r, err := config.Match(pat, text)
 	return err == nil && r.Ok && r.N == len(text)
 }
@@ -272,6 +438,7 @@ func (cfg Config) Parse(pat Pattern, text string) (caps []Capture, err error) {
 	config := cfg
 	config.DisableLineColumnCounting = false
 	config.DisableCapturing = false
+	config.SyntaxTree = false
 	r, err := config.Match(pat, text)
 	if err != nil {
 		return nil, err
@@ -299,14 +466,33 @@ func (cfg Config) Match(pat Pattern, text string) (result *Result, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if ctx.ret.ok {
+		if err = ctx.flush(0); err != nil {
+			return nil, err
+		}
+	}
 
 	if ctx.ret.ok {
+		groups, named := flattenGroups(ctx.groups)
+		if cfg.SyntaxTree {
+			return &Result{
+				Ok:          true,
+				N:           ctx.ret.n,
+				Groups:      groups,
+				NamedGroups: named,
+				GroupTree:   ctx.groups,
+				Tree:        ctx.syntaxTree(ctx.ret.n),
+				Values:      ctx.valstack[0],
+			}, nil
+		}
 		return &Result{
 			Ok:          true,
 			N:           ctx.ret.n,
-			Groups:      ctx.groups,
-			NamedGroups: ctx.namedGroups,
+			Groups:      groups,
+			NamedGroups: named,
+			GroupTree:   ctx.groups,
 			Captures:    ctx.capstack[0].args,
+			Values:      ctx.valstack[0],
 		}, nil
 	}
 	return &Result{
@@ -328,6 +514,16 @@ func (tok *Token) IsTerminal() bool {
 	return true
 }
 
+// IsTerminal method of the Table type always returns false.
+func (t Table) IsTerminal() bool {
+	return false
+}
+
+// IsTerminal method of the Text type always returns true.
+func (t Text) IsTerminal() bool {
+	return true
+}
+
 func (v *Variable) String() string {
 	strs := make([]string, len(v.Subs))
 	for i := range v.Subs {
@@ -336,6 +532,18 @@ func (v *Variable) String() string {
 	return fmt.Sprintf("%s(%s)", v.Name, strings.Join(strs, ", "))
 }
 
+func (t Table) String() string {
+	strs := make([]string, len(t))
+	for i := range t {
+		strs[i] = fmt.Sprint(t[i])
+	}
+	return fmt.Sprintf("{%s}", strings.Join(strs, ", "))
+}
+
+func (t Text) String() string {
+	return strconv.Quote(string(t))
+}
+
 func (tok *Token) String() string {
 	return fmt.Sprintf("token_%d%q@%s",
 		tok.Type, tok.Value, tok.Position.String())
@@ -15,6 +15,8 @@ import (
 )
 
 // Scope contains all the variables defined in this package.
+// It could be composed with other grammars by peg.Import, e.g.
+// peg.Let(peg.Import("util", Scope), peg.V("util.IPv4")).
 var Scope = map[string]peg.Pattern{
 	"OctDigit": OctDigit,
 	"DecDigit": DecDigit,
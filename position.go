@@ -7,31 +7,121 @@ import (
 )
 
 // Position is a record of byte offset and line-column numbers
-// counting from zero.
+// counting from zero. The columns are counted in runes by default, see
+// Config.ColumnUnit.
 type Position struct {
 	Offest int
 	Line   int
 	Column int
 }
 
+// ColumnUnit tells how the column numbers of positions are counted.
+type ColumnUnit int
+
+// Units of column numbers.
+const (
+	ColumnRunes    ColumnUnit = iota // counts runes, the default
+	ColumnBytes                      // counts bytes
+	ColumnUTF16                      // counts UTF-16 code units, as LSP does
+	ColumnTabStops                   // counts runes, expanding tabs to tab stops
+)
+
+// LineTerminators is a set of line terminators counted in addition to the
+// default "\r", "\n" and "\r\n".
+type LineTerminators int
+
+// Additional line terminators.
+const (
+	TerminateNEL LineTerminators = 1 << iota // U+0085 NEXT LINE
+	TerminateLS                              // U+2028 LINE SEPARATOR
+	TerminatePS                              // U+2029 PARAGRAPH SEPARATOR
+	TerminateVT                              // U+000B LINE TABULATION
+	TerminateFF                              // U+000C FORM FEED
+
+	// All the line terminators of Unicode.
+	TerminateUnicode = TerminateNEL | TerminateLS | TerminatePS | TerminateVT | TerminateFF
+)
+
+// Additional line terminators with their encoded text.
+var lineTerminators = []struct {
+	term LineTerminators
+	text string
+}{
+	{TerminateNEL, "\u0085"},
+	{TerminateLS, "\u2028"},
+	{TerminatePS, "\u2029"},
+	{TerminateVT, "\v"},
+	{TerminateFF, "\f"},
+}
+
+// IsTerminal method makes Position a terminal capture, as Cp captures.
+func (pos *Position) IsTerminal() bool {
+	return true
+}
+
 func (pos *Position) String() string {
 	return fmt.Sprintf("%d:%d+%d", pos.Line+1, pos.Column+1, pos.Offest)
 }
 
 // Simple utf-8 string line column calculator.
 type positionCalculator struct {
-	text   string
-	cached int   // cached to where
-	lnends []int // found "\r"|"\n"|"\r\n" line endings
+	text     string
+	cached   int   // cached to where
+	lnends   []int // found "\r"|"\n"|"\r\n" line endings
+	unit     ColumnUnit
+	tabwidth int
+	terms    LineTerminators // additional line terminators
+}
+
+func newPositionCalculator(text string, config Config) positionCalculator {
+	tabwidth := config.TabWidth
+	if tabwidth <= 0 {
+		tabwidth = DefaultTabWidth
+	}
+	return positionCalculator{
+		text:     text,
+		unit:     config.ColumnUnit,
+		tabwidth: tabwidth,
+		terms:    config.LineTerminators,
+	}
 }
 
 func (calc *positionCalculator) calculate(offset int) Position {
 	ln, lnstart := calc.search(offset)
-	col := utf8.RuneCountInString(calc.text[lnstart:offset])
 	return Position{
 		Offest: offset,
 		Line:   ln,
-		Column: col,
+		Column: calc.column(calc.text[lnstart:offset]),
+	}
+}
+
+// Counts the columns of text in line before the position.
+func (calc *positionCalculator) column(s string) int {
+	switch calc.unit {
+	case ColumnBytes:
+		return len(s)
+	case ColumnUTF16:
+		n := 0
+		for _, r := range s {
+			if r >= 0x10000 {
+				n += 2
+			} else {
+				n++
+			}
+		}
+		return n
+	case ColumnTabStops:
+		n := 0
+		for _, r := range s {
+			if r == '\t' {
+				n += calc.tabwidth - n%calc.tabwidth
+			} else {
+				n++
+			}
+		}
+		return n
+	default:
+		return utf8.RuneCountInString(s)
 	}
 }
 
@@ -52,6 +142,9 @@ func (calc *positionCalculator) search(offset int) (ln, lnstart int) {
 			return m + 1, offset
 		}
 	}
+	if i == 0 {
+		return 0, 0
+	}
 	return i, calc.lnends[i-1]
 }
 
@@ -64,6 +157,16 @@ func (calc *positionCalculator) caching(to int) {
 			if !strings.HasPrefix(calc.text[calc.cached+1:], "\n") {
 				calc.lnends = append(calc.lnends, calc.cached+1)
 			}
+		default:
+			if calc.terms == 0 {
+				continue
+			}
+			for _, t := range lineTerminators {
+				if calc.terms&t.term != 0 && strings.HasPrefix(calc.text[calc.cached:], t.text) {
+					calc.lnends = append(calc.lnends, calc.cached+len(t.text))
+					break
+				}
+			}
 		}
 	}
 }
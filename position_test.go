@@ -44,3 +44,42 @@ func TestPositionCalculator(t *testing.T) {
 		}
 	}
 }
+
+// Test the column units and the additional line terminators.
+func TestPositionConfig(t *testing.T) {
+	text := "a\tb\U0001F600c\u2028d\u0085e"
+	inputs := []int{7, 11, 14}
+	data := []struct {
+		config  Config
+		outputs []Position
+	}{
+		{Config{}, []Position{{7, 0, 4}, {11, 0, 6}, {14, 0, 8}}},
+		{Config{ColumnUnit: ColumnBytes}, []Position{{7, 0, 7}, {11, 0, 11}, {14, 0, 14}}},
+		{Config{ColumnUnit: ColumnUTF16}, []Position{{7, 0, 5}, {11, 0, 7}, {14, 0, 9}}},
+		{Config{ColumnUnit: ColumnTabStops}, []Position{{7, 0, 10}, {11, 0, 12}, {14, 0, 14}}},
+		{Config{ColumnUnit: ColumnTabStops, TabWidth: 4}, []Position{{7, 0, 6}, {11, 0, 8}, {14, 0, 10}}},
+		{Config{LineTerminators: TerminateLS}, []Position{{7, 0, 4}, {11, 1, 0}, {14, 1, 2}}},
+		{Config{LineTerminators: TerminateUnicode}, []Position{{7, 0, 4}, {11, 1, 0}, {14, 2, 0}}},
+		{Config{ColumnUnit: ColumnBytes, LineTerminators: TerminateNEL}, []Position{{7, 0, 7}, {11, 0, 11}, {14, 1, 0}}},
+	}
+
+	for _, d := range data {
+		pcalc := newPositionCalculator(text, d.config)
+		for i := range inputs {
+			pos := pcalc.calculate(inputs[i])
+			if d.outputs[i] != pos {
+				t.Errorf("%q.position(%d) with %+v => %v != %v",
+					text, inputs[i], d.config, pos, d.outputs[i])
+			}
+		}
+	}
+
+	// positions told while matching honor the configuration.
+	cfg := Config{ColumnUnit: ColumnUTF16, LineTerminators: TerminateLS}
+	r, err := cfg.Match(Seq(Q0(NS("c")), CT(func(s string, pos Position) (Capture, error) {
+		return &pos, nil
+	}, T("c"))), text)
+	if err != nil || !r.Ok || len(r.Captures) != 1 || *r.Captures[0].(*Position) != (Position{7, 0, 5}) {
+		t.Errorf("Match => %v, %v", r, err)
+	}
+}
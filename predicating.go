@@ -39,8 +39,9 @@ type (
 	patternEOFPredicate struct{}
 
 	patternPredicate struct {
-		not bool
-		pat Pattern
+		not  bool
+		keep bool
+		pat  Pattern
 	}
 
 	patternAndPredicate struct {
@@ -68,22 +69,32 @@ type (
 
 // Test predicates if pattern is matched, consuming no text.
 //
-// Note that, if predicates true, groups/parse captures won't be discarded.
+// The pattern is isolated as a lookahead: the groups, parse captures,
+// symbols and semantic values made inside are discarded, while the hooks of
+// Trigger, the constructors and the actions are not invoked. See Peek.
 func Test(pat Pattern) Pattern {
 	return &patternPredicate{not: false, pat: pat}
 }
 
 // Not predicates if pattern is dismatched, consuming no text.
 //
-// Note that, if predicates true, groups/parse captures won't be discarded.
+// The pattern is isolated as a lookahead, see Test.
 func Not(pat Pattern) Pattern {
 	return &patternPredicate{not: true, pat: pat}
 }
 
+// Peek predicates if pattern is matched, consuming no text, like Test.
+// But the pattern is not isolated, the groups, parse captures, symbols and
+// semantic values made inside are kept if predicates true. The syntax tree
+// nodes are never kept, since no text is consumed.
+func Peek(pat Pattern) Pattern {
+	return &patternPredicate{not: false, keep: true, pat: pat}
+}
+
 // And searches the patterns in order to predicate if all the pattern
 // is matched at current position. It consumes no text.
 //
-// Note that, if predicates true, groups/parse captures won't be discarded.
+// The patterns are isolated as lookaheads, see Test.
 func And(pats ...Pattern) Pattern {
 	if len(pats) == 0 {
 		return True
@@ -94,7 +105,7 @@ func And(pats ...Pattern) Pattern {
 // Or searches the patterns in order to predicate if any the pattern
 // is matched at current position. It consumes no text.
 //
-// Note that, if predicates true, groups/parse captures won't be discarded.
+// The patterns are isolated as lookaheads, see Test.
 func Or(pats ...Pattern) Pattern {
 	if len(pats) == 0 {
 		return False
@@ -110,8 +121,7 @@ func Abort(msg string) Pattern {
 // When tests the given condition but consumes no text, then determines to
 // execute then-branch or just predicates false.
 //
-// Note that, if cond predicates true, groups/parse captures won't be
-// discarded.
+// The condition is isolated as a lookahead, see Test.
 func When(cond, then Pattern) Pattern {
 	return &patternIf{cond: cond, yes: then, no: False}
 }
@@ -119,8 +129,7 @@ func When(cond, then Pattern) Pattern {
 // If tests the given condition but consumes no text, then determines to
 // execute yes-branch or no-branch.
 //
-// Note that, if cond predicates true, groups/parse captures won't be
-// discarded.
+// The condition is isolated as a lookahead, see Test.
 func If(cond, yes, no Pattern) Pattern {
 	return &patternIf{cond: cond, yes: yes, no: no}
 }
@@ -129,8 +138,7 @@ func If(cond, yes, no Pattern) Pattern {
 // If there is no true cond, executes the optional otherwise-branch
 // (the default pattern is True).
 //
-// Note that, if cond predicates true, groups/parse captures won't be
-// discarded.
+// The conditions are isolated as lookaheads, see Test.
 func Switch(cond, then Pattern, rest ...Pattern) Pattern {
 	pat := &patternSwitch{}
 	pat.cases = append(pat.cases, struct {
@@ -189,10 +197,18 @@ func (patternEOFPredicate) match(ctx *context) error {
 // Predicates if sub-pattern matches.
 func (pat *patternPredicate) match(ctx *context) error {
 	if !ctx.justReturned() {
-		return ctx.call(pat.pat)
+		if pat.keep {
+			ctx.locals.ncaps = len(ctx.capstack[len(ctx.capstack)-1].args)
+			return ctx.call(pat.pat)
+		}
+		return ctx.lookahead(pat.pat)
 	}
 
 	ret := ctx.ret
+	if pat.keep && ctx.config.SyntaxTree {
+		// no text is consumed, thus no syntax tree node is kept
+		ctx.truncateCaptures(ctx.locals.ncaps)
+	}
 	if pat.not {
 		ret.ok = !ret.ok
 	}
@@ -203,7 +219,7 @@ func (pat *patternPredicate) match(ctx *context) error {
 func (pat *patternAndPredicate) match(ctx *context) error {
 	for ctx.locals.i < len(pat.pats) {
 		if !ctx.justReturned() {
-			return ctx.call(pat.pats[ctx.locals.i])
+			return ctx.lookahead(pat.pats[ctx.locals.i])
 		}
 
 		if !ctx.ret.ok {
@@ -218,7 +234,7 @@ func (pat *patternAndPredicate) match(ctx *context) error {
 func (pat *patternOrPredicate) match(ctx *context) error {
 	for ctx.locals.i < len(pat.pats) {
 		if !ctx.justReturned() {
-			return ctx.call(pat.pats[ctx.locals.i])
+			return ctx.lookahead(pat.pats[ctx.locals.i])
 		}
 
 		if ctx.ret.ok {
@@ -238,7 +254,7 @@ func (pat *patternAbort) match(ctx *context) error {
 // Branch `if'.
 func (pat *patternIf) match(ctx *context) error {
 	if !ctx.justReturned() {
-		return ctx.call(pat.cond)
+		return ctx.lookahead(pat.cond)
 	}
 
 	if ctx.ret.ok {
@@ -251,7 +267,7 @@ func (pat *patternIf) match(ctx *context) error {
 func (pat *patternSwitch) match(ctx *context) error {
 	for ctx.locals.i < len(pat.cases) {
 		if !ctx.justReturned() {
-			return ctx.call(pat.cases[ctx.locals.i].cond)
+			return ctx.lookahead(pat.cases[ctx.locals.i].cond)
 		}
 
 		if ctx.ret.ok {
@@ -284,6 +300,9 @@ func (pat *patternPredicate) String() string {
 	if pat.not {
 		return fmt.Sprintf("!%s", pat.pat)
 	}
+	if pat.keep {
+		return fmt.Sprintf("peek%s", pat.pat)
+	}
 	return fmt.Sprintf("?%s", pat.pat)
 }
 
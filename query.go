@@ -0,0 +1,464 @@
+package peg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Query searches the capture trees for the captures matching its patterns.
+type Query struct {
+	pats []*queryPattern
+}
+
+// QueryMatch is a capture matched by query, along with the named
+// sub-captures bound by `@name`.
+type QueryMatch struct {
+	Pattern  int // index of the query pattern matched
+	Capture  Capture
+	Bindings map[string]Capture
+}
+
+// QueryNode is implemented by the customed captures to be matched by
+// queries. The name is matched by `(name ...)` (empty if unnamed), the
+// text is matched by `"text"`, and the children are searched and matched
+// as the subs of Variable.
+type QueryNode interface {
+	Capture
+	QueryName() string
+	QueryText() string
+	QueryChildren() []Capture
+}
+
+// Kinds of query patterns.
+const (
+	queryAny = iota
+	queryNode
+	queryToken
+	queryText
+	queryAlternative
+)
+
+// Compiled query pattern.
+type queryPattern struct {
+	kind    int
+	name    string // queryNode: variable name, "_" for any
+	toktype int    // queryToken: token type, negative for any
+	hastext bool   // queryToken: value specified
+	text    string // queryToken, queryText: the text
+	binding string
+	subs    []*queryPattern // queryNode: children, queryAlternative: choices
+	anchors []bool          // queryNode: anchored before each child and at the end
+}
+
+// Named capture bound during matching.
+type queryBinding struct {
+	name string
+	cap  Capture
+}
+
+// Backtracking query matcher.
+type queryMatcher struct {
+	bindings []queryBinding
+}
+
+// CompileQuery compiles the query source, which consists of one or more
+// patterns modeled on the queries of tree-sitter:
+//
+//     query    <- S pattern+ !.
+//     pattern  <- (node / token / string / '_' / '[' S pattern+ ']') S ('@' name S)?
+//     node     <- '(' S (name / '_') S ('.' S / pattern)* ')'
+//     token    <- '<' S (num / '_') S (string S)? '>'
+//     string   <- '"' ([^"\\] / '\\' .)* '"'
+//     S        <- (%s / ';' [^\n]*)*
+//
+// Pattern `(name ...)` matches Variable (or QueryNode) of the name, `_` for
+// any name, whose children are matched by the child patterns in order, but
+// not necessarily consecutively. Anchor `.` requires the child patterns
+// around it to match consecutive children, or the first (last) child if
+// placed at the beginning (end). Pattern `<type "value">` matches Token of
+// the type and optional value, `"text"` matches Token (or QueryNode) of the
+// text, `_` matches any capture, and `[...]` matches any of the choices.
+// Captures matched by patterns suffixed by `@name` are bound to the name.
+//
+// For example, `(call . <1 "print"> (args) @args)` finds the calls of print
+// and binds their arguments.
+//
+// Returns *SyntaxError with the line and column if the source is invalid.
+func CompileQuery(src string) (*Query, error) {
+	c := &queryCompiler{src: src, pcalc: positionCalculator{text: src}}
+	return c.compile()
+}
+
+// Each calls fn with each capture matched, searching the capture trees in
+// depth-first order, until fn returns false. Each capture is matched by the
+// first pattern matched in query. Table is searched as an unnamed node.
+func (q *Query) Each(caps []Capture, fn func(m *QueryMatch) bool) {
+	q.each(caps, fn)
+}
+
+// FindAll returns all the captures matched, see Each.
+func (q *Query) FindAll(caps []Capture) []*QueryMatch {
+	var matches []*QueryMatch
+	q.each(caps, func(m *QueryMatch) bool {
+		matches = append(matches, m)
+		return true
+	})
+	return matches
+}
+
+// Find returns the first capture matched, or nil if not found.
+func (q *Query) Find(caps []Capture) *QueryMatch {
+	var found *QueryMatch
+	q.each(caps, func(m *QueryMatch) bool {
+		found = m
+		return false
+	})
+	return found
+}
+
+func (q *Query) each(caps []Capture, fn func(m *QueryMatch) bool) bool {
+	for _, cap := range caps {
+		m := &queryMatcher{}
+		for i, pat := range q.pats {
+			if m.match(pat, cap, func() bool { return true }) {
+				match := &QueryMatch{Pattern: i, Capture: cap, Bindings: make(map[string]Capture)}
+				for _, b := range m.bindings {
+					match.Bindings[b.name] = b.cap
+				}
+				if !fn(match) {
+					return false
+				}
+				break
+			}
+		}
+		if _, subs, ok := queryNodeOf(cap); ok && !q.each(subs, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// Gets the name and children of the capture if it is a node.
+func queryNodeOf(cap Capture) (string, []Capture, bool) {
+	switch cap := cap.(type) {
+	case *Variable:
+		return cap.Name, cap.Subs, true
+	case Table:
+		return "", cap, true
+	case QueryNode:
+		return cap.QueryName(), cap.QueryChildren(), true
+	}
+	return "", nil, false
+}
+
+// Gets the text of the capture if it is a terminal.
+func queryTextOf(cap Capture) (string, bool) {
+	switch cap := cap.(type) {
+	case *Token:
+		return cap.Value, true
+	case QueryNode:
+		return cap.QueryText(), true
+	}
+	return "", false
+}
+
+// Matches the capture, then calls next to match the rest. The bindings are
+// rolled back if not matched.
+func (m *queryMatcher) match(pat *queryPattern, cap Capture, next func() bool) bool {
+	mark := len(m.bindings)
+	if pat.binding != "" {
+		m.bindings = append(m.bindings, queryBinding{name: pat.binding, cap: cap})
+	}
+	if m.matchPattern(pat, cap, next) {
+		return true
+	}
+	m.bindings = m.bindings[:mark]
+	return false
+}
+
+func (m *queryMatcher) matchPattern(pat *queryPattern, cap Capture, next func() bool) bool {
+	switch pat.kind {
+	case queryAny:
+		return next()
+	case queryText:
+		text, ok := queryTextOf(cap)
+		return ok && text == pat.text && next()
+	case queryToken:
+		tok, ok := cap.(*Token)
+		if !ok || (pat.toktype >= 0 && tok.Type != pat.toktype) || (pat.hastext && tok.Value != pat.text) {
+			return false
+		}
+		return next()
+	case queryNode:
+		name, subs, ok := queryNodeOf(cap)
+		if !ok || name == "" || (pat.name != "_" && name != pat.name) {
+			return false
+		}
+		return m.matchChildren(pat, subs, 0, 0, next)
+	case queryAlternative:
+		for _, choice := range pat.subs {
+			if m.match(choice, cap, next) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Matches the k-th child pattern and the rest with the children from j.
+func (m *queryMatcher) matchChildren(pat *queryPattern, subs []Capture, k, j int, next func() bool) bool {
+	if k == len(pat.subs) {
+		if pat.anchors[k] && j != len(subs) {
+			return false
+		}
+		return next()
+	}
+	for i := j; i < len(subs); i++ {
+		if i > j && pat.anchors[k] {
+			break
+		}
+		if m.match(pat.subs[k], subs[i], func() bool {
+			return m.matchChildren(pat, subs, k+1, i+1, next)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// Recursive descent compiler of query source.
+type queryCompiler struct {
+	src   string
+	at    int
+	pcalc positionCalculator
+}
+
+func (c *queryCompiler) compile() (*Query, error) {
+	q := &Query{}
+	c.skipSpaces()
+	for c.at < len(c.src) {
+		pat, err := c.pattern()
+		if err != nil {
+			return nil, err
+		}
+		q.pats = append(q.pats, pat)
+	}
+	if len(q.pats) == 0 {
+		return nil, c.errorAt(c.at, "empty query")
+	}
+	return q, nil
+}
+
+func (c *queryCompiler) pattern() (*queryPattern, error) {
+	var pat *queryPattern
+	var err error
+	start := c.at
+	switch {
+	case c.skip("("):
+		pat, err = c.node(start)
+	case c.skip("<"):
+		pat, err = c.token(start)
+	case c.skip("["):
+		pat, err = c.alternative(start)
+	case c.peek("\""):
+		pat = &queryPattern{kind: queryText}
+		pat.text, err = c.literal()
+	case c.skip("_"):
+		pat = &queryPattern{kind: queryAny}
+	default:
+		return nil, c.unexpected()
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.skipSpaces()
+
+	if c.skip("@") {
+		at := c.at
+		if pat.binding = c.name(); pat.binding == "" {
+			return nil, c.errorAt(at, "expect binding name")
+		}
+		c.skipSpaces()
+	}
+	return pat, nil
+}
+
+func (c *queryCompiler) node(start int) (*queryPattern, error) {
+	c.skipSpaces()
+	at := c.at
+	pat := &queryPattern{kind: queryNode}
+	if pat.name = c.name(); pat.name == "" {
+		return nil, c.errorAt(at, "expect variable name")
+	}
+	c.skipSpaces()
+
+	anchored := false
+	for !c.skip(")") {
+		if c.at >= len(c.src) {
+			return nil, c.expect(`")"`, start)
+		}
+		if c.skip(".") {
+			anchored = true
+			c.skipSpaces()
+			continue
+		}
+		sub, err := c.pattern()
+		if err != nil {
+			return nil, err
+		}
+		pat.subs = append(pat.subs, sub)
+		pat.anchors = append(pat.anchors, anchored)
+		anchored = false
+	}
+	pat.anchors = append(pat.anchors, anchored)
+	return pat, nil
+}
+
+func (c *queryCompiler) token(start int) (*queryPattern, error) {
+	c.skipSpaces()
+	pat := &queryPattern{kind: queryToken, toktype: -1}
+	if !c.skip("_") {
+		n, ok := c.number()
+		if !ok {
+			return nil, c.errorAt(c.at, "expect token type")
+		}
+		pat.toktype = n
+	}
+	c.skipSpaces()
+	if c.peek("\"") {
+		text, err := c.literal()
+		if err != nil {
+			return nil, err
+		}
+		pat.hastext, pat.text = true, text
+		c.skipSpaces()
+	}
+	if !c.skip(">") {
+		return nil, c.expect(`">"`, start)
+	}
+	return pat, nil
+}
+
+func (c *queryCompiler) alternative(start int) (*queryPattern, error) {
+	c.skipSpaces()
+	pat := &queryPattern{kind: queryAlternative}
+	for !c.skip("]") {
+		if c.at >= len(c.src) {
+			return nil, c.expect(`"]"`, start)
+		}
+		sub, err := c.pattern()
+		if err != nil {
+			return nil, err
+		}
+		pat.subs = append(pat.subs, sub)
+	}
+	if len(pat.subs) == 0 {
+		return nil, c.errorAt(start, "empty alternatives")
+	}
+	return pat, nil
+}
+
+// Reads the Go-style double quoted string.
+func (c *queryCompiler) literal() (string, error) {
+	start := c.at
+	for i := c.at + 1; i < len(c.src); i++ {
+		switch c.src[i] {
+		case '\\':
+			i++
+		case '"':
+			text, err := strconv.Unquote(c.src[start : i+1])
+			if err != nil {
+				return "", c.errorAt(start, "invalid string %s", c.src[start:i+1])
+			}
+			c.at = i + 1
+			return text, nil
+		}
+	}
+	c.at = len(c.src)
+	return "", c.expect(`'"'`, start)
+}
+
+func (c *queryCompiler) number() (int, bool) {
+	start := c.at
+	for c.at < len(c.src) && c.src[c.at] >= '0' && c.src[c.at] <= '9' {
+		c.at++
+	}
+	if c.at == start {
+		return 0, false
+	}
+	n, err := strconv.Atoi(c.src[start:c.at])
+	if err != nil {
+		c.at = start
+		return 0, false
+	}
+	return n, true
+}
+
+// Reads the name, which could be a variable name imported, such as "num.int".
+func (c *queryCompiler) name() string {
+	start := c.at
+	for c.at < len(c.src) {
+		ch := c.src[c.at]
+		if ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') ||
+			(c.at > start && ((ch >= '0' && ch <= '9') || ch == '.' || ch == '-')) {
+			c.at++
+		} else {
+			break
+		}
+	}
+	return c.src[start:c.at]
+}
+
+func (c *queryCompiler) skipSpaces() {
+	for c.at < len(c.src) {
+		if c.src[c.at] == ';' {
+			i := strings.IndexByte(c.src[c.at:], '\n')
+			if i < 0 {
+				c.at = len(c.src)
+			} else {
+				c.at += i + 1
+			}
+			continue
+		}
+		switch c.src[c.at] {
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+			c.at++
+		default:
+			return
+		}
+	}
+}
+
+func (c *queryCompiler) skip(s string) bool {
+	if c.peek(s) {
+		c.at += len(s)
+		return true
+	}
+	return false
+}
+
+func (c *queryCompiler) peek(s string) bool {
+	return strings.HasPrefix(c.src[c.at:], s)
+}
+
+func (c *queryCompiler) errorAt(at int, format string, v ...interface{}) error {
+	return &SyntaxError{
+		Position: c.pcalc.calculate(at),
+		Message:  fmt.Sprintf(format, v...),
+	}
+}
+
+func (c *queryCompiler) expect(what string, start int) error {
+	pos := c.pcalc.calculate(start)
+	return c.errorAt(c.at, "expect %s to close the one at %d:%d", what, pos.Line+1, pos.Column+1)
+}
+
+func (c *queryCompiler) unexpected() error {
+	if c.at >= len(c.src) {
+		return c.errorAt(c.at, "unexpected end of query")
+	}
+	r, _ := utf8.DecodeRuneInString(c.src[c.at:])
+	return c.errorAt(c.at, "unexpected %q", r)
+}
@@ -0,0 +1,141 @@
+package peg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Customed node, for testing QueryNode.
+type queryTestNode struct {
+	name string
+	subs []Capture
+}
+
+func (n *queryTestNode) IsTerminal() bool         { return false }
+func (n *queryTestNode) QueryName() string        { return n.name }
+func (n *queryTestNode) QueryText() string        { return "" }
+func (n *queryTestNode) QueryChildren() []Capture { return n.subs }
+func (n *queryTestNode) String() string           { return n.name + "{" + formatCapList(n.subs) + "}" }
+
+func TestQuery(t *testing.T) {
+	// statements like `print(x, f(y)); g()`.
+	grammar := Let(map[string]Pattern{
+		"ws":   Q0(S(" ")),
+		"name": Seq(CK(1, Q1(R('a', 'z'))), V("ws")),
+		"num":  Seq(CK(2, Q1(R('0', '9'))), V("ws")),
+		"args": J0(Alt(CV("call"), V("name"), V("num")), Seq(T(","), V("ws"))),
+		"call": Seq(V("name"), T("("), V("ws"), CV("args"), T(")"), V("ws")),
+		"stmt": J1(CV("call"), Seq(T(";"), V("ws"))),
+	}, V("stmt"))
+	caps, err := Parse(grammar, "print(x, f(1)); g(); print()")
+	if err != nil {
+		t.Fatalf("Parse => %v", err)
+	}
+	caps = append(caps, &queryTestNode{name: "custom", subs: []Capture{&Token{Type: 1, Value: "z"}}})
+
+	data := []struct {
+		query   string
+		matches []string
+	}{
+		{`(call)`, []string{
+			`call(<1"print">, args(<1"x">, call(<1"f">, args(<2"1">))))`,
+			`call(<1"f">, args(<2"1">))`,
+			`call(<1"g">, args())`,
+			`call(<1"print">, args())`}},
+		{`(call . <1 "print"> (args _) @args)`, []string{
+			`call(<1"print">, args(<1"x">, call(<1"f">, args(<2"1">)))) args=args(<1"x">, call(<1"f">, args(<2"1">)))`}},
+		{`(args (call <_> @fn))`, []string{
+			`args(<1"x">, call(<1"f">, args(<2"1">))) fn=<1"f">`}},
+		{`(args . _ @first . _ @second .)`, []string{
+			`args(<1"x">, call(<1"f">, args(<2"1">))) first=<1"x"> second=call(<1"f">, args(<2"1">))`}},
+		{`(args .)`, []string{`args()`, `args()`}},
+		{`(args [<2> "x"] @arg)`, []string{
+			`args(<1"x">, call(<1"f">, args(<2"1">))) arg=<1"x">`,
+			`args(<2"1">) arg=<2"1">`}},
+		{`(_ "z" @z)`, []string{`custom{<1"z">} z=<1"z">`}},
+		{`<2> (call <1 "g">) ; comment`, []string{`<2"1">`, `call(<1"g">, args())`}},
+
+		// backtracks to bind the later children.
+		{`(args _ @a (call) @c)`, []string{
+			`args(<1"x">, call(<1"f">, args(<2"1">))) a=<1"x"> c=call(<1"f">, args(<2"1">))`}},
+		{`(call (args) @a . _)`, nil},
+	}
+
+	for _, d := range data {
+		q, err := CompileQuery(d.query)
+		if err != nil {
+			t.Errorf("CompileQuery(%q) => %v", d.query, err)
+			continue
+		}
+		var matches []string
+		for _, m := range q.FindAll(caps) {
+			strs := []string{formatCap(m.Capture)}
+			for _, name := range sortedBindingNames(m.Bindings) {
+				strs = append(strs, name+"="+formatCap(m.Bindings[name]))
+			}
+			matches = append(matches, strings.Join(strs, " "))
+		}
+		if fmt.Sprintf("%q", matches) != fmt.Sprintf("%q", d.matches) {
+			t.Errorf("query %q => %q, expect %q", d.query, matches, d.matches)
+		}
+	}
+
+	q, _ := CompileQuery(`(call)`)
+	if m := q.Find(caps); m == nil || m.Capture != caps[0] {
+		t.Errorf("Find => %v", m)
+	}
+	n := 0
+	q.Each(caps, func(m *QueryMatch) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Errorf("Each stopped after %d matches", n)
+	}
+	// elements of Table are searched as children.
+	nums := Let(map[string]Pattern{"num": CK(2, Q1(R('0', '9')))}, J0(CV("num"), T(",")))
+	q, _ = CompileQuery(`(num) @n`)
+	for _, pat := range []Pattern{nums, Ct(nums)} {
+		caps, err := Parse(pat, "1,2,3")
+		if ms := q.FindAll(caps); err != nil || len(ms) != 3 {
+			t.Errorf("query of %s => %d matches, %v", pat, len(ms), err)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	data := []struct {
+		query string
+		pos   string
+	}{
+		{``, "1:1+0"},
+		{`(call`, "1:6+5"},
+		{"(call\n  <x>)", "2:4+9"},
+		{`[]`, "1:1+0"},
+		{`(call) @`, "1:9+8"},
+		{`"abc`, "1:5+4"},
+		{`(call) )`, "1:8+7"},
+	}
+	for _, d := range data {
+		_, err := CompileQuery(d.query)
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("CompileQuery(%q) => %v, expect *SyntaxError", d.query, err)
+			continue
+		}
+		if serr.Position.String() != d.pos {
+			t.Errorf("CompileQuery(%q) => %v at %s, expect at %s", d.query, err, serr.Position.String(), d.pos)
+		}
+	}
+}
+
+func sortedBindingNames(bindings map[string]Capture) []string {
+	names := make([]string, 0, len(bindings))
+	for name := range bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
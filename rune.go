@@ -114,6 +114,8 @@ func U(ranges ...string) Pattern {
 	for name := range eset {
 		enames = append(enames, name)
 	}
+	sort.Strings(inames)
+	sort.Strings(enames)
 
 	// choose underlying type
 	switch {
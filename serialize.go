@@ -0,0 +1,1329 @@
+package peg
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Maximum width of a line in serialized text, longer lists are broken
+// into lines.
+const serializeLineWidth = 80
+
+var (
+	// DefaultRegistry is used by the MarshalText method of patterns,
+	// UnmarshalPattern and TextPattern.
+	DefaultRegistry = NewRegistry()
+)
+
+// Registry maps names to the user defined functions and the customed capture
+// types, so that the patterns using them could be serialized. Marshal writes
+// a function as `@name` and fails if it is unregistered or registered under
+// several names, while Unmarshal looks the names up. The values of Cc are
+// encoded with their registered type names, see RegisterCapture.
+type Registry struct {
+	funcs     map[string]interface{}
+	names     map[uintptr]string
+	ambiguous map[uintptr]bool
+
+	types     map[string]reflect.Type // capture types, see RegisterCapture
+	typenames map[reflect.Type]string
+}
+
+// TextPattern wraps a pattern to implement encoding.TextUnmarshaler,
+// using DefaultRegistry.
+type TextPattern struct {
+	Pattern
+}
+
+// Node of the serialized text, an atom or a list.
+type sexp struct {
+	at   int
+	atom string
+	list []*sexp
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		funcs:     make(map[string]interface{}),
+		names:     make(map[uintptr]string),
+		ambiguous: make(map[uintptr]bool),
+		types:     make(map[string]reflect.Type),
+		typenames: make(map[reflect.Type]string),
+	}
+}
+
+// Register registers a user defined function to DefaultRegistry.
+//
+// Panics if the name is invalid or already registered, or fn is not a
+// function.
+func Register(name string, fn interface{}) {
+	DefaultRegistry.Register(name, fn)
+}
+
+// Register names a user defined function. The name consists of letters,
+// digits, '_' and '.'.
+//
+// Panics if the name is invalid or already registered, or fn is not a
+// function.
+func (reg *Registry) Register(name string, fn interface{}) {
+	if !isRegistryName(name) {
+		panic(errorf("invalid registry name %q", name))
+	}
+	if _, ok := reg.funcs[name]; ok {
+		panic(errorf("registry name %q is registered more than once", name))
+	}
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		panic(errorf("registry name %q is not bound to a function", name))
+	}
+	reg.funcs[name] = fn
+	if _, ok := reg.names[v.Pointer()]; ok {
+		reg.ambiguous[v.Pointer()] = true
+	}
+	reg.names[v.Pointer()] = name
+}
+
+// Marshal serializes the pattern into a deterministic text form, which
+// could be loaded back by Unmarshal.
+func (reg *Registry) Marshal(pat Pattern) ([]byte, error) {
+	node, err := reg.encode(pat)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	node.write(&buf, 0)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// Unmarshal loads the pattern serialized by Marshal.
+//
+// Returns *SyntaxError with the line and column if the text is invalid.
+func (reg *Registry) Unmarshal(text []byte) (Pattern, error) {
+	d := &sexpDecoder{
+		src:   string(text),
+		reg:   reg,
+		pcalc: positionCalculator{text: string(text)},
+	}
+	node, err := d.parse()
+	if err != nil {
+		return nil, err
+	}
+	return d.pattern(node)
+}
+
+// UnmarshalPattern loads the pattern serialized by MarshalText, using
+// DefaultRegistry.
+func UnmarshalPattern(text []byte) (Pattern, error) {
+	return DefaultRegistry.Unmarshal(text)
+}
+
+// UnmarshalText loads the pattern serialized by MarshalText.
+func (tp *TextPattern) UnmarshalText(text []byte) error {
+	pat, err := UnmarshalPattern(text)
+	if err != nil {
+		return err
+	}
+	tp.Pattern = pat
+	return nil
+}
+
+func isRegistryName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Gets the reference to the user defined function.
+func (reg *Registry) reference(fn interface{}, what string) (*sexp, error) {
+	v := reflect.ValueOf(fn)
+	if !v.IsValid() || v.IsNil() {
+		return nil, errorf("%s is nil", what)
+	}
+	ptr := v.Pointer()
+	if reg.ambiguous[ptr] {
+		return nil, errorf("%s %s is ambiguous in registry", what, reg.names[ptr])
+	}
+	name, ok := reg.names[ptr]
+	if !ok {
+		return nil, errorf("%s %#x is not registered", what, ptr)
+	}
+	return &sexp{atom: "@" + name}, nil
+}
+
+func sexpAtom(atom string) *sexp {
+	return &sexp{atom: atom}
+}
+
+func sexpString(s string) *sexp {
+	return &sexp{atom: strconv.Quote(s)}
+}
+
+func sexpInt(n int) *sexp {
+	return &sexp{atom: strconv.Itoa(n)}
+}
+
+func sexpList(head string, items ...*sexp) *sexp {
+	return &sexp{list: append([]*sexp{sexpAtom(head)}, items...)}
+}
+
+// Encodes the pattern into the serialization tree.
+func (reg *Registry) encode(pat Pattern) (*sexp, error) {
+	var subs []Pattern
+	var node *sexp
+	switch pat := pat.(type) {
+	case *patternBoolean:
+		if pat.ok {
+			return sexpAtom("true"), nil
+		}
+		return sexpAtom("false"), nil
+	case patternAnyRune:
+		return sexpAtom("dot"), nil
+	case patternEOFPredicate:
+		return sexpAtom("eof"), nil
+	case *patternLineAnchorPredicate:
+		if pat.linestart {
+			return sexpAtom("sol"), nil
+		}
+		return sexpAtom("eol"), nil
+
+	case *patternText:
+		head := "t"
+		if pat.insensitive {
+			head = "ti"
+		}
+		return sexpList(head, sexpString(pat.text)), nil
+	case *patternBackwardPredicate:
+		return sexpList("b", sexpString(pat.text)), nil
+	case *patternTextSet:
+		head := "ts"
+		if pat.insensitive {
+			head = "tsi"
+		}
+		node = sexpList(head)
+		for _, s := range pat.sorted {
+			node.list = append(node.list, sexpString(s))
+		}
+		return node, nil
+	case *patternTextReferring:
+		if pat.indexed {
+			return sexpList("refn", sexpInt(pat.index)), nil
+		}
+		return sexpList("ref", sexpString(pat.grpname)), nil
+	case *patternBackwardPredicateReferring:
+		if pat.indexed {
+			return sexpList("refbn", sexpInt(pat.index)), nil
+		}
+		return sexpList("refb", sexpString(pat.grpname)), nil
+
+	case *patternRuneSet:
+		head := "s"
+		if pat.not {
+			head = "ns"
+		}
+		return sexpList(head, sexpString(string(pat.charset))), nil
+	case *patternRuneRange:
+		head := "r"
+		if pat.not {
+			head = "nr"
+		}
+		node = sexpList(head)
+		for _, pair := range pat.ranges {
+			node.list = append(node.list, sexpAtom(runeLiteral(pair.low)), sexpAtom(runeLiteral(pair.high)))
+		}
+		return node, nil
+	case *patternUnicodeRanges:
+		node = sexpList("u")
+		appendUnicodeNames(node, pat)
+		return node, nil
+	case *patternUnicodeRangesWithExcluding:
+		node = sexpList("u")
+		appendUnicodeNames(node, &pat.include)
+		appendUnicodeNames(node, &pat.exclude)
+		return node, nil
+
+	case *patternSequence:
+		node, subs = sexpList("seq"), pat.pats
+	case *patternAlternative:
+		node, subs = sexpList("alt"), pat.pats
+	case *patternSkip:
+		return sexpList("skip", sexpInt(pat.n)), nil
+	case *patternAnyRuneUntil:
+		if pat.without {
+			node, subs = sexpList("until"), []Pattern{pat.pat}
+		} else {
+			node, subs = sexpList("untilb"), []Pattern{pat.pat}
+		}
+	case *patternQualifierAtLeast:
+		node, subs = sexpList("q", sexpInt(pat.n)), []Pattern{pat.pat}
+	case *patternQualifierOptional:
+		node, subs = sexpList("opt"), []Pattern{pat.pat}
+	case *patternQualifierRange:
+		node, subs = sexpList("qmn", sexpInt(pat.m), sexpInt(pat.n)), []Pattern{pat.pat}
+
+	case *patternPredicate:
+		switch {
+		case pat.not:
+			node, subs = sexpList("not"), []Pattern{pat.pat}
+		case pat.keep:
+			node, subs = sexpList("peek"), []Pattern{pat.pat}
+		default:
+			node, subs = sexpList("test"), []Pattern{pat.pat}
+		}
+	case *patternAndPredicate:
+		node, subs = sexpList("and"), pat.pats
+	case *patternOrPredicate:
+		node, subs = sexpList("or"), pat.pats
+	case *patternAbort:
+		return sexpList("abort", sexpString(pat.msg)), nil
+	case *patternIf:
+		node, subs = sexpList("if"), []Pattern{pat.cond, pat.yes, pat.no}
+	case *patternSwitch:
+		node = sexpList("switch")
+		for _, c := range pat.cases {
+			subs = append(subs, c.cond, c.then)
+		}
+		subs = append(subs, pat.otherwise)
+
+	case *patternGrouping:
+		if pat.grpname == "" {
+			node, subs = sexpList("g"), []Pattern{pat.pat}
+		} else {
+			node, subs = sexpList("ng", sexpString(pat.grpname)), []Pattern{pat.pat}
+		}
+	case *patternTrigger:
+		ref, err := reg.reference(pat.trigger, "hook")
+		if err != nil {
+			return nil, err
+		}
+		node, subs = sexpList("trigger", ref), []Pattern{pat.pat}
+	case *patternDeferredTrigger:
+		ref, err := reg.reference(pat.trigger, "hook")
+		if err != nil {
+			return nil, err
+		}
+		node, subs = sexpList("oncommit", ref), []Pattern{pat.pat}
+	case *patternCommit:
+		node, subs = sexpList("commit"), []Pattern{pat.pat}
+	case *patternInjector:
+		switch origin := pat.origin.(type) {
+		case int:
+			node = sexpList("trunc", sexpInt(origin))
+		case func(string) bool:
+			ref, err := reg.reference(origin, "checker")
+			if err != nil {
+				return nil, err
+			}
+			node = sexpList("check", ref)
+		default:
+			ref, err := reg.reference(origin, "injector")
+			if err != nil {
+				return nil, err
+			}
+			node = sexpList("inject", ref)
+		}
+		subs = []Pattern{pat.pat}
+
+	case *patternLet:
+		names := make([]string, 0, len(pat.vars))
+		for name := range pat.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		vars := &sexp{list: []*sexp{}}
+		for _, name := range names {
+			def, err := reg.encode(pat.vars[name])
+			if err != nil {
+				return nil, err
+			}
+			vars.list = append(vars.list, &sexp{list: []*sexp{sexpString(name), def}})
+		}
+		head := "let"
+		if pat.auto {
+			head = "clet"
+		}
+		node, subs = sexpList(head, vars), []Pattern{pat.pat}
+	case *patternRule:
+		head := "silent"
+		if pat.transparent {
+			head = "transparent"
+		}
+		node, subs = sexpList(head), []Pattern{pat.pat}
+	case *patternCaptureVariable:
+		if pat.cons == nil {
+			return sexpList("v", sexpString(pat.varname)), nil
+		}
+		return sexpList("cv", sexpString(pat.varname)), nil
+	case *patternCaptureToken:
+		node, subs = sexpList("ck", sexpInt(pat.toktype)), []Pattern{pat.pat}
+	case *patternCaptureCons:
+		ref, err := reg.reference(pat.cons, "constructor")
+		if err != nil {
+			return nil, err
+		}
+		node, subs = sexpList("cc", ref), []Pattern{pat.pat}
+	case *patternCaptureTerm:
+		ref, err := reg.reference(pat.cons, "constructor")
+		if err != nil {
+			return nil, err
+		}
+		node, subs = sexpList("ct", ref), []Pattern{pat.pat}
+	case *patternCaptureContext:
+		ref, err := reg.reference(pat.cons, "constructor")
+		if err != nil {
+			return nil, err
+		}
+		node, subs = sexpList("cx", ref), []Pattern{pat.pat}
+	case *patternCaptureConst:
+		node = sexpList("const")
+		for _, value := range pat.values {
+			data, err := reg.encodeCapture(value)
+			if err != nil {
+				return nil, err
+			}
+			node.list = append(node.list, sexpString(string(data)))
+		}
+		return node, nil
+	case *patternCapturePosition:
+		return sexpAtom("pos"), nil
+	case *patternCaptureFold:
+		ref, err := reg.reference(pat.fold, "folder")
+		if err != nil {
+			return nil, err
+		}
+		node, subs = sexpList("fold", ref), []Pattern{pat.pat}
+	case *patternCaptureTable:
+		node, subs = sexpList("table"), []Pattern{pat.pat}
+	case *patternCaptureBack:
+		return sexpList("back", sexpString(pat.grpname)), nil
+	case *patternCaptureArgument:
+		return sexpList("arg", sexpInt(pat.n)), nil
+	case *patternCaptureMatchTime:
+		ref, err := reg.reference(pat.fn, "match-time function")
+		if err != nil {
+			return nil, err
+		}
+		node, subs = sexpList("cmt", ref), []Pattern{pat.pat}
+	case *patternTemplate:
+		params := &sexp{list: []*sexp{}}
+		for _, param := range pat.params {
+			params.list = append(params.list, sexpString(param))
+		}
+		node, subs = sexpList("template", params), []Pattern{pat.body}
+	case *patternCallTemplate:
+		head := "call"
+		if pat.cons != nil {
+			head = "ccall"
+		}
+		node, subs = sexpList(head, sexpString(pat.varname)), pat.args
+
+	case *patternSymbolDeclare:
+		node, subs = sexpList("sym", sexpString(pat.setname)), []Pattern{pat.pat}
+	case *patternSymbolSet:
+		return sexpList("tsym", sexpString(pat.setname)), nil
+	case *patternSymbolScope:
+		node, subs = sexpList("symscope"), []Pattern{pat.pat}
+	case *patternAction:
+		ref, err := reg.reference(pat.fn, "action")
+		if err != nil {
+			return nil, err
+		}
+		node, subs = sexpList("action", ref), []Pattern{pat.pat}
+	case *patternActionText:
+		ref, err := reg.reference(pat.fn, "action")
+		if err != nil {
+			return nil, err
+		}
+		node, subs = sexpList("actiontext", ref), []Pattern{pat.pat}
+
+	default:
+		return nil, errorf("unable to serialize pattern %s", pat)
+	}
+
+	for _, sub := range subs {
+		item, err := reg.encode(sub)
+		if err != nil {
+			return nil, err
+		}
+		node.list = append(node.list, item)
+	}
+	return node, nil
+}
+
+func appendUnicodeNames(node *sexp, pat *patternUnicodeRanges) {
+	for _, name := range pat.names {
+		if pat.not {
+			name = "-" + name
+		}
+		node.list = append(node.list, sexpString(name))
+	}
+}
+
+// Writes the flat form if it fits in the line, otherwise breaks the items
+// into indented lines.
+func (node *sexp) write(buf *bytes.Buffer, indent int) {
+	flat := node.flat()
+	if len(node.list) == 0 || indent+len(flat) <= serializeLineWidth {
+		buf.WriteString(flat)
+		return
+	}
+
+	// Atoms following the head stay in the first line.
+	buf.WriteByte('(')
+	inline := node.list[0].list == nil
+	for i, item := range node.list {
+		if i > 0 && (!inline || item.list != nil) {
+			inline = false
+			buf.WriteByte('\n')
+			buf.WriteString(strings.Repeat(" ", indent+2))
+		} else if i > 0 {
+			buf.WriteByte(' ')
+		}
+		item.write(buf, indent+2)
+	}
+	buf.WriteByte(')')
+}
+
+func (node *sexp) flat() string {
+	if node.list == nil {
+		return node.atom
+	}
+	strs := make([]string, len(node.list))
+	for i, item := range node.list {
+		strs[i] = item.flat()
+	}
+	return "(" + strings.Join(strs, " ") + ")"
+}
+
+// Parser and decoder of the serialized text.
+type sexpDecoder struct {
+	src   string
+	at    int
+	reg   *Registry
+	pcalc positionCalculator
+}
+
+func (d *sexpDecoder) errorAt(at int, format string, v ...interface{}) error {
+	return &SyntaxError{
+		Position: d.pcalc.calculate(at),
+		Message:  fmt.Sprintf(format, v...),
+	}
+}
+
+// Skips white spaces and comments started with ';'.
+func (d *sexpDecoder) skip() {
+	for d.at < len(d.src) {
+		switch d.src[d.at] {
+		case ' ', '\t', '\r', '\n':
+			d.at++
+		case ';':
+			for d.at < len(d.src) && d.src[d.at] != '\n' {
+				d.at++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (d *sexpDecoder) parse() (*sexp, error) {
+	node, err := d.node()
+	if err != nil {
+		return nil, err
+	}
+	d.skip()
+	if d.at < len(d.src) {
+		return nil, d.errorAt(d.at, "unexpected %q", d.src[d.at:d.at+1])
+	}
+	return node, nil
+}
+
+func (d *sexpDecoder) node() (*sexp, error) {
+	d.skip()
+	start := d.at
+	if d.at >= len(d.src) {
+		return nil, d.errorAt(d.at, "unexpected end of text")
+	}
+
+	switch d.src[d.at] {
+	case '(':
+		d.at++
+		node := &sexp{at: start, list: []*sexp{}}
+		for {
+			d.skip()
+			if d.at >= len(d.src) {
+				pos := d.pcalc.calculate(start)
+				return nil, d.errorAt(d.at, "expect ')' to close the one at %d:%d",
+					pos.Line+1, pos.Column+1)
+			}
+			if d.src[d.at] == ')' {
+				d.at++
+				return node, nil
+			}
+			item, err := d.node()
+			if err != nil {
+				return nil, err
+			}
+			node.list = append(node.list, item)
+		}
+	case ')':
+		return nil, d.errorAt(d.at, "unexpected ')'")
+	case '"', '\'':
+		quote := d.src[d.at]
+		d.at++
+		for d.at < len(d.src) && d.src[d.at] != quote {
+			if d.src[d.at] == '\\' {
+				d.at++
+			}
+			d.at++
+		}
+		if d.at >= len(d.src) {
+			pos := d.pcalc.calculate(start)
+			return nil, d.errorAt(len(d.src), "expect %q to close the one at %d:%d",
+				string(quote), pos.Line+1, pos.Column+1)
+		}
+		d.at++
+		return &sexp{at: start, atom: d.src[start:d.at]}, nil
+	default:
+		for d.at < len(d.src) && strings.IndexByte(" \t\r\n;()\"'", d.src[d.at]) < 0 {
+			d.at++
+		}
+		return &sexp{at: start, atom: d.src[start:d.at]}, nil
+	}
+}
+
+// Decodes the pattern.
+func (d *sexpDecoder) pattern(node *sexp) (Pattern, error) {
+	if node.list == nil {
+		switch node.atom {
+		case "true":
+			return True, nil
+		case "false":
+			return False, nil
+		case "dot":
+			return Dot, nil
+		case "eof":
+			return EOF, nil
+		case "sol":
+			return SOL, nil
+		case "eol":
+			return EOL, nil
+		case "pos":
+			return Cp, nil
+		}
+		return nil, d.errorAt(node.at, "unknown pattern %q", node.atom)
+	}
+	if len(node.list) == 0 || node.list[0].list != nil {
+		return nil, d.errorAt(node.at, "expect pattern name")
+	}
+
+	head, args := node.list[0].atom, node.list[1:]
+	switch head {
+	case "t", "ti", "b", "ref", "refb", "s", "ns", "abort", "v", "cv", "tsym", "back":
+		if err := d.arity(node, 1); err != nil {
+			return nil, err
+		}
+		s, err := d.string(args[0])
+		if err != nil {
+			return nil, err
+		}
+		switch head {
+		case "t":
+			return &patternText{text: s}, nil
+		case "ti":
+			return &patternText{insensitive: true, text: s}, nil
+		case "b":
+			return &patternBackwardPredicate{text: s}, nil
+		case "ref":
+			return Ref(s), nil
+		case "refb":
+			return RefB(s), nil
+		case "s":
+			return &patternRuneSet{charset: []rune(s)}, nil
+		case "ns":
+			return &patternRuneSet{not: true, charset: []rune(s)}, nil
+		case "abort":
+			return Abort(s), nil
+		case "v":
+			return V(s), nil
+		case "cv":
+			return CV(s), nil
+		case "back":
+			return Cb(s), nil
+		default:
+			return TSym(s), nil
+		}
+
+	case "ts", "tsi":
+		textset := make([]string, len(args))
+		for i, arg := range args {
+			var err error
+			textset[i], err = d.string(arg)
+			if err != nil {
+				return nil, err
+			}
+		}
+		pat := &patternTextSet{insensitive: head == "tsi"}
+		pat.set(textset)
+		return pat, nil
+
+	case "const":
+		values := make([]Capture, len(args))
+		for i, arg := range args {
+			data, err := d.string(arg)
+			if err != nil {
+				return nil, err
+			}
+			values[i], err = d.reg.decodeCapture([]byte(data))
+			if err != nil {
+				return nil, d.errorAt(arg.at, "%s", strings.TrimPrefix(err.Error(), "peg: "))
+			}
+		}
+		return Cc(values...), nil
+
+	case "r", "nr":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return nil, d.errorAt(node.at, "expect pairs of runes")
+		}
+		pat := &patternRuneRange{not: head == "nr"}
+		for i := 0; i < len(args); i += 2 {
+			low, err := d.rune(args[i])
+			if err != nil {
+				return nil, err
+			}
+			high, err := d.rune(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			pat.ranges = append(pat.ranges, struct{ low, high rune }{low, high})
+		}
+		return pat, nil
+
+	case "u":
+		return d.unicode(node)
+
+	case "skip", "arg", "refn", "refbn":
+		if err := d.arity(node, 1); err != nil {
+			return nil, err
+		}
+		n, err := d.int(args[0])
+		if err != nil {
+			return nil, err
+		}
+		switch head {
+		case "arg":
+			return Carg(n), nil
+		case "refn":
+			return RefN(n), nil
+		case "refbn":
+			return RefBN(n), nil
+		}
+		return &patternSkip{n: n}, nil
+
+	case "q", "ck", "trunc":
+		if err := d.arity(node, 2); err != nil {
+			return nil, err
+		}
+		n, err := d.int(args[0])
+		if err != nil {
+			return nil, err
+		}
+		pat, err := d.pattern(args[1])
+		if err != nil {
+			return nil, err
+		}
+		switch head {
+		case "q":
+			return &patternQualifierAtLeast{n: n, pat: pat}, nil
+		case "ck":
+			return CK(n, pat), nil
+		default:
+			return Trunc(n, pat), nil
+		}
+
+	case "qmn":
+		if err := d.arity(node, 3); err != nil {
+			return nil, err
+		}
+		m, err := d.int(args[0])
+		if err != nil {
+			return nil, err
+		}
+		n, err := d.int(args[1])
+		if err != nil {
+			return nil, err
+		}
+		pat, err := d.pattern(args[2])
+		if err != nil {
+			return nil, err
+		}
+		return &patternQualifierRange{m: m, n: n, pat: pat}, nil
+
+	case "until", "untilb", "opt", "test", "not", "peek", "g", "symscope", "silent", "transparent", "table", "commit":
+		if err := d.arity(node, 1); err != nil {
+			return nil, err
+		}
+		pat, err := d.pattern(args[0])
+		if err != nil {
+			return nil, err
+		}
+		switch head {
+		case "until":
+			return Until(pat), nil
+		case "untilb":
+			return UntilB(pat), nil
+		case "opt":
+			return &patternQualifierOptional{pat: pat}, nil
+		case "test":
+			return Test(pat), nil
+		case "not":
+			return Not(pat), nil
+		case "peek":
+			return Peek(pat), nil
+		case "g":
+			return G(pat), nil
+		case "silent":
+			return Silent(pat), nil
+		case "transparent":
+			return Transparent(pat), nil
+		case "table":
+			return Ct(pat), nil
+		case "commit":
+			return Commit(pat), nil
+		default:
+			return SymScope(pat), nil
+		}
+
+	case "ng", "sym":
+		if err := d.arity(node, 2); err != nil {
+			return nil, err
+		}
+		s, err := d.string(args[0])
+		if err != nil {
+			return nil, err
+		}
+		pat, err := d.pattern(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if head == "ng" {
+			return NG(s, pat), nil
+		}
+		return Sym(s, pat), nil
+
+	case "seq", "alt", "and", "or", "if", "switch":
+		pats, err := d.patterns(args)
+		if err != nil {
+			return nil, err
+		}
+		switch head {
+		case "seq":
+			return &patternSequence{pats: pats}, nil
+		case "alt":
+			return &patternAlternative{pats: pats}, nil
+		case "and":
+			return &patternAndPredicate{pats: pats}, nil
+		case "or":
+			return &patternOrPredicate{pats: pats}, nil
+		case "if":
+			if err := d.arity(node, 3); err != nil {
+				return nil, err
+			}
+			return If(pats[0], pats[1], pats[2]), nil
+		default:
+			if len(pats) < 3 || len(pats)%2 != 1 {
+				return nil, d.errorAt(node.at, "expect cond-then pairs and otherwise")
+			}
+			return Switch(pats[0], pats[1], pats[2:]...), nil
+		}
+
+	case "trigger", "oncommit", "inject", "check", "cc", "ct", "cx", "fold", "cmt", "action", "actiontext":
+		if err := d.arity(node, 2); err != nil {
+			return nil, err
+		}
+		fn, err := d.reference(args[0])
+		if err != nil {
+			return nil, err
+		}
+		pat, err := d.pattern(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return d.callback(head, args[0], fn, pat)
+
+	case "let", "clet":
+		if err := d.arity(node, 2); err != nil {
+			return nil, err
+		}
+		if args[0].list == nil {
+			return nil, d.errorAt(args[0].at, "expect variable definitions")
+		}
+		vars := make(map[string]Pattern, len(args[0].list))
+		for _, def := range args[0].list {
+			if len(def.list) != 2 {
+				return nil, d.errorAt(def.at, "expect variable definition")
+			}
+			name, err := d.string(def.list[0])
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := vars[name]; ok {
+				return nil, d.errorAt(def.at, "variable %q is defined more than once", name)
+			}
+			vars[name], err = d.pattern(def.list[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+		pat, err := d.pattern(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if head == "clet" {
+			return CLet(vars, pat), nil
+		}
+		return Let(vars, pat), nil
+
+	case "template":
+		if err := d.arity(node, 2); err != nil {
+			return nil, err
+		}
+		if args[0].list == nil {
+			return nil, d.errorAt(args[0].at, "expect template parameters")
+		}
+		params := make([]string, len(args[0].list))
+		for i, param := range args[0].list {
+			var err error
+			params[i], err = d.string(param)
+			if err != nil {
+				return nil, err
+			}
+		}
+		body, err := d.pattern(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return Template(params, body), nil
+
+	case "call", "ccall":
+		if len(args) == 0 {
+			return nil, d.errorAt(node.at, "expect template name")
+		}
+		name, err := d.string(args[0])
+		if err != nil {
+			return nil, err
+		}
+		pats, err := d.patterns(args[1:])
+		if err != nil {
+			return nil, err
+		}
+		if head == "ccall" {
+			return CCall(name, pats...), nil
+		}
+		return Call(name, pats...), nil
+	}
+	return nil, d.errorAt(node.at, "unknown pattern %q", head)
+}
+
+func (d *sexpDecoder) patterns(nodes []*sexp) ([]Pattern, error) {
+	pats := make([]Pattern, len(nodes))
+	for i := range nodes {
+		var err error
+		pats[i], err = d.pattern(nodes[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pats, nil
+}
+
+func (d *sexpDecoder) unicode(node *sexp) (Pattern, error) {
+	var include, exclude []string
+	for _, arg := range node.list[1:] {
+		name, err := d.string(arg)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(name, "-") {
+			exclude = append(exclude, name[1:])
+		} else {
+			include = append(include, name)
+		}
+	}
+
+	var err error
+	switch {
+	case len(include) == 0 && len(exclude) == 0:
+		return nil, d.errorAt(node.at, "expect unicode range names")
+	case len(exclude) == 0:
+		pat := &patternUnicodeRanges{}
+		if err = pat.set(include); err == nil {
+			return pat, nil
+		}
+	case len(include) == 0:
+		pat := &patternUnicodeRanges{not: true}
+		if err = pat.set(exclude); err == nil {
+			return pat, nil
+		}
+	default:
+		pat := &patternUnicodeRangesWithExcluding{}
+		pat.exclude.not = true
+		if err = pat.include.set(include); err == nil {
+			if err = pat.exclude.set(exclude); err == nil {
+				return pat, nil
+			}
+		}
+	}
+	return nil, d.errorAt(node.at, "%s", strings.TrimPrefix(err.Error(), "peg: "))
+}
+
+// Binds the user defined function with expected type.
+func (d *sexpDecoder) callback(head string, ref *sexp, fn interface{}, pat Pattern) (Pattern, error) {
+	switch head {
+	case "trigger":
+		if hook, ok := fn.(func(string, Position) error); ok {
+			return Trigger(hook, pat), nil
+		}
+	case "oncommit":
+		if hook, ok := fn.(func(string, Position) error); ok {
+			return OnCommit(hook, pat), nil
+		}
+	case "inject":
+		if inject, ok := fn.(func(string) (int, bool)); ok {
+			return Inject(inject, pat), nil
+		}
+	case "check":
+		if check, ok := fn.(func(string) bool); ok {
+			return Check(check, pat), nil
+		}
+	case "cc":
+		switch cons := fn.(type) {
+		case NonTerminalConstructor:
+			return CC(cons, pat), nil
+		case func([]Capture) (Capture, error):
+			return CC(cons, pat), nil
+		}
+	case "ct":
+		switch cons := fn.(type) {
+		case TerminalConstructor:
+			return CT(cons, pat), nil
+		case func(string, Position) (Capture, error):
+			return CT(cons, pat), nil
+		}
+	case "cx":
+		switch cons := fn.(type) {
+		case ContextConstructor:
+			return CX(cons, pat), nil
+		case func(CaptureContext, []Capture) (Capture, error):
+			return CX(cons, pat), nil
+		}
+	case "fold":
+		switch fold := fn.(type) {
+		case Folder:
+			return Cf(fold, pat), nil
+		case func(Capture, Capture) (Capture, error):
+			return Cf(fold, pat), nil
+		}
+	case "cmt":
+		switch mt := fn.(type) {
+		case MatchTimeFunc:
+			return Cmt(mt, pat), nil
+		case func(CaptureContext, []Capture) (bool, []Capture, error):
+			return Cmt(mt, pat), nil
+		}
+	case "action":
+		switch fn := fn.(type) {
+		case ActionFunc:
+			return Action(fn, pat), nil
+		case func([]interface{}) (interface{}, error):
+			return Action(fn, pat), nil
+		}
+	case "actiontext":
+		switch fn := fn.(type) {
+		case TextActionFunc:
+			return ActionText(fn, pat), nil
+		case func(string) (interface{}, error):
+			return ActionText(fn, pat), nil
+		}
+	}
+	return nil, d.errorAt(ref.at, "function %s is not suitable for %s", ref.atom, head)
+}
+
+func (d *sexpDecoder) arity(node *sexp, n int) error {
+	if len(node.list)-1 != n {
+		return d.errorAt(node.at, "%s requires %d arguments, but %d given",
+			node.list[0].atom, n, len(node.list)-1)
+	}
+	return nil
+}
+
+func (d *sexpDecoder) string(node *sexp) (string, error) {
+	if node.list == nil && strings.HasPrefix(node.atom, "\"") {
+		if s, err := strconv.Unquote(node.atom); err == nil {
+			return s, nil
+		}
+	}
+	return "", d.errorAt(node.at, "expect string")
+}
+
+func (d *sexpDecoder) int(node *sexp) (int, error) {
+	if node.list == nil {
+		if n, err := strconv.Atoi(node.atom); err == nil {
+			return n, nil
+		}
+	}
+	return 0, d.errorAt(node.at, "expect integer")
+}
+
+func (d *sexpDecoder) rune(node *sexp) (rune, error) {
+	if node.list == nil && strings.HasPrefix(node.atom, "'") {
+		if s, err := strconv.Unquote(node.atom); err == nil {
+			r, _ := utf8.DecodeRuneInString(s)
+			return r, nil
+		}
+	}
+	if node.list == nil {
+		if n, err := strconv.ParseInt(node.atom, 10, 32); err == nil {
+			return rune(n), nil
+		}
+	}
+	return 0, d.errorAt(node.at, "expect rune")
+}
+
+func (d *sexpDecoder) reference(node *sexp) (interface{}, error) {
+	if node.list != nil || !strings.HasPrefix(node.atom, "@") {
+		return nil, d.errorAt(node.at, "expect function reference")
+	}
+	fn, ok := d.reg.funcs[node.atom[1:]]
+	if !ok {
+		return nil, d.errorAt(node.at, "function %s is not registered", node.atom)
+	}
+	return fn, nil
+}
+
+// MarshalText methods serialize the patterns using DefaultRegistry.
+
+func (pat *patternBoolean) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat patternAnyRune) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat patternEOFPredicate) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternLineAnchorPredicate) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternText) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternBackwardPredicate) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternTextSet) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternTextReferring) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternBackwardPredicateReferring) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternRuneSet) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternRuneRange) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternUnicodeRanges) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternUnicodeRangesWithExcluding) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternSequence) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternAlternative) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternSkip) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternAnyRuneUntil) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternQualifierAtLeast) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternQualifierOptional) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternQualifierRange) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternPredicate) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternAndPredicate) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternOrPredicate) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternAbort) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternIf) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternSwitch) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternGrouping) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternTrigger) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternDeferredTrigger) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCommit) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternInjector) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternLet) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternRule) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCaptureVariable) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCaptureToken) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCaptureCons) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCaptureTerm) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCaptureContext) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCaptureConst) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCapturePosition) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCaptureFold) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCaptureTable) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCaptureBack) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCaptureArgument) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCaptureMatchTime) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternAction) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternActionText) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternTemplate) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternCallTemplate) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternClosure) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternSymbolDeclare) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternSymbolSet) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
+
+func (pat *patternSymbolScope) MarshalText() ([]byte, error) {
+	return DefaultRegistry.Marshal(pat)
+}
@@ -0,0 +1,256 @@
+package peg
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func serializeTerm(s string, _ Position) (Capture, error) {
+	return Text(s), nil
+}
+
+func serializeCons(subs []Capture) (Capture, error) {
+	return Table(subs), nil
+}
+
+func serializeContext(_ CaptureContext, subs []Capture) (Capture, error) {
+	return Table(subs), nil
+}
+
+func serializeHook(string, Position) error {
+	return nil
+}
+
+func serializeInject(s string) (int, bool) {
+	return len(s), true
+}
+
+func serializeCheck(string) bool {
+	return true
+}
+
+func serializeFold(acc, _ Capture) (Capture, error) {
+	return acc, nil
+}
+
+func serializeMatchTime(_ CaptureContext, subs []Capture) (bool, []Capture, error) {
+	return true, subs, nil
+}
+
+func serializeAction(vals []interface{}) (interface{}, error) {
+	return len(vals), nil
+}
+
+func serializeTextAction(s string) (interface{}, error) {
+	return s, nil
+}
+
+func init() {
+	Register("test.term", serializeTerm)
+}
+
+func newSerializeTestRegistry() *Registry {
+	reg := NewRegistry()
+	reg.Register("term", serializeTerm)
+	reg.Register("cons", serializeCons)
+	reg.Register("context", serializeContext)
+	reg.Register("hook", serializeHook)
+	reg.Register("inject", serializeInject)
+	reg.Register("check", serializeCheck)
+	reg.Register("fold", serializeFold)
+	reg.Register("matchtime", serializeMatchTime)
+	reg.Register("action", serializeAction)
+	reg.Register("action.text", serializeTextAction)
+	return reg
+}
+
+// Tests that unmarshaled patterns are structurally equal to the original
+// ones, and are marshaled to the same text.
+func TestSerializeRoundTrip(t *testing.T) {
+	reg := newSerializeTestRegistry()
+	scope := map[string]Pattern{
+		"list": Template([]string{"item", "sep"},
+			Seq(T("["), J0(V("item"), V("sep")), T("]"))),
+		"wrap":  Template([]string{"item"}, Seq(T("("), V("item"), T(")"))),
+		"empty": Template([]string{}, True),
+		"item":  T("x"),
+		"digit": CK(0, R('0', '9')),
+		"expr":  Alt(Seq(T("("), CV("expr"), T(")")), CK(1, Q1(R('a', 'z')))),
+	}
+	data := []Pattern{
+		Seq(T("ab"), TI("Cd"), Dot, S("x\"yz"), NS("xyz"), R('0', '9', 'a', 'f'), NR('0', '9', -1, -1)),
+		Seq(U("Lu", "Greek"), U("-Letter"), U("Print", "-Digit"), EOF),
+		Q1(Alt(TS("a", "ab", "abc", ""), TSI("Hello", "hel"), SOL, EOL)),
+		Seq(Q0(S(" \n\t\u2028")), Q1(CK(2, Q1(R('a', 'z')))), Skip(2), Until(T(";")), UntilB(T(".")),
+			Qmn(1, 3, T("-")), Qnn(2, T("=")), Q0n(4, T("+"))),
+		Seq(NG("a", Q1(T("a"))), G(Q0(T("b"))), Ref("a"), Ref(""), RefB("a"), RefN(-1), RefBN(0),
+			Test(T("c")), Not(T("cd")), Peek(T("c")), And(T("c"), Dot), Or(T("x"), T("c")), B("c"), False),
+		Seq(If(T("a"), T("ab"), T("b")), When(T("1"), T("1")), Switch(T("1"), T("1"), T("2"), T("22"), Abort("bad\n"))),
+		Alt(CC(serializeCons, Seq(CT(serializeTerm, T("up")), CX(serializeContext, T("s")))),
+			Trigger(serializeHook, Q1(R('a', 'z'))), OnCommit(serializeHook, T(" ")), Commit(Dot)),
+		Seq(Inject(serializeInject, Q1(T("a"))), Check(serializeCheck, Q1(T("b"))), Trunc(2, Q0(T("c")))),
+		SymScope(Seq(Sym("v", Q1(R('a', 'z'))), T("="), TSym("v"), SymScope(Sym("v", T("x"))), TSym("v"))),
+		Let(scope, Seq(Call("list", V("digit"), T(",")), CCall("wrap", V("item")), Call("empty"), CV("expr"))),
+		Let(map[string]Pattern{}, True),
+		CLet(map[string]Pattern{
+			"ws":   Silent(Q0(T(" "))),
+			"num":  CK(0, Q1(R('0', '9'))),
+			"atom": Transparent(Alt(V("num"), Seq(T("("), V("ws"), V("atom"), T(")")))),
+		}, J1(V("atom"), T(","))),
+		Seq(Cp, Cf(serializeFold, J1(CK(0, R('0', '9')), CK(1, S("+-")))), Ct(Q0(CK(2, R('a', 'z')))),
+			Cb("x"), Cmt(serializeMatchTime, Q0(CK(3, T("y")))),
+			Cc(Text("c"), Table{&Position{}}, &Token{Type: 1, Value: "v"}), Carg(0)),
+		Action(serializeAction, J1(ActionText(serializeTextAction, Q1(R('a', 'z'))), T(","))),
+	}
+
+	for _, pat := range data {
+		text, err := reg.Marshal(pat)
+		if err != nil {
+			t.Errorf("Marshal(%s) => %s", pat, err)
+			continue
+		}
+		loaded, err := reg.Unmarshal(text)
+		if err != nil {
+			t.Errorf("Unmarshal(%q) => %s", text, err)
+			continue
+		}
+		if !Equal(loaded, pat) {
+			t.Errorf("Unmarshal(%q) => %s != %s", text, loaded, pat)
+		}
+		again, err := reg.Marshal(loaded)
+		if err != nil || string(again) != string(text) {
+			t.Errorf("Marshal(Unmarshal(%q)) => %q, %v", text, again, err)
+		}
+	}
+}
+
+// Tests the layout of serialized text.
+func TestSerializeText(t *testing.T) {
+	reg := newSerializeTestRegistry()
+	data := []struct {
+		pat  Pattern
+		text string
+	}{
+		{Seq(T("a"), Q0(R('0', '9')), CT(serializeTerm, TI("b"))),
+			"(seq (t \"a\") (q 0 (r '0' '9')) (ct @term (ti \"B\")))\n"},
+		{Let(map[string]Pattern{"b": T("b"), "a": V("b")}, V("a")),
+			"(let ((\"a\" (v \"b\")) (\"b\" (t \"b\"))) (v \"a\"))\n"},
+		{Alt(T(strings.Repeat("a", 40)), T(strings.Repeat("b", 40)), Skip(1)),
+			"(alt\n" +
+				"  (t \"" + strings.Repeat("a", 40) + "\")\n" +
+				"  (t \"" + strings.Repeat("b", 40) + "\")\n" +
+				"  (skip 1))\n"},
+	}
+	for _, d := range data {
+		text, err := reg.Marshal(d.pat)
+		if err != nil || string(text) != d.text {
+			t.Errorf("Marshal(%s) => %q, %v != %q", d.pat, text, err, d.text)
+		}
+	}
+
+	pat, err := reg.Unmarshal([]byte("; comment\n(seq\n  (t \"a\") ; trailing\n  eof)"))
+	if err != nil || pat.String() != Seq(T("a"), EOF).String() {
+		t.Errorf("Unmarshal with comments => %v, %v", pat, err)
+	}
+}
+
+// Tests serialization with DefaultRegistry.
+func TestSerializeDefaultRegistry(t *testing.T) {
+	var v struct {
+		Pat TextPattern
+	}
+	err := json.Unmarshal([]byte(`{"Pat": "(ct @test.term (t \"x\"))"}`), &v)
+	if err != nil {
+		t.Fatalf("json.Unmarshal => %s", err)
+	}
+	r, err := Match(v.Pat, "x")
+	if err != nil || !r.Ok || len(r.Captures) != 1 {
+		t.Errorf("Match(%s) => %v, %v", v.Pat, r, err)
+	}
+	text, err := json.Marshal(v.Pat)
+	if err != nil || string(text) != `"(ct @test.term (t \"x\"))\n"` {
+		t.Errorf("json.Marshal(%s) => %s, %v", v.Pat, text, err)
+	}
+	if _, err := CC(serializeCons, True).MarshalText(); err == nil {
+		t.Errorf("MarshalText of unregistered callback succeeded")
+	}
+}
+
+// Tests errors of serialization.
+func TestSerializeErrors(t *testing.T) {
+	reg := newSerializeTestRegistry()
+	closure := func(name string) NonTerminalConstructor {
+		return func(subs []Capture) (Capture, error) {
+			return &Variable{Name: name, Subs: subs}, nil
+		}
+	}
+	reg.Register("closure.a", closure("a"))
+	reg.Register("closure.b", closure("b"))
+	for _, pat := range []Pattern{
+		CC(func(subs []Capture) (Capture, error) { return nil, nil }, True),
+		CC(closure("a"), True),
+		Let(map[string]Pattern{"x": CT(nil, True)}, True),
+	} {
+		if _, err := reg.Marshal(pat); err == nil {
+			t.Errorf("Marshal(%s) succeeded, expect error", pat)
+		}
+	}
+
+	data := []struct {
+		text         string
+		line, column int
+	}{
+		{"", 1, 1},
+		{"(seq (t \"a\")", 1, 13},
+		{"(seq)\n)", 2, 1},
+		{"(t \"a)", 1, 7},
+		{"(seq\n  (foo))", 2, 3},
+		{"(t 1)", 1, 4},
+		{"(t \"a\" \"b\")", 1, 1},
+		{"(q x (t \"a\"))", 1, 4},
+		{"(r 'a')", 1, 1},
+		{"(u \"NoSuchRange\")", 1, 1},
+		{"(cc @term true)", 1, 5},
+		{"(cc @unknown true)", 1, 5},
+		{"(cc cons true)", 1, 5},
+		{"(switch true true)", 1, 1},
+		{"(let ((\"a\" true) (\"a\" false)) true)", 1, 18},
+		{"(() true)", 1, 1},
+		{"bool", 1, 1},
+	}
+	for _, d := range data {
+		_, err := reg.Unmarshal([]byte(d.text))
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Unmarshal(%q) => %v, expect syntax error", d.text, err)
+			continue
+		}
+		if serr.Position.Line+1 != d.line || serr.Position.Column+1 != d.column {
+			t.Errorf("Unmarshal(%q) => %s, expect error at %d:%d", d.text, err, d.line, d.column)
+		}
+	}
+}
+
+// Tests panics of Register.
+func TestRegisterPanics(t *testing.T) {
+	for _, d := range []struct {
+		name string
+		fn   interface{}
+	}{
+		{"", serializeCons},
+		{"a b", serializeCons},
+		{"cons", serializeCons},
+		{"str", "cons"},
+		{"nil", (func())(nil)},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) did not panic", d.name)
+				}
+			}()
+			newSerializeTestRegistry().Register(d.name, d.fn)
+		}()
+	}
+}
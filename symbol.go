@@ -0,0 +1,91 @@
+package peg
+
+import "fmt"
+
+// Underlying types implemented Pattern interface.
+type (
+	patternSymbolDeclare struct {
+		pat     Pattern
+		setname string
+	}
+
+	patternSymbolSet struct {
+		setname string
+	}
+
+	patternSymbolScope struct {
+		pat Pattern
+	}
+)
+
+// Sym adds the text matched by pat to the runtime symbol set named setname.
+//
+// The symbol is visible until leaving current symbol scope (see SymScope),
+// and would be removed if any parent pattern is later proved to be
+// dismatched.
+func Sym(setname string, pat Pattern) Pattern {
+	return &patternSymbolDeclare{pat: pat, setname: setname}
+}
+
+// TSym matches any symbol existed in the runtime symbol set named setname.
+// Like TS, the longest symbol is prior.
+//
+// It dismatches if the symbol set is empty or undefined.
+func TSym(setname string) Pattern {
+	return &patternSymbolSet{setname: setname}
+}
+
+// SymScope enters a new symbol scope then invokes the pattern,
+// the symbols added inside are dropped when leaving the scope.
+func SymScope(pat Pattern) Pattern {
+	return &patternSymbolScope{pat: pat}
+}
+
+// Adds matched text to symbol set.
+func (pat *patternSymbolDeclare) match(ctx *context) error {
+	if !ctx.justReturned() {
+		return ctx.call(pat.pat)
+	}
+
+	ret := ctx.ret
+	if !ret.ok {
+		return ctx.predicates(false)
+	}
+	ctx.consume(ret.n)
+	ctx.declare(pat.setname, ctx.span())
+	return ctx.commit()
+}
+
+// Matches the longest symbol.
+func (pat *patternSymbolSet) match(ctx *context) error {
+	n, ok := ctx.searchSymbol(pat.setname)
+	if !ok {
+		return ctx.predicates(false)
+	}
+	ctx.consume(n)
+	return ctx.commit()
+}
+
+// Setups symbol scope.
+func (pat *patternSymbolScope) match(ctx *context) error {
+	if !ctx.justReturned() {
+		ctx.enterSymbolScope()
+		return ctx.call(pat.pat)
+	}
+
+	ret := ctx.ret
+	ctx.leaveSymbolScope()
+	return ctx.returns(ret)
+}
+
+func (pat *patternSymbolDeclare) String() string {
+	return fmt.Sprintf("sym<%s>{%s}", pat.setname, pat.pat)
+}
+
+func (pat *patternSymbolSet) String() string {
+	return fmt.Sprintf("sym<%s>", pat.setname)
+}
+
+func (pat *patternSymbolScope) String() string {
+	return fmt.Sprintf("symscope{%s}", pat.pat)
+}
@@ -0,0 +1,49 @@
+package peg
+
+import "testing"
+
+// Tests Sym, TSym, SymScope.
+func TestSymbols(t *testing.T) {
+	ident := Q1(R('a', 'z'))
+	typedefs := Let(map[string]Pattern{
+		"typedef": Seq(T("typedef "), Sym("type", ident), T(";")),
+		"decl":    Seq(TSym("type"), T(" "), CK(0, ident), T(";")),
+		"block":   SymScope(Seq(T("{"), V("stmts"), T("}"))),
+		"stmt":    Alt(V("typedef"), V("decl"), V("block")),
+		"stmts":   Q0(V("stmt")),
+	}, Seq(V("stmts"), EOF))
+
+	data := []patternTestData{
+		{"", false, 0, false, ``, ``, TSym("undefined")},
+		{"a", true, 1, false, ``, ``, Seq(Sym("s", T("a")), Q0(TSym("s")))},
+		{"aaa", true, 3, false, ``, ``, Seq(Sym("s", T("a")), Q0(TSym("s")))},
+		{"ab", true, 1, false, ``, ``, Seq(Sym("s", T("a")), Q0(TSym("s")))},
+
+		// longest symbol is prior.
+		{"+ ++ ++", true, 7, false, ``, ``,
+			Seq(Sym("op", T("+")), T(" "), Sym("op", T("++")), T(" "), TSym("op"))},
+		{"++ + ++", true, 7, false, ``, ``,
+			Seq(Sym("op", T("++")), T(" "), Sym("op", T("+")), T(" "), TSym("op"))},
+
+		// symbols are dropped on backtracking.
+		{"a", false, 0, false, ``, ``, Seq(Alt(Seq(Sym("s", T("a")), False), True), TSym("s"))},
+		{"aa", true, 2, false, ``, ``, Seq(Alt(Seq(Sym("s", T("a")), T("b")), Sym("s", T("a"))), TSym("s"))},
+
+		// symbols are dropped on leaving scope.
+		{"aa", false, 0, false, ``, ``, Seq(SymScope(Sym("s", T("a"))), TSym("s"))},
+		{"aa", true, 2, false, ``, ``, SymScope(Seq(Sym("s", T("a")), TSym("s")))},
+		{"aa", true, 2, false, ``, ``, Seq(Sym("s", T("a")), SymScope(TSym("s")))},
+
+		// symbol sets are distinguished by names.
+		{"aa", false, 0, false, ``, ``, Seq(Sym("s", T("a")), TSym("t"))},
+
+		{"typedef int;int x;", true, 18, false, ``, `<0"x">`, typedefs},
+		{"int x;", false, 0, false, ``, ``, typedefs},
+		{"typedef t;{typedef u;u x;}t y;", true, 30, false, ``, `<0"x">, <0"y">`, typedefs},
+		{"typedef t;{typedef u;}u x;", false, 0, false, ``, ``, typedefs},
+	}
+
+	for _, d := range data {
+		runPatternTestData(t, d)
+	}
+}
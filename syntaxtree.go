@@ -0,0 +1,187 @@
+package peg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SyntaxNode is a node of the lossless concrete syntax tree, which is built
+// in the Config.SyntaxTree mode.
+//
+// Each invocation of variable (V, CV) or template (Call, CCall) is a rule
+// node named by the variable. The parameters of template are transparent,
+// while the variables passed as arguments are nodes.
+//
+// The text consumed by terminal patterns is kept in leaves with empty rule
+// name, and the continuous text consumed by the same pattern is merged into
+// one leaf. The text consumed between the rule nodes, such as white spaces
+// and comments, is kept in leaves as well. Thus, each node covers the exact
+// span of text, and concatenating the leaves reproduces the matched text.
+type SyntaxNode struct {
+	Rule     string
+	Text     string
+	Start    Position
+	End      Position
+	Children []*SyntaxNode
+
+	term Pattern // terminal pattern consumed the text of leaf
+}
+
+// ParseTree runs pattern matching on given text, then builds the concrete
+// syntax tree, guaranteeing that the text must only be full-matched when
+// success.
+func ParseTree(pat Pattern, text string) (tree *SyntaxNode, err error) {
+	return defaultConfig.ParseTree(pat, text)
+}
+
+// ParseTree runs pattern matching on given text, then builds the concrete
+// syntax tree, guaranteeing that the text must only be full-matched when
+// success.
+func (cfg Config) ParseTree(pat Pattern, text string) (tree *SyntaxNode, err error) {
+	config := cfg
+	config.SyntaxTree = true
+	r, err := config.Match(pat, text)
+	if err != nil {
+		return nil, err
+	}
+	if !r.Ok {
+		return nil, errorDismatch
+	}
+	if r.N != len(text) {
+		return nil, errorNotFullMatched
+	}
+	return r.Tree, nil
+}
+
+// IsTerminal tells if the node is a leaf.
+func (node *SyntaxNode) IsTerminal() bool {
+	return node.Rule == "" && len(node.Children) == 0
+}
+
+// Leaves returns the leaves in order.
+func (node *SyntaxNode) Leaves() []*SyntaxNode {
+	var leaves []*SyntaxNode
+	var walk func(node *SyntaxNode)
+	walk = func(node *SyntaxNode) {
+		if node.IsTerminal() {
+			leaves = append(leaves, node)
+			return
+		}
+		for _, sub := range node.Children {
+			walk(sub)
+		}
+	}
+	walk(node)
+	return leaves
+}
+
+func (node *SyntaxNode) String() string {
+	if node.IsTerminal() {
+		return strconv.Quote(node.Text)
+	}
+	strs := make([]string, len(node.Children))
+	for i, sub := range node.Children {
+		strs[i] = sub.String()
+	}
+	return node.Rule + "(" + strings.Join(strs, " ") + ")"
+}
+
+// Tells if the pattern consumes text by itself, without invoking others.
+func isTerminalPattern(pat Pattern) bool {
+	if _, ok := pat.(*patternClosure); ok {
+		return false
+	}
+	switch pat.Kind() {
+	case KindBoolean, KindAnyRune, KindEOF, KindLineAnchor, KindText,
+		KindBackward, KindTextSet, KindRefer, KindReferBackward, KindRuneSet,
+		KindRuneRange, KindUnicodeRanges, KindSkip, KindAbort, KindSymbolSet:
+		return true
+	}
+	return false
+}
+
+// Builds the node from the items in [from, to), which are left in order by
+// the matched patterns. The uncovered text is filled with leaves, and the
+// continuous text consumed by the same terminal pattern is merged into one
+// leaf.
+func newSyntaxNode(rule string, items []Capture, from, to int) *SyntaxNode {
+	node := &SyntaxNode{
+		Rule:  rule,
+		Start: Position{Offest: from},
+		End:   Position{Offest: to},
+	}
+	at := from
+	var last *SyntaxNode
+	for _, cap := range items {
+		item := cap.(*SyntaxNode)
+		if item.term != nil && last != nil && last.term == item.term && last.End.Offest == item.Start.Offest {
+			last.End = item.End
+			at = item.End.Offest
+			continue
+		}
+		if item.Start.Offest > at {
+			node.Children = append(node.Children, &SyntaxNode{
+				Start: Position{Offest: at},
+				End:   Position{Offest: item.Start.Offest},
+			})
+		}
+		node.Children = append(node.Children, item)
+		last = item
+		at = item.End.Offest
+	}
+	if at < to {
+		node.Children = append(node.Children, &SyntaxNode{
+			Start: Position{Offest: at},
+			End:   Position{Offest: to},
+		})
+	}
+	return node
+}
+
+// Fills the text and positions of nodes.
+func (node *SyntaxNode) locate(ctx *context) {
+	node.Text = ctx.text[node.Start.Offest:node.End.Offest]
+	node.Start = ctx.tellAt(node.Start.Offest)
+	node.End = ctx.tellAt(node.End.Offest)
+	node.term = nil
+	for _, sub := range node.Children {
+		sub.locate(ctx)
+	}
+}
+
+// Clips the items of current node to the text before limit, for Inject and
+// Trunc consuming less text than matched inside.
+func (ctx *context) clip(limit int) {
+	if !ctx.config.SyntaxTree {
+		return
+	}
+
+	argsp := &ctx.capstack[len(ctx.capstack)-1].args
+	items := (*argsp)[:0]
+	for _, cap := range *argsp {
+		item := cap.(*SyntaxNode)
+		if item.Start.Offest > limit || (item.Start.Offest == limit && item.End.Offest > limit) {
+			continue
+		}
+		if item.End.Offest > limit {
+			clipSyntaxNode(item, limit)
+		}
+		items = append(items, item)
+	}
+	*argsp = items
+}
+
+func clipSyntaxNode(node *SyntaxNode, limit int) {
+	node.End.Offest = limit
+	children := node.Children[:0]
+	for _, sub := range node.Children {
+		if sub.Start.Offest > limit || (sub.Start.Offest == limit && sub.End.Offest > limit) {
+			continue
+		}
+		if sub.End.Offest > limit {
+			clipSyntaxNode(sub, limit)
+		}
+		children = append(children, sub)
+	}
+	node.Children = children
+}
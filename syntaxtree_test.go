@@ -0,0 +1,111 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSyntaxTree(t *testing.T) {
+	grammar := map[string]Pattern{
+		"ws":      Q0(Alt(S(" \n"), Seq(T("#"), Q0(NS("\n"))))),
+		"num":     CK(0, Q1(R('0', '9'))),
+		"name":    Q1(R('a', 'z')),
+		"atom":    Alt(V("num"), V("name")),
+		"list":    Seq(T("("), V("ws"), J0(V("expr"), V("ws")), V("ws"), T(")")),
+		"expr":    Alt(V("list"), V("atom")),
+		"program": Seq(V("ws"), J0(V("expr"), V("ws")), V("ws"), EOF),
+		"tpl":     Template([]string{"item"}, Seq(T("["), V("item"), T("]"))),
+	}
+	data := []struct {
+		text string
+		tree string
+		pat  Pattern
+	}{
+		{"", `(program(ws() ws()))`, V("program")},
+		{"42", `(program(ws() expr(atom(num("42"))) ws()))`, V("program")},
+		{"a b", `(program(ws() expr(atom(name("a"))) ws(" ") expr(atom(name("b"))) ws()))`, V("program")},
+		{"# x\n(f 1)", `(program(ws("#" " x" "\n") expr(list("(" ws() expr(atom(name("f"))) ws(" ") expr(atom(num("1"))) ws() ")")) ws()))`, V("program")},
+
+		// text consumed outside the variables, merged by the terminal patterns.
+		{"x  y", `("x" "  " name("y"))`, Seq(T("x"), Q0(T(" ")), V("name"))},
+		{"ab", `("a" "b")`, Seq(T("a"), T("b"))},
+
+		// parameters of template are transparent.
+		{"[7]", `(tpl("[" num("7") "]"))`, Call("tpl", V("num"))},
+		{"[7]", `(tpl("[" "7" "]"))`, Call("tpl", R('0', '9'))},
+
+		// nodes of the dismatched branches and the lookaheads are discarded.
+		{"ab", `("ab")`, Alt(Seq(V("name"), T("!")), Until(EOF))},
+		{"ab", `(name("ab"))`, Alt(Seq(V("num"), T("!")), V("name"))},
+		{"ab!", `("ab" "!")`, Seq(Test(V("name")), Until(T("!")), T("!"))},
+		{"ab!", `("ab" "!")`, Seq(Not(Seq(V("name"), T("?"))), Until(T("!")), T("!"))},
+		{"ab", `("ab")`, Seq(Peek(V("name")), Until(EOF))},
+		{"a1", `(name("a") num("1"))`, Seq(Q0(Seq(V("name"), Test(T("?")))), Alt(Seq(V("name"), V("num")), Dot))},
+		{"a b", `(name("a") " b")`, Seq(V("name"), Q0(Seq(T(" "), Not(V("name")))), Until(EOF))},
+
+		// nodes are clipped to the text consumed by Trunc and Inject.
+		{"cccd", `("cc" "cd")`, Seq(Trunc(2, Q0(T("c"))), T("cd"))},
+		{"ab", `("a" "b")`, Seq(Inject(func(string) (int, bool) { return 1, true }, T("ab")), T("b"))},
+		{"ab1", `(name("a") "b1")`, Seq(Trunc(1, Seq(V("name"), V("num"))), Q0(Dot))},
+
+		// text consumed by non-terminal patterns is kept in leaves.
+		{"ab!", `("ab" "!")`, UntilB(T("!"))},
+		{"ab1", `(name("ab") "1")`, Seq(V("name"), Cmt(func(CaptureContext, []Capture) (bool, []Capture, error) {
+			return true, nil, nil
+		}, Q1(R('0', '9'))))},
+		{"ab1", `("ab1")`, Alt(Seq(V("name"), Cmt(func(CaptureContext, []Capture) (bool, []Capture, error) {
+			return false, nil, nil
+		}, V("num"))), Q0(Dot))},
+	}
+
+	for _, d := range data {
+		r, err := Config{SyntaxTree: true}.Match(Let(grammar, d.pat), d.text)
+		if err != nil || !r.Ok || r.N != len(d.text) {
+			t.Errorf("Match(%q) => %v, %v", d.text, r, err)
+			continue
+		}
+		if r.Captures != nil {
+			t.Errorf("Match(%q) captures %v in SyntaxTree mode", d.text, r.Captures)
+		}
+		if r.Tree.String() != d.tree {
+			t.Errorf("Match(%q).Tree => %s, expect %s", d.text, r.Tree.String(), d.tree)
+		}
+
+		// leaves reproduce the text.
+		var texts []string
+		for _, leaf := range r.Tree.Leaves() {
+			texts = append(texts, leaf.Text)
+		}
+		if strings.Join(texts, "") != d.text || r.Tree.Text != d.text {
+			t.Errorf("Match(%q).Tree leaves => %q", d.text, texts)
+		}
+	}
+}
+
+func TestParseTree(t *testing.T) {
+	pat := Let(map[string]Pattern{
+		"word": Q1(R('a', 'z')),
+		"line": Seq(V("word"), Q0(Seq(T(" "), V("word")))),
+	}, J1(V("line"), T("\n")))
+
+	tree, err := ParseTree(pat, "ab c\nd")
+	if err != nil {
+		t.Fatalf("ParseTree => %v", err)
+	}
+	if len(tree.Children) != 3 {
+		t.Fatalf("ParseTree => %s", tree.String())
+	}
+	line := tree.Children[2]
+	word := line.Children[0]
+	if line.Rule != "line" || word.Rule != "word" || word.Text != "d" ||
+		word.Start.String() != "2:1+5" || word.End.String() != "2:2+6" {
+		t.Errorf("ParseTree => %s, word %q at %s", tree.String(), word.Text, word.Start.String())
+	}
+
+	if _, err := ParseTree(pat, "ab c\n"); err == nil {
+		t.Errorf("ParseTree of partial matched text succeeded")
+	}
+	if _, err := ParseTree(pat, "1"); err == nil {
+		t.Errorf("ParseTree of dismatched text succeeded")
+	}
+}
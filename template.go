@@ -0,0 +1,174 @@
+package peg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Underlying types implemented Pattern interface.
+type (
+	patternTemplate struct {
+		params []string
+		body   Pattern
+	}
+
+	patternCallTemplate struct {
+		varname string
+		args    []Pattern
+		cons    ContextConstructor
+	}
+
+	// actual argument bound to the namespaces of the call site.
+	patternClosure struct {
+		pat    Pattern
+		scopes *namespace
+	}
+)
+
+// Template defines a parameterized grammar rule, which should be stored in
+// the namespace of Let, and be invoked by Call or CCall.
+//
+// The formal parameters are referred as variables (V(param) or CV(param))
+// inside the body. Other variables used in the body are looked up at the
+// call site, just like the variables used in normal rules.
+//
+// Panics if the body is nil.
+func Template(params []string, body Pattern) Pattern {
+	if body == nil {
+		panic(errorNilTemplateBody)
+	}
+	copied := make([]string, len(params))
+	copy(copied, params)
+	return &patternTemplate{params: copied, body: body}
+}
+
+// Call invokes a defined template with actual patterns without capturing.
+//
+// The actual patterns are resolved in the namespaces where Call is used,
+// thus they won't be confused with the formal parameters of the template.
+//
+// A runtime error occurs when template is undefined,
+// or the number of arguments mismatches.
+func Call(varname string, args ...Pattern) Pattern {
+	return &patternCallTemplate{
+		varname: varname,
+		args:    args,
+		cons:    nil,
+	}
+}
+
+// CCall invokes and captures a defined template, the parse capture would be
+// stored into a Variable-typed non-terminal named varname when the pattern
+// is matched.
+//
+// To capture each instantiation on its own, define the instantiations as
+// variables (e.g. "idents": Call("list", V("ident"))) and use CV instead.
+//
+// A runtime error occurs when template is undefined,
+// or the number of arguments mismatches.
+func CCall(varname string, args ...Pattern) Pattern {
+	return &patternCallTemplate{
+		varname: varname,
+		args:    args,
+		cons:    newVariableConstructor(varname),
+	}
+}
+
+// Template could only be invoked by Call/CCall.
+func (pat *patternTemplate) match(ctx *context) error {
+	return errorInvokeTemplate
+}
+
+// Binds arguments then invokes template, optionally captures it.
+func (pat *patternCallTemplate) match(ctx *context) error {
+	if !ctx.justReturned() {
+		callee := ctx.lookup(pat.varname)
+		if callee == nil {
+			return errorUndefinedVar(pat.varname)
+		}
+		callee = resolveTemplateArgument(callee)
+		tpl, ok := callee.(*patternTemplate)
+		if !ok {
+			return errorNotTemplate(pat.varname)
+		}
+		if len(tpl.params) != len(pat.args) {
+			return errorTemplateArity(pat.varname, len(tpl.params), len(pat.args))
+		}
+
+		// bind arguments to the namespaces of call site
+		vars := make(map[string]Pattern, len(tpl.params))
+		for i, param := range tpl.params {
+			vars[param] = &patternClosure{pat: pat.args[i], scopes: ctx.scopes}
+		}
+		ctx.enter(vars, false)
+		if pat.cons != nil {
+			ctx.beginContext(pat.cons, pat.varname)
+		}
+		ctx.beginRule(pat.varname)
+		err := ctx.call(tpl.body)
+		ctx.rule = pat.varname
+		return err
+	}
+
+	// leave namespace, finish capturing
+	ret := ctx.ret
+	ctx.leave()
+	if err := ctx.endRule(ret.ok, ret.n); err != nil {
+		return err
+	}
+	if pat.cons != nil {
+		err := ctx.end(ret.ok, ret.n)
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.returns(ret)
+}
+
+// Resolves template passed as argument, e.g. Call("tpl", V("list")).
+func resolveTemplateArgument(pat Pattern) Pattern {
+	for {
+		closure, ok := pat.(*patternClosure)
+		if !ok {
+			return pat
+		}
+		pat = closure.pat
+		if v, ok := pat.(*patternCaptureVariable); ok && v.cons == nil {
+			if callee := closure.scopes.lookup(v.varname); callee != nil {
+				pat = callee
+			}
+		}
+	}
+}
+
+// Invokes argument in the namespaces of call site.
+func (pat *patternClosure) match(ctx *context) error {
+	if !ctx.justReturned() {
+		ctx.locals.scopes = ctx.scopes
+		ctx.scopes = pat.scopes
+		return ctx.call(pat.pat)
+	}
+
+	ret := ctx.ret
+	ctx.scopes = ctx.locals.scopes
+	return ctx.returns(ret)
+}
+
+func (pat *patternTemplate) String() string {
+	return fmt.Sprintf("template(%s) %s", strings.Join(pat.params, ", "), pat.body)
+}
+
+func (pat *patternCallTemplate) String() string {
+	strs := make([]string, len(pat.args))
+	for i := range pat.args {
+		strs[i] = fmt.Sprint(pat.args[i])
+	}
+	if pat.cons == nil {
+		return fmt.Sprintf("$%s(%s)", pat.varname, strings.Join(strs, ", "))
+	}
+	return fmt.Sprintf("${%s(%s)}", pat.varname, strings.Join(strs, ", "))
+}
+
+func (pat *patternClosure) String() string {
+	return fmt.Sprint(pat.pat)
+}
@@ -0,0 +1,52 @@
+package peg
+
+import "testing"
+
+// Tests Template, Call, CCall.
+func TestTemplate(t *testing.T) {
+	scope := map[string]Pattern{
+		"list": Template([]string{"item", "sep"},
+			Seq(T("["), J0(V("item"), V("sep")), T("]"))),
+		"pair": Template([]string{"key", "sep", "value"},
+			Seq(CV("key"), V("sep"), CV("value"))),
+		"wrap": Template([]string{"item"},
+			Seq(T("("), V("item"), T(")"))),
+		"twice": Template([]string{"tpl", "item"},
+			Seq(Call("tpl", V("item")), Call("tpl", V("item")))),
+
+		"item":   T("x"),
+		"digit":  CK(0, R('0', '9')),
+		"digits": Call("list", V("digit"), T(",")),
+		"kv":     CCall("pair", Q1(R('a', 'z')), T("="), V("digit")),
+	}
+
+	data := []patternTestData{
+		{"[]", true, 2, false, ``, ``, Let(scope, Call("list", R('0', '9'), T(",")))},
+		{"[1,2,3]", true, 7, false, ``, ``, Let(scope, Call("list", R('0', '9'), T(",")))},
+		{"[1;2;3]", true, 7, false, ``, ``, Let(scope, Call("list", R('0', '9'), T(";")))},
+		{"[1;2;3]", false, 0, false, ``, ``, Let(scope, Call("list", R('0', '9'), T(",")))},
+
+		// arguments are resolved at call site.
+		{"(x)", true, 3, false, ``, ``, Let(scope, Call("wrap", V("item")))},
+		{"((x))", true, 5, false, ``, ``, Let(scope, Call("wrap", Call("wrap", V("item"))))},
+		{"(x)(x)", true, 6, false, ``, ``, Let(scope, Call("twice", V("wrap"), V("item")))},
+
+		// captures.
+		{"[1,2]", true, 5, false, ``, `<0"1">, <0"2">`, Let(scope, Call("list", V("digit"), T(",")))},
+		{"[1,2]", true, 5, false, ``, `list(<0"1">, <0"2">)`, Let(scope, CCall("list", V("digit"), T(",")))},
+		{"[1,2]", true, 5, false, ``, `digits(<0"1">, <0"2">)`, Let(scope, CV("digits"))},
+		{"[1,2][3]", true, 8, false, ``, `digits(<0"1">, <0"2">), digits(<0"3">)`, Let(scope, Q0(CV("digits")))},
+		{"k=1", true, 3, false, ``, `pair(key(), value(<0"1">))`, Let(scope, V("kv"))},
+
+		// errors.
+		{"", false, 0, true, ``, ``, Let(scope, Call("undefined"))},
+		{"", false, 0, true, ``, ``, Let(scope, Call("item"))},
+		{"", false, 0, true, ``, ``, Let(scope, Call("wrap"))},
+		{"", false, 0, true, ``, ``, Let(scope, Call("wrap", T("a"), T("b")))},
+		{"", false, 0, true, ``, ``, Let(scope, V("wrap"))},
+	}
+
+	for _, d := range data {
+		runPatternTestData(t, d)
+	}
+}
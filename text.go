@@ -26,10 +26,14 @@ type (
 
 	patternTextReferring struct {
 		grpname string
+		indexed bool // refers to the anonymous group by index
+		index   int
 	}
 
 	patternBackwardPredicateReferring struct {
 		grpname string
+		indexed bool // refers to the anonymous group by index
+		index   int
 	}
 )
 
@@ -143,7 +147,7 @@ func TSI(textset ...string) Pattern {
 //
 // Use "" if the name grpname does not exist.
 func Ref(grpname string) Pattern {
-	return &patternTextReferring{grpname}
+	return &patternTextReferring{grpname: grpname}
 }
 
 // RefB predicates if the text in the group named grpname matches in backward.
@@ -152,7 +156,22 @@ func Ref(grpname string) Pattern {
 //
 // Use "" if the name grpname does not exist.
 func RefB(grpname string) Pattern {
-	return &patternBackwardPredicateReferring{grpname}
+	return &patternBackwardPredicateReferring{grpname: grpname}
+}
+
+// RefN matches the text in the anonymous group indexed by index, counting
+// from zero in the order they are saved (as Result.Groups). Negative index
+// counts from the lastest group, that is, RefN(-1) is equivalent to Ref("").
+//
+// Use "" if the group does not exist.
+func RefN(index int) Pattern {
+	return &patternTextReferring{indexed: true, index: index}
+}
+
+// RefBN predicates if the text in the anonymous group indexed by index
+// matches in backward, see RefN.
+func RefBN(index int) Pattern {
+	return &patternBackwardPredicateReferring{indexed: true, index: index}
 }
 
 // Matches text.
@@ -230,7 +249,12 @@ func (pat *patternTextReferring) match(ctx *context) error {
 		return errorReferDisabled
 	}
 
-	text := ctx.refer(pat.grpname)
+	var text string
+	if pat.indexed {
+		text = ctx.referIndex(pat.index)
+	} else {
+		text = ctx.refer(pat.grpname)
+	}
 	if ctx.next(len(text)) == text {
 		ctx.consume(len(text))
 		return ctx.commit()
@@ -244,7 +268,12 @@ func (pat *patternBackwardPredicateReferring) match(ctx *context) error {
 		return errorReferDisabled
 	}
 
-	text := ctx.refer(pat.grpname)
+	var text string
+	if pat.indexed {
+		text = ctx.referIndex(pat.index)
+	} else {
+		text = ctx.refer(pat.grpname)
+	}
 	return ctx.predicates(ctx.previous(len(text)) == text)
 }
 
@@ -272,6 +301,9 @@ func (pat *patternTextSet) String() string {
 }
 
 func (pat *patternTextReferring) String() string {
+	if pat.indexed {
+		return fmt.Sprintf("%%%d%%", pat.index)
+	}
 	if pat.grpname == "" {
 		return "%%"
 	}
@@ -279,6 +311,9 @@ func (pat *patternTextReferring) String() string {
 }
 
 func (pat *patternBackwardPredicateReferring) String() string {
+	if pat.indexed {
+		return fmt.Sprintf("back? %%%d%%", pat.index)
+	}
 	if pat.grpname == "" {
 		return "back? %%"
 	}
@@ -0,0 +1,173 @@
+package peg
+
+// Transform rebuilds the pattern tree bottom-up. Each pattern is rebuilt
+// with its transformed children, then passed to fn, whose result replaces
+// it. fn returns nil to keep the rebuilt pattern.
+//
+// Patterns shared in the tree are transformed only once, and the results
+// are shared as well. The variables of Let are transformed in place, thus
+// recursive rules still refer to each other by names. The results of fn
+// are never transformed again, so that Transform always terminates even if
+// the grammar is cyclic.
+//
+// The untouched subtrees are reused rather than copied.
+func Transform(pat Pattern, fn func(Pattern) Pattern) Pattern {
+	memo := make(map[Pattern]Pattern)
+	var transform func(pat Pattern) Pattern
+	transform = func(pat Pattern) Pattern {
+		if pat == nil {
+			return nil
+		}
+		if result, ok := memo[pat]; ok {
+			return result
+		}
+
+		subs := Children(pat)
+		changed := false
+		for i, sub := range subs {
+			subs[i] = transform(sub)
+			if subs[i] != sub {
+				changed = true
+			}
+		}
+		result := pat
+		if changed {
+			result = rebuild(pat, subs)
+		}
+		if replaced := fn(result); replaced != nil {
+			result = replaced
+		}
+		memo[pat] = result
+		return result
+	}
+	return transform(pat)
+}
+
+// Copies the pattern with the children replaced, in the order of Children.
+func rebuild(pat Pattern, subs []Pattern) Pattern {
+	switch pat := pat.(type) {
+	case *patternSequence:
+		return &patternSequence{pats: subs}
+	case *patternAlternative:
+		return &patternAlternative{pats: subs}
+	case *patternAndPredicate:
+		return &patternAndPredicate{pats: subs}
+	case *patternOrPredicate:
+		return &patternOrPredicate{pats: subs}
+	case *patternCallTemplate:
+		copied := *pat
+		copied.args = subs
+		return &copied
+	case *patternAnyRuneUntil:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternQualifierAtLeast:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternQualifierOptional:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternQualifierRange:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternPredicate:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternGrouping:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternTrigger:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternDeferredTrigger:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternCommit:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternInjector:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternCaptureToken:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternCaptureCons:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternCaptureTerm:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternCaptureContext:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternCaptureFold:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternCaptureTable:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternCaptureMatchTime:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternTemplate:
+		copied := *pat
+		copied.body = subs[0]
+		return &copied
+	case *patternSymbolDeclare:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternSymbolScope:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternAction:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternActionText:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternRule:
+		copied := *pat
+		copied.pat = subs[0]
+		return &copied
+	case *patternIf:
+		return &patternIf{cond: subs[0], yes: subs[1], no: subs[2]}
+	case *patternSwitch:
+		copied := &patternSwitch{otherwise: subs[len(subs)-1]}
+		for i := 0; i+1 < len(subs); i += 2 {
+			copied.cases = append(copied.cases, struct {
+				cond Pattern
+				then Pattern
+			}{subs[i], subs[i+1]})
+		}
+		return copied
+	case *patternLet:
+		names := sortedVarNames(pat.vars)
+		vars := make(map[string]Pattern, len(names))
+		for i, name := range names {
+			vars[name] = subs[i]
+		}
+		return &patternLet{pat: subs[len(subs)-1], vars: vars, auto: pat.auto}
+	}
+	return pat
+}
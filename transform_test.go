@@ -0,0 +1,139 @@
+package peg
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Grammar of comma separated lists with captures.
+func transformTestGrammar() Pattern {
+	return Let(map[string]Pattern{
+		"ws":    Q0(S(" ")),
+		"item":  CT(genUpper, Q1(R('a', 'z'))),
+		"list":  CC(genJoin, Seq(T("("), J0(Alt(CV("list"), V("item")), Seq(V("ws"), T(","), V("ws"))), T(")"))),
+		"entry": Seq(V("list"), EOF),
+	}, V("entry"))
+}
+
+func TestTransformIdentity(t *testing.T) {
+	pat := transformTestGrammar()
+	count := 0
+	result := Transform(pat, func(pat Pattern) Pattern {
+		count++
+		return nil
+	})
+	if result != pat {
+		t.Errorf("Transform(%s) with identity => %s", pat, result)
+	}
+	distinct := make(map[Pattern]bool)
+	Walk(pat, func(pat Pattern) bool {
+		distinct[pat] = true
+		return true
+	})
+	if count != len(distinct) {
+		t.Errorf("Transform(%s) visited %d patterns, expect %d", pat, count, len(distinct))
+	}
+}
+
+func TestTransformStripCaptures(t *testing.T) {
+	pat := transformTestGrammar()
+	validator := Transform(pat, func(pat Pattern) Pattern {
+		switch pat.Kind() {
+		case KindCons, KindTerm, KindToken:
+			return Children(pat)[0]
+		case KindVariable:
+			return V(Params(pat).Name)
+		}
+		return nil
+	})
+
+	Walk(validator, func(pat Pattern) bool {
+		switch pat.Kind() {
+		case KindCons, KindTerm, KindToken:
+			t.Errorf("capture %s is not removed", pat)
+		case KindVariable:
+			if Params(pat).Capture {
+				t.Errorf("capture %s is not removed", pat)
+			}
+		}
+		return true
+	})
+	for _, text := range []string{"(a, (b,c), d)", "(a,,b)", "()"} {
+		r1, err1 := Match(pat, text)
+		r2, err2 := Match(validator, text)
+		if err1 != nil || err2 != nil || r1.Ok != r2.Ok || r1.N != r2.N || len(r2.Captures) != 0 {
+			t.Errorf("Match(%q) => %v, %v != %v, %v", text, r2, err2, r1, err1)
+		}
+	}
+}
+
+func TestTransformTracing(t *testing.T) {
+	var trace []string
+	tracer := func(name string) func(string, Position) error {
+		return func(s string, pos Position) error {
+			trace = append(trace, fmt.Sprintf("%s:%q", name, s))
+			return nil
+		}
+	}
+	pat := Transform(transformTestGrammar(), func(pat Pattern) Pattern {
+		if pat.Kind() == KindVariable && Params(pat).Name == "item" {
+			return Trigger(tracer("item"), pat)
+		}
+		return nil
+	})
+	r, err := Match(pat, "(ab,(c))")
+	if err != nil || !r.Ok || len(r.Captures) != 1 {
+		t.Fatalf("Match => %v, %v", r, err)
+	}
+	want := fmt.Sprint([]string{`item:"ab"`, `item:"c"`})
+	if fmt.Sprint(trace) != want {
+		t.Errorf("trace => %s != %s", trace, want)
+	}
+}
+
+func TestTransformRedefine(t *testing.T) {
+	// swaps the definition of ws everywhere
+	pat := Transform(transformTestGrammar(), func(pat Pattern) Pattern {
+		if pat.Kind() != KindLet {
+			return nil
+		}
+		names, subs := Params(pat).Names, Children(pat)
+		vars := make(map[string]Pattern, len(names))
+		for i, name := range names {
+			vars[name] = subs[i]
+		}
+		vars["ws"] = Q0(S(" \t\n"))
+		return Let(vars, subs[len(subs)-1])
+	})
+	r, err := Match(pat, "(a\t,\nb)")
+	if err != nil || !r.Ok {
+		t.Errorf("Match => %v, %v", r, err)
+	}
+}
+
+func TestTransformSharing(t *testing.T) {
+	shared := Seq(T("a"), CK(1, T("b")))
+	pat := Alt(shared, Not(shared), Let(map[string]Pattern{"x": shared}, V("x")))
+
+	count := 0
+	result := Transform(pat, func(pat Pattern) Pattern {
+		if pat.Kind() == KindToken {
+			count++
+			return Children(pat)[0]
+		}
+		return nil
+	})
+	if count != 1 {
+		t.Errorf("shared pattern is transformed %d times", count)
+	}
+	subs := Children(result)
+	if a, b, c := subs[0], Children(subs[1])[0], Children(subs[2])[0]; a != b || a != c {
+		t.Errorf("sharing is not kept: %p %p %p", a, b, c)
+	}
+	if !Equal(subs[0], Seq(T("a"), T("b"))) {
+		t.Errorf("Transform(%s) => %s", pat, result)
+	}
+	if !Equal(Children(pat)[0], Seq(T("a"), CK(1, T("b")))) {
+		t.Errorf("original pattern %s is modified", pat)
+	}
+}
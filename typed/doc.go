@@ -0,0 +1,20 @@
+// Package typed provides a type-safe layer of parse captures over the PEG
+// patterns, built with generics (Go 1.18 or later).
+//
+// A Rule[T] is a pattern which produces exactly one value of type T when
+// matched. Rules are combined by the sequence combinators returning tuples
+// (Seq2, Seq3, Seq4), the ordered choice of rules with the same type (Alt),
+// the repetitions returning slices (Many, Many1, SepBy, SepBy1), Optional
+// returning pointers and Map converting the values. The untyped patterns
+// mixed into rules (Token, Between, SepBy, ...) are checked to produce no
+// captures.
+//
+// Recursive rules are referred by Var[T] and defined by Define[T] in Defs,
+// then resolved by Let. The types of references are checked against the
+// definitions when the grammar is built, mismatches cause panics rather
+// than failures in production.
+//
+// The values are carried by the capture stack of peg (see peg.CC and
+// peg.CT), ParseAs matches the text and returns the typed value.
+// This package API is currently volatile.
+package typed // import "github.com/hucsmn/peg/typed"
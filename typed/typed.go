@@ -0,0 +1,481 @@
+//go:build go1.18
+// +build go1.18
+
+package typed
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hucsmn/peg"
+)
+
+// Rule is a pattern producing exactly one value of type T when matched.
+type Rule[T any] struct {
+	pat    peg.Pattern
+	refs   map[string]reflect.Type // typed variables referred
+	silent map[string]bool         // variables referred by untyped patterns
+}
+
+// Tuple2 is the value of Seq2.
+type Tuple2[A, B any] struct {
+	V1 A
+	V2 B
+}
+
+// Tuple3 is the value of Seq3.
+type Tuple3[A, B, C any] struct {
+	V1 A
+	V2 B
+	V3 C
+}
+
+// Tuple4 is the value of Seq4.
+type Tuple4[A, B, C, D any] struct {
+	V1 A
+	V2 B
+	V3 C
+	V4 D
+}
+
+// Defs is a namespace of typed rules, see Define and Let.
+type Defs struct {
+	vars   map[string]peg.Pattern
+	types  map[string]reflect.Type
+	refs   map[string]reflect.Type
+	silent map[string]bool
+}
+
+// Capture carrying a typed value.
+type value struct {
+	v interface{}
+}
+
+func (value) IsTerminal() bool {
+	return true
+}
+
+// Pattern returns the underlying pattern.
+func (r Rule[T]) Pattern() peg.Pattern {
+	return r.pat
+}
+
+// Token converts the text matched by the untyped pattern into value.
+//
+// Panics if pat produces captures.
+func Token[T any](pat peg.Pattern, fn func(string, peg.Position) (T, error)) Rule[T] {
+	r := Rule[T]{silent: untyped(nil, pat)}
+	r.pat = peg.CT(func(s string, pos peg.Position) (peg.Capture, error) {
+		v, err := fn(s, pos)
+		if err != nil {
+			return nil, err
+		}
+		return value{v}, nil
+	}, pat)
+	return r
+}
+
+// Text captures the text matched by the untyped pattern.
+//
+// Panics if pat produces captures.
+func Text(pat peg.Pattern) Rule[string] {
+	return Token(pat, func(s string, _ peg.Position) (string, error) {
+		return s, nil
+	})
+}
+
+// Const produces the constant value when the untyped pattern is matched.
+//
+// Panics if pat produces captures.
+func Const[T any](pat peg.Pattern, v T) Rule[T] {
+	return Token(pat, func(string, peg.Position) (T, error) {
+		return v, nil
+	})
+}
+
+// Map converts the value of rule.
+func Map[A, B any](r Rule[A], fn func(A) (B, error)) Rule[B] {
+	return combine[B](func(vs []interface{}) (interface{}, error) {
+		return fn(as[A](vs[0]))
+	}, r.pat, r.refs, r.silent)
+}
+
+// Between matches the untyped patterns around the rule.
+//
+// Panics if before or after produces captures.
+func Between[T any](before peg.Pattern, r Rule[T], after peg.Pattern) Rule[T] {
+	silent := untyped(untyped(copySet(r.silent), before), after)
+	return combine[T](func(vs []interface{}) (interface{}, error) {
+		return vs[0], nil
+	}, peg.Seq(before, r.pat, after), r.refs, silent)
+}
+
+// Seq2 matches the rules in sequence.
+func Seq2[A, B any](a Rule[A], b Rule[B]) Rule[Tuple2[A, B]] {
+	return combine[Tuple2[A, B]](func(vs []interface{}) (interface{}, error) {
+		return Tuple2[A, B]{as[A](vs[0]), as[B](vs[1])}, nil
+	}, peg.Seq(a.pat, b.pat), mergeRefs(a.refs, b.refs), mergeSets(a.silent, b.silent))
+}
+
+// Seq3 matches the rules in sequence.
+func Seq3[A, B, C any](a Rule[A], b Rule[B], c Rule[C]) Rule[Tuple3[A, B, C]] {
+	return combine[Tuple3[A, B, C]](func(vs []interface{}) (interface{}, error) {
+		return Tuple3[A, B, C]{as[A](vs[0]), as[B](vs[1]), as[C](vs[2])}, nil
+	}, peg.Seq(a.pat, b.pat, c.pat), mergeRefs(a.refs, b.refs, c.refs),
+		mergeSets(a.silent, b.silent, c.silent))
+}
+
+// Seq4 matches the rules in sequence.
+func Seq4[A, B, C, D any](a Rule[A], b Rule[B], c Rule[C], d Rule[D]) Rule[Tuple4[A, B, C, D]] {
+	return combine[Tuple4[A, B, C, D]](func(vs []interface{}) (interface{}, error) {
+		return Tuple4[A, B, C, D]{as[A](vs[0]), as[B](vs[1]), as[C](vs[2]), as[D](vs[3])}, nil
+	}, peg.Seq(a.pat, b.pat, c.pat, d.pat), mergeRefs(a.refs, b.refs, c.refs, d.refs),
+		mergeSets(a.silent, b.silent, c.silent, d.silent))
+}
+
+// Alt matches the first matched rule in order.
+//
+// Panics if no rule is given.
+func Alt[T any](rules ...Rule[T]) Rule[T] {
+	if len(rules) == 0 {
+		panic(fmt.Errorf("typed: Alt requires at least one rule"))
+	}
+	r := Rule[T]{}
+	pats := make([]peg.Pattern, len(rules))
+	for i := range rules {
+		pats[i] = rules[i].pat
+		r.refs = mergeRefs(r.refs, rules[i].refs)
+		r.silent = mergeSets(r.silent, rules[i].silent)
+	}
+	r.pat = peg.Alt(pats...)
+	return r
+}
+
+// Optional matches the rule optionally, the value is nil if dismatched.
+func Optional[T any](r Rule[T]) Rule[*T] {
+	return combine[*T](func(vs []interface{}) (interface{}, error) {
+		if len(vs) == 0 {
+			return (*T)(nil), nil
+		}
+		v := as[T](vs[0])
+		return &v, nil
+	}, peg.Q01(r.pat), r.refs, r.silent)
+}
+
+// Many matches the rule zero or more times.
+func Many[T any](r Rule[T]) Rule[[]T] {
+	return list(r, peg.Q0(r.pat), nil)
+}
+
+// Many1 matches the rule one or more times.
+func Many1[T any](r Rule[T]) Rule[[]T] {
+	return list(r, peg.Q1(r.pat), nil)
+}
+
+// SepBy matches zero or more rules joined by the untyped separator.
+//
+// Panics if sep produces captures.
+func SepBy[T any](r Rule[T], sep peg.Pattern) Rule[[]T] {
+	return list(r, peg.J0(r.pat, sep), sep)
+}
+
+// SepBy1 matches one or more rules joined by the untyped separator.
+//
+// Panics if sep produces captures.
+func SepBy1[T any](r Rule[T], sep peg.Pattern) Rule[[]T] {
+	return list(r, peg.J1(r.pat, sep), sep)
+}
+
+// Var refers to the typed rule defined in Defs.
+func Var[T any](name string) Rule[T] {
+	return Rule[T]{
+		pat:  peg.V(name),
+		refs: map[string]reflect.Type{name: typeOf[T]()},
+	}
+}
+
+// NewDefs creates an empty namespace.
+func NewDefs() *Defs {
+	return &Defs{
+		vars:  make(map[string]peg.Pattern),
+		types: make(map[string]reflect.Type),
+	}
+}
+
+// Define adds the typed rule to namespace.
+//
+// Panics if the name is already defined.
+func Define[T any](defs *Defs, name string, r Rule[T]) {
+	if _, ok := defs.vars[name]; ok {
+		panic(fmt.Errorf("typed: rule %q is defined more than once", name))
+	}
+	defs.vars[name] = r.pat
+	defs.types[name] = typeOf[T]()
+	defs.refs = mergeRefs(defs.refs, r.refs)
+	defs.silent = mergeSets(defs.silent, r.silent)
+}
+
+// Let resolves the variables of the entry rule and the defined rules.
+//
+// Panics if any variable refers to a rule of different type, or any
+// untyped pattern refers to a typed rule.
+func Let[T any](defs *Defs, entry Rule[T]) Rule[T] {
+	refs := mergeRefs(defs.refs, entry.refs)
+	silent := mergeSets(defs.silent, entry.silent)
+	r := Rule[T]{}
+	for _, name := range sortedNames(refs) {
+		typ, ok := defs.types[name]
+		if !ok {
+			r.refs = mergeRefs(r.refs, map[string]reflect.Type{name: refs[name]})
+			continue
+		}
+		if typ != refs[name] {
+			panic(fmt.Errorf("typed: rule %q of type %s is referred as %s", name, typ, refs[name]))
+		}
+	}
+	for name := range silent {
+		if typ, ok := defs.types[name]; ok {
+			panic(fmt.Errorf("typed: rule %q of type %s is referred by untyped pattern", name, typ))
+		}
+		r.silent = mergeSets(r.silent, map[string]bool{name: true})
+	}
+
+	vars := make(map[string]peg.Pattern, len(defs.vars))
+	for name, pat := range defs.vars {
+		vars[name] = pat
+	}
+	r.pat = peg.Let(vars, entry.pat)
+	return r
+}
+
+// ParseAs matches the whole text by rule, returns the value.
+func ParseAs[T any](r Rule[T], text string) (T, error) {
+	return ParseAsConfig(peg.Config{
+		CallstackLimit: peg.DefaultCallstackLimit,
+		RepeatLimit:    peg.DefaultRepeatLimit,
+	}, r, text)
+}
+
+// ParseAsConfig matches the whole text by rule using the configuration,
+// returns the value.
+func ParseAsConfig[T any](cfg peg.Config, r Rule[T], text string) (v T, err error) {
+	if len(r.refs) != 0 {
+		return v, fmt.Errorf("typed: rule %q is undefined", sortedNames(r.refs)[0])
+	}
+	cfg.DisableCapturing = false
+	cfg.SyntaxTree = false
+	result, err := cfg.Match(r.pat, text)
+	if err != nil {
+		return v, err
+	}
+	if !result.Ok {
+		return v, fmt.Errorf("typed: text dismatched")
+	}
+	if result.N != len(text) {
+		pos := locate(cfg, text, result.N)
+		return v, fmt.Errorf("typed: unexpected text at %s", pos.String())
+	}
+	if len(result.Captures) != 1 {
+		return v, fmt.Errorf("typed: rule produces %d values", len(result.Captures))
+	}
+	val, ok := result.Captures[0].(value)
+	if !ok {
+		return v, fmt.Errorf("typed: unexpected untyped capture %T", result.Captures[0])
+	}
+	return as[T](val.v), nil
+}
+
+// Builds a rule combining the values produced by pat.
+func combine[T any](fn func([]interface{}) (interface{}, error), pat peg.Pattern,
+	refs map[string]reflect.Type, silent map[string]bool) Rule[T] {
+	cons := func(caps []peg.Capture) (peg.Capture, error) {
+		vs := make([]interface{}, len(caps))
+		for i, cap := range caps {
+			val, ok := cap.(value)
+			if !ok {
+				return nil, fmt.Errorf("typed: unexpected untyped capture %T", cap)
+			}
+			vs[i] = val.v
+		}
+		v, err := fn(vs)
+		if err != nil {
+			return nil, err
+		}
+		return value{v}, nil
+	}
+	return Rule[T]{pat: peg.CC(cons, pat), refs: refs, silent: silent}
+}
+
+func list[T any](r Rule[T], pat, sep peg.Pattern) Rule[[]T] {
+	silent := r.silent
+	if sep != nil {
+		silent = untyped(copySet(silent), sep)
+	}
+	return combine[[]T](func(vs []interface{}) (interface{}, error) {
+		items := make([]T, len(vs))
+		for i := range vs {
+			items[i] = as[T](vs[i])
+		}
+		return items, nil
+	}, pat, r.refs, silent)
+}
+
+// Checks that the untyped pattern produces no captures, adds the variables
+// referred to silent.
+func untyped(silent map[string]bool, pat peg.Pattern) map[string]bool {
+	peg.Walk(pat, func(sub peg.Pattern) bool {
+		switch sub.Kind() {
+		case peg.KindToken, peg.KindCons, peg.KindTerm, peg.KindContext,
+			peg.KindConst, peg.KindPosition, peg.KindFold, peg.KindTable,
+			peg.KindBackCapture, peg.KindArgument, peg.KindMatchTime,
+			peg.KindAction, peg.KindActionText:
+			panic(fmt.Errorf("typed: untyped pattern %s produces captures", pat))
+		case peg.KindLet:
+			if peg.Params(sub).Capture && capturesRules(sub) {
+				panic(fmt.Errorf("typed: untyped pattern %s produces captures", pat))
+			}
+		case peg.KindVariable, peg.KindCall:
+			params := peg.Params(sub)
+			if params.Capture {
+				panic(fmt.Errorf("typed: untyped pattern %s produces captures", pat))
+			}
+			if silent == nil {
+				silent = make(map[string]bool)
+			}
+			silent[params.Name] = true
+		}
+		return true
+	})
+	return silent
+}
+
+// Tells if any rule defined in CLet is captured automatically.
+func capturesRules(let peg.Pattern) bool {
+	subs := peg.Children(let)
+	for _, rule := range subs[:len(subs)-1] {
+		switch rule.Kind() {
+		case peg.KindTemplate:
+		case peg.KindRule:
+			if peg.Params(rule).Bool {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// Calculates the position of offset in text, counting the lines and columns
+// as matching does under the configuration.
+func locate(cfg peg.Config, text string, offset int) peg.Position {
+	line, start := 0, 0
+	for i := 0; i < offset; {
+		r, n := utf8.DecodeRuneInString(text[i:])
+		var end bool
+		switch r {
+		case '\n':
+			end = true
+		case '\r':
+			end = !strings.HasPrefix(text[i+1:], "\n")
+		case '\u0085':
+			end = cfg.LineTerminators&peg.TerminateNEL != 0
+		case '\u2028':
+			end = cfg.LineTerminators&peg.TerminateLS != 0
+		case '\u2029':
+			end = cfg.LineTerminators&peg.TerminatePS != 0
+		case '\v':
+			end = cfg.LineTerminators&peg.TerminateVT != 0
+		case '\f':
+			end = cfg.LineTerminators&peg.TerminateFF != 0
+		}
+		i += n
+		if end {
+			line, start = line+1, i
+		}
+	}
+
+	column := 0
+	switch s := text[start:offset]; cfg.ColumnUnit {
+	case peg.ColumnBytes:
+		column = len(s)
+	case peg.ColumnUTF16:
+		for _, r := range s {
+			if r >= 0x10000 {
+				column += 2
+			} else {
+				column++
+			}
+		}
+	case peg.ColumnTabStops:
+		width := cfg.TabWidth
+		if width <= 0 {
+			width = peg.DefaultTabWidth
+		}
+		for _, r := range s {
+			if r == '\t' {
+				column += width - column%width
+			} else {
+				column++
+			}
+		}
+	default:
+		column = utf8.RuneCountInString(s)
+	}
+	return peg.Position{Offest: offset, Line: line, Column: column}
+}
+
+// Unboxes the value, nil is the zero value of interface types.
+func as[T any](v interface{}) T {
+	t, _ := v.(T)
+	return t
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func mergeRefs(refs ...map[string]reflect.Type) map[string]reflect.Type {
+	var merged map[string]reflect.Type
+	for _, m := range refs {
+		for name, typ := range m {
+			if merged == nil {
+				merged = make(map[string]reflect.Type)
+			}
+			if prev, ok := merged[name]; ok && prev != typ {
+				panic(fmt.Errorf("typed: rule %q is referred as both %s and %s", name, prev, typ))
+			}
+			merged[name] = typ
+		}
+	}
+	return merged
+}
+
+func mergeSets(sets ...map[string]bool) map[string]bool {
+	var merged map[string]bool
+	for _, m := range sets {
+		for name := range m {
+			if merged == nil {
+				merged = make(map[string]bool)
+			}
+			merged[name] = true
+		}
+	}
+	return merged
+}
+
+func copySet(set map[string]bool) map[string]bool {
+	return mergeSets(set)
+}
+
+func sortedNames(refs map[string]reflect.Type) []string {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
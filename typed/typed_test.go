@@ -0,0 +1,282 @@
+//go:build go1.18
+// +build go1.18
+
+package typed
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hucsmn/peg"
+)
+
+// S-expression of integers and lists.
+type sexp struct {
+	num  int
+	list []sexp
+}
+
+func (e sexp) String() string {
+	if e.list == nil {
+		return strconv.Itoa(e.num)
+	}
+	strs := make([]string, len(e.list))
+	for i := range e.list {
+		strs[i] = e.list[i].String()
+	}
+	return "(" + strings.Join(strs, " ") + ")"
+}
+
+func sexpGrammar() Rule[sexp] {
+	spaces := peg.Q1(peg.S(" \n"))
+	number := Token(peg.Seq(peg.Q01(peg.T("-")), peg.Q1(peg.R('0', '9'))),
+		func(s string, _ peg.Position) (int, error) {
+			return strconv.Atoi(s)
+		})
+
+	defs := NewDefs()
+	Define(defs, "sexp", Alt(
+		Map(number, func(n int) (sexp, error) {
+			return sexp{num: n}, nil
+		}),
+		Map(Between(peg.T("("), SepBy(Var[sexp]("sexp"), spaces), peg.T(")")),
+			func(list []sexp) (sexp, error) {
+				if list == nil {
+					list = []sexp{}
+				}
+				return sexp{list: list}, nil
+			})))
+	return Let(defs, Var[sexp]("sexp"))
+}
+
+func TestParseAs(t *testing.T) {
+	grammar := sexpGrammar()
+	data := []struct {
+		text string
+		ok   bool
+		want string
+	}{
+		{"42", true, "42"},
+		{"(1 (2 -3) ())", true, "(1 (2 -3) ())"},
+		{"(1 2", false, ""},
+		{"(1 2) 3", false, ""},
+		{"99999999999999999999999", false, ""},
+	}
+	for _, d := range data {
+		v, err := ParseAs(grammar, d.text)
+		if (err == nil) != d.ok || (d.ok && v.String() != d.want) {
+			t.Errorf("ParseAs(%q) => %s, %v", d.text, v, err)
+		}
+	}
+
+	_, err := ParseAs(grammar, "(1 2) 3")
+	if err == nil || !strings.Contains(err.Error(), "1:6") {
+		t.Errorf("ParseAs() => %v, expect error at 1:6", err)
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	digit := Token(peg.R('0', '9'), func(s string, _ peg.Position) (int, error) {
+		return int(s[0] - '0'), nil
+	})
+	letter := Text(peg.R('a', 'z'))
+
+	pair, err := ParseAs(Seq2(digit, letter), "1a")
+	if err != nil || pair != (Tuple2[int, string]{1, "a"}) {
+		t.Errorf("Seq2 => %v, %v", pair, err)
+	}
+	triple, err := ParseAs(Seq3(letter, Const(peg.T("="), true), digit), "x=3")
+	if err != nil || triple != (Tuple3[string, bool, int]{"x", true, 3}) {
+		t.Errorf("Seq3 => %v, %v", triple, err)
+	}
+	quad, err := ParseAs(Seq4(digit, digit, letter, Optional(letter)), "12a")
+	if err != nil || quad.V1 != 1 || quad.V2 != 2 || quad.V3 != "a" || quad.V4 != nil {
+		t.Errorf("Seq4 => %v, %v", quad, err)
+	}
+	opt, err := ParseAs(Optional(letter), "b")
+	if err != nil || opt == nil || *opt != "b" {
+		t.Errorf("Optional => %v, %v", opt, err)
+	}
+
+	many := Many(Alt(digit, Map(letter, func(s string) (int, error) {
+		return -1, nil
+	})))
+	list, err := ParseAs(many, "1a2")
+	if err != nil || !reflect.DeepEqual(list, []int{1, -1, 2}) {
+		t.Errorf("Many => %v, %v", list, err)
+	}
+	list, err = ParseAs(many, "")
+	if err != nil || len(list) != 0 {
+		t.Errorf("Many => %v, %v", list, err)
+	}
+	if _, err := ParseAs(Many1(digit), ""); err == nil {
+		t.Errorf("Many1 matched empty text")
+	}
+	list, err = ParseAs(SepBy1(digit, peg.T(",")), "1,2,3")
+	if err != nil || !reflect.DeepEqual(list, []int{1, 2, 3}) {
+		t.Errorf("SepBy1 => %v, %v", list, err)
+	}
+
+	// nil values of interface types
+	null, err := ParseAs(Const[error](peg.T("a"), nil), "a")
+	if err != nil || null != nil {
+		t.Errorf("Const(nil) => %v, %v", null, err)
+	}
+	pair2, err := ParseAs(Seq2(Const[any](peg.T("a"), nil), letter), "ab")
+	if err != nil || pair2.V1 != nil || pair2.V2 != "b" {
+		t.Errorf("Seq2(Const(nil)) => %v, %v", pair2, err)
+	}
+	opt2, err := ParseAs(Optional(Const[fmt.Stringer](peg.T("a"), nil)), "a")
+	if err != nil || opt2 == nil || *opt2 != nil {
+		t.Errorf("Optional(Const(nil)) => %v, %v", opt2, err)
+	}
+	nulls, err := ParseAs(Many(Map(Const[error](peg.T("a"), nil), func(e error) (error, error) {
+		return e, nil
+	})), "aa")
+	if err != nil || !reflect.DeepEqual(nulls, []error{nil, nil}) {
+		t.Errorf("Many(Map(Const(nil))) => %v, %v", nulls, err)
+	}
+
+	// values of the dismatched tries are dropped
+	list, err = ParseAs(Many(Between(peg.True, digit, peg.T(";"))), "1;2;")
+	if err != nil || !reflect.DeepEqual(list, []int{1, 2}) {
+		t.Errorf("Many(Between) => %v, %v", list, err)
+	}
+}
+
+func TestTypeChecking(t *testing.T) {
+	word := Text(peg.Q1(peg.R('a', 'z')))
+	cases := map[string]func(){
+		"mismatched variable": func() {
+			defs := NewDefs()
+			Define(defs, "word", word)
+			Let(defs, Var[int]("word"))
+		},
+		"conflicting references": func() {
+			Seq2(Var[int]("x"), Var[string]("x"))
+		},
+		"untyped pattern captures": func() {
+			Token(peg.CK(1, peg.Dot), func(s string, _ peg.Position) (string, error) {
+				return s, nil
+			})
+		},
+		"untyped separator captures": func() {
+			SepBy(word, peg.CV("sep"))
+		},
+		"untyped pattern refers typed rule": func() {
+			defs := NewDefs()
+			Define(defs, "word", word)
+			Let(defs, Between(peg.V("word"), word, peg.True))
+		},
+		"untyped position captures": func() {
+			Between(peg.Cp, word, peg.T(";"))
+		},
+		"untyped constant captures": func() {
+			Between(peg.Cc(peg.Text("c")), word, peg.True)
+		},
+		"untyped folding captures": func() {
+			Between(peg.True, word, peg.Cf(func(acc, _ peg.Capture) (peg.Capture, error) {
+				return acc, nil
+			}, peg.T(";")))
+		},
+		"untyped table captures": func() {
+			SepBy(word, peg.Ct(peg.T(",")))
+		},
+		"untyped back captures": func() {
+			Between(peg.NG("k", peg.T("k")), word, peg.Cb("k"))
+		},
+		"untyped argument captures": func() {
+			Between(peg.Carg(0), word, peg.True)
+		},
+		"untyped match-time captures": func() {
+			Token(peg.Cmt(func(_ peg.CaptureContext, subs []peg.Capture) (bool, []peg.Capture, error) {
+				return true, subs, nil
+			}, peg.Dot), func(s string, _ peg.Position) (string, error) {
+				return s, nil
+			})
+		},
+		"untyped context captures": func() {
+			Between(peg.CX(func(peg.CaptureContext, []peg.Capture) (peg.Capture, error) {
+				return nil, nil
+			}, peg.T("(")), word, peg.True)
+		},
+		"untyped automatic captures": func() {
+			ws := peg.CLet(map[string]peg.Pattern{"ws": peg.Q0(peg.T(" "))}, peg.V("ws"))
+			Between(ws, word, peg.True)
+		},
+		"untyped transparent rules": func() {
+			ws := peg.CLet(map[string]peg.Pattern{"ws": peg.Transparent(peg.T(" "))}, peg.V("ws"))
+			SepBy(word, ws)
+		},
+		"defined twice": func() {
+			defs := NewDefs()
+			Define(defs, "word", word)
+			Define(defs, "word", word)
+		},
+		"empty alternative": func() {
+			Alt[int]()
+		},
+	}
+	for name, fn := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expect panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+
+	// positions of the unexpected text honor the configuration
+	cfg := peg.Config{ColumnUnit: peg.ColumnBytes, SyntaxTree: true}
+	_, err := ParseAsConfig(cfg, word, "\u00e9")
+	if err == nil {
+		t.Errorf("ParseAsConfig matched unexpected text")
+	}
+	_, err = ParseAsConfig(cfg, Between(peg.T("\u00e9"), word, peg.True), "\u00e9a1")
+	if err == nil || !strings.Contains(err.Error(), "1:4") {
+		t.Errorf("ParseAsConfig => %v, expect error at 1:4", err)
+	}
+
+	// silent rules of CLet are never captured
+	ws := peg.CLet(map[string]peg.Pattern{"ws": peg.Silent(peg.Q1(peg.T(" ")))}, peg.V("ws"))
+	words, err := ParseAs(SepBy(word, ws), "a bc")
+	if err != nil || !reflect.DeepEqual(words, []string{"a", "bc"}) {
+		t.Errorf("SepBy(CLet) => %v, %v", words, err)
+	}
+
+	cfg = peg.Config{ColumnUnit: peg.ColumnTabStops, TabWidth: 4, LineTerminators: peg.TerminateLS}
+	spaces := peg.Q0(peg.S("\t\u2028\r\n"))
+	_, err = ParseAsConfig(cfg, Between(spaces, word, spaces), "\r\n\u2028a\t1")
+	if err == nil || !strings.Contains(err.Error(), "3:5") {
+		t.Errorf("ParseAsConfig => %v, expect error at 3:5", err)
+	}
+
+	// captures smuggled into the typed rules are reported as errors
+	smuggled := Rule[string]{pat: peg.Seq(peg.Cp, word.pat)}
+	if _, err := ParseAs(Many(smuggled), "ab"); err == nil {
+		t.Errorf("ParseAs with untyped captures succeeded")
+	}
+	if _, err := ParseAs(smuggled, "ab"); err == nil {
+		t.Errorf("ParseAs with untyped captures succeeded")
+	}
+
+	// unresolved variables are reported before matching
+	if _, err := ParseAs(Var[int]("x"), ""); err == nil {
+		t.Errorf("ParseAs with undefined rule succeeded")
+	}
+
+	// outer rules are resolved by outer Let
+	inner := NewDefs()
+	Define(inner, "pair", Seq2(Var[string]("word"), Var[string]("word")))
+	outer := NewDefs()
+	Define(outer, "word", Between(peg.Q0(peg.T(" ")), word, peg.True))
+	pair, err := ParseAs(Let(outer, Let(inner, Var[Tuple2[string, string]]("pair"))), "a bc")
+	if err != nil || pair.V1 != "a" || pair.V2 != "bc" {
+		t.Errorf("nested Let => %v, %v", pair, err)
+	}
+}